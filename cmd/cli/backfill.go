@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"os/signal"
+
+	"github.com/spf13/cobra"
+
+	"github.com/andrew-womeldorf/connect-boilerplate/internal/config"
+	"github.com/andrew-womeldorf/connect-boilerplate/internal/services/user/store"
+)
+
+// emailBackfiller is a store holding users that may predate the claims
+// keeping their emails unique, as the dynamodb store does.
+type emailBackfiller interface {
+	BackfillEmails(ctx context.Context) (int, error)
+}
+
+// listKeyBackfiller is a store holding users that may predate the keys
+// ListUsers finds them by, as the dynamodb store does.
+type listKeyBackfiller interface {
+	BackfillListKeys(ctx context.Context) (int, error)
+}
+
+// backfillEmailsCmd represents the backfill-emails command
+var backfillEmailsCmd = &cobra.Command{
+	Use:   "backfill-emails",
+	Short: "Claim the emails of users created before emails were claimed",
+	Long: `Claim the email of every user, of any tenant, of a dynamodb user store that
+has none claimed, as users created before emails were claimed do not, then
+exit. Until then those users are not found by email, and their emails may be
+taken by new users. It is safe to run more than once.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx, stop := signal.NotifyContext(cmd.Context(), os.Interrupt)
+		defer stop()
+
+		s := openBackfillStore(ctx)
+		backfiller, ok := s.(emailBackfiller)
+		if !ok {
+			slog.ErrorContext(ctx, "The user store keeps emails unique itself, there is nothing to backfill")
+			os.Exit(1)
+		}
+
+		n, err := backfiller.BackfillEmails(ctx)
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to backfill emails", "error", err)
+			os.Exit(1)
+		}
+		slog.InfoContext(ctx, "claimed emails", slog.Int("count", n))
+	},
+}
+
+// backfillListKeysCmd represents the backfill-list-keys command
+var backfillListKeysCmd = &cobra.Command{
+	Use:   "backfill-list-keys",
+	Short: "Set the list keys of users written before they were kept",
+	Long: `Set the keys ListUsers and CountUsers find users by, and the email domain
+they filter on, of every user, of any tenant, of a dynamodb user store that
+lacks them or has them in an older form, then exit. Until then users written
+before those keys were kept are not listed or counted. It is safe to run more
+than once.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx, stop := signal.NotifyContext(cmd.Context(), os.Interrupt)
+		defer stop()
+
+		s := openBackfillStore(ctx)
+
+		backfiller, ok := s.(listKeyBackfiller)
+		if !ok {
+			slog.ErrorContext(ctx, "The user store lists users without kept keys, there is nothing to backfill")
+			os.Exit(1)
+		}
+
+		n, err := backfiller.BackfillListKeys(ctx)
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to backfill list keys", "error", err)
+			os.Exit(1)
+		}
+		slog.InfoContext(ctx, "set list keys", slog.Int("count", n))
+	},
+}
+
+// openBackfillStore opens the configured user store, exiting if it cannot.
+func openBackfillStore(ctx context.Context) store.Store {
+	cfg := config.FromContext(ctx)
+	if err := cfg.Validate(config.EntryCLI); err != nil {
+		slog.ErrorContext(ctx, "Invalid config", "error", err)
+		os.Exit(1)
+	}
+
+	s, err := store.Open(ctx, cfg.Store.OpenURL())
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to open user store", "error", err)
+		os.Exit(1)
+	}
+	return s
+}
+
+func init() {
+	RootCmd.AddCommand(backfillEmailsCmd)
+	RootCmd.AddCommand(backfillListKeysCmd)
+}
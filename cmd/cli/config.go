@@ -0,0 +1,41 @@
+package main
+
+import (
+	"log/slog"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/andrew-womeldorf/connect-boilerplate/internal/config"
+)
+
+// configCmd represents the config command
+var configCmd = &cobra.Command{
+	Use:   "config",
+	Short: "Inspect configuration",
+	Run: func(cmd *cobra.Command, args []string) {
+		// If no subcommand is provided, print help
+		if err := cmd.Help(); err != nil {
+			slog.Error("Failed to display help", "error", err)
+			os.Exit(1)
+		}
+	},
+}
+
+var configShowCmd = &cobra.Command{
+	Use:   "show",
+	Short: "Print the effective configuration",
+	Long: `Print the configuration after merging defaults, the config file,
+environment variables and flags.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := config.FromContext(cmd.Context()).Write(os.Stdout); err != nil {
+			slog.Error("Failed to print config", "error", err)
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(configCmd)
+	configCmd.AddCommand(configShowCmd)
+}
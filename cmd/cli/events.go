@@ -0,0 +1,81 @@
+package main
+
+import (
+	"log/slog"
+	"os"
+	"os/signal"
+
+	"github.com/spf13/cobra"
+
+	"github.com/andrew-womeldorf/connect-boilerplate/internal/config"
+	"github.com/andrew-womeldorf/connect-boilerplate/internal/services/user/store"
+)
+
+var relayOnce bool
+
+// eventsCmd represents the events command
+var eventsCmd = &cobra.Command{
+	Use:   "events",
+	Short: "Manage user events",
+	Run: func(cmd *cobra.Command, args []string) {
+		// If no subcommand is provided, print help
+		if err := cmd.Help(); err != nil {
+			slog.Error("Failed to display help", "error", err)
+			os.Exit(1)
+		}
+	},
+}
+
+var eventsRelayCmd = &cobra.Command{
+	Use:   "relay",
+	Short: "Deliver recorded user events to the configured sinks",
+	Long: `Deliver the events recorded in the user store's outbox to the sinks chosen by
+--events-log, --events-file and --events-webhook, until interrupted or, with
+--once, until the outbox is empty.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx, stop := signal.NotifyContext(cmd.Context(), os.Interrupt)
+		defer stop()
+
+		cfg := config.FromContext(ctx)
+		if err := cfg.Validate(config.EntryCLI); err != nil {
+			slog.ErrorContext(ctx, "Invalid config", "error", err)
+			os.Exit(1)
+		}
+
+		if !cfg.Events.Enabled() {
+			slog.ErrorContext(ctx, "No event sinks are configured")
+			os.Exit(1)
+		}
+
+		s, err := store.Open(ctx, cfg.Store.OpenURL())
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to open user store", "error", err)
+			os.Exit(1)
+		}
+		relay := cfg.Events.NewRelay(s)
+
+		if !relayOnce {
+			relay.Run(ctx)
+			return
+		}
+
+		for {
+			n, err := relay.Flush(ctx)
+			if err != nil {
+				slog.ErrorContext(ctx, "Failed to relay events", "error", err)
+				os.Exit(1)
+			}
+			if n == 0 {
+				return
+			}
+			slog.InfoContext(ctx, "relayed events", slog.Int("count", n))
+		}
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(eventsCmd)
+	eventsCmd.AddCommand(eventsRelayCmd)
+
+	eventsRelayCmd.Flags().BoolVar(&relayOnce, "once", false, "Exit once the outbox is empty")
+}
@@ -10,6 +10,7 @@ import (
 	"github.com/spf13/cobra"
 
 	"github.com/andrew-womeldorf/connect-boilerplate/cmd/cli/user"
+	"github.com/andrew-womeldorf/connect-boilerplate/internal/config"
 )
 
 func main() {
@@ -54,6 +55,14 @@ var RootCmd = &cobra.Command{
 			slogformatter.ErrorFormatter("error"),
 		)(handler))
 		slog.SetDefault(logger)
+
+		// Make the effective configuration available to every subcommand
+		cfg, err := config.Load(cmd.Flags())
+		if err != nil {
+			slog.Error("Failed to load config", "error", err)
+			os.Exit(1)
+		}
+		cmd.SetContext(config.NewContext(cmd.Context(), cfg))
 	},
 	Run: func(cmd *cobra.Command, args []string) {
 		// If no subcommand is provided, print help
@@ -65,8 +74,13 @@ var RootCmd = &cobra.Command{
 }
 
 func init() {
+	// Run the persistent hooks of every parent, so subcommands can add to
+	// the context the root command sets up.
+	cobra.EnableTraverseRunHooks = true
+
 	// Add persistent flags that will be available to all commands
 	RootCmd.PersistentFlags().BoolVarP(&verbose, "verbose", "v", false, "Enable verbose output")
 	RootCmd.PersistentFlags().BoolVar(&jsonLogs, "json", false, "Output logs in JSON format (default: text)")
+	config.BindFlags(RootCmd.PersistentFlags())
 	user.Register(RootCmd)
 }
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"os"
+	"slices"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/andrew-womeldorf/connect-boilerplate/internal/config"
+	"github.com/andrew-womeldorf/connect-boilerplate/internal/services/user/store"
+	"github.com/andrew-womeldorf/connect-boilerplate/internal/services/user/store/migrate"
+)
+
+var migrateDownTo int
+
+// migrationSchemes are the store URL schemes whose schema is managed by
+// migrations. Other stores would reject the migrate=false option.
+var migrationSchemes = []string{"sqlite", "postgres", "postgresql"}
+
+// migrateCmd represents the migrate command
+var migrateCmd = &cobra.Command{
+	Use:   "migrate",
+	Short: "Manage SQL schema migrations",
+	Long: `Inspect, apply and revert the schema migrations of a sqlite or postgres user store.
+The database is chosen by the store configuration, e.g. --store sqlite --sqlite-path app.db.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		// If no subcommand is provided, print help
+		if err := cmd.Help(); err != nil {
+			slog.Error("Failed to display help", "error", err)
+			os.Exit(1)
+		}
+	},
+}
+
+var migrateStatusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Show which migrations have been applied",
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := cmd.Context()
+		migrator := getMigrator(ctx)
+
+		statuses, err := migrator.Status(ctx)
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to get migration status", "error", err)
+			os.Exit(1)
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED AT")
+		for _, status := range statuses {
+			appliedAt := "pending"
+			if status.Applied {
+				appliedAt = status.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Fprintf(w, "%d\t%s\t%s\n", status.Version, status.Name, appliedAt)
+		}
+		if err := w.Flush(); err != nil {
+			slog.ErrorContext(ctx, "Failed to print migration status", "error", err)
+			os.Exit(1)
+		}
+	},
+}
+
+var migrateUpCmd = &cobra.Command{
+	Use:   "up",
+	Short: "Apply all pending migrations",
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := cmd.Context()
+		if err := getMigrator(ctx).Up(ctx); err != nil {
+			slog.ErrorContext(ctx, "Failed to apply migrations", "error", err)
+			os.Exit(1)
+		}
+	},
+}
+
+var migrateDownCmd = &cobra.Command{
+	Use:   "down",
+	Short: "Revert migrations newer than --to",
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx := cmd.Context()
+		if err := getMigrator(ctx).Down(ctx, migrateDownTo); err != nil {
+			slog.ErrorContext(ctx, "Failed to revert migrations", "error", err)
+			os.Exit(1)
+		}
+	},
+}
+
+// getMigrator opens the configured database without applying migrations, so
+// that each subcommand decides what to apply.
+func getMigrator(ctx context.Context) migrate.Migrator {
+	cfg := config.FromContext(ctx)
+	if err := cfg.Validate(config.EntryCLI); err != nil {
+		slog.ErrorContext(ctx, "Invalid config", "error", err)
+		os.Exit(1)
+	}
+
+	u, err := url.Parse(cfg.Store.OpenURL())
+	if err != nil || !slices.Contains(migrationSchemes, u.Scheme) {
+		slog.ErrorContext(ctx, "Migrations are only supported for the sqlite and postgres stores", "store", cfg.Store.OpenURL())
+		os.Exit(1)
+	}
+
+	q := u.Query()
+	q.Set("migrate", "false")
+	u.RawQuery = q.Encode()
+
+	opened, err := store.Open(ctx, u.String())
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to open database", "error", err)
+		os.Exit(1)
+	}
+
+	migratable, ok := opened.(interface {
+		Migrator() (migrate.Migrator, error)
+	})
+	if !ok {
+		slog.ErrorContext(ctx, "Migrations are not supported for this store", "scheme", u.Scheme)
+		os.Exit(1)
+	}
+
+	migrator, err := migratable.Migrator()
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to load migrations", "error", err)
+		os.Exit(1)
+	}
+
+	return migrator
+}
+
+func init() {
+	RootCmd.AddCommand(migrateCmd)
+	migrateCmd.AddCommand(migrateStatusCmd, migrateUpCmd, migrateDownCmd)
+
+	migrateDownCmd.Flags().IntVar(&migrateDownTo, "to", 0, "Version to revert to; 0 reverts every migration")
+	if err := migrateDownCmd.MarkFlagRequired("to"); err != nil {
+		panic(err)
+	}
+}
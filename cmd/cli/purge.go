@@ -0,0 +1,60 @@
+package main
+
+import (
+	"log/slog"
+	"os"
+	"os/signal"
+
+	"github.com/spf13/cobra"
+
+	"github.com/andrew-womeldorf/connect-boilerplate/internal/config"
+	"github.com/andrew-womeldorf/connect-boilerplate/internal/services/user/store"
+)
+
+// purgeCmd represents the purge command
+var purgeCmd = &cobra.Command{
+	Use:   "purge",
+	Short: "Purge users deleted longer ago than the retention window",
+	Long: `Permanently remove every user, of any tenant, deleted longer ago than
+--purge-retention, then exit. Purged users can no longer be undeleted. serve
+purges them in the background; this is for deployments that do not, such as
+Lambda.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx, stop := signal.NotifyContext(cmd.Context(), os.Interrupt)
+		defer stop()
+
+		cfg := config.FromContext(ctx)
+		if err := cfg.Validate(config.EntryCLI); err != nil {
+			slog.ErrorContext(ctx, "Invalid config", "error", err)
+			os.Exit(1)
+		}
+
+		if !cfg.Purge.Enabled() {
+			slog.ErrorContext(ctx, "Purging is disabled, set a purge retention")
+			os.Exit(1)
+		}
+
+		s, err := store.Open(ctx, cfg.Store.OpenURL())
+		if err != nil {
+			slog.ErrorContext(ctx, "Failed to open user store", "error", err)
+			os.Exit(1)
+		}
+		job := cfg.Purge.NewJob(s)
+
+		for {
+			n, err := job.Purge(ctx)
+			if err != nil {
+				slog.ErrorContext(ctx, "Failed to purge users", "error", err)
+				os.Exit(1)
+			}
+			if n == 0 {
+				return
+			}
+			slog.InfoContext(ctx, "purged users", slog.Int("count", n))
+		}
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(purgeCmd)
+}
@@ -1,14 +1,13 @@
 package main
 
 import (
-	"context"
 	"log/slog"
 	"os"
 
 	"github.com/spf13/cobra"
 
+	"github.com/andrew-womeldorf/connect-boilerplate/internal/config"
 	"github.com/andrew-womeldorf/connect-boilerplate/internal/server"
-	"github.com/andrew-womeldorf/connect-boilerplate/internal/services/user/store/sqlite"
 )
 
 var port int
@@ -19,13 +18,35 @@ var serveCmd = &cobra.Command{
 	Short: "Start the API server",
 	Long:  `Start the API server that provides Connect RPC endpoints.`,
 	Run: func(cmd *cobra.Command, args []string) {
-		store, err := sqlite.NewStore(context.Background(), ":memory:")
+		ctx := cmd.Context()
+
+		cfg := config.FromContext(ctx)
+		if err := cfg.Validate(config.EntryServe); err != nil {
+			slog.ErrorContext(ctx, "Invalid config", "error", err)
+			os.Exit(1)
+		}
+
+		store, err := cfg.Store.OpenUserStore(ctx)
 		if err != nil {
-			panic(err)
+			slog.ErrorContext(ctx, "Failed to open user store", "error", err)
+			os.Exit(1)
+		}
+
+		// Deliver recorded events alongside the server when sinks are set
+		if relay := cfg.Events.NewRelay(store); relay != nil {
+			go relay.Run(ctx)
+		}
+
+		// Purge users once they have been deleted for the retention window
+		if job := cfg.Purge.NewJob(store); job != nil {
+			go job.Run(ctx)
 		}
 
 		// Create and run server
-		srv := server.NewServer(port, store)
+		srv := server.NewServer(port, store,
+			server.WithIdempotencyTTL(cfg.Idempotency.TTL),
+			server.WithIdempotencyLease(cfg.Idempotency.Lease),
+		)
 		if err := srv.Run(); err != nil {
 			slog.Error("Failed to run server", "error", err)
 			os.Exit(1)
@@ -0,0 +1,24 @@
+package user
+
+import (
+	"log/slog"
+	"os"
+
+	"github.com/spf13/cobra"
+)
+
+// auditCmd represents the audit command
+var auditCmd = &cobra.Command{
+	Use:   "audit",
+	Short: "Read the audit log of changes to users",
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		return scopeToTenant(cmd)
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		// If no subcommand is provided, print help
+		if err := cmd.Help(); err != nil {
+			slog.Error("Failed to display help", "error", err)
+			os.Exit(1)
+		}
+	},
+}
@@ -0,0 +1,74 @@
+package user
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"os"
+
+	"connectrpc.com/connect"
+	"github.com/spf13/cobra"
+	"google.golang.org/protobuf/encoding/protojson"
+
+	pb "github.com/andrew-womeldorf/connect-boilerplate/gen/user/v1"
+)
+
+func batchCreateUsersCmd() *cobra.Command {
+	var file string
+
+	cmd := &cobra.Command{
+		Use:   "batch-create-users",
+		Short: "Create several users",
+		Long: `Create several users from a JSON BatchCreateUsersRequest, such as
+{"requests": [{"name": "Jane Doe", "email": "jane@example.com"}]},
+reporting any that could not be created.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			runBatchCreateUsers(cmd.Context(), file)
+		},
+	}
+
+	cmd.Flags().StringVar(&file, "file", "", "Path to the JSON request, or - for stdin (required)")
+	if err := cmd.MarkFlagRequired("file"); err != nil {
+		panic(err)
+	}
+
+	return cmd
+}
+
+func runBatchCreateUsers(ctx context.Context, file string) {
+	// Get client based on endpoint flag
+	client, err := getClient(ctx)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to create client", "error", err)
+		os.Exit(1)
+	}
+
+	// Create request
+	var data []byte
+	if file == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(file)
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to read request", "error", err)
+		os.Exit(1)
+	}
+
+	req := &pb.BatchCreateUsersRequest{}
+	if err := protojson.Unmarshal(data, req); err != nil {
+		slog.ErrorContext(ctx, "Failed to parse request", "error", err)
+		os.Exit(1)
+	}
+
+	// Call the service
+	slog.DebugContext(ctx, "Creating users", "count", len(req.Requests))
+	resp, err := client.BatchCreateUsers(ctx, connect.NewRequest(req))
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to create users", "error", err)
+		os.Exit(1)
+	}
+	slog.DebugContext(ctx, "Created users", "failures", resp.Msg.FailureCount)
+
+	printJSON(resp.Msg)
+}
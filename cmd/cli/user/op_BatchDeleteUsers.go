@@ -0,0 +1,58 @@
+package user
+
+import (
+	"context"
+	"log/slog"
+	"os"
+
+	"connectrpc.com/connect"
+	"github.com/spf13/cobra"
+
+	pb "github.com/andrew-womeldorf/connect-boilerplate/gen/user/v1"
+)
+
+func batchDeleteUsersCmd() *cobra.Command {
+	var userIDs []string
+
+	cmd := &cobra.Command{
+		Use:   "batch-delete-users",
+		Short: "Delete several users by ID",
+		Long:  `Delete several users by their IDs, reporting any that could not be deleted.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			runBatchDeleteUsers(cmd.Context(), userIDs)
+		},
+	}
+
+	cmd.Flags().StringSliceVar(&userIDs, "id", nil, "User IDs to delete, repeated or comma separated (required)")
+	if err := cmd.MarkFlagRequired("id"); err != nil {
+		panic(err)
+	}
+
+	return cmd
+}
+
+func runBatchDeleteUsers(ctx context.Context, userIDs []string) {
+	// Get client based on endpoint flag
+	client, err := getClient(ctx)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to create client", "error", err)
+		os.Exit(1)
+	}
+
+	// Create request
+	req := &pb.BatchDeleteUsersRequest{}
+	for _, id := range userIDs {
+		req.Requests = append(req.Requests, &pb.DeleteUserRequest{Id: id})
+	}
+
+	// Call the service
+	slog.DebugContext(ctx, "Deleting users", "ids", userIDs)
+	resp, err := client.BatchDeleteUsers(ctx, connect.NewRequest(req))
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to delete users", "error", err)
+		os.Exit(1)
+	}
+	slog.DebugContext(ctx, "Deleted users", "failures", resp.Msg.FailureCount)
+
+	printJSON(resp.Msg)
+}
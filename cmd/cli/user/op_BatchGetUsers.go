@@ -0,0 +1,57 @@
+package user
+
+import (
+	"context"
+	"log/slog"
+	"os"
+
+	"connectrpc.com/connect"
+	"github.com/spf13/cobra"
+
+	pb "github.com/andrew-womeldorf/connect-boilerplate/gen/user/v1"
+)
+
+func batchGetUsersCmd() *cobra.Command {
+	var userIDs []string
+
+	cmd := &cobra.Command{
+		Use:   "batch-get-users",
+		Short: "Get several users by ID",
+		Long:  `Get several users by their IDs, reporting any that could not be read.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			runBatchGetUsers(cmd.Context(), userIDs)
+		},
+	}
+
+	cmd.Flags().StringSliceVar(&userIDs, "id", nil, "User IDs to retrieve, repeated or comma separated (required)")
+	if err := cmd.MarkFlagRequired("id"); err != nil {
+		panic(err)
+	}
+
+	return cmd
+}
+
+func runBatchGetUsers(ctx context.Context, userIDs []string) {
+	// Get client based on endpoint flag
+	client, err := getClient(ctx)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to create client", "error", err)
+		os.Exit(1)
+	}
+
+	// Create request
+	req := &pb.BatchGetUsersRequest{
+		Ids: userIDs,
+	}
+
+	// Call the service
+	slog.DebugContext(ctx, "Getting users", "ids", userIDs)
+	resp, err := client.BatchGetUsers(ctx, connect.NewRequest(req))
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to get users", "error", err)
+		os.Exit(1)
+	}
+	slog.DebugContext(ctx, "Got users", "failures", resp.Msg.FailureCount)
+
+	printJSON(resp.Msg)
+}
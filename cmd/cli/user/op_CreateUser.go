@@ -14,31 +14,30 @@ import (
 func createUserCmd() *cobra.Command {
 	var userName string
 	var userEmail string
+	var userID string
 
 	cmd := &cobra.Command{
 		Use:   "create-user",
 		Short: "Create a new user",
-		Long:  `Create a new user with the given name and email.`,
+		Long: `Create a new user with the given name and email, and the given ID or a
+generated one.`,
 		Run: func(cmd *cobra.Command, args []string) {
-			runCreateUser(userName, userEmail)
+			runCreateUser(cmd.Context(), &pb.CreateUserRequest{
+				Name:   userName,
+				Email:  userEmail,
+				UserId: userID,
+			})
 		},
 	}
 
 	cmd.Flags().StringVar(&userName, "name", "", "User name (required)")
 	cmd.Flags().StringVar(&userEmail, "email", "", "User email (required)")
-	if err := cmd.MarkFlagRequired("name"); err != nil {
-		panic(err)
-	}
-	if err := cmd.MarkFlagRequired("email"); err != nil {
-		panic(err)
-	}
+	cmd.Flags().StringVar(&userID, "user-id", "", "ID to create the user with, instead of a generated one")
 
 	return cmd
 }
 
-func runCreateUser(userName, userEmail string) {
-	ctx := context.Background()
-
+func runCreateUser(ctx context.Context, req *pb.CreateUserRequest) {
 	// Get client based on endpoint flag
 	client, err := getClient(ctx)
 	if err != nil {
@@ -46,14 +45,8 @@ func runCreateUser(userName, userEmail string) {
 		os.Exit(1)
 	}
 
-	// Create request
-	req := &pb.CreateUserRequest{
-		Name:  userName,
-		Email: userEmail,
-	}
-
 	// Call the service
-	slog.DebugContext(ctx, "Creating user", "name", userName, "email", userEmail)
+	slog.DebugContext(ctx, "Creating user", "name", req.Name, "email", req.Email)
 	resp, err := client.CreateUser(ctx, connect.NewRequest(req))
 	if err != nil {
 		slog.ErrorContext(ctx, "Failed to create user", "error", err)
@@ -13,27 +13,24 @@ import (
 
 func deleteUserCmd() *cobra.Command {
 	var userID string
+	var ifMatch string
 
 	cmd := &cobra.Command{
 		Use:   "delete-user",
 		Short: "Delete a user by ID",
 		Long:  `Delete a user by their ID.`,
 		Run: func(cmd *cobra.Command, args []string) {
-			runDeleteUser(userID)
+			runDeleteUser(cmd.Context(), userID, ifMatch)
 		},
 	}
 
 	cmd.Flags().StringVar(&userID, "id", "", "User ID to delete (required)")
-	if err := cmd.MarkFlagRequired("id"); err != nil {
-		panic(err)
-	}
+	cmd.Flags().StringVar(&ifMatch, "if-match", "", "Only delete if the user's etag matches")
 
 	return cmd
 }
 
-func runDeleteUser(userID string) {
-	ctx := context.Background()
-
+func runDeleteUser(ctx context.Context, userID, ifMatch string) {
 	// Get client based on endpoint flag
 	client, err := getClient(ctx)
 	if err != nil {
@@ -43,7 +40,8 @@ func runDeleteUser(userID string) {
 
 	// Create request
 	req := &pb.DeleteUserRequest{
-		Id: userID,
+		Id:           userID,
+		ExpectedEtag: ifMatch,
 	}
 
 	// Call the service
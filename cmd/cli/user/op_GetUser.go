@@ -13,27 +13,34 @@ import (
 
 func getUserCmd() *cobra.Command {
 	var userID string
+	var asOf string
 
 	cmd := &cobra.Command{
 		Use:   "get-user",
 		Short: "Get a user by ID",
-		Long:  `Get a user by their ID.`,
+		Long:  `Get a user by their ID, optionally as it was at an earlier time.`,
 		Run: func(cmd *cobra.Command, args []string) {
-			runGetUser(userID)
+			req := &pb.GetUserRequest{
+				Id: userID,
+			}
+
+			var err error
+			if req.AsOf, err = parseTimeFlag("as-of", asOf); err != nil {
+				slog.ErrorContext(cmd.Context(), "Invalid flag", "error", err)
+				os.Exit(1)
+			}
+
+			runGetUser(cmd.Context(), req)
 		},
 	}
 
 	cmd.Flags().StringVar(&userID, "id", "", "User ID to retrieve (required)")
-	if err := cmd.MarkFlagRequired("id"); err != nil {
-		panic(err)
-	}
+	cmd.Flags().StringVar(&asOf, "as-of", "", "Get the user as it was at this RFC 3339 time")
 
 	return cmd
 }
 
-func runGetUser(userID string) {
-	ctx := context.Background()
-
+func runGetUser(ctx context.Context, req *pb.GetUserRequest) {
 	// Get client based on endpoint flag
 	client, err := getClient(ctx)
 	if err != nil {
@@ -41,13 +48,8 @@ func runGetUser(userID string) {
 		os.Exit(1)
 	}
 
-	// Create request
-	req := &pb.GetUserRequest{
-		Id: userID,
-	}
-
 	// Call the service
-	slog.DebugContext(ctx, "Getting user", "id", userID)
+	slog.DebugContext(ctx, "Getting user", "id", req.Id)
 	resp, err := client.GetUser(ctx, connect.NewRequest(req))
 	if err != nil {
 		slog.ErrorContext(ctx, "Failed to get user", "error", err)
@@ -0,0 +1,54 @@
+package user
+
+import (
+	"context"
+	"log/slog"
+	"os"
+
+	"connectrpc.com/connect"
+	"github.com/spf13/cobra"
+
+	pb "github.com/andrew-womeldorf/connect-boilerplate/gen/user/v1"
+)
+
+func getUserByEmailCmd() *cobra.Command {
+	var userEmail string
+
+	cmd := &cobra.Command{
+		Use:   "get-user-by-email",
+		Short: "Get a user by email",
+		Long:  `Get a user by their email, ignoring case.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			runGetUserByEmail(cmd.Context(), userEmail)
+		},
+	}
+
+	cmd.Flags().StringVar(&userEmail, "email", "", "User email to retrieve (required)")
+
+	return cmd
+}
+
+func runGetUserByEmail(ctx context.Context, userEmail string) {
+	// Get client based on endpoint flag
+	client, err := getClient(ctx)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to create client", "error", err)
+		os.Exit(1)
+	}
+
+	// Create request
+	req := &pb.GetUserByEmailRequest{
+		Email: userEmail,
+	}
+
+	// Call the service
+	slog.DebugContext(ctx, "Getting user by email", "email", userEmail)
+	resp, err := client.GetUserByEmail(ctx, connect.NewRequest(req))
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to get user by email", "error", err)
+		os.Exit(1)
+	}
+	slog.DebugContext(ctx, "Successfully got user by email")
+
+	printJSON(resp.Msg)
+}
@@ -0,0 +1,73 @@
+package user
+
+import (
+	"context"
+	"log/slog"
+	"os"
+
+	"connectrpc.com/connect"
+	"github.com/spf13/cobra"
+
+	pb "github.com/andrew-womeldorf/connect-boilerplate/gen/user/v1"
+)
+
+func listAuditEventsCmd() *cobra.Command {
+	var pageSize int32
+	var pageToken string
+	var userID string
+	var startTime, endTime string
+
+	cmd := &cobra.Command{
+		Use:   "list",
+		Short: "List audit events",
+		Long: `List the audit events recorded for changes to users, oldest first,
+optionally only those for one user or within a time range.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			req := &pb.ListAuditEventsRequest{
+				PageSize:  pageSize,
+				PageToken: pageToken,
+				UserId:    userID,
+			}
+
+			var err error
+			if req.StartTime, err = parseTimeFlag("start-time", startTime); err != nil {
+				slog.ErrorContext(cmd.Context(), "Invalid flag", "error", err)
+				os.Exit(1)
+			}
+			if req.EndTime, err = parseTimeFlag("end-time", endTime); err != nil {
+				slog.ErrorContext(cmd.Context(), "Invalid flag", "error", err)
+				os.Exit(1)
+			}
+
+			runListAuditEvents(cmd.Context(), req)
+		},
+	}
+
+	cmd.Flags().Int32Var(&pageSize, "page-size", 10, "Number of events to return per page")
+	cmd.Flags().StringVar(&pageToken, "page-token", "", "Page token for pagination")
+	cmd.Flags().StringVar(&userID, "user-id", "", "Only list events for this user")
+	cmd.Flags().StringVar(&startTime, "start-time", "", "Only list events at or after this RFC 3339 time")
+	cmd.Flags().StringVar(&endTime, "end-time", "", "Only list events before this RFC 3339 time")
+
+	return cmd
+}
+
+func runListAuditEvents(ctx context.Context, req *pb.ListAuditEventsRequest) {
+	// Get client based on endpoint flag
+	client, err := getClient(ctx)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to create client", "error", err)
+		os.Exit(1)
+	}
+
+	// Call the service
+	slog.DebugContext(ctx, "Listing audit events...")
+	resp, err := client.ListAuditEvents(ctx, connect.NewRequest(req))
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to list audit events", "error", err)
+		os.Exit(1)
+	}
+	slog.DebugContext(ctx, "Successfully listed audit events", "count", len(resp.Msg.AuditEvents))
+
+	printJSON(resp.Msg)
+}
@@ -0,0 +1,58 @@
+package user
+
+import (
+	"context"
+	"log/slog"
+	"os"
+
+	"connectrpc.com/connect"
+	"github.com/spf13/cobra"
+
+	pb "github.com/andrew-womeldorf/connect-boilerplate/gen/user/v1"
+)
+
+func listUserRevisionsCmd() *cobra.Command {
+	var userID string
+	var pageSize int32
+	var pageToken string
+
+	cmd := &cobra.Command{
+		Use:   "list-user-revisions",
+		Short: "List the revisions of a user",
+		Long: `List the revisions kept of a user, newest first. A revision is kept for
+each change to the user, up to a limit, after which the oldest are dropped.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			runListUserRevisions(cmd.Context(), &pb.ListUserRevisionsRequest{
+				UserId:    userID,
+				PageSize:  pageSize,
+				PageToken: pageToken,
+			})
+		},
+	}
+
+	cmd.Flags().StringVar(&userID, "user-id", "", "User ID to list revisions of (required)")
+	cmd.Flags().Int32Var(&pageSize, "page-size", 10, "Number of revisions to return per page")
+	cmd.Flags().StringVar(&pageToken, "page-token", "", "Page token for pagination")
+
+	return cmd
+}
+
+func runListUserRevisions(ctx context.Context, req *pb.ListUserRevisionsRequest) {
+	// Get client based on endpoint flag
+	client, err := getClient(ctx)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to create client", "error", err)
+		os.Exit(1)
+	}
+
+	// Call the service
+	slog.DebugContext(ctx, "Listing user revisions", "user id", req.UserId)
+	resp, err := client.ListUserRevisions(ctx, connect.NewRequest(req))
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to list user revisions", "error", err)
+		os.Exit(1)
+	}
+	slog.DebugContext(ctx, "Successfully listed user revisions", "count", len(resp.Msg.Revisions))
+
+	printJSON(resp.Msg)
+}
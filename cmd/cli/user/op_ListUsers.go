@@ -2,11 +2,14 @@ package user
 
 import (
 	"context"
+	"fmt"
 	"log/slog"
 	"os"
+	"time"
 
 	"connectrpc.com/connect"
 	"github.com/spf13/cobra"
+	"google.golang.org/protobuf/types/known/timestamppb"
 
 	pb "github.com/andrew-womeldorf/connect-boilerplate/gen/user/v1"
 )
@@ -14,26 +17,73 @@ import (
 func listUsersCmd() *cobra.Command {
 	var pageSize int32
 	var pageToken string
+	var orderBy string
+	var showTotalSize, showDeleted bool
+	var namePrefix, emailDomain string
+	var createdAfter, createdBefore string
 
 	cmd := &cobra.Command{
 		Use:   "list-users",
 		Short: "List users",
 		Long: `List users with optional pagination.
-This command allows listing users with page size and token parameters.`,
+This command allows listing users with page size and token parameters,
+narrowed by name prefix, email domain and creation time, in a chosen order.`,
 		Run: func(cmd *cobra.Command, args []string) {
-			runListUsers(pageSize, pageToken)
+			req := &pb.ListUsersRequest{
+				PageSize:      pageSize,
+				PageToken:     pageToken,
+				OrderBy:       orderBy,
+				ShowTotalSize: showTotalSize,
+				ShowDeleted:   showDeleted,
+				Filter: &pb.UserFilter{
+					NamePrefix:  namePrefix,
+					EmailDomain: emailDomain,
+				},
+			}
+
+			var err error
+			if req.Filter.CreatedAfter, err = parseTimeFlag("created-after", createdAfter); err != nil {
+				slog.ErrorContext(cmd.Context(), "Invalid flag", "error", err)
+				os.Exit(1)
+			}
+			if req.Filter.CreatedBefore, err = parseTimeFlag("created-before", createdBefore); err != nil {
+				slog.ErrorContext(cmd.Context(), "Invalid flag", "error", err)
+				os.Exit(1)
+			}
+
+			runListUsers(cmd.Context(), req)
 		},
 	}
 
 	cmd.Flags().Int32Var(&pageSize, "page-size", 10, "Number of users to return per page")
 	cmd.Flags().StringVar(&pageToken, "page-token", "", "Page token for pagination")
+	cmd.Flags().StringVar(&orderBy, "order-by", "", `Order of the users: "name" or "created_at", optionally followed by "desc"`)
+	cmd.Flags().BoolVar(&showTotalSize, "show-total-size", false, "Count every matching user into total_size")
+	cmd.Flags().BoolVar(&showDeleted, "show-deleted", false, "Also list users that are deleted but not yet purged")
+	cmd.Flags().StringVar(&namePrefix, "name-prefix", "", "Only list users whose name starts with this prefix")
+	cmd.Flags().StringVar(&emailDomain, "email-domain", "", "Only list users with an email at this domain")
+	cmd.Flags().StringVar(&createdAfter, "created-after", "", "Only list users created at or after this RFC 3339 time")
+	cmd.Flags().StringVar(&createdBefore, "created-before", "", "Only list users created before this RFC 3339 time")
 
 	return cmd
 }
 
-func runListUsers(pageSize int32, pageToken string) {
-	ctx := context.Background()
+// parseTimeFlag parses the RFC 3339 value of the named flag, which is unset
+// when empty.
+func parseTimeFlag(name, value string) (*timestamppb.Timestamp, error) {
+	if value == "" {
+		return nil, nil
+	}
+
+	t, err := time.Parse(time.RFC3339Nano, value)
+	if err != nil {
+		return nil, fmt.Errorf("--%s: %w", name, err)
+	}
 
+	return timestamppb.New(t), nil
+}
+
+func runListUsers(ctx context.Context, req *pb.ListUsersRequest) {
 	// Get client based on endpoint flag
 	client, err := getClient(ctx)
 	if err != nil {
@@ -41,12 +91,6 @@ func runListUsers(pageSize int32, pageToken string) {
 		os.Exit(1)
 	}
 
-	// Create request
-	req := &pb.ListUsersRequest{
-		PageSize:  pageSize,
-		PageToken: pageToken,
-	}
-
 	// Call the service
 	slog.DebugContext(ctx, "Listing users...")
 	resp, err := client.ListUsers(ctx, connect.NewRequest(req))
@@ -60,10 +104,14 @@ func runListUsers(pageSize int32, pageToken string) {
 	result := struct {
 		Users         []*pb.User `json:"users"`
 		NextPageToken string     `json:"next_page_token,omitempty"`
+		TotalSize     *int32     `json:"total_size,omitempty"`
 	}{
 		Users:         resp.Msg.Users,
 		NextPageToken: resp.Msg.NextPageToken,
 	}
+	if req.ShowTotalSize {
+		result.TotalSize = &resp.Msg.TotalSize
+	}
 
 	printJSON(result)
 }
@@ -0,0 +1,58 @@
+package user
+
+import (
+	"context"
+	"log/slog"
+	"os"
+
+	"connectrpc.com/connect"
+	"github.com/spf13/cobra"
+
+	pb "github.com/andrew-womeldorf/connect-boilerplate/gen/user/v1"
+)
+
+func searchUsersCmd() *cobra.Command {
+	var query string
+	var pageSize int32
+	var pageToken string
+
+	cmd := &cobra.Command{
+		Use:   "search-users",
+		Short: "Search users by name or email",
+		Long: `Search users by the start of words in their name or email, such as
+"jon smi" or "smith@exa". Results are ranked, best match first.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			runSearchUsers(cmd.Context(), &pb.SearchUsersRequest{
+				Query:     query,
+				PageSize:  pageSize,
+				PageToken: pageToken,
+			})
+		},
+	}
+
+	cmd.Flags().StringVar(&query, "query", "", "Text to search for (required)")
+	cmd.Flags().Int32Var(&pageSize, "page-size", 10, "Number of results to return per page")
+	cmd.Flags().StringVar(&pageToken, "page-token", "", "Page token for pagination")
+
+	return cmd
+}
+
+func runSearchUsers(ctx context.Context, req *pb.SearchUsersRequest) {
+	// Get client based on endpoint flag
+	client, err := getClient(ctx)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to create client", "error", err)
+		os.Exit(1)
+	}
+
+	// Call the service
+	slog.DebugContext(ctx, "Searching users", "query", req.Query)
+	resp, err := client.SearchUsers(ctx, connect.NewRequest(req))
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to search users", "error", err)
+		os.Exit(1)
+	}
+	slog.DebugContext(ctx, "Successfully searched users", "count", len(resp.Msg.Results))
+
+	printJSON(resp.Msg)
+}
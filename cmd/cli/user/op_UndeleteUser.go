@@ -0,0 +1,57 @@
+package user
+
+import (
+	"context"
+	"log/slog"
+	"os"
+
+	"connectrpc.com/connect"
+	"github.com/spf13/cobra"
+
+	pb "github.com/andrew-womeldorf/connect-boilerplate/gen/user/v1"
+)
+
+func undeleteUserCmd() *cobra.Command {
+	var userID string
+	var ifMatch string
+
+	cmd := &cobra.Command{
+		Use:   "undelete-user",
+		Short: "Restore a deleted user by ID",
+		Long:  `Restore a deleted user by their ID, unless it has been purged.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			runUndeleteUser(cmd.Context(), userID, ifMatch)
+		},
+	}
+
+	cmd.Flags().StringVar(&userID, "id", "", "User ID to restore (required)")
+	cmd.Flags().StringVar(&ifMatch, "if-match", "", "Only restore if the user's etag matches")
+
+	return cmd
+}
+
+func runUndeleteUser(ctx context.Context, userID, ifMatch string) {
+	// Get client based on endpoint flag
+	client, err := getClient(ctx)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to create client", "error", err)
+		os.Exit(1)
+	}
+
+	// Create request
+	req := &pb.UndeleteUserRequest{
+		Id:           userID,
+		ExpectedEtag: ifMatch,
+	}
+
+	// Call the service
+	slog.DebugContext(ctx, "Restoring user", "id", userID)
+	resp, err := client.UndeleteUser(ctx, connect.NewRequest(req))
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to restore user", "error", err)
+		os.Exit(1)
+	}
+	slog.DebugContext(ctx, "Successfully restored user")
+
+	printJSON(resp.Msg)
+}
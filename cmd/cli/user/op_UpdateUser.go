@@ -2,11 +2,13 @@ package user
 
 import (
 	"context"
+	"errors"
 	"log/slog"
 	"os"
 
 	"connectrpc.com/connect"
 	"github.com/spf13/cobra"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
 
 	pb "github.com/andrew-womeldorf/connect-boilerplate/gen/user/v1"
 )
@@ -15,35 +17,39 @@ func updateUserCmd() *cobra.Command {
 	var userID string
 	var userName string
 	var userEmail string
+	var ifMatch string
 
 	cmd := &cobra.Command{
 		Use:   "update-user",
 		Short: "Update an existing user",
-		Long:  `Update an existing user with the given ID, name, and email.`,
+		Long: `Update the name, email, or both of an existing user with the given ID.
+Only the fields given as flags are changed.`,
+		Args: func(cmd *cobra.Command, args []string) error {
+			if !cmd.Flags().Changed("name") && !cmd.Flags().Changed("email") {
+				return errors.New("at least one of --name or --email is required")
+			}
+			return nil
+		},
 		Run: func(cmd *cobra.Command, args []string) {
-			runUpdateUser(userID, userName, userEmail)
+			var paths []string
+			for _, flag := range []string{"name", "email"} {
+				if cmd.Flags().Changed(flag) {
+					paths = append(paths, flag)
+				}
+			}
+			runUpdateUser(cmd.Context(), userID, userName, userEmail, paths, ifMatch)
 		},
 	}
 
 	cmd.Flags().StringVar(&userID, "id", "", "User ID to update (required)")
-	cmd.Flags().StringVar(&userName, "name", "", "User name (required)")
-	cmd.Flags().StringVar(&userEmail, "email", "", "User email (required)")
-	if err := cmd.MarkFlagRequired("id"); err != nil {
-		panic(err)
-	}
-	if err := cmd.MarkFlagRequired("name"); err != nil {
-		panic(err)
-	}
-	if err := cmd.MarkFlagRequired("email"); err != nil {
-		panic(err)
-	}
+	cmd.Flags().StringVar(&userName, "name", "", "New user name")
+	cmd.Flags().StringVar(&userEmail, "email", "", "New user email")
+	cmd.Flags().StringVar(&ifMatch, "if-match", "", "Only update if the user's etag matches")
 
 	return cmd
 }
 
-func runUpdateUser(userID, userName, userEmail string) {
-	ctx := context.Background()
-
+func runUpdateUser(ctx context.Context, userID, userName, userEmail string, paths []string, ifMatch string) {
 	// Get client based on endpoint flag
 	client, err := getClient(ctx)
 	if err != nil {
@@ -53,13 +59,15 @@ func runUpdateUser(userID, userName, userEmail string) {
 
 	// Create request
 	req := &pb.UpdateUserRequest{
-		Id:    userID,
-		Name:  userName,
-		Email: userEmail,
+		Id:           userID,
+		Name:         userName,
+		Email:        userEmail,
+		ExpectedEtag: ifMatch,
+		UpdateMask:   &fieldmaskpb.FieldMask{Paths: paths},
 	}
 
 	// Call the service
-	slog.DebugContext(ctx, "Updating user", "id", userID, "name", userName, "email", userEmail)
+	slog.DebugContext(ctx, "Updating user", "id", userID, "fields", paths)
 	resp, err := client.UpdateUser(ctx, connect.NewRequest(req))
 	if err != nil {
 		slog.ErrorContext(ctx, "Failed to update user", "error", err)
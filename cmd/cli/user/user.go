@@ -7,17 +7,24 @@ import (
 	"log/slog"
 	"net/http"
 	"os"
+	osuser "os/user"
 
+	"connectrpc.com/connect"
+	"github.com/google/uuid"
 	"github.com/spf13/cobra"
 
 	v1 "github.com/andrew-womeldorf/connect-boilerplate/gen/user/v1/userv1connect"
+	"github.com/andrew-womeldorf/connect-boilerplate/internal/config"
 	"github.com/andrew-womeldorf/connect-boilerplate/internal/server"
 	"github.com/andrew-womeldorf/connect-boilerplate/internal/services/user"
-	"github.com/andrew-womeldorf/connect-boilerplate/internal/services/user/store/sqlite"
+	"github.com/andrew-womeldorf/connect-boilerplate/internal/services/user/store"
 )
 
 var (
-	apiEndpoint string
+	apiEndpoint    string
+	actor          string
+	tenant         string
+	idempotencyKey string
 )
 
 // userCmd represents the user command
@@ -26,6 +33,12 @@ var userCmd = &cobra.Command{
 	Short: "Execute RPC calls to the User service",
 	Long: `Execute RPC calls to the User service using RPC-style commands.
 This command provides subcommands for all RPCs in the User service.`,
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		// Changes made in-memory are audited as made by the actor; remote
+		// calls name it in a header instead, see getClient.
+		cmd.SetContext(store.WithAuditInfo(cmd.Context(), store.AuditInfo{Actor: actor}))
+		return scopeToTenant(cmd)
+	},
 	Run: func(cmd *cobra.Command, args []string) {
 		// If no subcommand is provided, print help
 		if err := cmd.Help(); err != nil {
@@ -40,13 +53,30 @@ func Register(root *cobra.Command) {
 
 	// Add API endpoint flag to the user command
 	userCmd.PersistentFlags().StringVar(&apiEndpoint, "endpoint", "", "API endpoint URL (e.g., http://localhost:8088)")
+	userCmd.PersistentFlags().StringVar(&actor, "actor", currentUsername(), "Who to record in the audit log as making changes")
+	userCmd.PersistentFlags().StringVar(&tenant, "tenant", "", "Tenant whose users to act on (default \""+store.DefaultTenant+"\")")
+	userCmd.PersistentFlags().StringVar(&idempotencyKey, "idempotency-key", "", "Key making a change safe to repeat with the same key, with --endpoint (default a new key for each call)")
 
 	// Add all User RPC commands
 	userCmd.AddCommand(listUsersCmd())
 	userCmd.AddCommand(getUserCmd())
+	userCmd.AddCommand(getUserByEmailCmd())
 	userCmd.AddCommand(createUserCmd())
 	userCmd.AddCommand(updateUserCmd())
 	userCmd.AddCommand(deleteUserCmd())
+	userCmd.AddCommand(undeleteUserCmd())
+	userCmd.AddCommand(batchGetUsersCmd())
+	userCmd.AddCommand(batchCreateUsersCmd())
+	userCmd.AddCommand(batchDeleteUsersCmd())
+	userCmd.AddCommand(searchUsersCmd())
+	userCmd.AddCommand(listUserRevisionsCmd())
+
+	// The audit log is read through the User service too, so shares its
+	// endpoint flag
+	root.AddCommand(auditCmd)
+	auditCmd.PersistentFlags().StringVar(&apiEndpoint, "endpoint", "", "API endpoint URL (e.g., http://localhost:8088)")
+	auditCmd.PersistentFlags().StringVar(&tenant, "tenant", "", "Tenant whose audit log to read (default \""+store.DefaultTenant+"\")")
+	auditCmd.AddCommand(listAuditEventsCmd())
 }
 
 // getClient returns either a local client or a remote client based on whether the API endpoint is provided
@@ -57,11 +87,17 @@ func getClient(ctx context.Context) (v1.UserServiceClient, error) {
 		return v1.NewUserServiceClient(
 			httpClient,
 			apiEndpoint,
+			connect.WithInterceptors(callerInterceptor()),
 		), nil
 	} else {
-		store, err := sqlite.NewStore(ctx, ":memory:")
+		cfg := config.FromContext(ctx)
+		if err := cfg.Validate(config.EntryCLI); err != nil {
+			return nil, err
+		}
+
+		store, err := cfg.Store.OpenUserStore(ctx)
 		if err != nil {
-			slog.DebugContext(ctx, "could not get sqlite user store", slog.Any("error", err))
+			slog.DebugContext(ctx, "could not open user store", slog.Any("error", err))
 			return nil, err
 		}
 
@@ -70,6 +106,55 @@ func getClient(ctx context.Context) (v1.UserServiceClient, error) {
 	}
 }
 
+// callerInterceptor names the actor and tenant on every remote call, and
+// sends it with the idempotency key flag, or else a new key, which --verbose
+// logs. Running a command again with its key answers it as the first run was
+// rather than making its change twice. Calls made in-memory have no key.
+func callerInterceptor() connect.UnaryInterceptorFunc {
+	return func(next connect.UnaryFunc) connect.UnaryFunc {
+		return func(ctx context.Context, req connect.AnyRequest) (connect.AnyResponse, error) {
+			if actor != "" {
+				req.Header().Set(server.ActorHeader, actor)
+			}
+			if tenant != "" {
+				req.Header().Set(server.TenantHeader, tenant)
+			}
+
+			key := idempotencyKey
+			if key == "" {
+				key = uuid.NewString()
+			}
+			slog.DebugContext(ctx, "calling with idempotency key", slog.String("idempotency key", key))
+			req.Header().Set(server.IdempotencyKeyHeader, key)
+
+			return next(ctx, req)
+		}
+	}
+}
+
+// scopeToTenant scopes the command's context to the tenant flag, for calls
+// made in-memory; remote calls name it in a header instead, see getClient.
+func scopeToTenant(cmd *cobra.Command) error {
+	if tenant == "" {
+		return nil
+	}
+	if err := store.ValidateTenant(tenant); err != nil {
+		return err
+	}
+	cmd.SetContext(store.WithTenant(cmd.Context(), tenant))
+	return nil
+}
+
+// currentUsername returns the name of the user running the CLI, or nothing
+// if it cannot be found.
+func currentUsername() string {
+	u, err := osuser.Current()
+	if err != nil {
+		return ""
+	}
+	return u.Username
+}
+
 // printJSON prints the given data as JSON
 func printJSON(data interface{}) {
 	jsonBytes, err := json.MarshalIndent(data, "", "  ")
@@ -7,8 +7,8 @@ import (
 
 	"github.com/aws/aws-lambda-go/lambda"
 
+	"github.com/andrew-womeldorf/connect-boilerplate/internal/config"
 	"github.com/andrew-womeldorf/connect-boilerplate/internal/server"
-	"github.com/andrew-womeldorf/connect-boilerplate/internal/services/user/store/sqlite"
 )
 
 func init() {
@@ -29,13 +29,32 @@ func init() {
 
 func main() {
 	ctx := context.Background()
-	userStore, err := sqlite.NewStore(ctx, ":memory:")
+
+	cfg, err := config.Load(nil)
+	if err != nil {
+		slog.Error("Failed to load config", "error", err)
+		os.Exit(1)
+	}
+	if err := cfg.Validate(config.EntryLambda); err != nil {
+		slog.Error("Invalid config", "error", err)
+		os.Exit(1)
+	}
+
+	// Events recorded in the outbox are not relayed from the lambda, whose
+	// instances are frozen between requests; run `cli events relay` instead.
+	// Nor are stores given an in-memory search index, so SearchUsers is
+	// unimplemented unless the store searches natively.
+	userStore, err := cfg.Store.OpenUserStore(ctx)
 	if err != nil {
-		panic(err)
+		slog.Error("Failed to open user store", "error", err)
+		os.Exit(1)
 	}
 
 	// Create server
-	srv := server.NewServer(0, userStore) // Port doesn't matter for lambda
+	srv := server.NewServer(0, userStore, // Port doesn't matter for lambda
+		server.WithIdempotencyTTL(cfg.Idempotency.TTL),
+		server.WithIdempotencyLease(cfg.Idempotency.Lease),
+	)
 
 	// Create handler
 	handler, err := srv.CreateHandler(ctx)
@@ -0,0 +1,523 @@
+// Package config builds the effective configuration for every entry point.
+//
+// Values are merged in order of increasing precedence: built in defaults, an
+// optional YAML file, API_* environment variables, and finally command line
+// flags that were explicitly set.
+package config
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/spf13/pflag"
+	"gopkg.in/yaml.v3"
+)
+
+// EnvConfigFile names the environment variable holding the config file path.
+const EnvConfigFile = "API_CONFIG"
+
+var ErrInvalidConfig = errors.New("invalid config")
+
+// EntryPoint identifies the binary or command the configuration is for.
+// Some settings are only valid for some entry points.
+type EntryPoint string
+
+const (
+	EntryServe  EntryPoint = "serve"
+	EntryLambda EntryPoint = "lambda"
+	EntryCLI    EntryPoint = "cli"
+)
+
+type StoreType string
+
+const (
+	StoreSQLite   StoreType = "sqlite"
+	StoreDynamoDB StoreType = "dynamodb"
+	StorePostgres StoreType = "postgres"
+	StoreMemory   StoreType = "memory"
+)
+
+type Config struct {
+	Store  StoreConfig  `yaml:"store"`
+	Events EventsConfig `yaml:"events"`
+	Purge  PurgeConfig  `yaml:"purge"`
+
+	Idempotency IdempotencyConfig `yaml:"idempotency"`
+}
+
+// StoreConfig selects the user store backend. When URL is set it is passed
+// to store.Open as is; otherwise only the section matching Type is used.
+type StoreConfig struct {
+	URL      string         `yaml:"url,omitempty"`
+	Type     StoreType      `yaml:"type"`
+	SQLite   SQLiteConfig   `yaml:"sqlite"`
+	DynamoDB DynamoDBConfig `yaml:"dynamodb"`
+	Postgres PostgresConfig `yaml:"postgres"`
+	Cache    CacheConfig    `yaml:"cache"`
+	Search   SearchConfig   `yaml:"search"`
+}
+
+type SQLiteConfig struct {
+	Path string `yaml:"path"`
+}
+
+// PostgresConfig holds a postgres:// connection URL as understood by pgx,
+// e.g. postgres://app@localhost:5432/app?sslmode=disable.
+type PostgresConfig struct {
+	URL string `yaml:"url"`
+}
+
+type DynamoDBConfig struct {
+	Table    string `yaml:"table"`
+	Region   string `yaml:"region,omitempty"`
+	Endpoint string `yaml:"endpoint,omitempty"`
+}
+
+// CacheConfig puts a cache of users in front of the store. It is disabled
+// while Size is zero.
+type CacheConfig struct {
+	Size        int           `yaml:"size"`
+	TTL         time.Duration `yaml:"ttl"`
+	NegativeTTL time.Duration `yaml:"negative_ttl"`
+}
+
+// SearchConfig sets how users are searched in stores that cannot search
+// natively. SearchUsers fails as unimplemented in them unless MemoryIndex is
+// set.
+type SearchConfig struct {
+	// MemoryIndex searches an in-memory index of the users, built by the
+	// first search of each tenant. It only sees the writes of its own
+	// process, so it suits a single instance.
+	MemoryIndex bool `yaml:"memory_index"`
+}
+
+// EventsConfig selects the sinks that the events recorded with each change
+// to a user are relayed to. Events are only relayed while a sink is set.
+type EventsConfig struct {
+	// Log logs each event.
+	Log bool `yaml:"log"`
+	// File appends each event to the file at the path as a line of JSON.
+	File string `yaml:"file,omitempty"`
+	// Webhook posts each event to the URL as JSON.
+	Webhook string `yaml:"webhook,omitempty"`
+	// DeadLetter appends the events a sink keeps failing to take to the file
+	// at the path, as lines of JSON, so that they stop holding back the rest.
+	DeadLetter string `yaml:"dead_letter,omitempty"`
+	// Interval is how often the outbox is checked for new events.
+	Interval time.Duration `yaml:"interval"`
+}
+
+// PurgeConfig sets how long deleted users are kept, so that they can be
+// undeleted, before they are purged for good. Users are never purged while
+// Retention is zero.
+type PurgeConfig struct {
+	Retention time.Duration `yaml:"retention"`
+	// Interval is how often deleted users are checked for ones to purge.
+	Interval time.Duration `yaml:"interval"`
+}
+
+// IdempotencyConfig sets how long the response to a request made with an
+// idempotency key is kept, to answer retries of it with. Idempotency keys are
+// ignored while TTL is zero.
+type IdempotencyConfig struct {
+	TTL time.Duration `yaml:"ttl"`
+	// Lease is how long a request in progress holds its key, after which a
+	// retry may make it again, as when the server stopped before answering.
+	Lease time.Duration `yaml:"lease"`
+}
+
+// setting ties a single value to its flag and environment variable. value
+// returns a *string, *bool, *int or *time.Duration.
+type setting struct {
+	flag  string
+	env   string
+	usage string
+	value func(*Config) any
+}
+
+// set parses v into the setting's value in cfg.
+func (s setting) set(cfg *Config, v string) error {
+	var err error
+	switch p := s.value(cfg).(type) {
+	case *string:
+		*p = v
+	case *bool:
+		*p, err = strconv.ParseBool(v)
+	case *int:
+		*p, err = strconv.Atoi(v)
+	case *time.Duration:
+		*p, err = time.ParseDuration(v)
+	default:
+		err = fmt.Errorf("unsupported type %T", p)
+	}
+	if err != nil {
+		return fmt.Errorf("%w: %s: %w", ErrInvalidConfig, s.flag, err)
+	}
+	return nil
+}
+
+var settings = []setting{
+	{
+		flag:  "store-url",
+		env:   "API_STORE_URL",
+		usage: "User store URL, e.g. sqlite:///var/lib/app.db?journal=wal; overrides the other store settings",
+		value: func(c *Config) any { return &c.Store.URL },
+	},
+	{
+		flag:  "store",
+		env:   "API_STORE",
+		usage: "User store backend: memory, sqlite, dynamodb or postgres",
+		value: func(c *Config) any { return (*string)(&c.Store.Type) },
+	},
+	{
+		flag:  "sqlite-path",
+		env:   "API_SQLITE_PATH",
+		usage: "Path to the sqlite database, or :memory:",
+		value: func(c *Config) any { return &c.Store.SQLite.Path },
+	},
+	{
+		flag:  "dynamodb-table",
+		env:   "API_DYNAMODB_TABLE",
+		usage: "DynamoDB table name",
+		value: func(c *Config) any { return &c.Store.DynamoDB.Table },
+	},
+	{
+		flag:  "dynamodb-region",
+		env:   "API_DYNAMODB_REGION",
+		usage: "DynamoDB region (default: from the AWS configuration)",
+		value: func(c *Config) any { return &c.Store.DynamoDB.Region },
+	},
+	{
+		flag:  "dynamodb-endpoint",
+		env:   "API_DYNAMODB_ENDPOINT",
+		usage: "Custom DynamoDB endpoint, e.g. http://localhost:4566",
+		value: func(c *Config) any { return &c.Store.DynamoDB.Endpoint },
+	},
+	{
+		flag:  "postgres-url",
+		env:   "API_POSTGRES_URL",
+		usage: "Postgres connection URL, e.g. postgres://app@localhost:5432/app",
+		value: func(c *Config) any { return &c.Store.Postgres.URL },
+	},
+	{
+		flag:  "cache-size",
+		env:   "API_CACHE_SIZE",
+		usage: "How many users to cache in front of the store; 0 disables the cache",
+		value: func(c *Config) any { return &c.Store.Cache.Size },
+	},
+	{
+		flag:  "cache-ttl",
+		env:   "API_CACHE_TTL",
+		usage: "How long to cache users for, e.g. 30s",
+		value: func(c *Config) any { return &c.Store.Cache.TTL },
+	},
+	{
+		flag:  "cache-negative-ttl",
+		env:   "API_CACHE_NEGATIVE_TTL",
+		usage: "How long to cache users that were not found; 0 disables caching them",
+		value: func(c *Config) any { return &c.Store.Cache.NegativeTTL },
+	},
+	{
+		flag:  "search-memory-index",
+		env:   "API_SEARCH_MEMORY_INDEX",
+		usage: "Search stores that cannot search natively through an in-memory index: true or false",
+		value: func(c *Config) any { return &c.Store.Search.MemoryIndex },
+	},
+	{
+		flag:  "events-log",
+		env:   "API_EVENTS_LOG",
+		usage: "Log each user event: true or false",
+		value: func(c *Config) any { return &c.Events.Log },
+	},
+	{
+		flag:  "events-file",
+		env:   "API_EVENTS_FILE",
+		usage: "Append each user event to this file as a line of JSON",
+		value: func(c *Config) any { return &c.Events.File },
+	},
+	{
+		flag:  "events-webhook",
+		env:   "API_EVENTS_WEBHOOK",
+		usage: "Post each user event to this URL as JSON",
+		value: func(c *Config) any { return &c.Events.Webhook },
+	},
+	{
+		flag:  "events-dead-letter",
+		env:   "API_EVENTS_DEAD_LETTER",
+		usage: "Append user events that a sink keeps failing to take to this file as lines of JSON",
+		value: func(c *Config) any { return &c.Events.DeadLetter },
+	},
+	{
+		flag:  "events-interval",
+		env:   "API_EVENTS_INTERVAL",
+		usage: "How often to check for user events to relay, e.g. 1s",
+		value: func(c *Config) any { return &c.Events.Interval },
+	},
+	{
+		flag:  "purge-retention",
+		env:   "API_PURGE_RETENTION",
+		usage: "How long to keep deleted users before purging them, e.g. 720h; 0 disables purging",
+		value: func(c *Config) any { return &c.Purge.Retention },
+	},
+	{
+		flag:  "purge-interval",
+		env:   "API_PURGE_INTERVAL",
+		usage: "How often to check for deleted users to purge, e.g. 1h",
+		value: func(c *Config) any { return &c.Purge.Interval },
+	},
+	{
+		flag:  "idempotency-ttl",
+		env:   "API_IDEMPOTENCY_TTL",
+		usage: "How long to keep responses to requests with idempotency keys, e.g. 24h; 0 ignores the keys",
+		value: func(c *Config) any { return &c.Idempotency.TTL },
+	},
+	{
+		flag:  "idempotency-lease",
+		env:   "API_IDEMPOTENCY_LEASE",
+		usage: "How long a request with an idempotency key holds it while in progress, e.g. 1m",
+		value: func(c *Config) any { return &c.Idempotency.Lease },
+	},
+}
+
+// Default returns the configuration used when nothing else is set: an
+// in-memory store, uncached, whose events are not relayed, whose deleted
+// users are purged after 30 days, and which answers retries of requests with
+// idempotency keys for a day.
+func Default() Config {
+	return Config{
+		Store: StoreConfig{
+			Type:     StoreMemory,
+			SQLite:   SQLiteConfig{Path: ":memory:"},
+			DynamoDB: DynamoDBConfig{Table: "users"},
+			Cache: CacheConfig{
+				TTL:         time.Minute,
+				NegativeTTL: 5 * time.Second,
+			},
+		},
+		Events: EventsConfig{
+			Interval: time.Second,
+		},
+		Purge: PurgeConfig{
+			Retention: 30 * 24 * time.Hour,
+			Interval:  time.Hour,
+		},
+		Idempotency: IdempotencyConfig{
+			TTL:   24 * time.Hour,
+			Lease: time.Minute,
+		},
+	}
+}
+
+// BindFlags registers a flag for every setting, plus --config.
+func BindFlags(flags *pflag.FlagSet) {
+	flags.String("config", "", fmt.Sprintf("Path to a YAML config file (env: %s)", EnvConfigFile))
+	var cfg Config
+	for _, s := range settings {
+		flags.String(s.flag, "", fmt.Sprintf("%s (env: %s)", s.usage, s.env))
+		// Boolean settings may be given as a bare flag, like --events-log.
+		if _, ok := s.value(&cfg).(*bool); ok {
+			flags.Lookup(s.flag).NoOptDefVal = "true"
+		}
+	}
+}
+
+// Load builds the effective configuration. flags may be nil, in which case
+// only defaults, the config file and the environment are considered.
+func Load(flags *pflag.FlagSet) (*Config, error) {
+	cfg := Default()
+
+	path := os.Getenv(EnvConfigFile)
+	if flags != nil {
+		if f := flags.Lookup("config"); f != nil && f.Changed {
+			path = f.Value.String()
+		}
+	}
+
+	if path != "" {
+		if err := loadFile(&cfg, path); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, s := range settings {
+		if v, ok := os.LookupEnv(s.env); ok {
+			if err := s.set(&cfg, v); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if flags != nil {
+		for _, s := range settings {
+			if f := flags.Lookup(s.flag); f != nil && f.Changed {
+				if err := s.set(&cfg, f.Value.String()); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+
+	return &cfg, nil
+}
+
+func loadFile(cfg *Config, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("could not open config file: %w", err)
+	}
+	defer func() { _ = f.Close() }()
+
+	dec := yaml.NewDecoder(f)
+	dec.KnownFields(true)
+	if err := dec.Decode(cfg); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("%w: %s: %w", ErrInvalidConfig, path, err)
+	}
+
+	return nil
+}
+
+// Validate reports whether the configuration can be used by entry.
+func (c *Config) Validate(entry EntryPoint) error {
+	if c.Store.URL == "" {
+		if err := c.Store.validateTyped(); err != nil {
+			return err
+		}
+	}
+
+	if err := c.Store.Cache.validate(); err != nil {
+		return err
+	}
+	if err := c.Events.validate(); err != nil {
+		return err
+	}
+	if err := c.Purge.validate(); err != nil {
+		return err
+	}
+	if err := c.Idempotency.validate(); err != nil {
+		return err
+	}
+
+	u, err := url.Parse(c.Store.OpenURL())
+	if err != nil {
+		return fmt.Errorf("%w: store url: %w", ErrInvalidConfig, err)
+	}
+
+	// Lambda instances are ephemeral and share neither memory nor a
+	// filesystem, so these stores would silently lose data.
+	if entry == EntryLambda && (u.Scheme == string(StoreSQLite) || u.Scheme == string(StoreMemory)) {
+		return fmt.Errorf("%w: the %s store cannot be used from %s", ErrInvalidConfig, u.Scheme, entry)
+	}
+	// Nor would an in-memory index see the writes of other instances.
+	if entry == EntryLambda && c.Store.Search.MemoryIndex {
+		return fmt.Errorf("%w: store.search.memory_index cannot be used from %s", ErrInvalidConfig, entry)
+	}
+
+	return nil
+}
+
+func (c StoreConfig) validateTyped() error {
+	switch c.Type {
+	case StoreMemory:
+	case StoreSQLite:
+		if c.SQLite.Path == "" {
+			return fmt.Errorf("%w: store.sqlite.path is required", ErrInvalidConfig)
+		}
+	case StoreDynamoDB:
+		if c.DynamoDB.Table == "" {
+			return fmt.Errorf("%w: store.dynamodb.table is required", ErrInvalidConfig)
+		}
+	case StorePostgres:
+		if c.Postgres.URL == "" {
+			return fmt.Errorf("%w: store.postgres.url is required", ErrInvalidConfig)
+		}
+	default:
+		return fmt.Errorf("%w: unknown store type %q", ErrInvalidConfig, c.Type)
+	}
+
+	return nil
+}
+
+func (c CacheConfig) validate() error {
+	switch {
+	case c.Size < 0:
+		return fmt.Errorf("%w: store.cache.size must not be negative", ErrInvalidConfig)
+	case c.Size > 0 && c.TTL <= 0:
+		return fmt.Errorf("%w: store.cache.ttl must be positive", ErrInvalidConfig)
+	case c.NegativeTTL < 0:
+		return fmt.Errorf("%w: store.cache.negative_ttl must not be negative", ErrInvalidConfig)
+	}
+
+	return nil
+}
+
+func (c EventsConfig) validate() error {
+	if c.Webhook != "" {
+		u, err := url.Parse(c.Webhook)
+		if err != nil {
+			return fmt.Errorf("%w: events.webhook: %w", ErrInvalidConfig, err)
+		}
+		if u.Scheme != "http" && u.Scheme != "https" {
+			return fmt.Errorf("%w: events.webhook must be an http or https URL", ErrInvalidConfig)
+		}
+	}
+	if c.Enabled() && c.Interval <= 0 {
+		return fmt.Errorf("%w: events.interval must be positive", ErrInvalidConfig)
+	}
+
+	return nil
+}
+
+func (c PurgeConfig) validate() error {
+	switch {
+	case c.Retention < 0:
+		return fmt.Errorf("%w: purge.retention must not be negative", ErrInvalidConfig)
+	case c.Enabled() && c.Interval <= 0:
+		return fmt.Errorf("%w: purge.interval must be positive", ErrInvalidConfig)
+	}
+
+	return nil
+}
+
+func (c IdempotencyConfig) validate() error {
+	switch {
+	case c.TTL < 0:
+		return fmt.Errorf("%w: idempotency.ttl must not be negative", ErrInvalidConfig)
+	case c.TTL > 0 && c.Lease <= 0:
+		return fmt.Errorf("%w: idempotency.lease must be positive", ErrInvalidConfig)
+	}
+
+	return nil
+}
+
+// Write prints the configuration as YAML.
+func (c *Config) Write(w io.Writer) error {
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	if err := enc.Encode(c); err != nil {
+		return err
+	}
+	return enc.Close()
+}
+
+type contextKey struct{}
+
+// NewContext returns a copy of ctx carrying cfg.
+func NewContext(ctx context.Context, cfg *Config) context.Context {
+	return context.WithValue(ctx, contextKey{}, cfg)
+}
+
+// FromContext returns the configuration stored by NewContext, or the defaults
+// if there is none.
+func FromContext(ctx context.Context) *Config {
+	if cfg, ok := ctx.Value(contextKey{}).(*Config); ok {
+		return cfg
+	}
+	cfg := Default()
+	return &cfg
+}
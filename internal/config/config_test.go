@@ -0,0 +1,286 @@
+package config
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/spf13/pflag"
+
+	"github.com/andrew-womeldorf/connect-boilerplate/internal/services/user/store"
+)
+
+func TestLoadPrecedence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	contents := `store:
+  type: dynamodb
+  dynamodb:
+    table: from-file
+    region: us-west-2
+`
+	if err := os.WriteFile(path, []byte(contents), 0o600); err != nil {
+		t.Fatalf("failed to write config file: %v", err)
+	}
+
+	t.Setenv(EnvConfigFile, path)
+	t.Setenv("API_DYNAMODB_TABLE", "from-env")
+	t.Setenv("API_DYNAMODB_REGION", "eu-west-1")
+
+	flags := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	BindFlags(flags)
+	if err := flags.Parse([]string{"--dynamodb-region", "ap-south-1"}); err != nil {
+		t.Fatalf("failed to parse flags: %v", err)
+	}
+
+	cfg, err := Load(flags)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	if cfg.Store.Type != StoreDynamoDB {
+		t.Errorf("expected store type from file, got %q", cfg.Store.Type)
+	}
+	if cfg.Store.DynamoDB.Table != "from-env" {
+		t.Errorf("expected table from env, got %q", cfg.Store.DynamoDB.Table)
+	}
+	if cfg.Store.DynamoDB.Region != "ap-south-1" {
+		t.Errorf("expected region from flag, got %q", cfg.Store.DynamoDB.Region)
+	}
+	if cfg.Store.SQLite.Path != ":memory:" {
+		t.Errorf("expected default sqlite path, got %q", cfg.Store.SQLite.Path)
+	}
+}
+
+func TestOpenURLSQLite(t *testing.T) {
+	ctx := context.Background()
+
+	for _, name := range []string{"app.db", "a?b.db", "a#b.db", "a%20b.db", "a b.db"} {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), name)
+			cfg := StoreConfig{Type: StoreSQLite, SQLite: SQLiteConfig{Path: path}}
+
+			s, err := store.Open(ctx, cfg.OpenURL())
+			if err != nil {
+				t.Fatalf("failed to open %s: %v", cfg.OpenURL(), err)
+			}
+			if closer, ok := s.(io.Closer); ok {
+				t.Cleanup(func() { _ = closer.Close() })
+			}
+
+			if _, err := os.Stat(path); err != nil {
+				t.Errorf("expected the database at %q: %v", path, err)
+			}
+		})
+	}
+}
+
+func TestLoadCache(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	contents := `store:
+  cache:
+    size: 100
+    ttl: 30s
+`
+	if err := os.WriteFile(path, []byte(contents), 0o600); err != nil {
+		t.Fatalf("failed to write config file: %v", err)
+	}
+
+	t.Setenv(EnvConfigFile, path)
+	t.Setenv("API_CACHE_NEGATIVE_TTL", "1s")
+
+	flags := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	BindFlags(flags)
+	if err := flags.Parse([]string{"--cache-size", "500"}); err != nil {
+		t.Fatalf("failed to parse flags: %v", err)
+	}
+
+	cfg, err := Load(flags)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	want := CacheConfig{Size: 500, TTL: 30 * time.Second, NegativeTTL: time.Second}
+	if cfg.Store.Cache != want {
+		t.Errorf("expected cache config %+v, got %+v", want, cfg.Store.Cache)
+	}
+
+	t.Setenv("API_CACHE_TTL", "soon")
+	if _, err := Load(nil); !errors.Is(err, ErrInvalidConfig) {
+		t.Errorf("expected ErrInvalidConfig for an unparsable ttl, got %v", err)
+	}
+}
+
+func TestOpenUserStoreSearch(t *testing.T) {
+	ctx := context.Background()
+
+	cfg := Default()
+	s, err := cfg.Store.OpenUserStore(ctx)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if _, ok := s.(store.Searcher); ok {
+		t.Error("expected the memory store not to search without an index")
+	}
+
+	t.Setenv("API_SEARCH_MEMORY_INDEX", "true")
+	loaded, err := Load(nil)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	s, err = loaded.Store.OpenUserStore(ctx)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if _, ok := s.(store.Searcher); !ok {
+		t.Error("expected the memory store to search through an in-memory index")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		store   StoreConfig
+		entry   EntryPoint
+		wantErr bool
+	}{
+		{"memory_serve", StoreConfig{Type: StoreMemory}, EntryServe, false},
+		{"memory_lambda", StoreConfig{Type: StoreMemory}, EntryLambda, true},
+		{"sqlite_serve", StoreConfig{Type: StoreSQLite, SQLite: SQLiteConfig{Path: "app.db"}}, EntryServe, false},
+		{"sqlite_lambda", StoreConfig{Type: StoreSQLite, SQLite: SQLiteConfig{Path: "app.db"}}, EntryLambda, true},
+		{"sqlite_without_path", StoreConfig{Type: StoreSQLite}, EntryCLI, true},
+		{"dynamodb_lambda", StoreConfig{Type: StoreDynamoDB, DynamoDB: DynamoDBConfig{Table: "users"}}, EntryLambda, false},
+		{"dynamodb_without_table", StoreConfig{Type: StoreDynamoDB}, EntryServe, true},
+		{"postgres_lambda", StoreConfig{Type: StorePostgres, Postgres: PostgresConfig{URL: "postgres://localhost/app"}}, EntryLambda, false},
+		{"postgres_without_url", StoreConfig{Type: StorePostgres}, EntryServe, true},
+		{"unknown_type", StoreConfig{Type: "mysql"}, EntryServe, true},
+		{"memory_index_serve", StoreConfig{Type: StorePostgres, Postgres: PostgresConfig{URL: "postgres://localhost/app"}, Search: SearchConfig{MemoryIndex: true}}, EntryServe, false},
+		{"memory_index_lambda", StoreConfig{Type: StorePostgres, Postgres: PostgresConfig{URL: "postgres://localhost/app"}, Search: SearchConfig{MemoryIndex: true}}, EntryLambda, true},
+		{"cache", StoreConfig{Type: StoreMemory, Cache: CacheConfig{Size: 10, TTL: time.Minute}}, EntryServe, false},
+		{"cache_negative_size", StoreConfig{Type: StoreMemory, Cache: CacheConfig{Size: -1}}, EntryServe, true},
+		{"cache_without_ttl", StoreConfig{Type: StoreMemory, Cache: CacheConfig{Size: 10}}, EntryServe, true},
+		{"cache_negative_ttl", StoreConfig{Type: StoreMemory, Cache: CacheConfig{Size: 10, TTL: time.Minute, NegativeTTL: -time.Second}}, EntryServe, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{Store: tt.store}
+			err := cfg.Validate(tt.entry)
+			if tt.wantErr && !errors.Is(err, ErrInvalidConfig) {
+				t.Fatalf("expected ErrInvalidConfig, got %v", err)
+			}
+			if !tt.wantErr && err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+		})
+	}
+}
+
+func TestLoadEvents(t *testing.T) {
+	t.Setenv("API_EVENTS_WEBHOOK", "https://example.com/events")
+
+	// A bare boolean flag must not take the next argument as its value.
+	flags := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	BindFlags(flags)
+	if err := flags.Parse([]string{"--events-log", "--store", "memory"}); err != nil {
+		t.Fatalf("failed to parse flags: %v", err)
+	}
+
+	cfg, err := Load(flags)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+
+	want := EventsConfig{Log: true, Webhook: "https://example.com/events", Interval: time.Second}
+	if cfg.Events != want {
+		t.Errorf("expected events config %+v, got %+v", want, cfg.Events)
+	}
+	if sinks := cfg.Events.Sinks(); len(sinks) != 2 {
+		t.Errorf("expected 2 sinks, got %d", len(sinks))
+	}
+}
+
+func TestValidateEvents(t *testing.T) {
+	tests := []struct {
+		name    string
+		events  EventsConfig
+		wantErr bool
+	}{
+		{"disabled", EventsConfig{}, false},
+		{"log", EventsConfig{Log: true, Interval: time.Second}, false},
+		{"webhook", EventsConfig{Webhook: "http://localhost:9000/hook", Interval: time.Second}, false},
+		{"webhook_bad_scheme", EventsConfig{Webhook: "ftp://localhost/hook", Interval: time.Second}, true},
+		{"without_interval", EventsConfig{File: "events.jsonl"}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{Store: StoreConfig{Type: StoreMemory}, Events: tt.events}
+			err := cfg.Validate(EntryServe)
+			if tt.wantErr && !errors.Is(err, ErrInvalidConfig) {
+				t.Fatalf("expected ErrInvalidConfig, got %v", err)
+			}
+			if !tt.wantErr && err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+		})
+	}
+}
+
+func TestValidatePurge(t *testing.T) {
+	tests := []struct {
+		name    string
+		purge   PurgeConfig
+		wantErr bool
+	}{
+		{"disabled", PurgeConfig{}, false},
+		{"enabled", PurgeConfig{Retention: time.Hour, Interval: time.Minute}, false},
+		{"negative_retention", PurgeConfig{Retention: -time.Hour, Interval: time.Minute}, true},
+		{"without_interval", PurgeConfig{Retention: time.Hour}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{Store: StoreConfig{Type: StoreMemory}, Purge: tt.purge}
+			err := cfg.Validate(EntryServe)
+			if tt.wantErr && !errors.Is(err, ErrInvalidConfig) {
+				t.Fatalf("expected ErrInvalidConfig, got %v", err)
+			}
+			if !tt.wantErr && err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if job := tt.purge.NewJob(nil); (job != nil) != tt.purge.Enabled() {
+				t.Errorf("expected a job only when enabled, got %v", job)
+			}
+		})
+	}
+}
+
+func TestValidateIdempotency(t *testing.T) {
+	tests := []struct {
+		name        string
+		idempotency IdempotencyConfig
+		wantErr     bool
+	}{
+		{"disabled", IdempotencyConfig{}, false},
+		{"enabled", IdempotencyConfig{TTL: time.Hour, Lease: time.Minute}, false},
+		{"negative_ttl", IdempotencyConfig{TTL: -time.Hour}, true},
+		{"without_lease", IdempotencyConfig{TTL: time.Hour}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{Store: StoreConfig{Type: StoreMemory}, Idempotency: tt.idempotency}
+			err := cfg.Validate(EntryServe)
+			if tt.wantErr && !errors.Is(err, ErrInvalidConfig) {
+				t.Fatalf("expected ErrInvalidConfig, got %v", err)
+			}
+			if !tt.wantErr && err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+		})
+	}
+}
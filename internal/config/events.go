@@ -0,0 +1,40 @@
+package config
+
+import (
+	"github.com/andrew-womeldorf/connect-boilerplate/internal/services/user/events"
+	"github.com/andrew-womeldorf/connect-boilerplate/internal/services/user/store"
+)
+
+// Enabled reports whether any sink is set.
+func (c EventsConfig) Enabled() bool {
+	return c.Log || c.File != "" || c.Webhook != ""
+}
+
+// Sinks constructs the sinks selected by the configuration.
+func (c EventsConfig) Sinks() []events.Sink {
+	var sinks []events.Sink
+	if c.Log {
+		sinks = append(sinks, events.LogSink{})
+	}
+	if c.File != "" {
+		sinks = append(sinks, events.NewFileSink(c.File))
+	}
+	if c.Webhook != "" {
+		sinks = append(sinks, events.NewWebhookSink(c.Webhook, nil))
+	}
+	return sinks
+}
+
+// NewRelay constructs a relay from outbox to the configured sinks, or returns
+// nil if there are none.
+func (c EventsConfig) NewRelay(outbox store.Outbox) *events.Relay {
+	if !c.Enabled() {
+		return nil
+	}
+
+	opts := []events.Option{events.WithInterval(c.Interval)}
+	if c.DeadLetter != "" {
+		opts = append(opts, events.WithDeadLetter(events.NewFileSink(c.DeadLetter)))
+	}
+	return events.NewRelay(outbox, c.Sinks(), opts...)
+}
@@ -0,0 +1,20 @@
+package config
+
+import (
+	"github.com/andrew-womeldorf/connect-boilerplate/internal/services/user/purge"
+	"github.com/andrew-womeldorf/connect-boilerplate/internal/services/user/store"
+)
+
+// Enabled reports whether deleted users are purged.
+func (c PurgeConfig) Enabled() bool {
+	return c.Retention > 0
+}
+
+// NewJob constructs a job purging the deleted users of purger, or returns
+// nil if purging is disabled.
+func (c PurgeConfig) NewJob(purger store.Purger) *purge.Job {
+	if !c.Enabled() {
+		return nil
+	}
+	return purge.NewJob(purger, c.Retention, purge.WithInterval(c.Interval))
+}
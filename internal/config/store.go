@@ -0,0 +1,74 @@
+package config
+
+import (
+	"context"
+	"net/url"
+	"strings"
+
+	"github.com/andrew-womeldorf/connect-boilerplate/internal/services/user/store"
+	"github.com/andrew-womeldorf/connect-boilerplate/internal/services/user/store/cache"
+	"github.com/andrew-womeldorf/connect-boilerplate/internal/services/user/store/search"
+
+	// Register the store backends with store.Open
+	_ "github.com/andrew-womeldorf/connect-boilerplate/internal/services/user/store/dynamodb"
+	_ "github.com/andrew-womeldorf/connect-boilerplate/internal/services/user/store/memory"
+	_ "github.com/andrew-womeldorf/connect-boilerplate/internal/services/user/store/postgres"
+	_ "github.com/andrew-womeldorf/connect-boilerplate/internal/services/user/store/sqlite"
+)
+
+// OpenURL returns the store URL described by the configuration.
+func (c StoreConfig) OpenURL() string {
+	if c.URL != "" {
+		return c.URL
+	}
+
+	switch c.Type {
+	case StoreMemory:
+		return string(StoreMemory) + ":"
+	case StoreSQLite:
+		// Opaque is written as is, so each segment of the path is escaped
+		// for characters such as '?', '#' and '%' to keep their meaning.
+		segments := strings.Split(c.SQLite.Path, "/")
+		for i, segment := range segments {
+			segments[i] = url.PathEscape(segment)
+		}
+		return (&url.URL{Scheme: string(StoreSQLite), Opaque: strings.Join(segments, "/")}).String()
+	case StoreDynamoDB:
+		q := url.Values{}
+		if c.DynamoDB.Region != "" {
+			q.Set("region", c.DynamoDB.Region)
+		}
+		if c.DynamoDB.Endpoint != "" {
+			q.Set("endpoint", c.DynamoDB.Endpoint)
+		}
+		return (&url.URL{Scheme: string(StoreDynamoDB), Host: c.DynamoDB.Table, RawQuery: q.Encode()}).String()
+	case StorePostgres:
+		return c.Postgres.URL
+	default:
+		return string(c.Type) + ":"
+	}
+}
+
+// OpenUserStore constructs the user store selected by the configuration.
+// Stores that cannot search natively are searched through an in-memory
+// index when Search.MemoryIndex is set. The result is cached when Cache.Size
+// is set.
+func (c StoreConfig) OpenUserStore(ctx context.Context) (store.Store, error) {
+	s, err := store.Open(ctx, c.OpenURL())
+	if err != nil {
+		return nil, err
+	}
+
+	if _, ok := s.(store.Searcher); !ok && c.Search.MemoryIndex {
+		s = search.New(s, search.NewMemoryIndex())
+	}
+
+	if c.Cache.Size > 0 {
+		s = cache.New(s,
+			cache.WithSize(c.Cache.Size),
+			cache.WithTTL(c.Cache.TTL),
+			cache.WithNegativeTTL(c.Cache.NegativeTTL),
+		)
+	}
+	return s, nil
+}
@@ -0,0 +1,92 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+
+	"connectrpc.com/connect"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+
+	"github.com/andrew-womeldorf/connect-boilerplate/internal/services/user"
+	"github.com/andrew-womeldorf/connect-boilerplate/internal/services/user/store"
+)
+
+const errorDomain = "user.v1"
+
+// connectError translates errors returned by the user service into Connect
+// errors, so clients see a meaningful code instead of "unknown". Errors the
+// service recognizes carry an ErrorInfo detail with their user.ErrorReason,
+// and requests that broke the rules of their message a BadRequest detail.
+func connectError(ctx context.Context, err error) error {
+	var connectErr *connect.Error
+	if errors.As(err, &connectErr) {
+		return err
+	}
+
+	if errors.Is(err, context.Canceled) {
+		return connect.NewError(connect.CodeCanceled, err)
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return connect.NewError(connect.CodeDeadlineExceeded, err)
+	}
+
+	reason := user.ErrorReason(err)
+
+	var code connect.Code
+	switch reason {
+	case user.ReasonInvalidArgument:
+		code = connect.CodeInvalidArgument
+	case user.ReasonNotFound:
+		code = connect.CodeNotFound
+	case user.ReasonAlreadyExists:
+		code = connect.CodeAlreadyExists
+	case user.ReasonConflict:
+		code = connect.CodeAborted
+	case user.ReasonPreconditionFailed:
+		code = connect.CodeFailedPrecondition
+	case user.ReasonUnavailable:
+		code = connect.CodeUnavailable
+	case user.ReasonUnimplemented:
+		code = connect.CodeUnimplemented
+	default:
+		// Internal failures, and errors the service does not recognize, are
+		// logged here and hidden from clients, since the cause may describe
+		// the backend.
+		slog.ErrorContext(ctx, "internal error", slog.Any("error", err))
+		code = connect.CodeInternal
+		err = errors.New(store.KindInternal.String())
+	}
+
+	cerr := connect.NewError(code, err)
+	if detail, detailErr := connect.NewErrorDetail(&errdetails.ErrorInfo{
+		Reason: reason,
+		Domain: errorDomain,
+	}); detailErr == nil {
+		cerr.AddDetail(detail)
+	}
+
+	var validationErr *user.ValidationError
+	if errors.As(err, &validationErr) {
+		if detail, detailErr := connect.NewErrorDetail(badRequest(validationErr)); detailErr == nil {
+			cerr.AddDetail(detail)
+		}
+	}
+
+	return cerr
+}
+
+// badRequest lists the field violations of a request that broke the rules of
+// its message.
+func badRequest(err *user.ValidationError) *errdetails.BadRequest {
+	detail := &errdetails.BadRequest{
+		FieldViolations: make([]*errdetails.BadRequest_FieldViolation, len(err.Violations)),
+	}
+	for i, v := range err.Violations {
+		detail.FieldViolations[i] = &errdetails.BadRequest_FieldViolation{
+			Field:       v.Field,
+			Description: v.Description,
+		}
+	}
+	return detail
+}
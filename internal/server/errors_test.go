@@ -0,0 +1,70 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"connectrpc.com/connect"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+
+	"github.com/andrew-womeldorf/connect-boilerplate/internal/services/user"
+	"github.com/andrew-womeldorf/connect-boilerplate/internal/services/user/store"
+)
+
+func TestConnectError(t *testing.T) {
+	cause := errors.New("table users-prod: connection refused")
+
+	tests := []struct {
+		name   string
+		err    error
+		code   connect.Code
+		reason string
+	}{
+		{"not found", store.NotFound(cause), connect.CodeNotFound, user.ReasonNotFound},
+		{"already exists", store.AlreadyExists(cause), connect.CodeAlreadyExists, user.ReasonAlreadyExists},
+		{"conflict", store.Conflict(cause), connect.CodeAborted, user.ReasonConflict},
+		{"precondition failed", store.PreconditionFailed(cause), connect.CodeFailedPrecondition, user.ReasonPreconditionFailed},
+		{"unavailable", store.Unavailable(cause), connect.CodeUnavailable, user.ReasonUnavailable},
+		{"internal", store.Internal(cause), connect.CodeInternal, user.ReasonInternal},
+		{"wrapped", fmt.Errorf("could not get user: %w", store.NotFound(cause)), connect.CodeNotFound, user.ReasonNotFound},
+		{"unrecognized", cause, connect.CodeInternal, user.ReasonInternal},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := connectError(context.Background(), tt.err)
+
+			var connectErr *connect.Error
+			if !errors.As(err, &connectErr) {
+				t.Fatalf("expected a connect error, got %v", err)
+			}
+			if connectErr.Code() != tt.code {
+				t.Errorf("expected %v, got %v", tt.code, connectErr.Code())
+			}
+
+			var reasons []string
+			for _, detail := range connectErr.Details() {
+				value, err := detail.Value()
+				if err != nil {
+					t.Fatalf("failed to read error detail: %v", err)
+				}
+				if info, ok := value.(*errdetails.ErrorInfo); ok {
+					reasons = append(reasons, info.GetReason())
+					if info.GetDomain() != errorDomain {
+						t.Errorf("expected domain %q, got %q", errorDomain, info.GetDomain())
+					}
+				}
+			}
+			if len(reasons) != 1 || reasons[0] != tt.reason {
+				t.Errorf("expected reason %q, got %q", tt.reason, reasons)
+			}
+
+			if tt.code == connect.CodeInternal && strings.Contains(connectErr.Message(), cause.Error()) {
+				t.Errorf("expected the cause hidden, got %q", connectErr.Message())
+			}
+		})
+	}
+}
@@ -0,0 +1,196 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"connectrpc.com/connect"
+	"google.golang.org/protobuf/proto"
+
+	pb "github.com/andrew-womeldorf/connect-boilerplate/gen/user/v1"
+	v1 "github.com/andrew-womeldorf/connect-boilerplate/gen/user/v1/userv1connect"
+	"github.com/andrew-womeldorf/connect-boilerplate/internal/services/user/store"
+)
+
+const (
+	// IdempotencyKeyHeader names the idempotency key of a request, which
+	// requests may instead set as their idempotency_key field. The header
+	// wins if both are set.
+	IdempotencyKeyHeader = "Idempotency-Key"
+
+	// IdempotentReplayedHeader is set to "true" on the response to a request
+	// answered with the response to an earlier one with its key.
+	IdempotentReplayedHeader = "Idempotent-Replayed"
+
+	// DefaultIdempotencyTTL is how long responses are kept to answer retries
+	// with, unless the server is given another TTL.
+	DefaultIdempotencyTTL = 24 * time.Hour
+
+	// DefaultIdempotencyLease is how long a request in progress holds its
+	// idempotency key, unless the server is given another lease.
+	DefaultIdempotencyLease = time.Minute
+)
+
+// idempotencyWriteTimeout bounds how long keeping the outcome of a request
+// may take once the request is over, when its context no longer does.
+const idempotencyWriteTimeout = 5 * time.Second
+
+// maxIdempotencyKeyLength bounds the keys callers may choose, which stores
+// build into their keys.
+const maxIdempotencyKeyLength = 255
+
+// idempotentProcedures are the procedures that accept idempotency keys: those
+// that change users. Each makes an empty response of the procedure, for a
+// kept response to be unmarshaled into.
+var idempotentProcedures = map[string]func() connect.AnyResponse{
+	v1.UserServiceCreateUserProcedure:       emptyResponse[pb.CreateUserResponse],
+	v1.UserServiceUpdateUserProcedure:       emptyResponse[pb.UpdateUserResponse],
+	v1.UserServiceDeleteUserProcedure:       emptyResponse[pb.DeleteUserResponse],
+	v1.UserServiceUndeleteUserProcedure:     emptyResponse[pb.UndeleteUserResponse],
+	v1.UserServiceBatchCreateUsersProcedure: emptyResponse[pb.BatchCreateUsersResponse],
+	v1.UserServiceBatchDeleteUsersProcedure: emptyResponse[pb.BatchDeleteUsersResponse],
+}
+
+func emptyResponse[T any]() connect.AnyResponse {
+	return connect.NewResponse(new(T))
+}
+
+// NewIdempotencyInterceptor makes requests that change users safe to retry.
+// A request with an idempotency key is made once, and its response kept in s
+// for ttl, in the request's tenant. Until then, requests repeating the key
+// are answered with that response rather than made again, and fail with
+// CodeAborted while the first is in progress, for up to lease, after which
+// a request that never finished, as when its server stopped, is taken to have
+// failed. A request reusing a key for a different request, to another
+// procedure or with other fields, fails with CodeInvalidArgument. Requests
+// that fail keep nothing, so that they may be retried, even when they fail
+// because their caller gave up. It must run after NewTenantInterceptor.
+func NewIdempotencyInterceptor(s store.IdempotencyStore, ttl, lease time.Duration) connect.Interceptor {
+	return connect.UnaryInterceptorFunc(func(next connect.UnaryFunc) connect.UnaryFunc {
+		return func(ctx context.Context, req connect.AnyRequest) (connect.AnyResponse, error) {
+			newResponse, ok := idempotentProcedures[req.Spec().Procedure]
+			if req.Spec().IsClient || !ok {
+				return next(ctx, req)
+			}
+
+			key := idempotencyKey(req)
+			if key == "" {
+				return next(ctx, req)
+			}
+			if len(key) > maxIdempotencyKeyLength {
+				return nil, connect.NewError(connect.CodeInvalidArgument,
+					fmt.Errorf("idempotency key must be at most %d characters", maxIdempotencyKeyLength))
+			}
+
+			fingerprint, err := requestFingerprint(req)
+			if err != nil {
+				return nil, connect.NewError(connect.CodeInternal, err)
+			}
+
+			record := store.IdempotencyRecord{
+				Key:         key,
+				Fingerprint: fingerprint,
+				ExpiresAt:   time.Now().Add(lease),
+			}
+			kept, err := s.ClaimIdempotencyKey(ctx, record)
+			if err != nil {
+				return nil, connectError(ctx, err)
+			}
+			if kept != nil {
+				return replay(kept, fingerprint, newResponse)
+			}
+
+			res, err := next(ctx, req)
+
+			// The outcome is kept even if the caller has given up, as it
+			// does when it cancels the request, so that its retry is answered
+			// rather than told the request is still in progress.
+			writeCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), idempotencyWriteTimeout)
+			defer cancel()
+
+			if err != nil {
+				if releaseErr := s.ReleaseIdempotencyKey(writeCtx, key); releaseErr != nil {
+					slog.ErrorContext(ctx, "could not release idempotency key",
+						slog.Any("error", releaseErr),
+						slog.String("idempotency key", key),
+					)
+				}
+				return nil, err
+			}
+
+			// The request was made, so it is answered even if its response
+			// cannot be kept. Its key stays claimed until its lease runs out,
+			// so that retries fail rather than make it again until then.
+			record.Response, err = proto.Marshal(res.Any().(proto.Message))
+			if err == nil {
+				record.ExpiresAt = time.Now().Add(ttl)
+				err = s.CompleteIdempotencyKey(writeCtx, record)
+			}
+			if err != nil {
+				slog.ErrorContext(ctx, "could not keep response for idempotency key",
+					slog.Any("error", err),
+					slog.String("idempotency key", key),
+				)
+			}
+
+			return res, nil
+		}
+	})
+}
+
+// idempotencyKey returns the idempotency key of req, from its header or else
+// its idempotency_key field, or "" if it has none.
+func idempotencyKey(req connect.AnyRequest) string {
+	if key := req.Header().Get(IdempotencyKeyHeader); key != "" {
+		return key
+	}
+	if msg, ok := req.Any().(interface{ GetIdempotencyKey() string }); ok {
+		return msg.GetIdempotencyKey()
+	}
+	return ""
+}
+
+// requestFingerprint identifies req by its procedure and fields, other than
+// its idempotency key, so that a retry of it has the same fingerprint
+// whichever way it sends its key.
+func requestFingerprint(req connect.AnyRequest) (string, error) {
+	msg := proto.Clone(req.Any().(proto.Message))
+	if field := msg.ProtoReflect().Descriptor().Fields().ByName("idempotency_key"); field != nil {
+		msg.ProtoReflect().Clear(field)
+	}
+
+	data, err := proto.MarshalOptions{Deterministic: true}.Marshal(msg)
+	if err != nil {
+		return "", fmt.Errorf("could not fingerprint request: %w", err)
+	}
+
+	h := sha256.New()
+	h.Write([]byte(req.Spec().Procedure))
+	h.Write([]byte{0})
+	h.Write(data)
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// replay answers the request with fingerprint from kept, the record already
+// kept with its key.
+func replay(kept *store.IdempotencyRecord, fingerprint string, newResponse func() connect.AnyResponse) (connect.AnyResponse, error) {
+	if kept.Fingerprint != fingerprint {
+		return nil, connect.NewError(connect.CodeInvalidArgument,
+			fmt.Errorf("idempotency key %q was used for a different request", kept.Key))
+	}
+	if !kept.Completed {
+		return nil, connect.NewError(connect.CodeAborted,
+			fmt.Errorf("a request with idempotency key %q is in progress", kept.Key))
+	}
+
+	res := newResponse()
+	if err := proto.Unmarshal(kept.Response, res.Any().(proto.Message)); err != nil {
+		return nil, connect.NewError(connect.CodeInternal, fmt.Errorf("could not read kept response: %w", err))
+	}
+	res.Header().Set(IdempotentReplayedHeader, "true")
+	return res, nil
+}
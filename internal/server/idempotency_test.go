@@ -0,0 +1,273 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"connectrpc.com/connect"
+
+	pb "github.com/andrew-womeldorf/connect-boilerplate/gen/user/v1"
+	v1 "github.com/andrew-womeldorf/connect-boilerplate/gen/user/v1/userv1connect"
+	"github.com/andrew-womeldorf/connect-boilerplate/internal/services/user/store"
+	"github.com/andrew-womeldorf/connect-boilerplate/internal/services/user/store/memory"
+)
+
+func TestIdempotencyInterceptor(t *testing.T) {
+	ctx := context.Background()
+	client := newTestClient(t)
+
+	create := func(key, tenant, email string) (*connect.Response[pb.CreateUserResponse], error) {
+		req := connect.NewRequest(&pb.CreateUserRequest{Name: "Jane Doe", Email: email})
+		req.Header().Set(IdempotencyKeyHeader, key)
+		if tenant != "" {
+			req.Header().Set(TenantHeader, tenant)
+		}
+		return client.CreateUser(ctx, req)
+	}
+
+	first, err := create("key-1", "", "jane@example.com")
+	if err != nil {
+		t.Fatalf("failed to create user: %v", err)
+	}
+	id := first.Msg.GetUser().GetId()
+	if first.Header().Get(IdempotentReplayedHeader) != "" {
+		t.Errorf("expected the first request not to be replayed")
+	}
+
+	t.Run("replays_response", func(t *testing.T) {
+		resp, err := create("key-1", "", "jane@example.com")
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if resp.Msg.GetUser().GetId() != id {
+			t.Errorf("expected user %q, got %q", id, resp.Msg.GetUser().GetId())
+		}
+		if resp.Header().Get(IdempotentReplayedHeader) != "true" {
+			t.Errorf("expected the response marked replayed")
+		}
+	})
+
+	t.Run("key_in_field", func(t *testing.T) {
+		resp, err := client.CreateUser(ctx, connect.NewRequest(&pb.CreateUserRequest{
+			Name:           "Jane Doe",
+			Email:          "jane@example.com",
+			IdempotencyKey: "key-1",
+		}))
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if resp.Msg.GetUser().GetId() != id {
+			t.Errorf("expected user %q, got %q", id, resp.Msg.GetUser().GetId())
+		}
+	})
+
+	t.Run("mismatched_request", func(t *testing.T) {
+		_, err := create("key-1", "", "other@example.com")
+		if code := connect.CodeOf(err); code != connect.CodeInvalidArgument {
+			t.Errorf("expected %v for other fields, got %v", connect.CodeInvalidArgument, err)
+		}
+
+		req := connect.NewRequest(&pb.DeleteUserRequest{Id: id})
+		req.Header().Set(IdempotencyKeyHeader, "key-1")
+		_, err = client.DeleteUser(ctx, req)
+		if code := connect.CodeOf(err); code != connect.CodeInvalidArgument {
+			t.Errorf("expected %v for another procedure, got %v", connect.CodeInvalidArgument, err)
+		}
+	})
+
+	t.Run("failures_are_not_kept", func(t *testing.T) {
+		// Were the failure kept, the retry would fail as in progress.
+		for i := range 2 {
+			_, err := create("key-2", "", "jane@example.com")
+			if code := connect.CodeOf(err); code != connect.CodeAlreadyExists {
+				t.Fatalf("create %d: expected %v, got %v", i, connect.CodeAlreadyExists, err)
+			}
+		}
+	})
+
+	t.Run("empty_response", func(t *testing.T) {
+		for i := range 2 {
+			req := connect.NewRequest(&pb.DeleteUserRequest{Id: id})
+			req.Header().Set(IdempotencyKeyHeader, "key-3")
+			resp, err := client.DeleteUser(ctx, req)
+			if err != nil {
+				t.Fatalf("delete %d: expected no error, got %v", i, err)
+			}
+			if replayed := resp.Header().Get(IdempotentReplayedHeader) == "true"; replayed != (i == 1) {
+				t.Errorf("delete %d: expected replayed %v", i, i == 1)
+			}
+		}
+	})
+
+	t.Run("tenants", func(t *testing.T) {
+		resp, err := create("key-1", "acme", "jane@example.com")
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if resp.Msg.GetUser().GetId() == id || resp.Header().Get(IdempotentReplayedHeader) != "" {
+			t.Errorf("expected another tenant's request made, got %v", resp.Msg.GetUser())
+		}
+	})
+
+	t.Run("long_key", func(t *testing.T) {
+		_, err := create(strings.Repeat("k", maxIdempotencyKeyLength+1), "", "long@example.com")
+		if code := connect.CodeOf(err); code != connect.CodeInvalidArgument {
+			t.Errorf("expected %v, got %v", connect.CodeInvalidArgument, err)
+		}
+	})
+}
+
+// blockingStore blocks the first CreateUser until unblock is closed, or its
+// context is done if unblock is nil, and fails to release idempotency keys
+// with a done context, as stores over a network do.
+type blockingStore struct {
+	store.Store
+	started chan struct{}
+	unblock chan struct{}
+	once    sync.Once
+}
+
+func newBlockingStore(unblock chan struct{}) *blockingStore {
+	return &blockingStore{Store: memory.NewStore(), started: make(chan struct{}), unblock: unblock}
+}
+
+func (s *blockingStore) CreateUser(ctx context.Context, user *pb.User) error {
+	first := false
+	s.once.Do(func() { first = true })
+	if first {
+		close(s.started)
+		if s.unblock == nil {
+			<-ctx.Done()
+			return ctx.Err()
+		}
+		<-s.unblock
+	}
+	return s.Store.CreateUser(ctx, user)
+}
+
+func (s *blockingStore) ReleaseIdempotencyKey(ctx context.Context, key string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return s.Store.ReleaseIdempotencyKey(ctx, key)
+}
+
+func TestIdempotencyCanceled(t *testing.T) {
+	s := newBlockingStore(nil)
+	client := newTestStoreClient(t, s)
+
+	create := func(ctx context.Context) (*connect.Response[pb.CreateUserResponse], error) {
+		req := connect.NewRequest(&pb.CreateUserRequest{Name: "Jane Doe", Email: "jane@example.com"})
+		req.Header().Set(IdempotencyKeyHeader, "key-1")
+		return client.CreateUser(ctx, req)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		_, err := create(ctx)
+		done <- err
+	}()
+	<-s.started
+	cancel()
+	if err := <-done; connect.CodeOf(err) != connect.CodeCanceled {
+		t.Fatalf("expected %v, got %v", connect.CodeCanceled, err)
+	}
+
+	// The server sees the cancellation a moment after the client does, so
+	// the retry may find the canceled request still in progress at first.
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		resp, err := create(context.Background())
+		if connect.CodeOf(err) == connect.CodeAborted && time.Now().Before(deadline) {
+			time.Sleep(10 * time.Millisecond)
+			continue
+		}
+		if err != nil {
+			t.Fatalf("expected the retry made once the canceled request released its key, got %v", err)
+		}
+		if resp.Header().Get(IdempotentReplayedHeader) != "" {
+			t.Errorf("expected the retry made rather than replayed")
+		}
+		break
+	}
+}
+
+func TestIdempotencyLease(t *testing.T) {
+	unblock := make(chan struct{})
+	s := newBlockingStore(unblock)
+	client := newTestStoreClient(t, s, WithIdempotencyLease(100*time.Millisecond))
+
+	create := func() (*connect.Response[pb.CreateUserResponse], error) {
+		req := connect.NewRequest(&pb.CreateUserRequest{Name: "Jane Doe", Email: "jane@example.com"})
+		req.Header().Set(IdempotencyKeyHeader, "key-1")
+		return client.CreateUser(context.Background(), req)
+	}
+
+	stuck := make(chan error)
+	go func() {
+		_, err := create()
+		stuck <- err
+	}()
+	<-s.started
+
+	if _, err := create(); connect.CodeOf(err) != connect.CodeAborted {
+		t.Errorf("expected %v while the first request holds its lease, got %v", connect.CodeAborted, err)
+	}
+
+	time.Sleep(200 * time.Millisecond)
+	if _, err := create(); err != nil {
+		t.Errorf("expected the retry made once the lease ran out, got %v", err)
+	}
+
+	close(unblock)
+	if err := <-stuck; connect.CodeOf(err) != connect.CodeAlreadyExists {
+		t.Errorf("expected the stuck request to find its user made by the retry, got %v", err)
+	}
+}
+
+func TestIdempotencyDisabled(t *testing.T) {
+	ctx := context.Background()
+	client := newTestClient(t, WithIdempotencyTTL(0))
+
+	create := func() error {
+		req := connect.NewRequest(&pb.CreateUserRequest{Name: "Jane Doe", Email: "jane@example.com"})
+		req.Header().Set(IdempotencyKeyHeader, "key-1")
+		_, err := client.CreateUser(ctx, req)
+		return err
+	}
+
+	if err := create(); err != nil {
+		t.Fatalf("failed to create user: %v", err)
+	}
+	if err := create(); connect.CodeOf(err) != connect.CodeAlreadyExists {
+		t.Errorf("expected the request made again and %v, got %v", connect.CodeAlreadyExists, err)
+	}
+}
+
+// newTestClient serves a server with opts over a memory store, and returns a
+// client of it.
+func newTestClient(t *testing.T, opts ...Option) v1.UserServiceClient {
+	t.Helper()
+	return newTestStoreClient(t, memory.NewStore(), opts...)
+}
+
+// newTestStoreClient serves a server with opts over s, and returns a client
+// of it.
+func newTestStoreClient(t *testing.T, s store.Store, opts ...Option) v1.UserServiceClient {
+	t.Helper()
+
+	handler, err := NewServer(0, s, opts...).CreateHandler(context.Background())
+	if err != nil {
+		t.Fatalf("failed to create handler: %v", err)
+	}
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+
+	return v1.NewUserServiceClient(http.DefaultClient, srv.URL)
+}
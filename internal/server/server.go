@@ -2,10 +2,13 @@ package server
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"log/slog"
 	"net/http"
+	"time"
 
+	"connectrpc.com/connect"
 	"connectrpc.com/grpcreflect"
 	sloghttp "github.com/samber/slog-http"
 	slogmulti "github.com/samber/slog-multi"
@@ -15,21 +18,81 @@ import (
 	v1 "github.com/andrew-womeldorf/connect-boilerplate/gen/user/v1/userv1connect"
 	"github.com/andrew-womeldorf/connect-boilerplate/internal/services/user"
 	"github.com/andrew-womeldorf/connect-boilerplate/internal/services/user/store"
+	"github.com/andrew-womeldorf/connect-boilerplate/internal/services/user/store/cache"
 	"github.com/andrew-womeldorf/connect-boilerplate/internal/web"
 )
 
+// ActorHeader names who is making a request, for the audit log. There is no
+// authentication, so callers are taken at their word; requests without it
+// are audited as AnonymousActor.
+const (
+	ActorHeader    = "X-Actor"
+	AnonymousActor = "anonymous"
+)
+
+// ActorResolver returns who is making r, for the audit log, or "" for
+// AnonymousActor.
+type ActorResolver func(r *http.Request) string
+
+// HeaderActor resolves the actor from ActorHeader. It is unauthenticated
+// input: callers may name anyone this way, and the audit log records whoever
+// they name. Deployments that authenticate callers should resolve the actor
+// from their verified identity instead, with WithActorResolver.
+func HeaderActor(r *http.Request) string {
+	return r.Header.Get(ActorHeader)
+}
+
 // Server represents the API server
 type Server struct {
-	port      int
-	userStore store.Store
+	port             int
+	userStore        store.Store
+	idempotencyTTL   time.Duration
+	idempotencyLease time.Duration
+	resolveActor     ActorResolver
+}
+
+// Option configures a Server.
+type Option func(*Server)
+
+// WithIdempotencyTTL sets how long the responses to requests with idempotency
+// keys are kept to answer retries with. Zero ignores the keys. The default is
+// DefaultIdempotencyTTL.
+func WithIdempotencyTTL(d time.Duration) Option {
+	return func(s *Server) {
+		s.idempotencyTTL = d
+	}
+}
+
+// WithIdempotencyLease sets how long a request with an idempotency key holds
+// its key while in progress, so that retries of a request that never finished
+// are made once it runs out. The default is DefaultIdempotencyLease.
+func WithIdempotencyLease(d time.Duration) Option {
+	return func(s *Server) {
+		s.idempotencyLease = d
+	}
+}
+
+// WithActorResolver sets how the actor of each request is resolved for the
+// audit log. The default is HeaderActor.
+func WithActorResolver(resolve ActorResolver) Option {
+	return func(s *Server) {
+		s.resolveActor = resolve
+	}
 }
 
 // NewServer creates a new server
-func NewServer(port int, userStore store.Store) *Server {
-	return &Server{
-		port:      port,
-		userStore: userStore,
+func NewServer(port int, userStore store.Store, opts ...Option) *Server {
+	s := &Server{
+		port:             port,
+		userStore:        userStore,
+		idempotencyTTL:   DefaultIdempotencyTTL,
+		idempotencyLease: DefaultIdempotencyLease,
+		resolveActor:     HeaderActor,
 	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
 }
 
 // Run starts the server
@@ -88,9 +151,17 @@ func (s *Server) CreateHandler(ctx context.Context) (http.Handler, error) {
 	userService := user.NewService(s.userStore)
 	webHandler := web.NewHandler(userService)
 
-	// Create Connect server
+	// Create Connect server. Idempotency keys are kept per tenant, so the
+	// tenant is resolved first, and only for valid requests.
+	interceptors := []connect.Interceptor{NewTenantInterceptor(HeaderTenant), NewValidationInterceptor()}
+	if s.idempotencyTTL > 0 {
+		interceptors = append(interceptors, NewIdempotencyInterceptor(s.userStore, s.idempotencyTTL, s.idempotencyLease))
+	}
 	mux := http.NewServeMux()
-	p, h := v1.NewUserServiceHandler(NewUserConnectHandler(userService))
+	p, h := v1.NewUserServiceHandler(
+		NewUserConnectHandler(userService),
+		connect.WithInterceptors(interceptors...),
+	)
 	mux.Handle(p, h)
 
 	// Add gRPC Reflector
@@ -106,12 +177,24 @@ func (s *Server) CreateHandler(ctx context.Context) (http.Handler, error) {
 		}
 	})
 
-	// Add web interface endpoints
+	// Add cache statistics endpoint when the user store is cached
+	if c, ok := s.userStore.(*cache.Store); ok {
+		mux.HandleFunc("/cache/stats", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			if err := json.NewEncoder(w).Encode(c.Stats()); err != nil {
+				slog.ErrorContext(ctx, "Failed to write cache stats response", slog.Any("error", err))
+			}
+		})
+	}
+
+	// Add web interface endpoints. They are not Connect handlers, so they
+	// act for the default tenant.
 	mux.HandleFunc("/", webHandler.IndexHandler)
 	mux.HandleFunc("/create-user", webHandler.CreateUserHandler)
 
 	// Add CORS middleware for browser clients
 	mid := corsMiddleware(mux)
+	mid = auditMiddleware(s.resolveActor, mid)
 	mid = sloghttp.Recovery(mid)
 	mid = sloghttp.NewWithConfig(
 		slog.Default(),
@@ -135,7 +218,7 @@ func corsMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Access-Control-Allow-Origin", "*")
 		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Connect-Protocol-Version, Connect-Timeout-Ms, X-Request-ID")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Connect-Protocol-Version, Connect-Timeout-Ms, X-Request-ID, X-Actor, X-Tenant-ID, Idempotency-Key")
 
 		if r.Method == "OPTIONS" {
 			w.WriteHeader(http.StatusOK)
@@ -145,3 +228,24 @@ func corsMiddleware(next http.Handler) http.Handler {
 		next.ServeHTTP(w, r)
 	})
 }
+
+// auditMiddleware identifies the caller, as resolve names them, and request to
+// the audit log. With HeaderActor the caller is whoever the request claims to
+// be, so the audit log is only as trustworthy as the callers. It must run
+// inside the sloghttp middleware, which assigns the request id.
+func auditMiddleware(resolve ActorResolver, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		actor := resolve(r)
+		if actor == "" {
+			actor = AnonymousActor
+		}
+
+		ctx = store.WithAuditInfo(ctx, store.AuditInfo{
+			Actor:     actor,
+			RequestID: sloghttp.GetRequestIDFromContext(ctx),
+		})
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
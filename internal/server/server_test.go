@@ -0,0 +1,44 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"connectrpc.com/connect"
+
+	pb "github.com/andrew-womeldorf/connect-boilerplate/gen/user/v1"
+)
+
+func TestActorResolver(t *testing.T) {
+	ctx := context.Background()
+	verified := func(r *http.Request) string { return "verified" }
+
+	for _, tt := range []struct {
+		name  string
+		opts  []Option
+		actor string
+	}{
+		{"header", nil, "claimed"},
+		{"resolver", []Option{WithActorResolver(verified)}, "verified"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			client := newTestClient(t, tt.opts...)
+
+			req := connect.NewRequest(&pb.CreateUserRequest{Name: "Jane Doe", Email: "jane@example.com"})
+			req.Header().Set(ActorHeader, "claimed")
+			if _, err := client.CreateUser(ctx, req); err != nil {
+				t.Fatalf("failed to create user: %v", err)
+			}
+
+			resp, err := client.ListAuditEvents(ctx, connect.NewRequest(&pb.ListAuditEventsRequest{}))
+			if err != nil {
+				t.Fatalf("failed to list audit events: %v", err)
+			}
+			events := resp.Msg.GetAuditEvents()
+			if len(events) != 1 || events[0].GetActor() != tt.actor {
+				t.Errorf("expected one event by %q, got %v", tt.actor, events)
+			}
+		})
+	}
+}
@@ -0,0 +1,51 @@
+package server
+
+import (
+	"context"
+
+	"connectrpc.com/connect"
+
+	"github.com/andrew-womeldorf/connect-boilerplate/internal/services/user/store"
+)
+
+// TenantHeader names the tenant a request acts for. Requests without it act
+// for store.DefaultTenant.
+const TenantHeader = "X-Tenant-ID"
+
+// TenantResolver returns the tenant req acts for, or "" for the default
+// tenant. Errors should be *connect.Error, such as CodeUnauthenticated for a
+// caller that may not name a tenant.
+type TenantResolver func(ctx context.Context, req connect.AnyRequest) (string, error)
+
+// HeaderTenant resolves the tenant from TenantHeader. Callers may name any
+// tenant this way, so deployments that authenticate callers should resolve it
+// from their verified claims instead.
+func HeaderTenant(ctx context.Context, req connect.AnyRequest) (string, error) {
+	return req.Header().Get(TenantHeader), nil
+}
+
+// NewTenantInterceptor scopes each request handled to the tenant resolve
+// returns, so the stores it reaches only see that tenant's users. Requests
+// naming an invalid tenant fail with CodeInvalidArgument.
+func NewTenantInterceptor(resolve TenantResolver) connect.Interceptor {
+	return connect.UnaryInterceptorFunc(func(next connect.UnaryFunc) connect.UnaryFunc {
+		return func(ctx context.Context, req connect.AnyRequest) (connect.AnyResponse, error) {
+			if req.Spec().IsClient {
+				return next(ctx, req)
+			}
+
+			tenant, err := resolve(ctx, req)
+			if err != nil {
+				return nil, connectError(ctx, err)
+			}
+			if tenant == "" {
+				tenant = store.DefaultTenant
+			}
+			if err := store.ValidateTenant(tenant); err != nil {
+				return nil, connect.NewError(connect.CodeInvalidArgument, err)
+			}
+
+			return next(store.WithTenant(ctx, tenant), req)
+		}
+	})
+}
@@ -0,0 +1,69 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"connectrpc.com/connect"
+
+	pb "github.com/andrew-womeldorf/connect-boilerplate/gen/user/v1"
+	v1 "github.com/andrew-womeldorf/connect-boilerplate/gen/user/v1/userv1connect"
+	"github.com/andrew-womeldorf/connect-boilerplate/internal/services/user/store/memory"
+)
+
+func TestTenantInterceptor(t *testing.T) {
+	ctx := context.Background()
+
+	handler, err := NewServer(0, memory.NewStore()).CreateHandler(ctx)
+	if err != nil {
+		t.Fatalf("failed to create handler: %v", err)
+	}
+	srv := httptest.NewServer(handler)
+	defer srv.Close()
+
+	client := v1.NewUserServiceClient(http.DefaultClient, srv.URL)
+
+	withTenant := func(req connect.AnyRequest, tenant string) {
+		if tenant != "" {
+			req.Header().Set(TenantHeader, tenant)
+		}
+	}
+
+	create := connect.NewRequest(&pb.CreateUserRequest{Name: "Jane Doe", Email: "jane@example.com"})
+	withTenant(create, "acme")
+	resp, err := client.CreateUser(ctx, create)
+	if err != nil {
+		t.Fatalf("failed to create user: %v", err)
+	}
+	id := resp.Msg.GetUser().GetId()
+
+	tests := []struct {
+		name   string
+		tenant string
+		code   connect.Code
+	}{
+		{"same_tenant", "acme", 0},
+		{"other_tenant", "globex", connect.CodeNotFound},
+		{"default_tenant", "", connect.CodeNotFound},
+		{"invalid_tenant", "acme#USER", connect.CodeInvalidArgument},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			get := connect.NewRequest(&pb.GetUserRequest{Id: id})
+			withTenant(get, tt.tenant)
+
+			_, err := client.GetUser(ctx, get)
+			if tt.code == 0 {
+				if err != nil {
+					t.Fatalf("expected no error, got %v", err)
+				}
+				return
+			}
+			if code := connect.CodeOf(err); code != tt.code {
+				t.Errorf("expected %v, got %v", tt.code, err)
+			}
+		})
+	}
+}
@@ -26,7 +26,7 @@ func NewUserConnectHandler(service *user.Service) *UserConnectHandler {
 func (a *UserConnectHandler) ListUsers(ctx context.Context, req *connect.Request[pb.ListUsersRequest]) (*connect.Response[pb.ListUsersResponse], error) {
 	resp, err := a.service.ListUsers(ctx, req.Msg)
 	if err != nil {
-		return nil, err
+		return nil, connectError(ctx, err)
 	}
 	return connect.NewResponse(resp), nil
 }
@@ -35,7 +35,16 @@ func (a *UserConnectHandler) ListUsers(ctx context.Context, req *connect.Request
 func (a *UserConnectHandler) GetUser(ctx context.Context, req *connect.Request[pb.GetUserRequest]) (*connect.Response[pb.GetUserResponse], error) {
 	resp, err := a.service.GetUser(ctx, req.Msg)
 	if err != nil {
-		return nil, err
+		return nil, connectError(ctx, err)
+	}
+	return connect.NewResponse(resp), nil
+}
+
+// GetUserByEmail implements the Connect interface
+func (a *UserConnectHandler) GetUserByEmail(ctx context.Context, req *connect.Request[pb.GetUserByEmailRequest]) (*connect.Response[pb.GetUserByEmailResponse], error) {
+	resp, err := a.service.GetUserByEmail(ctx, req.Msg)
+	if err != nil {
+		return nil, connectError(ctx, err)
 	}
 	return connect.NewResponse(resp), nil
 }
@@ -44,7 +53,7 @@ func (a *UserConnectHandler) GetUser(ctx context.Context, req *connect.Request[p
 func (a *UserConnectHandler) CreateUser(ctx context.Context, req *connect.Request[pb.CreateUserRequest]) (*connect.Response[pb.CreateUserResponse], error) {
 	resp, err := a.service.CreateUser(ctx, req.Msg)
 	if err != nil {
-		return nil, err
+		return nil, connectError(ctx, err)
 	}
 	return connect.NewResponse(resp), nil
 }
@@ -53,7 +62,7 @@ func (a *UserConnectHandler) CreateUser(ctx context.Context, req *connect.Reques
 func (a *UserConnectHandler) UpdateUser(ctx context.Context, req *connect.Request[pb.UpdateUserRequest]) (*connect.Response[pb.UpdateUserResponse], error) {
 	resp, err := a.service.UpdateUser(ctx, req.Msg)
 	if err != nil {
-		return nil, err
+		return nil, connectError(ctx, err)
 	}
 	return connect.NewResponse(resp), nil
 }
@@ -62,7 +71,70 @@ func (a *UserConnectHandler) UpdateUser(ctx context.Context, req *connect.Reques
 func (a *UserConnectHandler) DeleteUser(ctx context.Context, req *connect.Request[pb.DeleteUserRequest]) (*connect.Response[pb.DeleteUserResponse], error) {
 	resp, err := a.service.DeleteUser(ctx, req.Msg)
 	if err != nil {
-		return nil, err
+		return nil, connectError(ctx, err)
+	}
+	return connect.NewResponse(resp), nil
+}
+
+// UndeleteUser implements the Connect interface
+func (a *UserConnectHandler) UndeleteUser(ctx context.Context, req *connect.Request[pb.UndeleteUserRequest]) (*connect.Response[pb.UndeleteUserResponse], error) {
+	resp, err := a.service.UndeleteUser(ctx, req.Msg)
+	if err != nil {
+		return nil, connectError(ctx, err)
+	}
+	return connect.NewResponse(resp), nil
+}
+
+// BatchGetUsers implements the Connect interface
+func (a *UserConnectHandler) BatchGetUsers(ctx context.Context, req *connect.Request[pb.BatchGetUsersRequest]) (*connect.Response[pb.BatchGetUsersResponse], error) {
+	resp, err := a.service.BatchGetUsers(ctx, req.Msg)
+	if err != nil {
+		return nil, connectError(ctx, err)
+	}
+	return connect.NewResponse(resp), nil
+}
+
+// BatchCreateUsers implements the Connect interface
+func (a *UserConnectHandler) BatchCreateUsers(ctx context.Context, req *connect.Request[pb.BatchCreateUsersRequest]) (*connect.Response[pb.BatchCreateUsersResponse], error) {
+	resp, err := a.service.BatchCreateUsers(ctx, req.Msg)
+	if err != nil {
+		return nil, connectError(ctx, err)
+	}
+	return connect.NewResponse(resp), nil
+}
+
+// BatchDeleteUsers implements the Connect interface
+func (a *UserConnectHandler) BatchDeleteUsers(ctx context.Context, req *connect.Request[pb.BatchDeleteUsersRequest]) (*connect.Response[pb.BatchDeleteUsersResponse], error) {
+	resp, err := a.service.BatchDeleteUsers(ctx, req.Msg)
+	if err != nil {
+		return nil, connectError(ctx, err)
+	}
+	return connect.NewResponse(resp), nil
+}
+
+// SearchUsers implements the Connect interface
+func (a *UserConnectHandler) SearchUsers(ctx context.Context, req *connect.Request[pb.SearchUsersRequest]) (*connect.Response[pb.SearchUsersResponse], error) {
+	resp, err := a.service.SearchUsers(ctx, req.Msg)
+	if err != nil {
+		return nil, connectError(ctx, err)
+	}
+	return connect.NewResponse(resp), nil
+}
+
+// ListAuditEvents implements the Connect interface
+func (a *UserConnectHandler) ListAuditEvents(ctx context.Context, req *connect.Request[pb.ListAuditEventsRequest]) (*connect.Response[pb.ListAuditEventsResponse], error) {
+	resp, err := a.service.ListAuditEvents(ctx, req.Msg)
+	if err != nil {
+		return nil, connectError(ctx, err)
+	}
+	return connect.NewResponse(resp), nil
+}
+
+// ListUserRevisions implements the Connect interface
+func (a *UserConnectHandler) ListUserRevisions(ctx context.Context, req *connect.Request[pb.ListUserRevisionsRequest]) (*connect.Response[pb.ListUserRevisionsResponse], error) {
+	resp, err := a.service.ListUserRevisions(ctx, req.Msg)
+	if err != nil {
+		return nil, connectError(ctx, err)
 	}
 	return connect.NewResponse(resp), nil
 }
@@ -0,0 +1,33 @@
+package server
+
+import (
+	"context"
+
+	"connectrpc.com/connect"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/andrew-womeldorf/connect-boilerplate/internal/services/user"
+)
+
+// NewValidationInterceptor rejects requests that break the buf.validate rules
+// declared on their messages before they are handled, with
+// CodeInvalidArgument and a BadRequest detail listing every field violation.
+func NewValidationInterceptor() connect.Interceptor {
+	return connect.UnaryInterceptorFunc(func(next connect.UnaryFunc) connect.UnaryFunc {
+		return func(ctx context.Context, req connect.AnyRequest) (connect.AnyResponse, error) {
+			if req.Spec().IsClient {
+				return next(ctx, req)
+			}
+
+			msg, ok := req.Any().(proto.Message)
+			if !ok {
+				return next(ctx, req)
+			}
+			if err := user.ValidateRequest(msg); err != nil {
+				return nil, connectError(ctx, err)
+			}
+
+			return next(ctx, req)
+		}
+	})
+}
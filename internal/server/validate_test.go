@@ -0,0 +1,79 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync/atomic"
+	"testing"
+
+	"connectrpc.com/connect"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+
+	pb "github.com/andrew-womeldorf/connect-boilerplate/gen/user/v1"
+	"github.com/andrew-womeldorf/connect-boilerplate/internal/services/user/store"
+	"github.com/andrew-womeldorf/connect-boilerplate/internal/services/user/store/memory"
+)
+
+func TestValidationInterceptor(t *testing.T) {
+	ctx := context.Background()
+	client := newTestClient(t)
+
+	_, err := client.CreateUser(ctx, connect.NewRequest(&pb.CreateUserRequest{Email: "jane"}))
+	if code := connect.CodeOf(err); code != connect.CodeInvalidArgument {
+		t.Fatalf("expected %v, got %v", connect.CodeInvalidArgument, err)
+	}
+
+	var connectErr *connect.Error
+	if !errors.As(err, &connectErr) {
+		t.Fatalf("expected a connect error, got %v", err)
+	}
+	var fields []string
+	for _, detail := range connectErr.Details() {
+		value, err := detail.Value()
+		if err != nil {
+			t.Fatalf("failed to read error detail: %v", err)
+		}
+		if badRequest, ok := value.(*errdetails.BadRequest); ok {
+			for _, v := range badRequest.GetFieldViolations() {
+				fields = append(fields, v.GetField())
+			}
+		}
+	}
+	if want := []string{"name", "email"}; !slices.Equal(fields, want) {
+		t.Errorf("expected field violations of %q, got %q", want, fields)
+	}
+
+	if _, err := client.CreateUser(ctx, connect.NewRequest(&pb.CreateUserRequest{
+		Name:  "Jane Doe",
+		Email: "jane@example.com",
+	})); err != nil {
+		t.Errorf("expected a valid request made, got %v", err)
+	}
+}
+
+// claimCountingStore counts the idempotency keys claimed in it.
+type claimCountingStore struct {
+	store.Store
+	claims atomic.Int32
+}
+
+func (s *claimCountingStore) ClaimIdempotencyKey(ctx context.Context, record store.IdempotencyRecord) (*store.IdempotencyRecord, error) {
+	s.claims.Add(1)
+	return s.Store.ClaimIdempotencyKey(ctx, record)
+}
+
+func TestValidationBeforeIdempotency(t *testing.T) {
+	s := &claimCountingStore{Store: memory.NewStore()}
+	client := newTestStoreClient(t, s)
+
+	req := connect.NewRequest(&pb.CreateUserRequest{Email: "jane"})
+	req.Header().Set(IdempotencyKeyHeader, "key-1")
+	_, err := client.CreateUser(context.Background(), req)
+	if code := connect.CodeOf(err); code != connect.CodeInvalidArgument {
+		t.Fatalf("expected %v, got %v", connect.CodeInvalidArgument, err)
+	}
+	if n := s.claims.Load(); n != 0 {
+		t.Errorf("expected an invalid request to claim no idempotency key, got %d claims", n)
+	}
+}
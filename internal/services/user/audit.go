@@ -0,0 +1,16 @@
+package user
+
+import (
+	"context"
+
+	"github.com/andrew-womeldorf/connect-boilerplate/internal/services/user/store"
+)
+
+// withRPC returns ctx with rpc as the RPC of its store.AuditInfo, so that the
+// audit events of the changes made under it name the method that made them.
+// Who the caller is, and which request it made, is left to the transport.
+func withRPC(ctx context.Context, rpc string) context.Context {
+	info := store.AuditInfoFromContext(ctx)
+	info.RPC = rpc
+	return store.WithAuditInfo(ctx, info)
+}
@@ -0,0 +1,21 @@
+package user
+
+import (
+	"context"
+	"log/slog"
+
+	pb "github.com/andrew-womeldorf/connect-boilerplate/gen/user/v1"
+	"github.com/andrew-womeldorf/connect-boilerplate/internal/services/user/store"
+)
+
+// batchError reports why one item of a batch failed. As for a single call,
+// internal failures are logged and their cause hidden from clients.
+func batchError(ctx context.Context, err error) *pb.BatchError {
+	reason := ErrorReason(err)
+	if reason == ReasonInternal {
+		slog.ErrorContext(ctx, "internal error in batch item", slog.Any("error", err))
+		return &pb.BatchError{Reason: reason, Message: store.KindInternal.String()}
+	}
+
+	return &pb.BatchError{Reason: reason, Message: err.Error()}
+}
@@ -0,0 +1,22 @@
+package user
+
+import "time"
+
+// Clock tells the service what time it is. Tests supply their own to make
+// timestamps deterministic.
+type Clock interface {
+	Now() time.Time
+}
+
+// ClockFunc adapts an ordinary function into a Clock.
+type ClockFunc func() time.Time
+
+func (f ClockFunc) Now() time.Time {
+	return f()
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
@@ -0,0 +1,49 @@
+package user
+
+import (
+	"errors"
+
+	"github.com/andrew-womeldorf/connect-boilerplate/internal/services/user/store"
+)
+
+// Reasons name why a call failed, for clients to act on. They are reported
+// in the ErrorInfo detail of a failed call and in the errors of batch items.
+const (
+	ReasonInvalidArgument    = "INVALID_ARGUMENT"
+	ReasonNotFound           = "NOT_FOUND"
+	ReasonAlreadyExists      = "ALREADY_EXISTS"
+	ReasonConflict           = "CONFLICT"
+	ReasonPreconditionFailed = "PRECONDITION_FAILED"
+	ReasonUnavailable        = "UNAVAILABLE"
+	ReasonUnimplemented      = "UNIMPLEMENTED"
+	ReasonInternal           = "INTERNAL"
+)
+
+// ErrorReason returns the reason for an error returned by the service.
+// Errors it does not recognize are internal.
+func ErrorReason(err error) string {
+	if errors.Is(err, store.ErrInvalidPageToken) || errors.Is(err, ErrInvalidEtag) ||
+		errors.Is(err, ErrInvalidOrderBy) || errors.Is(err, ErrInvalidFilter) ||
+		errors.Is(err, ErrInvalidQuery) || errors.Is(err, ErrInvalidAsOf) ||
+		errors.Is(err, ErrInvalidRequest) {
+		return ReasonInvalidArgument
+	}
+	if errors.Is(err, ErrSearchNotSupported) {
+		return ReasonUnimplemented
+	}
+
+	switch store.KindOf(err) {
+	case store.KindNotFound:
+		return ReasonNotFound
+	case store.KindAlreadyExists:
+		return ReasonAlreadyExists
+	case store.KindConflict:
+		return ReasonConflict
+	case store.KindPreconditionFailed:
+		return ReasonPreconditionFailed
+	case store.KindUnavailable:
+		return ReasonUnavailable
+	default:
+		return ReasonInternal
+	}
+}
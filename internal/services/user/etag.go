@@ -0,0 +1,38 @@
+package user
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	pb "github.com/andrew-womeldorf/connect-boilerplate/gen/user/v1"
+)
+
+var ErrInvalidEtag = errors.New("invalid etag")
+
+// etag formats a user version as a strong HTTP style entity tag, e.g. "3".
+func etag(version int64) string {
+	return strconv.Quote(strconv.FormatInt(version, 10))
+}
+
+// parseEtag returns the version named by tag, or 0 if tag is empty. The
+// quotes are optional, so both "3" and 3 name version 3.
+func parseEtag(tag string) (int64, error) {
+	if tag == "" {
+		return 0, nil
+	}
+
+	version, err := strconv.ParseInt(strings.Trim(tag, `"`), 10, 64)
+	if err != nil || version <= 0 {
+		return 0, fmt.Errorf("%w: %q", ErrInvalidEtag, tag)
+	}
+
+	return version, nil
+}
+
+// withEtag sets the etag of user from its version.
+func withEtag(user *pb.User) *pb.User {
+	user.Etag = etag(user.GetVersion())
+	return user
+}
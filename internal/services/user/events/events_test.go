@@ -0,0 +1,319 @@
+package events
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	pb "github.com/andrew-womeldorf/connect-boilerplate/gen/user/v1"
+	"github.com/andrew-womeldorf/connect-boilerplate/internal/services/user/store"
+	"github.com/andrew-womeldorf/connect-boilerplate/internal/services/user/store/memory"
+)
+
+// recordingSink keeps the events sent to it, failing while err is set or
+// for its first failures sends. calls counts every send.
+type recordingSink struct {
+	mu       sync.Mutex
+	err      error
+	failures int
+	calls    int
+	events   []*pb.UserEvent
+}
+
+func (s *recordingSink) Send(ctx context.Context, events []*pb.UserEvent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.calls++
+	if s.err != nil {
+		return s.err
+	}
+	if s.calls <= s.failures {
+		return errors.New("flaky")
+	}
+	s.events = append(s.events, events...)
+	return nil
+}
+
+func createUsers(ctx context.Context, t *testing.T, s store.Store, ids ...string) {
+	t.Helper()
+
+	for _, id := range ids {
+		now := timestamppb.Now()
+		user := &pb.User{Id: id, Name: id, Email: id + "@example.com", CreatedAt: now, UpdatedAt: now}
+		if err := s.CreateUser(ctx, user); err != nil {
+			t.Fatalf("failed to create user: %v", err)
+		}
+	}
+}
+
+func TestRelayFlush(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("delivers_and_acknowledges", func(t *testing.T) {
+		s := memory.NewStore()
+		createUsers(ctx, t, s, "a", "b", "c")
+
+		sink := &recordingSink{}
+		relay := NewRelay(s, []Sink{sink}, WithBatchSize(2))
+
+		for _, want := range []int{2, 1, 0} {
+			n, err := relay.Flush(ctx)
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if n != want {
+				t.Errorf("expected %d events, got %d", want, n)
+			}
+		}
+
+		var got []string
+		for _, event := range sink.events {
+			got = append(got, UserID(event))
+		}
+		if want := []string{"a", "b", "c"}; !slices.Equal(got, want) {
+			t.Errorf("expected events for %v in order, got %v", want, got)
+		}
+	})
+
+	t.Run("redelivers_after_failure", func(t *testing.T) {
+		s := memory.NewStore()
+		createUsers(ctx, t, s, "a")
+
+		delivered := &recordingSink{}
+		failing := &recordingSink{err: errors.New("unreachable")}
+		relay := NewRelay(s, []Sink{delivered, failing}, WithRetryDelay(0))
+
+		if _, err := relay.Flush(ctx); err == nil {
+			t.Fatal("expected the failing sink's error")
+		}
+
+		failing.err = nil
+		if n, err := relay.Flush(ctx); err != nil || n != 1 {
+			t.Fatalf("expected 1 event and no error, got %d and %v", n, err)
+		}
+
+		// The sink that took the first delivery sees the event again, with
+		// the same id.
+		if len(delivered.events) != 2 || delivered.events[0].GetId() != delivered.events[1].GetId() {
+			t.Errorf("expected the event delivered twice with one id, got %v", delivered.events)
+		}
+		if len(failing.events) != 1 {
+			t.Errorf("expected the event delivered once to the recovered sink, got %v", failing.events)
+		}
+	})
+
+	t.Run("retries_a_failing_sink", func(t *testing.T) {
+		s := memory.NewStore()
+		createUsers(ctx, t, s, "a")
+
+		delivered := &recordingSink{}
+		flaky := &recordingSink{failures: 2}
+		relay := NewRelay(s, []Sink{delivered, flaky}, WithMaxAttempts(3), WithRetryDelay(0))
+
+		if n, err := relay.Flush(ctx); err != nil || n != 1 {
+			t.Fatalf("expected 1 event and no error, got %d and %v", n, err)
+		}
+		if flaky.calls != 3 || len(flaky.events) != 1 {
+			t.Errorf("expected the event taken on the third attempt, got %d attempts and %v", flaky.calls, flaky.events)
+		}
+		// Only the failing sink is sent the batch again.
+		if len(delivered.events) != 1 {
+			t.Errorf("expected the event delivered once to the other sink, got %v", delivered.events)
+		}
+		if pending, _ := s.PendingEvents(ctx, 10); len(pending) != 0 {
+			t.Errorf("expected the event acknowledged, got %v", pending)
+		}
+	})
+
+	t.Run("stops_retrying", func(t *testing.T) {
+		s := memory.NewStore()
+		createUsers(ctx, t, s, "a")
+
+		failing := &recordingSink{err: errors.New("unreachable")}
+		relay := NewRelay(s, []Sink{failing}, WithMaxAttempts(3), WithRetryDelay(0))
+
+		if _, err := relay.Flush(ctx); err == nil {
+			t.Fatal("expected the failing sink's error")
+		}
+		if failing.calls != 3 {
+			t.Errorf("expected 3 attempts, got %d", failing.calls)
+		}
+		if pending, _ := s.PendingEvents(ctx, 10); len(pending) != 1 {
+			t.Errorf("expected the event left in the outbox without a dead letter sink, got %v", pending)
+		}
+	})
+
+	t.Run("parks_in_dead_letter", func(t *testing.T) {
+		s := memory.NewStore()
+		createUsers(ctx, t, s, "a", "b")
+
+		failing := &recordingSink{err: errors.New("unreachable")}
+		delivered := &recordingSink{}
+		deadLetter := &recordingSink{}
+		relay := NewRelay(s, []Sink{failing, delivered},
+			WithMaxAttempts(2), WithRetryDelay(0), WithDeadLetter(deadLetter))
+
+		if n, err := relay.Flush(ctx); err != nil || n != 2 {
+			t.Fatalf("expected 2 events and no error, got %d and %v", n, err)
+		}
+		if failing.calls != 2 {
+			t.Errorf("expected 2 attempts, got %d", failing.calls)
+		}
+		if len(deadLetter.events) != 2 {
+			t.Errorf("expected the events parked, got %v", deadLetter.events)
+		}
+		// The sinks after the failing one still take the events.
+		if len(delivered.events) != 2 {
+			t.Errorf("expected the events delivered to the other sink, got %v", delivered.events)
+		}
+		if pending, _ := s.PendingEvents(ctx, 10); len(pending) != 0 {
+			t.Errorf("expected the parked events acknowledged, got %v", pending)
+		}
+	})
+
+	t.Run("keeps_events_the_dead_letter_refuses", func(t *testing.T) {
+		s := memory.NewStore()
+		createUsers(ctx, t, s, "a")
+
+		failing := &recordingSink{err: errors.New("unreachable")}
+		deadLetter := &recordingSink{err: errors.New("full")}
+		relay := NewRelay(s, []Sink{failing},
+			WithMaxAttempts(1), WithRetryDelay(0), WithDeadLetter(deadLetter))
+
+		if _, err := relay.Flush(ctx); err == nil {
+			t.Fatal("expected the dead letter sink's error")
+		}
+		if pending, _ := s.PendingEvents(ctx, 10); len(pending) != 1 {
+			t.Errorf("expected the event left in the outbox, got %v", pending)
+		}
+	})
+}
+
+func TestRelayRun(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	s := memory.NewStore()
+	sink := &recordingSink{}
+	relay := NewRelay(s, []Sink{sink}, WithInterval(time.Millisecond))
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		relay.Run(ctx)
+	}()
+
+	createUsers(ctx, t, s, "a")
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		sink.mu.Lock()
+		n := len(sink.events)
+		sink.mu.Unlock()
+		if n == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("expected the event to be relayed")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	cancel()
+	<-done
+}
+
+func TestFileSink(t *testing.T) {
+	ctx := context.Background()
+
+	s := memory.NewStore()
+	createUsers(ctx, t, s, "a", "b")
+	events, err := s.PendingEvents(ctx, 10)
+	if err != nil {
+		t.Fatalf("failed to read events: %v", err)
+	}
+
+	path := filepath.Join(t.TempDir(), "events.jsonl")
+	sink := NewFileSink(path)
+	for _, event := range events {
+		if err := sink.Send(ctx, []*pb.UserEvent{event}); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("failed to open file: %v", err)
+	}
+	defer func() { _ = f.Close() }()
+
+	var got []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		event := &pb.UserEvent{}
+		if err := protojson.Unmarshal(scanner.Bytes(), event); err != nil {
+			t.Fatalf("failed to parse line %q: %v", scanner.Text(), err)
+		}
+		got = append(got, event.GetId())
+	}
+
+	if len(got) != 2 || got[0] != events[0].GetId() || got[1] != events[1].GetId() {
+		t.Errorf("expected a line per event, got %v", got)
+	}
+}
+
+func TestWebhookSink(t *testing.T) {
+	ctx := context.Background()
+
+	s := memory.NewStore()
+	createUsers(ctx, t, s, "a")
+	events, err := s.PendingEvents(ctx, 10)
+	if err != nil {
+		t.Fatalf("failed to read events: %v", err)
+	}
+
+	var (
+		status = http.StatusNoContent
+		keys   []string
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		event := &pb.UserEvent{}
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Errorf("failed to read body: %v", err)
+		}
+		if err := protojson.Unmarshal(body, event); err != nil {
+			t.Errorf("failed to parse body: %v", err)
+		}
+		if event.GetId() != r.Header.Get(IdempotencyKeyHeader) {
+			t.Errorf("expected the idempotency key to be the event id, got %q", r.Header.Get(IdempotencyKeyHeader))
+		}
+		keys = append(keys, r.Header.Get(IdempotencyKeyHeader))
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(server.Close)
+
+	sink := NewWebhookSink(server.URL, server.Client())
+	if err := sink.Send(ctx, events); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if len(keys) != 1 || keys[0] != events[0].GetId() {
+		t.Errorf("expected one request keyed by the event id, got %v", keys)
+	}
+
+	status = http.StatusInternalServerError
+	if err := sink.Send(ctx, events); !errors.Is(err, ErrCouldNotPostEvent) {
+		t.Errorf("expected ErrCouldNotPostEvent, got %v", err)
+	}
+}
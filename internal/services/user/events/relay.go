@@ -0,0 +1,205 @@
+// Package events delivers the events user stores record in their outbox to
+// sinks outside the service.
+//
+// Delivery is at least once: a Relay acknowledges events only once every
+// sink has taken them, or they have been parked in its dead letter sink, so a
+// failure or a crash in between delivers them again. Sinks should drop events
+// whose id they have already seen.
+package events
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	pb "github.com/andrew-womeldorf/connect-boilerplate/gen/user/v1"
+	"github.com/andrew-womeldorf/connect-boilerplate/internal/services/user/store"
+)
+
+const (
+	DefaultBatchSize   = 100
+	DefaultInterval    = time.Second
+	DefaultMaxAttempts = 5
+	DefaultRetryDelay  = time.Second
+)
+
+// Sink receives events. Send must not return until the events are durably
+// delivered, since they are acknowledged once it does.
+type Sink interface {
+	Send(ctx context.Context, events []*pb.UserEvent) error
+}
+
+// Relay moves events from an outbox to sinks.
+type Relay struct {
+	outbox      store.Outbox
+	sinks       []Sink
+	deadLetter  Sink
+	batchSize   int32
+	interval    time.Duration
+	maxAttempts int
+	retryDelay  time.Duration
+}
+
+type Option func(*Relay)
+
+// WithBatchSize sets how many events are read from the outbox at a time.
+func WithBatchSize(n int32) Option {
+	return func(r *Relay) {
+		r.batchSize = n
+	}
+}
+
+// WithInterval sets how long Run waits before looking for events again once
+// the outbox is empty or a delivery failed.
+func WithInterval(d time.Duration) Option {
+	return func(r *Relay) {
+		r.interval = d
+	}
+}
+
+// WithMaxAttempts sets how many times Flush sends a batch to a sink before
+// giving up on it.
+func WithMaxAttempts(n int) Option {
+	return func(r *Relay) {
+		r.maxAttempts = n
+	}
+}
+
+// WithRetryDelay sets how long Flush waits before sending a batch to a sink
+// again, which doubles with each attempt.
+func WithRetryDelay(d time.Duration) Option {
+	return func(r *Relay) {
+		r.retryDelay = d
+	}
+}
+
+// WithDeadLetter sets the sink that events are parked in once a sink has
+// failed to take them WithMaxAttempts times, so that they are acknowledged
+// rather than holding back the events after them. Without one, they stay in
+// the outbox and are delivered again by the next Flush.
+func WithDeadLetter(sink Sink) Option {
+	return func(r *Relay) {
+		r.deadLetter = sink
+	}
+}
+
+func NewRelay(outbox store.Outbox, sinks []Sink, opts ...Option) *Relay {
+	r := &Relay{
+		outbox:      outbox,
+		sinks:       sinks,
+		batchSize:   DefaultBatchSize,
+		interval:    DefaultInterval,
+		maxAttempts: DefaultMaxAttempts,
+		retryDelay:  DefaultRetryDelay,
+	}
+
+	for _, opt := range opts {
+		opt(r)
+	}
+
+	return r
+}
+
+// Run delivers events until ctx is done. Failures are logged and retried.
+func (r *Relay) Run(ctx context.Context) {
+	for {
+		n, err := r.Flush(ctx)
+		if err != nil && ctx.Err() == nil {
+			slog.ErrorContext(ctx, "could not relay events", slog.Any("error", err))
+		}
+
+		// A full batch suggests more are waiting, so the next is read at once.
+		if err == nil && n == int(r.batchSize) {
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(r.interval):
+		}
+	}
+}
+
+// Flush delivers one batch of events to every sink and acknowledges them,
+// returning how many there were. A sink that fails is sent the batch again,
+// up to the relay's max attempts, and if it never takes it the batch is
+// parked in the dead letter sink, when there is one.
+func (r *Relay) Flush(ctx context.Context) (int, error) {
+	events, err := r.outbox.PendingEvents(ctx, r.batchSize)
+	if err != nil || len(events) == 0 {
+		return 0, err
+	}
+
+	// The batch is parked once, however many sinks fail, and the sinks after
+	// a failing one are still sent it.
+	var parked bool
+	for _, sink := range r.sinks {
+		err := r.send(ctx, sink, events)
+		if err == nil {
+			continue
+		}
+		if r.deadLetter == nil || ctx.Err() != nil {
+			return 0, err
+		}
+
+		if !parked {
+			if err := r.deadLetter.Send(ctx, events); err != nil {
+				return 0, err
+			}
+			parked = true
+		}
+		slog.ErrorContext(ctx, "parked user events in the dead letter sink",
+			slog.Any("error", err),
+			slog.Any("event ids", store.EventIDs(events)),
+		)
+	}
+
+	if err := r.outbox.AckEvents(ctx, events); err != nil {
+		return 0, err
+	}
+
+	return len(events), nil
+}
+
+// send sends events to sink until it takes them, waiting longer after each
+// failure, up to the relay's max attempts.
+func (r *Relay) send(ctx context.Context, sink Sink, events []*pb.UserEvent) error {
+	delay := r.retryDelay
+	for attempt := 1; ; attempt++ {
+		err := sink.Send(ctx, events)
+		if err == nil || attempt >= r.maxAttempts {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+}
+
+// Type names the kind of change event is for, such as "user_created".
+func Type(event *pb.UserEvent) string {
+	switch event.GetEvent().(type) {
+	case *pb.UserEvent_UserCreated:
+		return "user_created"
+	case *pb.UserEvent_UserUpdated:
+		return "user_updated"
+	case *pb.UserEvent_UserDeleted:
+		return "user_deleted"
+	case *pb.UserEvent_UserUndeleted:
+		return "user_undeleted"
+	case *pb.UserEvent_UserPurged:
+		return "user_purged"
+	default:
+		return "unknown"
+	}
+}
+
+// UserID returns the id of the user event is about.
+func UserID(event *pb.UserEvent) string {
+	return store.EventUserID(event)
+}
@@ -0,0 +1,136 @@
+package events
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protojson"
+
+	pb "github.com/andrew-womeldorf/connect-boilerplate/gen/user/v1"
+)
+
+var (
+	ErrCouldNotWriteEvents = errors.New("could not write events")
+	ErrCouldNotPostEvent   = errors.New("could not post event")
+)
+
+// LogSink logs each event.
+type LogSink struct{}
+
+func (LogSink) Send(ctx context.Context, events []*pb.UserEvent) error {
+	for _, event := range events {
+		slog.InfoContext(ctx, "user event",
+			slog.String("event id", event.GetId()),
+			slog.String("type", Type(event)),
+			slog.String("user id", UserID(event)),
+			slog.String("tenant id", event.GetTenantId()),
+		)
+	}
+	return nil
+}
+
+// FileSink appends each event to a file as a line of JSON.
+type FileSink struct {
+	path string
+	mu   sync.Mutex
+}
+
+func NewFileSink(path string) *FileSink {
+	return &FileSink{path: path}
+}
+
+// Send writes the events and syncs the file before returning.
+func (s *FileSink) Send(ctx context.Context, events []*pb.UserEvent) error {
+	var buf bytes.Buffer
+	for _, event := range events {
+		line, err := protojson.Marshal(event)
+		if err != nil {
+			return fmt.Errorf("%w: %w", ErrCouldNotWriteEvents, err)
+		}
+		buf.Write(line)
+		buf.WriteByte('\n')
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	f, err := os.OpenFile(s.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrCouldNotWriteEvents, err)
+	}
+
+	_, err = f.Write(buf.Bytes())
+	if err == nil {
+		err = f.Sync()
+	}
+	if err = errors.Join(err, f.Close()); err != nil {
+		return fmt.Errorf("%w: %w", ErrCouldNotWriteEvents, err)
+	}
+
+	return nil
+}
+
+// DefaultWebhookTimeout bounds each request a WebhookSink makes.
+const DefaultWebhookTimeout = 10 * time.Second
+
+// IdempotencyKeyHeader carries the event id on webhook requests, for the
+// receiver to drop events delivered more than once.
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+// WebhookSink posts each event, as JSON, to a URL. Any response other than a
+// 2xx fails the delivery.
+type WebhookSink struct {
+	url    string
+	client *http.Client
+}
+
+func NewWebhookSink(url string, client *http.Client) *WebhookSink {
+	if client == nil {
+		client = &http.Client{Timeout: DefaultWebhookTimeout}
+	}
+	return &WebhookSink{url: url, client: client}
+}
+
+func (s *WebhookSink) Send(ctx context.Context, events []*pb.UserEvent) error {
+	for _, event := range events {
+		if err := s.post(ctx, event); err != nil {
+			return fmt.Errorf("%w %s: %w", ErrCouldNotPostEvent, event.GetId(), err)
+		}
+	}
+	return nil
+}
+
+func (s *WebhookSink) post(ctx context.Context, event *pb.UserEvent) error {
+	body, err := protojson.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(IdempotencyKeyHeader, event.GetId())
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	_, _ = io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	return nil
+}
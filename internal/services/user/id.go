@@ -0,0 +1,114 @@
+package user
+
+import (
+	"crypto/rand"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// IDGenerator makes the ids of new users. Ids made later should sort after
+// those made earlier, so that stores keyed or paginated by id keep users in
+// the order they were created.
+type IDGenerator interface {
+	NewID() string
+}
+
+// IDGeneratorFunc adapts an ordinary function into an IDGenerator.
+type IDGeneratorFunc func() string
+
+func (f IDGeneratorFunc) NewID() string {
+	return f()
+}
+
+// UUIDv7Generator makes version 7 UUIDs, which start with the millisecond
+// they were made in and sort in the order they were made within a process.
+// It is the service's default.
+type UUIDv7Generator struct{}
+
+func (UUIDv7Generator) NewID() string {
+	return uuid.Must(uuid.NewV7()).String()
+}
+
+// crockford is the alphabet ULIDs are written in.
+const crockford = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// ULIDGenerator makes ULIDs: a millisecond timestamp and 80 random bits,
+// written as 26 characters of Crockford's base32. Ids made in the same
+// millisecond increment the random bits of the last, so they sort in the
+// order they were made. The zero value is ready to use.
+type ULIDGenerator struct {
+	mu     sync.Mutex
+	lastMS uint64
+	hi     uint16 // the top 16 of the random bits
+	lo     uint64 // the bottom 64 of the random bits
+}
+
+func (g *ULIDGenerator) NewID() string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	ms := uint64(time.Now().UnixMilli())
+	if ms <= g.lastMS {
+		// Within the millisecond of the last id, or the clock went back:
+		// carry on from the last id so that this one sorts after it. Should
+		// the random bits run out, borrow the next millisecond.
+		ms = g.lastMS
+		g.lo++
+		if g.lo == 0 {
+			g.hi++
+			if g.hi == 0 {
+				ms++
+			}
+		}
+	} else {
+		var b [10]byte
+		if _, err := rand.Read(b[:]); err != nil {
+			panic(fmt.Sprintf("ulid: reading random bits: %v", err))
+		}
+		g.hi = uint16(b[0])<<8 | uint16(b[1])
+		g.lo = 0
+		for _, c := range b[2:] {
+			g.lo = g.lo<<8 | uint64(c)
+		}
+	}
+	g.lastMS = ms
+
+	// The 128 bits are the 48 bit timestamp then the 80 random bits, read
+	// five at a time from the least significant end.
+	hi := ms<<16 | uint64(g.hi)
+	lo := g.lo
+	var id [26]byte
+	for i := len(id) - 1; i >= 0; i-- {
+		id[i] = crockford[lo&31]
+		lo = lo>>5 | hi<<59
+		hi >>= 5
+	}
+	return string(id[:])
+}
+
+// SequentialIDGenerator makes the ids prefix00000001, prefix00000002 and so
+// on, for tests that need to know the ids they will get.
+type SequentialIDGenerator struct {
+	prefix string
+
+	mu   sync.Mutex
+	next uint64
+}
+
+// NewSequentialIDGenerator returns a SequentialIDGenerator whose ids start
+// with prefix.
+func NewSequentialIDGenerator(prefix string) *SequentialIDGenerator {
+	return &SequentialIDGenerator{prefix: prefix, next: 1}
+}
+
+func (g *SequentialIDGenerator) NewID() string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	id := fmt.Sprintf("%s%08d", g.prefix, g.next)
+	g.next++
+	return id
+}
@@ -0,0 +1,43 @@
+package user
+
+import (
+	"context"
+	"log/slog"
+
+	pb "github.com/andrew-womeldorf/connect-boilerplate/gen/user/v1"
+)
+
+func (s *Service) BatchCreateUsers(ctx context.Context, req *pb.BatchCreateUsersRequest) (*pb.BatchCreateUsersResponse, error) {
+	if err := ValidateRequest(req); err != nil {
+		return nil, err
+	}
+
+	slog.InfoContext(ctx, "creating users", slog.Int("count", len(req.Requests)))
+	ctx = withRPC(ctx, "BatchCreateUsers")
+
+	users := make([]*pb.User, len(req.Requests))
+	for i, r := range req.Requests {
+		users[i] = s.newUser(r)
+	}
+
+	results, err := s.store.BatchCreateUsers(ctx, users)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &pb.BatchCreateUsersResponse{
+		Results: make([]*pb.BatchCreateUsersResult, len(results)),
+	}
+	for i, result := range results {
+		r := &pb.BatchCreateUsersResult{}
+		if result.Err != nil {
+			r.Result = &pb.BatchCreateUsersResult_Error{Error: batchError(ctx, result.Err)}
+			resp.FailureCount++
+		} else {
+			r.Result = &pb.BatchCreateUsersResult_User{User: withEtag(users[i])}
+		}
+		resp.Results[i] = r
+	}
+
+	return resp, nil
+}
@@ -0,0 +1,52 @@
+package user
+
+import (
+	"context"
+
+	pb "github.com/andrew-womeldorf/connect-boilerplate/gen/user/v1"
+	"github.com/andrew-womeldorf/connect-boilerplate/internal/services/user/store"
+)
+
+func (s *Service) BatchDeleteUsers(ctx context.Context, req *pb.BatchDeleteUsersRequest) (*pb.BatchDeleteUsersResponse, error) {
+	if err := ValidateRequest(req); err != nil {
+		return nil, err
+	}
+
+	ctx = withRPC(ctx, "BatchDeleteUsers")
+
+	resp := &pb.BatchDeleteUsersResponse{
+		Results: make([]*pb.BatchDeleteUsersResult, len(req.Requests)),
+	}
+
+	// Requests with an invalid etag fail without reaching the store;
+	// positions maps the rest back to their place in the request.
+	deletes := make([]store.BatchDelete, 0, len(req.Requests))
+	positions := make([]int, 0, len(req.Requests))
+	for i, r := range req.Requests {
+		resp.Results[i] = &pb.BatchDeleteUsersResult{Id: r.Id}
+
+		expectedVersion, err := parseEtag(r.ExpectedEtag)
+		if err != nil {
+			resp.Results[i].Error = batchError(ctx, err)
+			resp.FailureCount++
+			continue
+		}
+
+		deletes = append(deletes, store.BatchDelete{ID: r.Id, ExpectedVersion: expectedVersion})
+		positions = append(positions, i)
+	}
+
+	results, err := s.store.BatchDeleteUsers(ctx, deletes, s.clock.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	for j, result := range results {
+		if result.Err != nil {
+			resp.Results[positions[j]].Error = batchError(ctx, result.Err)
+			resp.FailureCount++
+		}
+	}
+
+	return resp, nil
+}
@@ -0,0 +1,34 @@
+package user
+
+import (
+	"context"
+
+	pb "github.com/andrew-womeldorf/connect-boilerplate/gen/user/v1"
+)
+
+func (s *Service) BatchGetUsers(ctx context.Context, req *pb.BatchGetUsersRequest) (*pb.BatchGetUsersResponse, error) {
+	if err := ValidateRequest(req); err != nil {
+		return nil, err
+	}
+
+	results, err := s.store.BatchGetUsers(ctx, req.Ids)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &pb.BatchGetUsersResponse{
+		Results: make([]*pb.BatchGetUsersResult, len(results)),
+	}
+	for i, result := range results {
+		r := &pb.BatchGetUsersResult{Id: req.Ids[i]}
+		if result.Err != nil {
+			r.Result = &pb.BatchGetUsersResult_Error{Error: batchError(ctx, result.Err)}
+			resp.FailureCount++
+		} else {
+			r.Result = &pb.BatchGetUsersResult_User{User: withEtag(result.User)}
+		}
+		resp.Results[i] = r
+	}
+
+	return resp, nil
+}
@@ -5,21 +5,41 @@ import (
 	"log/slog"
 
 	pb "github.com/andrew-womeldorf/connect-boilerplate/gen/user/v1"
-	"github.com/google/uuid"
+	"github.com/andrew-womeldorf/connect-boilerplate/internal/services/user/store"
+	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
 func (s *Service) CreateUser(ctx context.Context, req *pb.CreateUserRequest) (*pb.CreateUserResponse, error) {
-	slog.InfoContext(ctx, "creating user", slog.String("name", req.Name), slog.String("email", req.Email))
-
-	user := &pb.User{
-		Id:    uuid.New().String(),
-		Name:  req.Name,
-		Email: req.Email,
+	if err := ValidateRequest(req); err != nil {
+		return nil, err
 	}
 
+	slog.InfoContext(ctx, "creating user", slog.String("name", req.Name), slog.String("email", req.Email))
+	ctx = withRPC(ctx, "CreateUser")
+
+	user := s.newUser(req)
 	if err := s.store.CreateUser(ctx, user); err != nil {
 		return nil, err
 	}
 
-	return &pb.CreateUserResponse{User: user}, nil
+	return &pb.CreateUserResponse{User: withEtag(user)}, nil
+}
+
+// newUser returns the user req asks to create, with the id it asks for or a
+// new one.
+func (s *Service) newUser(req *pb.CreateUserRequest) *pb.User {
+	id := req.UserId
+	if id == "" {
+		id = s.ids.NewID()
+	}
+
+	now := timestamppb.New(s.clock.Now())
+	return &pb.User{
+		Id:        id,
+		Name:      req.Name,
+		Email:     req.Email,
+		CreatedAt: now,
+		UpdatedAt: now,
+		Version:   store.FirstVersion,
+	}
 }
@@ -7,7 +7,18 @@ import (
 )
 
 func (s *Service) DeleteUser(ctx context.Context, req *pb.DeleteUserRequest) (*pb.DeleteUserResponse, error) {
-	if err := s.store.DeleteUser(ctx, req.Id); err != nil {
+	if err := ValidateRequest(req); err != nil {
+		return nil, err
+	}
+
+	ctx = withRPC(ctx, "DeleteUser")
+
+	expectedVersion, err := parseEtag(req.ExpectedEtag)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.store.DeleteUser(ctx, req.Id, expectedVersion, s.clock.Now()); err != nil {
 		return nil, err
 	}
 
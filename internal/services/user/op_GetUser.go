@@ -2,15 +2,36 @@ package user
 
 import (
 	"context"
+	"errors"
+	"fmt"
 
 	pb "github.com/andrew-womeldorf/connect-boilerplate/gen/user/v1"
 )
 
+var ErrInvalidAsOf = errors.New("invalid as of time")
+
 func (s *Service) GetUser(ctx context.Context, req *pb.GetUserRequest) (*pb.GetUserResponse, error) {
+	if err := ValidateRequest(req); err != nil {
+		return nil, err
+	}
+
+	if req.AsOf != nil {
+		if err := req.AsOf.CheckValid(); err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrInvalidAsOf, err)
+		}
+
+		user, err := s.store.GetUserAsOf(ctx, req.Id, req.AsOf.AsTime())
+		if err != nil {
+			return nil, err
+		}
+
+		return &pb.GetUserResponse{User: withEtag(user)}, nil
+	}
+
 	user, err := s.store.GetUser(ctx, req.Id)
 	if err != nil {
 		return nil, err
 	}
 
-	return &pb.GetUserResponse{User: user}, nil
+	return &pb.GetUserResponse{User: withEtag(user)}, nil
 }
@@ -0,0 +1,20 @@
+package user
+
+import (
+	"context"
+
+	pb "github.com/andrew-womeldorf/connect-boilerplate/gen/user/v1"
+)
+
+func (s *Service) GetUserByEmail(ctx context.Context, req *pb.GetUserByEmailRequest) (*pb.GetUserByEmailResponse, error) {
+	if err := ValidateRequest(req); err != nil {
+		return nil, err
+	}
+
+	user, err := s.store.GetUserByEmail(ctx, req.Email)
+	if err != nil {
+		return nil, err
+	}
+
+	return &pb.GetUserByEmailResponse{User: withEtag(user)}, nil
+}
@@ -0,0 +1,55 @@
+package user
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	pb "github.com/andrew-womeldorf/connect-boilerplate/gen/user/v1"
+	"github.com/andrew-womeldorf/connect-boilerplate/internal/services/user/store"
+)
+
+func (s *Service) ListAuditEvents(ctx context.Context, req *pb.ListAuditEventsRequest) (*pb.ListAuditEventsResponse, error) {
+	if err := ValidateRequest(req); err != nil {
+		return nil, err
+	}
+
+	slog.InfoContext(ctx, "listing audit events", slog.String("user id", req.UserId))
+
+	filter, err := auditFilter(req)
+	if err != nil {
+		return nil, err
+	}
+
+	events, nextPageToken, err := s.store.ListAuditEvents(ctx, store.ListAuditEventsParams{
+		PageSize:  req.PageSize,
+		PageToken: req.PageToken,
+		Filter:    filter,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &pb.ListAuditEventsResponse{AuditEvents: events, NextPageToken: nextPageToken}, nil
+}
+
+// auditFilter converts the filter fields of a request, rejecting malformed
+// ones.
+func auditFilter(req *pb.ListAuditEventsRequest) (store.AuditFilter, error) {
+	filter := store.AuditFilter{UserID: req.UserId}
+
+	if req.StartTime != nil {
+		if err := req.StartTime.CheckValid(); err != nil {
+			return filter, fmt.Errorf("%w: start time: %w", ErrInvalidFilter, err)
+		}
+		filter.StartTime = req.StartTime.AsTime()
+	}
+	if req.EndTime != nil {
+		if err := req.EndTime.CheckValid(); err != nil {
+			return filter, fmt.Errorf("%w: end time: %w", ErrInvalidFilter, err)
+		}
+		filter.EndTime = req.EndTime.AsTime()
+	}
+
+	return filter, nil
+}
@@ -0,0 +1,32 @@
+package user
+
+import (
+	"context"
+	"log/slog"
+
+	pb "github.com/andrew-womeldorf/connect-boilerplate/gen/user/v1"
+	"github.com/andrew-womeldorf/connect-boilerplate/internal/services/user/store"
+)
+
+func (s *Service) ListUserRevisions(ctx context.Context, req *pb.ListUserRevisionsRequest) (*pb.ListUserRevisionsResponse, error) {
+	if err := ValidateRequest(req); err != nil {
+		return nil, err
+	}
+
+	slog.InfoContext(ctx, "listing user revisions", slog.String("user id", req.UserId))
+
+	revisions, nextPageToken, err := s.store.ListUserRevisions(ctx, store.ListUserRevisionsParams{
+		UserID:    req.UserId,
+		PageSize:  req.PageSize,
+		PageToken: req.PageToken,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, revision := range revisions {
+		withEtag(revision.User)
+	}
+
+	return &pb.ListUserRevisionsResponse{Revisions: revisions, NextPageToken: nextPageToken}, nil
+}
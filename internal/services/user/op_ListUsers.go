@@ -2,18 +2,120 @@ package user
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"log/slog"
+	"math"
+	"strings"
 
 	pb "github.com/andrew-womeldorf/connect-boilerplate/gen/user/v1"
+	"github.com/andrew-womeldorf/connect-boilerplate/internal/services/user/store"
+)
+
+var (
+	ErrInvalidOrderBy = errors.New(`order by must be "name" or "created_at", optionally followed by "asc" or "desc"`)
+	ErrInvalidFilter  = errors.New("invalid filter")
 )
 
 func (s *Service) ListUsers(ctx context.Context, req *pb.ListUsersRequest) (*pb.ListUsersResponse, error) {
+	if err := ValidateRequest(req); err != nil {
+		return nil, err
+	}
+
 	slog.InfoContext(ctx, "listing users")
 
-	users, err := s.store.ListUsers(ctx)
+	order, err := parseOrderBy(req.OrderBy)
+	if err != nil {
+		return nil, err
+	}
+
+	filter, err := userFilter(req.GetFilter())
 	if err != nil {
 		return nil, err
 	}
+	filter.ShowDeleted = req.ShowDeleted
+
+	users, nextPageToken, err := s.store.ListUsers(ctx, store.ListUsersParams{
+		PageSize:  req.PageSize,
+		PageToken: req.PageToken,
+		Filter:    filter,
+		OrderBy:   order,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, user := range users {
+		withEtag(user)
+	}
+
+	resp := &pb.ListUsersResponse{Users: users, NextPageToken: nextPageToken}
+
+	if req.ShowTotalSize {
+		total, err := s.store.CountUsers(ctx, filter)
+		if err != nil {
+			return nil, err
+		}
+		resp.TotalSize = int32(min(total, math.MaxInt32))
+	}
+
+	return resp, nil
+}
+
+// parseOrderBy parses an order_by such as "created_at desc". Users are listed
+// by name when it is empty.
+func parseOrderBy(orderBy string) (store.UserOrder, error) {
+	var order store.UserOrder
+
+	fields := strings.Fields(orderBy)
+	if len(fields) == 0 {
+		return order, nil
+	}
+	if len(fields) > 2 {
+		return order, ErrInvalidOrderBy
+	}
+
+	switch fields[0] {
+	case "name":
+		order.Field = store.OrderByName
+	case "created_at":
+		order.Field = store.OrderByCreatedAt
+	default:
+		return order, ErrInvalidOrderBy
+	}
+
+	if len(fields) == 2 {
+		switch fields[1] {
+		case "asc":
+		case "desc":
+			order.Desc = true
+		default:
+			return order, ErrInvalidOrderBy
+		}
+	}
+
+	return order, nil
+}
+
+// userFilter converts the filter of a request, rejecting malformed ones.
+func userFilter(f *pb.UserFilter) (store.UserFilter, error) {
+	filter := store.UserFilter{
+		NamePrefix:  f.GetNamePrefix(),
+		EmailDomain: f.GetEmailDomain(),
+	}
+
+	if f.GetCreatedAfter() != nil {
+		if err := f.GetCreatedAfter().CheckValid(); err != nil {
+			return filter, fmt.Errorf("%w: created after: %w", ErrInvalidFilter, err)
+		}
+		filter.CreatedAfter = f.GetCreatedAfter().AsTime()
+	}
+	if f.GetCreatedBefore() != nil {
+		if err := f.GetCreatedBefore().CheckValid(); err != nil {
+			return filter, fmt.Errorf("%w: created before: %w", ErrInvalidFilter, err)
+		}
+		filter.CreatedBefore = f.GetCreatedBefore().AsTime()
+	}
 
-	return &pb.ListUsersResponse{Users: users}, nil
+	return filter, nil
 }
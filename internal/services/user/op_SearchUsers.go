@@ -0,0 +1,58 @@
+package user
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+
+	pb "github.com/andrew-womeldorf/connect-boilerplate/gen/user/v1"
+	"github.com/andrew-womeldorf/connect-boilerplate/internal/services/user/store"
+)
+
+var (
+	ErrInvalidQuery       = errors.New("search query must contain a word")
+	ErrSearchNotSupported = store.ErrSearchNotSupported
+)
+
+// SearchUsers needs a store that implements store.Searcher; stores that
+// cannot search natively are only given a search index when configured to.
+func (s *Service) SearchUsers(ctx context.Context, req *pb.SearchUsersRequest) (*pb.SearchUsersResponse, error) {
+	if err := ValidateRequest(req); err != nil {
+		return nil, err
+	}
+
+	slog.InfoContext(ctx, "searching users", slog.String("query", req.Query))
+
+	searcher, ok := s.store.(store.Searcher)
+	if !ok {
+		return nil, ErrSearchNotSupported
+	}
+
+	if len(store.SearchTerms(req.Query)) == 0 {
+		return nil, ErrInvalidQuery
+	}
+
+	results, nextPageToken, err := searcher.SearchUsers(ctx, store.SearchUsersParams{
+		Query:     req.Query,
+		PageSize:  req.PageSize,
+		PageToken: req.PageToken,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &pb.SearchUsersResponse{
+		Results:       make([]*pb.UserSearchResult, 0, len(results)),
+		NextPageToken: nextPageToken,
+	}
+	for _, result := range results {
+		resp.Results = append(resp.Results, &pb.UserSearchResult{
+			User:           withEtag(result.User),
+			Score:          result.Score,
+			NameHighlight:  result.NameHighlight,
+			EmailHighlight: result.EmailHighlight,
+		})
+	}
+
+	return resp, nil
+}
@@ -0,0 +1,27 @@
+package user
+
+import (
+	"context"
+
+	pb "github.com/andrew-womeldorf/connect-boilerplate/gen/user/v1"
+)
+
+func (s *Service) UndeleteUser(ctx context.Context, req *pb.UndeleteUserRequest) (*pb.UndeleteUserResponse, error) {
+	if err := ValidateRequest(req); err != nil {
+		return nil, err
+	}
+
+	ctx = withRPC(ctx, "UndeleteUser")
+
+	expectedVersion, err := parseEtag(req.ExpectedEtag)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := s.store.UndeleteUser(ctx, req.Id, expectedVersion, s.clock.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	return &pb.UndeleteUserResponse{User: withEtag(user)}, nil
+}
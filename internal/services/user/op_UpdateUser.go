@@ -3,19 +3,55 @@ package user
 import (
 	"context"
 
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
 	pb "github.com/andrew-womeldorf/connect-boilerplate/gen/user/v1"
+	"github.com/andrew-womeldorf/connect-boilerplate/internal/services/user/store"
 )
 
 func (s *Service) UpdateUser(ctx context.Context, req *pb.UpdateUserRequest) (*pb.UpdateUserResponse, error) {
-	user := &pb.User{
-		Id:    req.Id,
-		Name:  req.Name,
-		Email: req.Email,
+	if err := ValidateRequest(req); err != nil {
+		return nil, err
+	}
+
+	ctx = withRPC(ctx, "UpdateUser")
+
+	expectedVersion, err := parseEtag(req.ExpectedEtag)
+	if err != nil {
+		return nil, err
 	}
 
-	if err := s.store.UpdateUser(ctx, user); err != nil {
+	user, err := s.store.UpdateUser(ctx, &pb.User{
+		Id:        req.Id,
+		Name:      req.Name,
+		Email:     req.Email,
+		UpdatedAt: timestamppb.New(s.clock.Now()),
+	}, updateMask(req.UpdateMask), expectedVersion)
+	if err != nil {
 		return nil, err
 	}
 
-	return &pb.UpdateUserResponse{User: user}, nil
+	return &pb.UpdateUserResponse{User: withEtag(user)}, nil
+}
+
+// updateMask returns the fields an update_mask selects. An empty mask selects
+// every mutable field, as updates did before masks were supported. Its paths
+// are checked by the rules of UpdateUserRequest.
+func updateMask(m *fieldmaskpb.FieldMask) store.FieldMask {
+	if len(m.GetPaths()) == 0 {
+		return store.AllFields
+	}
+
+	var mask store.FieldMask
+	for _, path := range m.GetPaths() {
+		switch path {
+		case "name":
+			mask.Name = true
+		case "email":
+			mask.Email = true
+		}
+	}
+
+	return mask
 }
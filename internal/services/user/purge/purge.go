@@ -0,0 +1,112 @@
+// Package purge permanently removes users once they have been deleted for
+// longer than a retention window.
+//
+// Deleted users are kept so that they can be undeleted. A Job purges them in
+// the background, across every tenant, recording a purge event and audit
+// event for each.
+package purge
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/andrew-womeldorf/connect-boilerplate/internal/services/user/store"
+)
+
+const (
+	DefaultBatchSize = 100
+	DefaultInterval  = time.Hour
+)
+
+// Actor is who the audit events of purged users name as having purged them.
+const Actor = "purge"
+
+// Job purges users deleted longer ago than its retention.
+type Job struct {
+	purger    store.Purger
+	retention time.Duration
+	batchSize int32
+	interval  time.Duration
+	now       func() time.Time
+}
+
+type Option func(*Job)
+
+// WithBatchSize sets how many users are purged at a time.
+func WithBatchSize(n int32) Option {
+	return func(j *Job) {
+		j.batchSize = n
+	}
+}
+
+// WithInterval sets how long Run waits before looking for users to purge
+// again once none are due or a purge failed.
+func WithInterval(d time.Duration) Option {
+	return func(j *Job) {
+		j.interval = d
+	}
+}
+
+// WithClock sets the function telling the job what time it is, which the
+// retention counts back from and purges are recorded at.
+func WithClock(now func() time.Time) Option {
+	return func(j *Job) {
+		j.now = now
+	}
+}
+
+// NewJob returns a Job purging the users of purger that have been deleted
+// for longer than retention.
+func NewJob(purger store.Purger, retention time.Duration, opts ...Option) *Job {
+	j := &Job{
+		purger:    purger,
+		retention: retention,
+		batchSize: DefaultBatchSize,
+		interval:  DefaultInterval,
+		now:       time.Now,
+	}
+
+	for _, opt := range opts {
+		opt(j)
+	}
+
+	return j
+}
+
+// Run purges users until ctx is done. Failures are logged and retried.
+func (j *Job) Run(ctx context.Context) {
+	for {
+		n, err := j.Purge(ctx)
+		if err != nil && ctx.Err() == nil {
+			slog.ErrorContext(ctx, "could not purge users", slog.Any("error", err))
+		}
+		if n > 0 {
+			slog.InfoContext(ctx, "purged users", slog.Int("count", n))
+		}
+
+		// A full batch suggests more are due, so the next is purged at once.
+		if err == nil && n == int(j.batchSize) {
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(j.interval):
+		}
+	}
+}
+
+// Purge purges one batch of the users that are due, returning how many
+// there were.
+func (j *Job) Purge(ctx context.Context) (int, error) {
+	info := store.AuditInfoFromContext(ctx)
+	if info.Actor == "" {
+		info.Actor = Actor
+	}
+	ctx = store.WithAuditInfo(ctx, info)
+
+	now := j.now()
+	return j.purger.PurgeUsers(ctx, now.Add(-j.retention), j.batchSize, now)
+}
@@ -0,0 +1,159 @@
+package purge
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	pb "github.com/andrew-womeldorf/connect-boilerplate/gen/user/v1"
+	"github.com/andrew-womeldorf/connect-boilerplate/internal/services/user/store"
+	"github.com/andrew-womeldorf/connect-boilerplate/internal/services/user/store/memory"
+)
+
+// deleteUsers creates and deletes a user for each id.
+func deleteUsers(ctx context.Context, t *testing.T, s store.Store, ids ...string) {
+	t.Helper()
+
+	for _, id := range ids {
+		now := timestamppb.Now()
+		user := &pb.User{Id: id, Name: id, Email: id + "@example.com", CreatedAt: now, UpdatedAt: now}
+		if err := s.CreateUser(ctx, user); err != nil {
+			t.Fatalf("failed to create user: %v", err)
+		}
+		if err := s.DeleteUser(ctx, id, 0, time.Now()); err != nil {
+			t.Fatalf("failed to delete user: %v", err)
+		}
+	}
+}
+
+// deletedUsers counts the deleted users of the tenant of ctx.
+func deletedUsers(ctx context.Context, t *testing.T, s store.Store) int64 {
+	t.Helper()
+
+	all, err := s.CountUsers(ctx, store.UserFilter{ShowDeleted: true})
+	if err != nil {
+		t.Fatalf("failed to count users: %v", err)
+	}
+	live, err := s.CountUsers(ctx, store.UserFilter{})
+	if err != nil {
+		t.Fatalf("failed to count users: %v", err)
+	}
+	return all - live
+}
+
+func TestJobPurge(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("purges_in_batches", func(t *testing.T) {
+		s := memory.NewStore()
+		deleteUsers(ctx, t, s, "a", "b", "c")
+
+		job := NewJob(s, 0, WithBatchSize(2))
+		for _, want := range []int{2, 1, 0} {
+			n, err := job.Purge(ctx)
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if n != want {
+				t.Errorf("expected %d users purged, got %d", want, n)
+			}
+		}
+
+		if n := deletedUsers(ctx, t, s); n != 0 {
+			t.Errorf("expected no deleted users left, got %d", n)
+		}
+	})
+
+	t.Run("keeps_users_within_retention", func(t *testing.T) {
+		s := memory.NewStore()
+		deleteUsers(ctx, t, s, "a")
+
+		n, err := NewJob(s, time.Hour).Purge(ctx)
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if n != 0 {
+			t.Errorf("expected no users purged, got %d", n)
+		}
+
+		if _, err := s.UndeleteUser(ctx, "a", 0, time.Now()); err != nil {
+			t.Errorf("expected the user to still be undeletable, got %v", err)
+		}
+	})
+
+	t.Run("audits_as_purge", func(t *testing.T) {
+		s := memory.NewStore()
+		deleteUsers(ctx, t, s, "a")
+
+		if _, err := NewJob(s, 0).Purge(ctx); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+
+		events, _, err := s.ListAuditEvents(ctx, store.ListAuditEventsParams{Filter: store.AuditFilter{UserID: "a"}})
+		if err != nil {
+			t.Fatalf("failed to list audit events: %v", err)
+		}
+		last := events[len(events)-1]
+		if last.GetAction() != pb.AuditAction_AUDIT_ACTION_PURGE || last.GetActor() != Actor {
+			t.Errorf("expected a purge by %q, got %v", Actor, last)
+		}
+	})
+
+	t.Run("purges_by_its_clock", func(t *testing.T) {
+		s := memory.NewStore()
+		deleteUsers(ctx, t, s, "a")
+
+		now := time.Now().Add(2 * time.Hour)
+		n, err := NewJob(s, time.Hour, WithClock(func() time.Time { return now })).Purge(ctx)
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if n != 1 {
+			t.Fatalf("expected the user deleted past the retention purged, got %d", n)
+		}
+
+		events, _, err := s.ListAuditEvents(ctx, store.ListAuditEventsParams{Filter: store.AuditFilter{UserID: "a"}})
+		if err != nil {
+			t.Fatalf("failed to list audit events: %v", err)
+		}
+		if got := events[len(events)-1].GetOccurredAt().AsTime(); !got.Equal(now) {
+			t.Errorf("expected the purge audited at %v, got %v", now, got)
+		}
+	})
+}
+
+func TestJobRun(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	s := memory.NewStore()
+	deleteUsers(ctx, t, s, "a")
+	job := NewJob(s, 0, WithInterval(time.Millisecond))
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		job.Run(ctx)
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for deletedUsers(ctx, t, s) != 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("expected the user to be purged")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	if _, err := s.UndeleteUser(ctx, "a", 0, time.Now()); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("expected the purged user to be ErrNotFound, got %v", err)
+	}
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected Run to return once ctx is done")
+	}
+}
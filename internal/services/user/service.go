@@ -5,8 +5,37 @@ import "github.com/andrew-womeldorf/connect-boilerplate/internal/services/user/s
 // Service handles the business logic
 type Service struct {
 	store store.Store
+	clock Clock
+	ids   IDGenerator
 }
 
-func NewService(store store.Store) *Service {
-	return &Service{store}
+type Option func(*Service)
+
+// WithClock sets the clock used to stamp created_at, updated_at and
+// deleted_at, and the times of the events recorded for each change.
+func WithClock(clock Clock) Option {
+	return func(s *Service) {
+		s.clock = clock
+	}
+}
+
+// WithIDGenerator sets the generator of the ids of users created without one.
+func WithIDGenerator(ids IDGenerator) Option {
+	return func(s *Service) {
+		s.ids = ids
+	}
+}
+
+func NewService(store store.Store, opts ...Option) *Service {
+	s := &Service{
+		store: store,
+		clock: systemClock{},
+		ids:   UUIDv7Generator{},
+	}
+
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	return s
 }
@@ -0,0 +1,590 @@
+package user
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"buf.build/gen/go/bufbuild/protovalidate/protocolbuffers/go/buf/validate"
+	"github.com/google/uuid"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	pb "github.com/andrew-womeldorf/connect-boilerplate/gen/user/v1"
+	"github.com/andrew-womeldorf/connect-boilerplate/internal/services/user/store"
+	"github.com/andrew-womeldorf/connect-boilerplate/internal/services/user/store/memory"
+	"github.com/andrew-womeldorf/connect-boilerplate/internal/services/user/store/search"
+)
+
+func TestServiceTimestamps(t *testing.T) {
+	ctx := context.Background()
+
+	st := memory.NewStore()
+
+	now := time.Date(2024, 1, 2, 3, 4, 5, 6000, time.UTC)
+	svc := NewService(st, WithClock(ClockFunc(func() time.Time { return now })))
+
+	created, err := svc.CreateUser(ctx, &pb.CreateUserRequest{Name: "John Doe", Email: "john@example.com"})
+	if err != nil {
+		t.Fatalf("failed to create user: %v", err)
+	}
+
+	createdAt := now
+	if got := created.User.GetCreatedAt().AsTime(); !got.Equal(createdAt) {
+		t.Errorf("expected created_at %s, got %s", createdAt, got)
+	}
+	if got := created.User.GetUpdatedAt().AsTime(); !got.Equal(createdAt) {
+		t.Errorf("expected updated_at %s, got %s", createdAt, got)
+	}
+
+	now = now.Add(time.Hour)
+	updated, err := svc.UpdateUser(ctx, &pb.UpdateUserRequest{
+		Id:    created.User.GetId(),
+		Name:  "John Smith",
+		Email: "john@example.com",
+	})
+	if err != nil {
+		t.Fatalf("failed to update user: %v", err)
+	}
+
+	if got := updated.User.GetCreatedAt().AsTime(); !got.Equal(createdAt) {
+		t.Errorf("expected created_at to stay %s, got %s", createdAt, got)
+	}
+	if got := updated.User.GetUpdatedAt().AsTime(); !got.Equal(now) {
+		t.Errorf("expected updated_at %s, got %s", now, got)
+	}
+
+	now = now.Add(time.Hour)
+	if _, err := svc.DeleteUser(ctx, &pb.DeleteUserRequest{Id: created.User.GetId()}); err != nil {
+		t.Fatalf("failed to delete user: %v", err)
+	}
+
+	users, _, err := st.ListUsers(ctx, store.ListUsersParams{Filter: store.UserFilter{ShowDeleted: true}})
+	if err != nil || len(users) != 1 {
+		t.Fatalf("failed to list the deleted user: %d users, %v", len(users), err)
+	}
+	if got := users[0].GetDeletedAt().AsTime(); !got.Equal(now) {
+		t.Errorf("expected deleted_at %s, got %s", now, got)
+	}
+}
+
+func TestServiceEtags(t *testing.T) {
+	ctx := context.Background()
+
+	svc := NewService(memory.NewStore())
+
+	created, err := svc.CreateUser(ctx, &pb.CreateUserRequest{Name: "John Doe", Email: "john@example.com"})
+	if err != nil {
+		t.Fatalf("failed to create user: %v", err)
+	}
+	if got := created.User.GetEtag(); got != `"1"` {
+		t.Errorf("expected etag %q, got %q", `"1"`, got)
+	}
+
+	updated, err := svc.UpdateUser(ctx, &pb.UpdateUserRequest{
+		Id:           created.User.GetId(),
+		Name:         "John Smith",
+		Email:        "john@example.com",
+		ExpectedEtag: created.User.GetEtag(),
+	})
+	if err != nil {
+		t.Fatalf("failed to update user: %v", err)
+	}
+	if got := updated.User.GetEtag(); got != `"2"` {
+		t.Errorf("expected etag %q, got %q", `"2"`, got)
+	}
+
+	got, err := svc.GetUser(ctx, &pb.GetUserRequest{Id: created.User.GetId()})
+	if err != nil {
+		t.Fatalf("failed to get user: %v", err)
+	}
+	if got.User.GetEtag() != updated.User.GetEtag() {
+		t.Errorf("expected etag %q, got %q", updated.User.GetEtag(), got.User.GetEtag())
+	}
+
+	_, err = svc.DeleteUser(ctx, &pb.DeleteUserRequest{
+		Id:           created.User.GetId(),
+		ExpectedEtag: created.User.GetEtag(),
+	})
+	if !errors.Is(err, store.ErrPreconditionFailed) {
+		t.Errorf("expected ErrPreconditionFailed for a stale etag, got %v", err)
+	}
+
+	_, err = svc.DeleteUser(ctx, &pb.DeleteUserRequest{
+		Id:           created.User.GetId(),
+		ExpectedEtag: `"0"`,
+	})
+	if !errors.Is(err, ErrInvalidEtag) {
+		t.Errorf("expected ErrInvalidEtag, got %v", err)
+	}
+
+	if _, err := svc.DeleteUser(ctx, &pb.DeleteUserRequest{
+		Id:           created.User.GetId(),
+		ExpectedEtag: updated.User.GetEtag(),
+	}); err != nil {
+		t.Errorf("failed to delete user: %v", err)
+	}
+}
+
+func TestServiceBatch(t *testing.T) {
+	ctx := context.Background()
+
+	svc := NewService(memory.NewStore())
+
+	created, err := svc.BatchCreateUsers(ctx, &pb.BatchCreateUsersRequest{
+		Requests: []*pb.CreateUserRequest{
+			{Name: "John Doe", Email: "john@example.com"},
+			{Name: "Johnny Doe", Email: "JOHN@example.com"},
+			{Name: "Jane Doe", Email: "jane@example.com"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to create users: %v", err)
+	}
+	if created.GetFailureCount() != 1 {
+		t.Errorf("expected 1 failure, got %d", created.GetFailureCount())
+	}
+	if got := created.Results[1].GetError().GetReason(); got != ReasonAlreadyExists {
+		t.Errorf("expected reason %s, got %q", ReasonAlreadyExists, got)
+	}
+	john, jane := created.Results[0].GetUser(), created.Results[2].GetUser()
+	if john.GetEtag() == "" || jane.GetEtag() == "" {
+		t.Fatalf("expected created users with etags, got %v and %v", john, jane)
+	}
+
+	deleted, err := svc.BatchDeleteUsers(ctx, &pb.BatchDeleteUsersRequest{
+		Requests: []*pb.DeleteUserRequest{
+			{Id: john.GetId(), ExpectedEtag: `"0"`},
+			{Id: jane.GetId(), ExpectedEtag: jane.GetEtag()},
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to delete users: %v", err)
+	}
+	if deleted.GetFailureCount() != 1 {
+		t.Errorf("expected 1 failure, got %d", deleted.GetFailureCount())
+	}
+	if got := deleted.Results[0].GetError().GetReason(); got != ReasonInvalidArgument {
+		t.Errorf("expected reason %s, got %q", ReasonInvalidArgument, got)
+	}
+	if deleted.Results[1].GetError() != nil {
+		t.Errorf("expected user %s to be deleted, got %v", jane.GetId(), deleted.Results[1].GetError())
+	}
+
+	got, err := svc.BatchGetUsers(ctx, &pb.BatchGetUsersRequest{Ids: []string{john.GetId(), jane.GetId()}})
+	if err != nil {
+		t.Fatalf("failed to get users: %v", err)
+	}
+	if got.Results[0].GetUser().GetEtag() != john.GetEtag() {
+		t.Errorf("expected user %s with etag %s, got %v", john.GetId(), john.GetEtag(), got.Results[0])
+	}
+	if got.Results[1].GetError().GetReason() != ReasonNotFound {
+		t.Errorf("expected user %s to be gone, got %v", jane.GetId(), got.Results[1])
+	}
+
+	_, err = svc.BatchGetUsers(ctx, &pb.BatchGetUsersRequest{Ids: make([]string, store.MaxBatchSize+1)})
+	if !errors.Is(err, ErrInvalidRequest) {
+		t.Errorf("expected ErrInvalidRequest, got %v", err)
+	}
+}
+
+func TestServiceUpdateMask(t *testing.T) {
+	ctx := context.Background()
+
+	svc := NewService(memory.NewStore())
+
+	created, err := svc.CreateUser(ctx, &pb.CreateUserRequest{Name: "John Doe", Email: "john@example.com"})
+	if err != nil {
+		t.Fatalf("failed to create user: %v", err)
+	}
+
+	updated, err := svc.UpdateUser(ctx, &pb.UpdateUserRequest{
+		Id:         created.User.GetId(),
+		Name:       "John Smith",
+		UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"name"}},
+	})
+	if err != nil {
+		t.Fatalf("failed to update user: %v", err)
+	}
+	if updated.User.GetName() != "John Smith" || updated.User.GetEmail() != "john@example.com" {
+		t.Errorf("expected only the name to change, got %s <%s>", updated.User.GetName(), updated.User.GetEmail())
+	}
+
+	for _, path := range []string{"id", "created_at", "nickname"} {
+		_, err := svc.UpdateUser(ctx, &pb.UpdateUserRequest{
+			Id:         created.User.GetId(),
+			UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{path}},
+		})
+		if !errors.Is(err, ErrInvalidRequest) {
+			t.Errorf("%s: expected ErrInvalidRequest, got %v", path, err)
+		}
+	}
+}
+
+func TestServiceListUsers(t *testing.T) {
+	ctx := context.Background()
+
+	svc := NewService(memory.NewStore())
+
+	for _, name := range []string{"Carol", "Alice", "Bob"} {
+		if _, err := svc.CreateUser(ctx, &pb.CreateUserRequest{Name: name, Email: name + "@example.com"}); err != nil {
+			t.Fatalf("failed to create user: %v", err)
+		}
+	}
+
+	resp, err := svc.ListUsers(ctx, &pb.ListUsersRequest{
+		OrderBy:       " name  desc ",
+		Filter:        &pb.UserFilter{EmailDomain: "Example.com"},
+		PageSize:      2,
+		ShowTotalSize: true,
+	})
+	if err != nil {
+		t.Fatalf("failed to list users: %v", err)
+	}
+	if len(resp.Users) != 2 || resp.Users[0].GetName() != "Carol" || resp.Users[1].GetName() != "Bob" {
+		t.Errorf("expected Carol then Bob, got %v", resp.Users)
+	}
+	if resp.TotalSize != 3 {
+		t.Errorf("expected a total size of 3, got %d", resp.TotalSize)
+	}
+
+	for _, orderBy := range []string{"email", "name up", "name desc id"} {
+		_, err := svc.ListUsers(ctx, &pb.ListUsersRequest{OrderBy: orderBy})
+		if !errors.Is(err, ErrInvalidOrderBy) {
+			t.Errorf("%q: expected ErrInvalidOrderBy, got %v", orderBy, err)
+		}
+	}
+
+	now := time.Now()
+	for name, tt := range map[string]struct {
+		filter *pb.UserFilter
+		want   error
+	}{
+		"email":         {&pb.UserFilter{EmailDomain: "bob@example.com"}, ErrInvalidRequest},
+		"reversed":      {&pb.UserFilter{CreatedAfter: timestamppb.New(now), CreatedBefore: timestamppb.New(now.Add(-time.Hour))}, ErrInvalidRequest},
+		"invalid_after": {&pb.UserFilter{CreatedAfter: &timestamppb.Timestamp{Nanos: -1}}, ErrInvalidFilter},
+	} {
+		_, err := svc.ListUsers(ctx, &pb.ListUsersRequest{Filter: tt.filter})
+		if !errors.Is(err, tt.want) {
+			t.Errorf("%s: expected %v, got %v", name, tt.want, err)
+		}
+	}
+}
+
+func TestServiceUndeleteUser(t *testing.T) {
+	ctx := context.Background()
+
+	svc := NewService(memory.NewStore())
+
+	created, err := svc.CreateUser(ctx, &pb.CreateUserRequest{Name: "John Doe", Email: "john@example.com"})
+	if err != nil {
+		t.Fatalf("failed to create user: %v", err)
+	}
+	if _, err := svc.DeleteUser(ctx, &pb.DeleteUserRequest{Id: created.User.GetId()}); err != nil {
+		t.Fatalf("failed to delete user: %v", err)
+	}
+
+	listed, err := svc.ListUsers(ctx, &pb.ListUsersRequest{ShowDeleted: true})
+	if err != nil {
+		t.Fatalf("failed to list users: %v", err)
+	}
+	if len(listed.Users) != 1 || listed.Users[0].GetDeletedAt() == nil {
+		t.Fatalf("expected show_deleted to list the deleted user, got %v", listed.Users)
+	}
+
+	_, err = svc.UndeleteUser(ctx, &pb.UndeleteUserRequest{
+		Id:           created.User.GetId(),
+		ExpectedEtag: created.User.GetEtag(),
+	})
+	if !errors.Is(err, store.ErrPreconditionFailed) {
+		t.Errorf("expected ErrPreconditionFailed for a stale etag, got %v", err)
+	}
+
+	restored, err := svc.UndeleteUser(ctx, &pb.UndeleteUserRequest{
+		Id:           created.User.GetId(),
+		ExpectedEtag: listed.Users[0].GetEtag(),
+	})
+	if err != nil {
+		t.Fatalf("failed to undelete user: %v", err)
+	}
+	if got := restored.User.GetEtag(); got != `"3"` || restored.User.GetDeletedAt() != nil {
+		t.Errorf("expected the live user at etag %q, got %v", `"3"`, restored.User)
+	}
+
+	if _, err := svc.GetUser(ctx, &pb.GetUserRequest{Id: created.User.GetId()}); err != nil {
+		t.Errorf("expected the restored user to be found, got %v", err)
+	}
+}
+
+func TestServiceSearchUsers(t *testing.T) {
+	ctx := context.Background()
+
+	if _, err := NewService(memory.NewStore()).SearchUsers(ctx, &pb.SearchUsersRequest{Query: "jon"}); !errors.Is(err, ErrSearchNotSupported) {
+		t.Errorf("expected ErrSearchNotSupported without a searcher, got %v", err)
+	}
+
+	svc := NewService(search.New(memory.NewStore(), search.NewMemoryIndex()))
+
+	if _, err := svc.CreateUser(ctx, &pb.CreateUserRequest{Name: "Jon Smith", Email: "jon@example.com"}); err != nil {
+		t.Fatalf("failed to create user: %v", err)
+	}
+
+	resp, err := svc.SearchUsers(ctx, &pb.SearchUsersRequest{Query: "jon smi"})
+	if err != nil {
+		t.Fatalf("failed to search users: %v", err)
+	}
+	if len(resp.Results) != 1 {
+		t.Fatalf("expected 1 result, got %d", len(resp.Results))
+	}
+	if got := resp.Results[0].GetUser().GetEtag(); got != etag(store.FirstVersion) {
+		t.Errorf("expected etag %s, got %s", etag(store.FirstVersion), got)
+	}
+
+	if _, err := svc.SearchUsers(ctx, &pb.SearchUsersRequest{Query: "  -- "}); !errors.Is(err, ErrInvalidQuery) {
+		t.Errorf("expected ErrInvalidQuery, got %v", err)
+	}
+}
+
+func TestServiceListAuditEvents(t *testing.T) {
+	ctx := store.WithAuditInfo(context.Background(), store.AuditInfo{Actor: "alice", RequestID: "req-1"})
+
+	svc := NewService(memory.NewStore())
+
+	created, err := svc.CreateUser(ctx, &pb.CreateUserRequest{Name: "John Doe", Email: "john@example.com"})
+	if err != nil {
+		t.Fatalf("failed to create user: %v", err)
+	}
+	if _, err := svc.DeleteUser(ctx, &pb.DeleteUserRequest{Id: created.User.GetId()}); err != nil {
+		t.Fatalf("failed to delete user: %v", err)
+	}
+
+	resp, err := svc.ListAuditEvents(ctx, &pb.ListAuditEventsRequest{UserId: created.User.GetId()})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if len(resp.AuditEvents) != 2 {
+		t.Fatalf("expected 2 audit events, got %d", len(resp.AuditEvents))
+	}
+	for i, rpc := range []string{"CreateUser", "DeleteUser"} {
+		event := resp.AuditEvents[i]
+		if event.GetRpc() != rpc || event.GetActor() != "alice" || event.GetRequestId() != "req-1" {
+			t.Errorf("expected %s by alice in req-1, got %v", rpc, event)
+		}
+	}
+
+	now := time.Now()
+	_, err = svc.ListAuditEvents(ctx, &pb.ListAuditEventsRequest{
+		StartTime: timestamppb.New(now),
+		EndTime:   timestamppb.New(now.Add(-time.Hour)),
+	})
+	if !errors.Is(err, ErrInvalidRequest) {
+		t.Errorf("expected ErrInvalidRequest for an inverted range, got %v", err)
+	}
+}
+
+func TestServiceUserRevisions(t *testing.T) {
+	ctx := context.Background()
+
+	svc := NewService(memory.NewStore())
+
+	created, err := svc.CreateUser(ctx, &pb.CreateUserRequest{Name: "John Doe", Email: "john@example.com"})
+	if err != nil {
+		t.Fatalf("failed to create user: %v", err)
+	}
+	asOf := time.Now()
+	if _, err := svc.UpdateUser(ctx, &pb.UpdateUserRequest{
+		Id:    created.User.GetId(),
+		Name:  "John Smith",
+		Email: "john@example.com",
+	}); err != nil {
+		t.Fatalf("failed to update user: %v", err)
+	}
+
+	resp, err := svc.ListUserRevisions(ctx, &pb.ListUserRevisionsRequest{UserId: created.User.GetId()})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if len(resp.Revisions) != 2 {
+		t.Fatalf("expected 2 revisions, got %d", len(resp.Revisions))
+	}
+	for i, etag := range []string{`"2"`, `"1"`} {
+		if got := resp.Revisions[i].GetUser().GetEtag(); got != etag {
+			t.Errorf("revision %d: expected etag %s, got %s", i, etag, got)
+		}
+	}
+
+	got, err := svc.GetUser(ctx, &pb.GetUserRequest{Id: created.User.GetId(), AsOf: timestamppb.New(asOf)})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if got.User.GetName() != "John Doe" || got.User.GetEtag() != `"1"` {
+		t.Errorf("expected the user as created, got %v", got.User)
+	}
+
+	invalid := &timestamppb.Timestamp{Nanos: -1}
+	if _, err := svc.GetUser(ctx, &pb.GetUserRequest{Id: created.User.GetId(), AsOf: invalid}); !errors.Is(err, ErrInvalidAsOf) {
+		t.Errorf("expected ErrInvalidAsOf, got %v", err)
+	}
+	if _, err := svc.ListUserRevisions(ctx, &pb.ListUserRevisionsRequest{UserId: created.User.GetId(), PageSize: -1}); !errors.Is(err, ErrInvalidRequest) {
+		t.Errorf("expected ErrInvalidRequest, got %v", err)
+	}
+}
+
+func TestServiceUserIDs(t *testing.T) {
+	ctx := context.Background()
+
+	svc := NewService(memory.NewStore(), WithIDGenerator(NewSequentialIDGenerator("user-")))
+
+	for _, want := range []string{"user-00000001", "user-00000002"} {
+		created, err := svc.CreateUser(ctx, &pb.CreateUserRequest{Name: "John Doe", Email: want + "@example.com"})
+		if err != nil {
+			t.Fatalf("failed to create user: %v", err)
+		}
+		if created.User.GetId() != want {
+			t.Errorf("expected id %q, got %q", want, created.User.GetId())
+		}
+	}
+
+	req := &pb.CreateUserRequest{Name: "Jane Doe", Email: "jane@example.com", UserId: "01J9ZK3W5QF8X2N4R6T8V0B2D4"}
+	created, err := svc.CreateUser(ctx, req)
+	if err != nil {
+		t.Fatalf("failed to create user: %v", err)
+	}
+	if created.User.GetId() != req.UserId {
+		t.Errorf("expected the requested id %q, got %q", req.UserId, created.User.GetId())
+	}
+	if _, err := svc.CreateUser(ctx, req); !errors.Is(err, store.ErrAlreadyExists) {
+		t.Errorf("expected a retried create to be ErrAlreadyExists, got %v", err)
+	}
+
+	for _, id := range []string{"has space", "tenant#user", strings.Repeat("a", 65)} {
+		_, err := svc.CreateUser(ctx, &pb.CreateUserRequest{Name: "Bad", Email: "bad@example.com", UserId: id})
+		if !errors.Is(err, ErrInvalidRequest) {
+			t.Errorf("%q: expected ErrInvalidRequest, got %v", id, err)
+		}
+	}
+
+	_, err = svc.BatchCreateUsers(ctx, &pb.BatchCreateUsersRequest{Requests: []*pb.CreateUserRequest{
+		{Name: "Good", Email: "good@example.com"},
+		{Name: "Bad", Email: "bad@example.com", UserId: "bad id"},
+	}})
+	if !errors.Is(err, ErrInvalidRequest) {
+		t.Errorf("expected a batch with a bad id to be ErrInvalidRequest, got %v", err)
+	}
+	if _, err := svc.GetUserByEmail(ctx, &pb.GetUserByEmailRequest{Email: "good@example.com"}); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("expected no user created from the rejected batch, got %v", err)
+	}
+}
+
+func TestIDGenerators(t *testing.T) {
+	for name, tt := range map[string]struct {
+		ids   IDGenerator
+		valid func(string) bool
+	}{
+		"uuidv7": {UUIDv7Generator{}, func(id string) bool {
+			u, err := uuid.Parse(id)
+			return err == nil && u.Version() == 7
+		}},
+		"ulid": {&ULIDGenerator{}, func(id string) bool {
+			return len(id) == 26 && id[0] <= '7' && strings.Trim(id, crockford) == ""
+		}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			// Enough ids that many share a millisecond.
+			ids := make([]string, 1000)
+			for i := range ids {
+				ids[i] = tt.ids.NewID()
+				if !tt.valid(ids[i]) {
+					t.Fatalf("malformed id %q", ids[i])
+				}
+				if err := ValidateRequest(&pb.GetUserRequest{Id: ids[i]}); err != nil {
+					t.Fatalf("expected id to be a valid user id, got %v", err)
+				}
+			}
+			if !slices.IsSorted(ids) || len(slices.Compact(slices.Clone(ids))) != len(ids) {
+				t.Errorf("expected unique ids in the order they were made")
+			}
+		})
+	}
+}
+
+func TestValidateRequest(t *testing.T) {
+	tests := []struct {
+		name   string
+		req    proto.Message
+		fields []string
+	}{
+		{"valid", &pb.CreateUserRequest{Name: "Jane Doe", Email: "jane@example.com"}, nil},
+		{"missing_fields", &pb.CreateUserRequest{}, []string{"name", "email"}},
+		{"invalid_email", &pb.CreateUserRequest{Name: "Jane Doe", Email: "jane"}, []string{"email"}},
+		{"long_name", &pb.CreateUserRequest{Name: strings.Repeat("n", 257), Email: "jane@example.com"}, []string{"name"}},
+		{"invalid_user_id", &pb.CreateUserRequest{Name: "Jane Doe", Email: "jane@example.com", UserId: "a#b"}, []string{"user_id"}},
+		{"missing_id", &pb.GetUserRequest{}, []string{"id"}},
+		{"negative_page_size", &pb.ListUsersRequest{PageSize: -1}, []string{"page_size"}},
+		{"email_domain", &pb.ListUsersRequest{Filter: &pb.UserFilter{EmailDomain: "@example.com"}}, []string{"filter.email_domain"}},
+		{"inverted_range", &pb.ListAuditEventsRequest{
+			StartTime: timestamppb.New(time.Unix(2, 0)),
+			EndTime:   timestamppb.New(time.Unix(1, 0)),
+		}, []string{""}},
+		{"batch_item", &pb.BatchCreateUsersRequest{Requests: []*pb.CreateUserRequest{
+			{Name: "Jane Doe", Email: "jane@example.com"},
+			{Name: "John Doe", Email: "john"},
+		}}, []string{"requests[1].email"}},
+		{"batch_too_large", &pb.BatchGetUsersRequest{Ids: slices.Repeat([]string{"id"}, store.MaxBatchSize+1)}, []string{"ids"}},
+		{"update_masked", &pb.UpdateUserRequest{
+			Id:         "id",
+			Name:       "Jane Doe",
+			UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"name"}},
+		}, nil},
+		{"update_unmasked", &pb.UpdateUserRequest{Id: "id", Name: "Jane Doe"}, []string{""}},
+		{"update_mask_path", &pb.UpdateUserRequest{
+			Id:         "id",
+			UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"id"}},
+		}, []string{"update_mask"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateRequest(tt.req)
+			if tt.fields == nil {
+				if err != nil {
+					t.Fatalf("expected no error, got %v", err)
+				}
+				return
+			}
+
+			var validationErr *ValidationError
+			if !errors.As(err, &validationErr) || !errors.Is(err, ErrInvalidRequest) {
+				t.Fatalf("expected a ValidationError, got %v", err)
+			}
+			var fields []string
+			for _, v := range validationErr.Violations {
+				fields = append(fields, v.Field)
+			}
+			if !slices.Equal(fields, tt.fields) {
+				t.Errorf("expected violations of %q, got %q", tt.fields, fields)
+			}
+			if reason := ErrorReason(err); reason != ReasonInvalidArgument {
+				t.Errorf("expected reason %s, got %s", ReasonInvalidArgument, reason)
+			}
+		})
+	}
+}
+
+func TestBatchRulesMatchStore(t *testing.T) {
+	for _, msg := range []proto.Message{
+		&pb.BatchCreateUsersRequest{},
+		&pb.BatchDeleteUsersRequest{},
+		&pb.BatchGetUsersRequest{},
+	} {
+		desc := msg.ProtoReflect().Descriptor()
+		field := desc.Fields().ByNumber(1)
+		rules, _ := proto.GetExtension(field.Options(), validate.E_Field).(*validate.FieldRules)
+		if got := rules.GetRepeated().GetMaxItems(); got != store.MaxBatchSize {
+			t.Errorf("expected %s.%s to allow at most store.MaxBatchSize (%d) items, got %d", desc.Name(), field.Name(), store.MaxBatchSize, got)
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package store
+
+import (
+	"cmp"
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	pb "github.com/andrew-womeldorf/connect-boilerplate/gen/user/v1"
+)
+
+// AuditLog keeps a record of who changed which user, and how.
+//
+// Every write that changes a user records one audit event, in the same
+// transaction as the change and its Outbox event, describing the caller by
+// the AuditInfo of the write's context. Unlike events in the outbox, audit
+// events are kept, and belong to the tenant of the user they describe.
+type AuditLog interface {
+	// ListAuditEvents returns a page of the audit events of the tenant of
+	// ctx matching params.Filter, oldest first, and a token for the next
+	// page if there may be one.
+	ListAuditEvents(ctx context.Context, params ListAuditEventsParams) ([]*pb.AuditEvent, string, error)
+}
+
+// ListAuditEventsParams controls which page of audit events a store returns.
+type ListAuditEventsParams struct {
+	PageSize  int32
+	PageToken string
+	Filter    AuditFilter
+}
+
+// Limit returns the page size clamped as ListUsersParams.Limit does.
+func (p ListAuditEventsParams) Limit() int32 {
+	return ListUsersParams{PageSize: p.PageSize}.Limit()
+}
+
+// AuditFilter selects the audit events that match every field that is set.
+// The zero AuditFilter matches every event.
+type AuditFilter struct {
+	UserID string
+	// StartTime matches events that occurred at or after it, and EndTime
+	// events that occurred before it.
+	StartTime time.Time
+	EndTime   time.Time
+}
+
+// Matches reports whether event passes the filter.
+func (f AuditFilter) Matches(event *pb.AuditEvent) bool {
+	occurredAt := event.GetOccurredAt().AsTime()
+	switch {
+	case f.UserID != "" && event.GetUserId() != f.UserID:
+		return false
+	case !f.StartTime.IsZero() && occurredAt.Before(f.StartTime):
+		return false
+	case !f.EndTime.IsZero() && !occurredAt.Before(f.EndTime):
+		return false
+	default:
+		return true
+	}
+}
+
+// CompareAuditEvents orders a and b as ListAuditEvents lists them: by when
+// they occurred, then by id.
+func CompareAuditEvents(a, b *pb.AuditEvent) int {
+	return cmp.Or(
+		a.GetOccurredAt().AsTime().Compare(b.GetOccurredAt().AsTime()),
+		cmp.Compare(a.GetId(), b.GetId()),
+	)
+}
+
+// AuditInfo describes the caller making a change, for its audit event.
+type AuditInfo struct {
+	Actor     string
+	RPC       string
+	RequestID string
+}
+
+type auditInfoKey struct{}
+
+// WithAuditInfo returns a copy of ctx carrying info, for stores to record
+// with the changes made under it.
+func WithAuditInfo(ctx context.Context, info AuditInfo) context.Context {
+	return context.WithValue(ctx, auditInfoKey{}, info)
+}
+
+// AuditInfoFromContext returns the AuditInfo ctx carries, or the zero
+// AuditInfo if it has none.
+func AuditInfoFromContext(ctx context.Context) AuditInfo {
+	info, _ := ctx.Value(auditInfoKey{}).(AuditInfo)
+	return info
+}
+
+// NewAuditEvent returns the audit event for a change made under ctx at
+// occurredAt that left the user stored as before as after. before is nil for
+// a created user and after nil for a purged one; a change of deleted_at
+// deletes or undeletes the user.
+func NewAuditEvent(ctx context.Context, before, after *pb.User, occurredAt time.Time) *pb.AuditEvent {
+	info := AuditInfoFromContext(ctx)
+	event := &pb.AuditEvent{
+		Id:         uuid.Must(uuid.NewV7()).String(),
+		Actor:      info.Actor,
+		Rpc:        info.RPC,
+		RequestId:  info.RequestID,
+		OccurredAt: timestamppb.New(occurredAt),
+	}
+
+	switch {
+	case before == nil:
+		event.Action = pb.AuditAction_AUDIT_ACTION_CREATE
+		event.UserId = after.GetId()
+	case after == nil:
+		event.Action = pb.AuditAction_AUDIT_ACTION_PURGE
+		event.UserId = before.GetId()
+	case before.GetDeletedAt() == nil && after.GetDeletedAt() != nil:
+		event.Action = pb.AuditAction_AUDIT_ACTION_DELETE
+		event.UserId = after.GetId()
+	case before.GetDeletedAt() != nil && after.GetDeletedAt() == nil:
+		event.Action = pb.AuditAction_AUDIT_ACTION_UNDELETE
+		event.UserId = after.GetId()
+	default:
+		event.Action = pb.AuditAction_AUDIT_ACTION_UPDATE
+		event.UserId = after.GetId()
+	}
+
+	fields := []struct {
+		name          string
+		before, after string
+	}{
+		{"name", before.GetName(), after.GetName()},
+		{"email", before.GetEmail(), after.GetEmail()},
+		{"deleted_at", formatTimestamp(before.GetDeletedAt()), formatTimestamp(after.GetDeletedAt())},
+	}
+	for _, f := range fields {
+		if f.before != f.after {
+			event.Changes = append(event.Changes, &pb.FieldChange{Field: f.name, Before: f.before, After: f.after})
+		}
+	}
+
+	return event
+}
+
+// formatTimestamp formats ts for a FieldChange, leaving an unset one empty.
+func formatTimestamp(ts *timestamppb.Timestamp) string {
+	if ts == nil {
+		return ""
+	}
+	return ts.AsTime().Format(time.RFC3339Nano)
+}
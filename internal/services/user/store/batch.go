@@ -0,0 +1,22 @@
+package store
+
+import pb "github.com/andrew-womeldorf/connect-boilerplate/gen/user/v1"
+
+// MaxBatchSize is the most items a caller should pass to a batch method.
+// Stores split larger batches as their backend requires. The batch requests
+// of proto/user/v1 allow as many items, which a test of the service checks.
+const MaxBatchSize = 100
+
+// BatchResult is the outcome of one item of a batch: the user, for methods
+// that return one, or the error that item failed with.
+type BatchResult struct {
+	User *pb.User
+	Err  error
+}
+
+// BatchDelete names a user for BatchDeleteUsers to delete, with the version
+// it is expected to have, as for DeleteUser.
+type BatchDelete struct {
+	ID              string
+	ExpectedVersion int64
+}
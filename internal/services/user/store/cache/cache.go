@@ -0,0 +1,318 @@
+// Package cache puts a bounded in-memory cache in front of a store.Store, so
+// that repeated reads of the same users skip the backend.
+package cache
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+	"google.golang.org/protobuf/proto"
+
+	pb "github.com/andrew-womeldorf/connect-boilerplate/gen/user/v1"
+	"github.com/andrew-womeldorf/connect-boilerplate/internal/services/user/store"
+)
+
+const (
+	DefaultSize        = 1024
+	DefaultTTL         = time.Minute
+	DefaultNegativeTTL = 5 * time.Second
+)
+
+var ErrCouldNotSearchUsers = errors.New("could not search users")
+
+// Store caches the results of GetUser, including users that were not found,
+// for a limited time, evicting the least recently used users once it holds
+// its maximum. Concurrent misses for one user share a single read of the
+// wrapped store.
+//
+// Writes made through the Store invalidate the users they touch, whether or
+// not they succeed, since a failed write may still have been applied. Writes
+// made any other way, such as by another process, are only seen once the
+// cached user expires, so the TTL bounds how stale a read may be.
+//
+// Users are cached by tenant and id, so a tenant is never answered with
+// another's user. Every other read goes to the wrapped store.
+type Store struct {
+	store.Store
+
+	size        int
+	ttl         time.Duration
+	negativeTTL time.Duration
+	now         func() time.Time
+
+	group singleflight.Group
+
+	mu      sync.Mutex
+	entries map[userKey]*list.Element
+	// lru holds the entries, most recently used first.
+	lru *list.List
+	// generation is incremented by every invalidation, so that reads which
+	// started before it do not cache what they read.
+	generation uint64
+	stats      Stats
+}
+
+var _ store.Searcher = (*Store)(nil)
+
+// userKey identifies a user across tenants.
+type userKey struct {
+	tenant string
+	id     string
+}
+
+// entry is a cached GetUser result: a user, or the error for a user that was
+// not found.
+type entry struct {
+	key     userKey
+	user    *pb.User
+	err     error
+	expires time.Time
+}
+
+// Stats counts how GetUser calls were answered.
+type Stats struct {
+	// Hits were answered from the cache, including users cached as not
+	// found, and Misses from the wrapped store.
+	Hits   uint64 `json:"hits"`
+	Misses uint64 `json:"misses"`
+	// Evictions counts users dropped to make room for others, rather than
+	// because they expired or were invalidated.
+	Evictions uint64 `json:"evictions"`
+	// Size is how many users are cached now.
+	Size int `json:"size"`
+}
+
+type Option func(*Store)
+
+// WithSize sets how many users are cached at most.
+func WithSize(n int) Option {
+	return func(s *Store) {
+		s.size = n
+	}
+}
+
+// WithTTL sets how long a user is cached for.
+func WithTTL(d time.Duration) Option {
+	return func(s *Store) {
+		s.ttl = d
+	}
+}
+
+// WithNegativeTTL sets how long a user that was not found is cached for.
+// Zero disables caching them.
+func WithNegativeTTL(d time.Duration) Option {
+	return func(s *Store) {
+		s.negativeTTL = d
+	}
+}
+
+// WithClock sets the function used to tell when cached users expire.
+func WithClock(now func() time.Time) Option {
+	return func(s *Store) {
+		s.now = now
+	}
+}
+
+// New wraps s in a cache.
+func New(s store.Store, opts ...Option) *Store {
+	c := &Store{
+		Store:       s,
+		size:        DefaultSize,
+		ttl:         DefaultTTL,
+		negativeTTL: DefaultNegativeTTL,
+		now:         time.Now,
+		entries:     make(map[userKey]*list.Element),
+		lru:         list.New(),
+	}
+
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	return c
+}
+
+// Stats returns the counts so far.
+func (s *Store) Stats() Stats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stats := s.stats
+	stats.Size = s.lru.Len()
+	return stats
+}
+
+func (s *Store) GetUser(ctx context.Context, id string) (*pb.User, error) {
+	k := userKey{tenant: store.TenantFromContext(ctx), id: id}
+	e, generation, ok := s.lookup(k)
+	if ok {
+		if e.err != nil {
+			return nil, e.err
+		}
+		return proto.CloneOf(e.user), nil
+	}
+
+	// Reads that start after an invalidation must not share the result of
+	// one that started before it, so the generation is part of the key.
+	// Tenant ids hold no "/", so no two users share one.
+	key := strconv.FormatUint(generation, 10) + "/" + k.tenant + "/" + id
+	ch := s.group.DoChan(key, func() (any, error) {
+		// Callers share this read, so one giving up must not fail it for
+		// the others.
+		user, err := s.Store.GetUser(context.WithoutCancel(ctx), id)
+		s.fill(k, generation, user, err)
+		return user, err
+	})
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case res := <-ch:
+		if res.Err != nil {
+			return nil, res.Err
+		}
+		return proto.CloneOf(res.Val.(*pb.User)), nil
+	}
+}
+
+// SearchUsers passes the search to the wrapped store, so that caching a
+// store.Searcher keeps it searchable, and fails with
+// store.ErrSearchNotSupported if the wrapped store cannot search. Results are
+// not cached.
+func (s *Store) SearchUsers(ctx context.Context, params store.SearchUsersParams) ([]store.SearchResult, string, error) {
+	searcher, ok := s.Store.(store.Searcher)
+	if !ok {
+		return nil, "", fmt.Errorf("%w: %w", ErrCouldNotSearchUsers, store.ErrSearchNotSupported)
+	}
+	return searcher.SearchUsers(ctx, params)
+}
+
+func (s *Store) CreateUser(ctx context.Context, user *pb.User) error {
+	defer s.invalidate(ctx, user.GetId())
+	return s.Store.CreateUser(ctx, user)
+}
+
+func (s *Store) UpdateUser(ctx context.Context, user *pb.User, mask store.FieldMask, expectedVersion int64) (*pb.User, error) {
+	defer s.invalidate(ctx, user.GetId())
+	return s.Store.UpdateUser(ctx, user, mask, expectedVersion)
+}
+
+func (s *Store) DeleteUser(ctx context.Context, id string, expectedVersion int64, deletedAt time.Time) error {
+	defer s.invalidate(ctx, id)
+	return s.Store.DeleteUser(ctx, id, expectedVersion, deletedAt)
+}
+
+func (s *Store) UndeleteUser(ctx context.Context, id string, expectedVersion int64, undeletedAt time.Time) (*pb.User, error) {
+	defer s.invalidate(ctx, id)
+	return s.Store.UndeleteUser(ctx, id, expectedVersion, undeletedAt)
+}
+
+func (s *Store) BatchCreateUsers(ctx context.Context, users []*pb.User) ([]store.BatchResult, error) {
+	ids := make([]string, 0, len(users))
+	for _, user := range users {
+		ids = append(ids, user.GetId())
+	}
+
+	defer s.invalidate(ctx, ids...)
+	return s.Store.BatchCreateUsers(ctx, users)
+}
+
+func (s *Store) BatchDeleteUsers(ctx context.Context, deletes []store.BatchDelete, deletedAt time.Time) ([]store.BatchResult, error) {
+	ids := make([]string, 0, len(deletes))
+	for _, d := range deletes {
+		ids = append(ids, d.ID)
+	}
+
+	defer s.invalidate(ctx, ids...)
+	return s.Store.BatchDeleteUsers(ctx, deletes, deletedAt)
+}
+
+// PurgeUsers passes the purge to the wrapped store. The users it removes
+// were deleted, so the cache can only hold that they were not found, which
+// stays true; but their ids are free again once purged, so reads that began
+// before the purge must not cache what they read.
+func (s *Store) PurgeUsers(ctx context.Context, deletedBefore time.Time, limit int32, purgedAt time.Time) (int, error) {
+	defer s.invalidate(ctx)
+	return s.Store.PurgeUsers(ctx, deletedBefore, limit, purgedAt)
+}
+
+// lookup returns the unexpired entry for key, if there is one, and the
+// generation to fill the cache at if there is not.
+func (s *Store) lookup(key userKey) (*entry, uint64, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if elem, ok := s.entries[key]; ok {
+		e := elem.Value.(*entry)
+		if s.now().Before(e.expires) {
+			s.lru.MoveToFront(elem)
+			s.stats.Hits++
+			return e, s.generation, true
+		}
+		s.remove(elem)
+	}
+
+	s.stats.Misses++
+	return nil, s.generation, false
+}
+
+// fill caches the result of reading the user with key from the wrapped
+// store, unless the cache was invalidated since the read started or the
+// result is an error other than the user not being found.
+func (s *Store) fill(key userKey, generation uint64, user *pb.User, err error) {
+	e := &entry{key: key, expires: s.now().Add(s.ttl)}
+	switch {
+	case err == nil:
+		e.user = proto.CloneOf(user)
+	case store.KindOf(err) == store.KindNotFound && s.negativeTTL > 0:
+		e.err = err
+		e.expires = s.now().Add(s.negativeTTL)
+	default:
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if generation != s.generation || s.size <= 0 {
+		return
+	}
+
+	if elem, ok := s.entries[key]; ok {
+		s.remove(elem)
+	}
+	s.entries[key] = s.lru.PushFront(e)
+
+	for s.lru.Len() > s.size {
+		s.remove(s.lru.Back())
+		s.stats.Evictions++
+	}
+}
+
+// invalidate drops the users with ids in the tenant of ctx, and keeps any
+// read already under way from being cached.
+func (s *Store) invalidate(ctx context.Context, ids ...string) {
+	tenant := store.TenantFromContext(ctx)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.generation++
+	for _, id := range ids {
+		if elem, ok := s.entries[userKey{tenant: tenant, id: id}]; ok {
+			s.remove(elem)
+		}
+	}
+}
+
+// remove drops elem from the cache. The caller must hold mu.
+func (s *Store) remove(elem *list.Element) {
+	s.lru.Remove(elem)
+	delete(s.entries, elem.Value.(*entry).key)
+}
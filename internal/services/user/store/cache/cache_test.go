@@ -0,0 +1,377 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	pb "github.com/andrew-womeldorf/connect-boilerplate/gen/user/v1"
+	"github.com/andrew-womeldorf/connect-boilerplate/internal/services/user/store"
+	"github.com/andrew-womeldorf/connect-boilerplate/internal/services/user/store/memory"
+	"github.com/andrew-womeldorf/connect-boilerplate/internal/services/user/store/search"
+	"github.com/andrew-womeldorf/connect-boilerplate/internal/services/user/store/storetest"
+)
+
+func TestStore(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) store.Store {
+		return New(memory.NewStore())
+	})
+}
+
+func TestSearch(t *testing.T) {
+	storetest.RunSearch(t, func(t *testing.T) storetest.SearchableStore {
+		return New(search.New(memory.NewStore(), search.NewMemoryIndex()))
+	})
+}
+
+func TestSearchWithoutSearcher(t *testing.T) {
+	s := New(memory.NewStore())
+
+	_, _, err := s.SearchUsers(context.Background(), store.SearchUsersParams{Query: "jane"})
+	if !errors.Is(err, store.ErrSearchNotSupported) {
+		t.Errorf("expected ErrSearchNotSupported, got %v", err)
+	}
+}
+
+// countingStore counts the GetUser calls that reach the backend, and holds
+// the result of each one until release is closed, if it is set.
+type countingStore struct {
+	store.Store
+	gets    atomic.Int64
+	release chan struct{}
+}
+
+func (s *countingStore) GetUser(ctx context.Context, id string) (*pb.User, error) {
+	s.gets.Add(1)
+	user, err := s.Store.GetUser(ctx, id)
+	if s.release != nil {
+		<-s.release
+	}
+	return user, err
+}
+
+// fakeClock is a clock that only moves when told to.
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+func newTestStore(t *testing.T, opts ...Option) (*Store, *countingStore, *fakeClock) {
+	t.Helper()
+
+	backend := &countingStore{Store: memory.NewStore()}
+	clock := &fakeClock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	return New(backend, append([]Option{WithClock(clock.Now)}, opts...)...), backend, clock
+}
+
+func createUser(ctx context.Context, t *testing.T, s store.Store, id string) *pb.User {
+	t.Helper()
+
+	now := timestamppb.Now()
+	user := &pb.User{Id: id, Name: id, Email: id + "@example.com", CreatedAt: now, UpdatedAt: now}
+	if err := s.CreateUser(ctx, user); err != nil {
+		t.Fatalf("failed to create user: %v", err)
+	}
+	return user
+}
+
+func getUser(ctx context.Context, t *testing.T, s store.Store, id string) *pb.User {
+	t.Helper()
+
+	user, err := s.GetUser(ctx, id)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	return user
+}
+
+func TestGetUser(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("hits", func(t *testing.T) {
+		s, backend, _ := newTestStore(t)
+		createUser(ctx, t, s, "a")
+
+		for range 3 {
+			if user := getUser(ctx, t, s, "a"); user.GetId() != "a" {
+				t.Errorf("expected user a, got %v", user)
+			}
+		}
+
+		if got := backend.gets.Load(); got != 1 {
+			t.Errorf("expected 1 backend read, got %d", got)
+		}
+		if got, want := s.Stats(), (Stats{Hits: 2, Misses: 1, Size: 1}); got != want {
+			t.Errorf("expected stats %+v, got %+v", want, got)
+		}
+	})
+
+	t.Run("copies", func(t *testing.T) {
+		s, _, _ := newTestStore(t)
+		createUser(ctx, t, s, "a")
+
+		getUser(ctx, t, s, "a").Name = "changed"
+		if user := getUser(ctx, t, s, "a"); user.GetName() != "a" {
+			t.Errorf("expected the cached user to be unchanged, got %q", user.GetName())
+		}
+	})
+
+	t.Run("expires", func(t *testing.T) {
+		s, backend, clock := newTestStore(t, WithTTL(time.Minute))
+		createUser(ctx, t, s, "a")
+
+		getUser(ctx, t, s, "a")
+		clock.Advance(59 * time.Second)
+		getUser(ctx, t, s, "a")
+		clock.Advance(time.Second)
+		getUser(ctx, t, s, "a")
+
+		if got := backend.gets.Load(); got != 2 {
+			t.Errorf("expected 2 backend reads, got %d", got)
+		}
+	})
+
+	t.Run("not_found", func(t *testing.T) {
+		s, backend, clock := newTestStore(t, WithNegativeTTL(time.Second))
+
+		for range 2 {
+			if _, err := s.GetUser(ctx, "missing"); !errors.Is(err, store.ErrNotFound) {
+				t.Fatalf("expected ErrNotFound, got %v", err)
+			}
+		}
+		if got := backend.gets.Load(); got != 1 {
+			t.Errorf("expected 1 backend read, got %d", got)
+		}
+
+		clock.Advance(time.Second)
+		if _, err := s.GetUser(ctx, "missing"); !errors.Is(err, store.ErrNotFound) {
+			t.Fatalf("expected ErrNotFound, got %v", err)
+		}
+		if got := backend.gets.Load(); got != 2 {
+			t.Errorf("expected 2 backend reads, got %d", got)
+		}
+	})
+
+	t.Run("not_found_uncached", func(t *testing.T) {
+		s, backend, _ := newTestStore(t, WithNegativeTTL(0))
+
+		for range 2 {
+			if _, err := s.GetUser(ctx, "missing"); !errors.Is(err, store.ErrNotFound) {
+				t.Fatalf("expected ErrNotFound, got %v", err)
+			}
+		}
+		if got := backend.gets.Load(); got != 2 {
+			t.Errorf("expected 2 backend reads, got %d", got)
+		}
+	})
+
+	t.Run("evicts_least_recently_used", func(t *testing.T) {
+		s, backend, _ := newTestStore(t, WithSize(2))
+		for _, id := range []string{"a", "b", "c"} {
+			createUser(ctx, t, s, id)
+		}
+
+		getUser(ctx, t, s, "a")
+		getUser(ctx, t, s, "b")
+		getUser(ctx, t, s, "a")
+		getUser(ctx, t, s, "c") // evicts b
+		backend.gets.Store(0)
+
+		getUser(ctx, t, s, "a")
+		getUser(ctx, t, s, "c")
+		if got := backend.gets.Load(); got != 0 {
+			t.Errorf("expected a and c to be cached, got %d backend reads", got)
+		}
+		getUser(ctx, t, s, "b")
+		if got := backend.gets.Load(); got != 1 {
+			t.Errorf("expected b to have been evicted, got %d backend reads", got)
+		}
+
+		if got := s.Stats(); got.Evictions != 2 || got.Size != 2 {
+			t.Errorf("expected 2 evictions and size 2, got %+v", got)
+		}
+	})
+
+	t.Run("collapses_concurrent_misses", func(t *testing.T) {
+		s, backend, _ := newTestStore(t)
+		createUser(ctx, t, s, "a")
+		backend.release = make(chan struct{})
+
+		var wg sync.WaitGroup
+		for range 10 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if _, err := s.GetUser(ctx, "a"); err != nil {
+					t.Errorf("expected no error, got %v", err)
+				}
+			}()
+		}
+
+		// Every caller misses before the backend read is released.
+		for s.Stats().Misses < 10 {
+			time.Sleep(time.Millisecond)
+		}
+		close(backend.release)
+		wg.Wait()
+
+		if got := backend.gets.Load(); got != 1 {
+			t.Errorf("expected 1 backend read, got %d", got)
+		}
+	})
+
+	t.Run("caller_gives_up", func(t *testing.T) {
+		s, backend, _ := newTestStore(t)
+		createUser(ctx, t, s, "a")
+		backend.release = make(chan struct{})
+
+		cancelled, cancel := context.WithCancel(ctx)
+		cancel()
+		if _, err := s.GetUser(cancelled, "a"); !errors.Is(err, context.Canceled) {
+			t.Fatalf("expected context.Canceled, got %v", err)
+		}
+
+		close(backend.release)
+		if user := getUser(ctx, t, s, "a"); user.GetId() != "a" {
+			t.Errorf("expected user a, got %v", user)
+		}
+	})
+}
+
+func TestInvalidation(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("create", func(t *testing.T) {
+		s, _, _ := newTestStore(t)
+
+		if _, err := s.GetUser(ctx, "a"); !errors.Is(err, store.ErrNotFound) {
+			t.Fatalf("expected ErrNotFound, got %v", err)
+		}
+		createUser(ctx, t, s, "a")
+		getUser(ctx, t, s, "a")
+	})
+
+	t.Run("update", func(t *testing.T) {
+		s, _, _ := newTestStore(t)
+		user := createUser(ctx, t, s, "a")
+		getUser(ctx, t, s, "a")
+
+		user.Name = "renamed"
+		if _, err := s.UpdateUser(ctx, user, store.AllFields, 0); err != nil {
+			t.Fatalf("failed to update user: %v", err)
+		}
+		if got := getUser(ctx, t, s, "a"); got.GetName() != "renamed" {
+			t.Errorf("expected the updated name, got %q", got.GetName())
+		}
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		s, _, _ := newTestStore(t)
+		createUser(ctx, t, s, "a")
+		getUser(ctx, t, s, "a")
+
+		if err := s.DeleteUser(ctx, "a", 0, time.Now()); err != nil {
+			t.Fatalf("failed to delete user: %v", err)
+		}
+		if _, err := s.GetUser(ctx, "a"); !errors.Is(err, store.ErrNotFound) {
+			t.Errorf("expected ErrNotFound, got %v", err)
+		}
+	})
+
+	t.Run("batch", func(t *testing.T) {
+		s, _, _ := newTestStore(t)
+		createUser(ctx, t, s, "a")
+		getUser(ctx, t, s, "a")
+		if _, err := s.GetUser(ctx, "b"); !errors.Is(err, store.ErrNotFound) {
+			t.Fatalf("expected ErrNotFound, got %v", err)
+		}
+
+		now := timestamppb.Now()
+		if _, err := s.BatchCreateUsers(ctx, []*pb.User{
+			{Id: "b", Name: "b", Email: "b@example.com", CreatedAt: now, UpdatedAt: now},
+		}); err != nil {
+			t.Fatalf("failed to create users: %v", err)
+		}
+		if _, err := s.BatchDeleteUsers(ctx, []store.BatchDelete{{ID: "a"}}, time.Now()); err != nil {
+			t.Fatalf("failed to delete users: %v", err)
+		}
+
+		getUser(ctx, t, s, "b")
+		if _, err := s.GetUser(ctx, "a"); !errors.Is(err, store.ErrNotFound) {
+			t.Errorf("expected ErrNotFound, got %v", err)
+		}
+	})
+
+	t.Run("during_read", func(t *testing.T) {
+		s, backend, _ := newTestStore(t)
+		user := createUser(ctx, t, s, "a")
+		backend.release = make(chan struct{})
+
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			_, _ = s.GetUser(ctx, "a")
+		}()
+		for backend.gets.Load() == 0 {
+			time.Sleep(time.Millisecond)
+		}
+
+		// The read in flight saw the old name, so must not be cached.
+		user.Name = "renamed"
+		if _, err := s.UpdateUser(ctx, user, store.AllFields, 0); err != nil {
+			t.Fatalf("failed to update user: %v", err)
+		}
+		close(backend.release)
+		<-done
+
+		if got := getUser(ctx, t, s, "a"); got.GetName() != "renamed" {
+			t.Errorf("expected the updated name, got %q", got.GetName())
+		}
+	})
+
+	t.Run("purge_during_read", func(t *testing.T) {
+		s, backend, _ := newTestStore(t)
+		createUser(ctx, t, s, "a")
+		if err := s.DeleteUser(ctx, "a", 0, time.Now()); err != nil {
+			t.Fatalf("failed to delete user: %v", err)
+		}
+		backend.release = make(chan struct{})
+
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			_, _ = s.GetUser(ctx, "a")
+		}()
+		for backend.gets.Load() == 0 {
+			time.Sleep(time.Millisecond)
+		}
+
+		if n, err := s.PurgeUsers(ctx, time.Now().Add(time.Hour), 10, time.Now()); err != nil || n != 1 {
+			t.Fatalf("expected 1 user purged, got %d and %v", n, err)
+		}
+		close(backend.release)
+		<-done
+
+		// The purged id is taken again, as by another instance, which the
+		// read in flight did not see.
+		createUser(ctx, t, backend.Store, "a")
+		getUser(ctx, t, s, "a")
+	})
+}
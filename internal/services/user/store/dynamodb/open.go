@@ -0,0 +1,53 @@
+package dynamodb
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+
+	"github.com/andrew-womeldorf/connect-boilerplate/internal/services/user/store"
+)
+
+func init() {
+	store.Register("dynamodb", Open)
+}
+
+// Open is the store.Factory for dynamodb URLs, where the host names the
+// table:
+//
+//	dynamodb://users
+//	dynamodb://users?region=us-west-2
+//	dynamodb://users?endpoint=http://localhost:4566
+func Open(ctx context.Context, u *url.URL) (store.Store, error) {
+	if u.Host == "" {
+		return nil, fmt.Errorf("%w: dynamodb url has no table name", store.ErrInvalidURL)
+	}
+	if u.Path != "" && u.Path != "/" {
+		return nil, fmt.Errorf("%w: dynamodb url must not have a path, got %q", store.ErrInvalidURL, u.Path)
+	}
+
+	opts, err := store.URLOptions(u, "region", "endpoint")
+	if err != nil {
+		return nil, err
+	}
+
+	storeOpts := []Option{WithTable(u.Host)}
+
+	if region, ok := opts["region"]; ok {
+		storeOpts = append(storeOpts, WithRegion(region))
+	}
+
+	if endpoint, ok := opts["endpoint"]; ok {
+		e, err := url.Parse(endpoint)
+		if err != nil || e.Scheme == "" || e.Host == "" {
+			return nil, fmt.Errorf("%w: dynamodb endpoint must be an absolute url, got %q", store.ErrInvalidURL, endpoint)
+		}
+		storeOpts = append(storeOpts, WithEndpoint(endpoint))
+	}
+
+	s, err := NewStore(ctx, storeOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
@@ -4,7 +4,12 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"hash/fnv"
 	"log/slog"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
@@ -12,24 +17,91 @@ import (
 	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
 	ddb "github.com/aws/aws-sdk-go-v2/service/dynamodb"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/smithy-go"
+	"google.golang.org/protobuf/proto"
 	"google.golang.org/protobuf/types/known/timestamppb"
 
 	pb "github.com/andrew-womeldorf/connect-boilerplate/gen/user/v1"
+	"github.com/andrew-womeldorf/connect-boilerplate/internal/services/user/store"
 )
 
 const defaultTableName = "users"
 
+// usersPartition is the partition of the indexes that list users, which
+// holds every user of a tenant.
+const usersPartition = "USERS"
+
+// The events not yet delivered, of every tenant, are spread over
+// outboxShards partitions of the table named after outboxPartition, so that
+// recording them does not make one partition hot. Events recorded before the
+// outbox was sharded are in outboxPartition itself.
+const (
+	outboxPartition = "OUTBOX"
+	outboxShards    = 8
+)
+
+// auditPartition is the partition of the table holding every audit event of
+// a tenant.
+const auditPartition = "AUDIT"
+
+// deletedPartition is the partition of GSI1 holding the deleted users of
+// every tenant, for PurgeUsers to find.
+const deletedPartition = "DELETED"
+
+// sortableTimeLayout is a fixed width form of RFC 3339, which sorts as a
+// string in time order when in UTC.
+const sortableTimeLayout = "2006-01-02T15:04:05.000000000Z07:00"
+
+// nameKeySeparator separates the name from the id in GSI2SK. It sorts below
+// every character a name holds, so a name sorts before the names it is a
+// prefix of, as it does in the other stores: "Al" before "Al Smith".
+const nameKeySeparator = "\x00"
+
+// maxBatchGetKeys is the most keys DynamoDB accepts in one BatchGetItem,
+// and maxBatchWriteItems the most requests in one BatchWriteItem.
+const (
+	maxBatchGetKeys    = 100
+	maxBatchWriteItems = 25
+)
+
+// Keys DynamoDB leaves unprocessed, as it does when throttled, are retried
+// with exponential backoff from batchRetryDelay, up to batchRetries times.
+const (
+	batchRetries    = 5
+	batchRetryDelay = 50 * time.Millisecond
+)
+
+// batchWriteWorkers bounds how many writes of a batch are in flight at once.
+const batchWriteWorkers = 10
+
 var (
-	ErrCouldNotGetUser    = errors.New("could not get user")
-	ErrCouldNotCreateUser = errors.New("could not create user")
-	ErrCouldNotDeleteUser = errors.New("could not delete user")
-	ErrCouldNotListUsers  = errors.New("could not list users")
-	ErrCouldNotUpdateUser = errors.New("could not update user")
+	ErrCouldNotGetUser        = errors.New("could not get user")
+	ErrCouldNotCreateUser     = errors.New("could not create user")
+	ErrCouldNotDeleteUser     = errors.New("could not delete user")
+	ErrCouldNotListUsers      = errors.New("could not list users")
+	ErrCouldNotUpdateUser     = errors.New("could not update user")
+	ErrCouldNotUndeleteUser   = errors.New("could not undelete user")
+	ErrCouldNotPurgeUsers     = errors.New("could not purge users")
+	ErrCouldNotRecordEvent    = errors.New("could not record event")
+	ErrCouldNotReadEvents     = errors.New("could not read events")
+	ErrCouldNotAckEvents      = errors.New("could not acknowledge events")
+	ErrCouldNotRecordAudit    = errors.New("could not record audit event")
+	ErrCouldNotListAudit      = errors.New("could not list audit events")
+	ErrCouldNotRecordRevision = errors.New("could not record revision")
+	ErrCouldNotListRevisions  = errors.New("could not list revisions")
+	ErrCouldNotClaimKey       = errors.New("could not claim idempotency key")
+	ErrCouldNotCompleteKey    = errors.New("could not complete idempotency key")
+	ErrCouldNotReleaseKey     = errors.New("could not release idempotency key")
+	ErrCouldNotBackfillEmails = errors.New("could not backfill emails")
+	ErrCouldNotBackfillKeys   = errors.New("could not backfill list keys")
 )
 
 type Store struct {
 	client *ddb.Client
 	table  string
+
+	region   string
+	endpoint string
 }
 
 type Option func(*Store)
@@ -40,52 +112,379 @@ func WithTable(name string) Option {
 	}
 }
 
+// WithClient uses an existing client instead of building one from the
+// default AWS configuration. WithRegion and WithEndpoint are then ignored.
 func WithClient(client *ddb.Client) Option {
 	return func(s *Store) {
 		s.client = client
 	}
 }
 
-func NewStore(ctx context.Context, opts ...Option) (*Store, error) {
-	cfg, err := config.LoadDefaultConfig(ctx)
-	if err != nil {
-		slog.ErrorContext(ctx, "could not load default aws config", slog.Any("error", err))
-		return nil, err
+// WithRegion overrides the region from the default AWS configuration.
+func WithRegion(region string) Option {
+	return func(s *Store) {
+		s.region = region
+	}
+}
+
+// WithEndpoint sends requests to a custom endpoint, such as localstack or
+// DynamoDB Local.
+func WithEndpoint(endpoint string) Option {
+	return func(s *Store) {
+		s.endpoint = endpoint
 	}
+}
 
+func NewStore(ctx context.Context, opts ...Option) (*Store, error) {
 	s := &Store{
-		client: ddb.NewFromConfig(cfg),
-		table:  defaultTableName,
+		table: defaultTableName,
 	}
 
 	for _, opt := range opts {
 		opt(s)
 	}
 
+	if s.client != nil {
+		return s, nil
+	}
+
+	var loadOpts []func(*config.LoadOptions) error
+	if s.region != "" {
+		loadOpts = append(loadOpts, config.WithRegion(s.region))
+	}
+
+	cfg, err := config.LoadDefaultConfig(ctx, loadOpts...)
+	if err != nil {
+		slog.ErrorContext(ctx, "could not load default aws config", slog.Any("error", err))
+		return nil, err
+	}
+
+	s.client = ddb.NewFromConfig(cfg, func(o *ddb.Options) {
+		if s.endpoint != "" {
+			o.BaseEndpoint = aws.String(s.endpoint)
+		}
+	})
+
 	return s, nil
 }
 
 type User struct {
-	Id        string    `dynamodbav:"id"`
-	Name      string    `dynamodbav:"name"`
-	Email     string    `dynamodbav:"email"`
-	CreatedAt time.Time `dynamodbav:"createdAt"`
-	UpdatedAt time.Time `dynamodbav:"updatedAt"`
+	Id        string     `dynamodbav:"id"`
+	Name      string     `dynamodbav:"name"`
+	Email     string     `dynamodbav:"email"`
+	CreatedAt time.Time  `dynamodbav:"createdAt"`
+	UpdatedAt time.Time  `dynamodbav:"updatedAt"`
+	Version   int64      `dynamodbav:"version"`
+	DeletedAt *time.Time `dynamodbav:"deletedAt,omitempty"`
 }
 
+// UserItem is a user along with the keys that index it. GSI2 lists users by
+// name and GSI3 by creation time, each sorting on its key and then the id.
+// EmailDomain is kept beside them for ListUsers to filter on. Users written
+// before these were kept have none, so are not listed, and users last written
+// when GSI2SK joined the name and id with "#" sort by that key, until they are
+// updated or BackfillListKeys sets them. Deleted users stay in both, and move
+// to the deleted partition of GSI1, sorting by when they were deleted.
 type UserItem struct {
+	PK          string `dynamodbav:"PK"`
+	SK          string `dynamodbav:"SK"`
+	GSI1PK      string `dynamodbav:"GSI1PK"`
+	GSI1SK      string `dynamodbav:"GSI1SK"`
+	GSI2PK      string `dynamodbav:"GSI2PK"`
+	GSI2SK      string `dynamodbav:"GSI2SK"`
+	GSI3PK      string `dynamodbav:"GSI3PK"`
+	GSI3SK      string `dynamodbav:"GSI3SK"`
+	EmailDomain string `dynamodbav:"emailDomain"`
+	User        User   `dynamodbav:"user"`
+}
+
+// SetKeys sets the keys of the user in tenant.
+func (item *UserItem) SetKeys(tenant string) {
+	item.PK = userKey(tenant, item.User.Id)
+	item.SK = item.PK
+	item.GSI1PK = tenantKey(tenant, usersPartition)
+	item.GSI1SK = item.User.Id
+	item.GSI2PK = item.GSI1PK
+	item.GSI2SK = item.User.Name + nameKeySeparator + item.User.Id
+	item.GSI3PK = item.GSI1PK
+	item.GSI3SK = fmt.Sprintf("%s#%s", sortableTime(item.User.CreatedAt), item.User.Id)
+	item.EmailDomain = store.EmailDomain(item.User.Email)
+
+	if item.User.DeletedAt != nil {
+		item.GSI1PK = deletedPartition
+		item.GSI1SK = fmt.Sprintf("%s#%s", sortableTime(*item.User.DeletedAt), item.PK)
+	}
+}
+
+// deleted reports whether the user is deleted.
+func (item *UserItem) deleted() bool {
+	return item.User.DeletedAt != nil
+}
+
+// tenantKey scopes a partition or item key to tenant. Tenant ids hold no
+// "#", so no key of one tenant is another's. Keys of store.DefaultTenant are
+// left as they are, so that the items written before users were partitioned
+// by tenant belong to it.
+func tenantKey(tenant, key string) string {
+	if tenant == store.DefaultTenant {
+		return key
+	}
+	return fmt.Sprintf("TENANT#%s#%s", tenant, key)
+}
+
+// tenantOf returns the tenant of a key made by tenantKey.
+func tenantOf(key string) string {
+	rest, ok := strings.CutPrefix(key, "TENANT#")
+	if !ok {
+		return store.DefaultTenant
+	}
+	tenant, _, _ := strings.Cut(rest, "#")
+	return tenant
+}
+
+// userKey is the partition and sort key of the user with id in tenant.
+func userKey(tenant, id string) string {
+	return tenantKey(tenant, fmt.Sprintf("USER#%s", id))
+}
+
+// userItemKey returns the primary key of the user with id in tenant.
+func userItemKey(tenant, id string) map[string]types.AttributeValue {
+	key := userKey(tenant, id)
+	return map[string]types.AttributeValue{
+		"PK": &types.AttributeValueMemberS{Value: key},
+		"SK": &types.AttributeValueMemberS{Value: key},
+	}
+}
+
+// sortableTime formats t so that times sort as their strings do.
+func sortableTime(t time.Time) string {
+	return t.UTC().Format(sortableTimeLayout)
+}
+
+func (item *UserItem) key() map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{
+		"PK": &types.AttributeValueMemberS{Value: item.PK},
+		"SK": &types.AttributeValueMemberS{Value: item.SK},
+	}
+}
+
+// version returns the user's version. Users written before versions were
+// tracked have none and count as FirstVersion.
+func (item *UserItem) version() int64 {
+	return max(item.User.Version, store.FirstVersion)
+}
+
+// EmailItem claims a normalized email for one user, which keeps emails unique
+// within a tenant. It is not indexed, so it never appears in ListUsers.
+// Users created before emails were claimed have none until they are updated
+// or BackfillEmails claims theirs, so until then they are not found by email
+// and do not keep their email from being taken.
+type EmailItem struct {
+	PK     string `dynamodbav:"PK"`
+	SK     string `dynamodbav:"SK"`
+	UserID string `dynamodbav:"userId"`
+}
+
+// SetKeys sets the keys of the item claiming email in tenant.
+func (item *EmailItem) SetKeys(tenant, email string) {
+	item.PK = tenantKey(tenant, fmt.Sprintf("EMAIL#%s", store.NormalizeEmail(email)))
+	item.SK = item.PK
+}
+
+// EventItem is an event in an outbox partition, keyed by its id. Event ids
+// sort by the time they were made, so each partition reads oldest first. The
+// events of a user are all in the same partition, as outboxKey picks it. It
+// is not indexed, so it never appears in ListUsers.
+type EventItem struct {
+	PK    string `dynamodbav:"PK"`
+	SK    string `dynamodbav:"SK"`
+	Event []byte `dynamodbav:"event"`
+}
+
+// recordEvent returns the item of a transaction that adds event to the
+// outbox, so that it is written together with the change it is for.
+func (s *Store) recordEvent(event *pb.UserEvent) (types.TransactWriteItem, error) {
+	data, err := proto.Marshal(event)
+	if err != nil {
+		return types.TransactWriteItem{}, fmt.Errorf("%w: %w", ErrCouldNotRecordEvent, err)
+	}
+
+	av, err := attributevalue.MarshalMap(EventItem{PK: outboxKey(event), SK: event.GetId(), Event: data})
+	if err != nil {
+		return types.TransactWriteItem{}, fmt.Errorf("%w: %w", ErrCouldNotRecordEvent, err)
+	}
+
+	return types.TransactWriteItem{Put: &types.Put{
+		TableName: &s.table,
+		Item:      av,
+	}}, nil
+}
+
+// outboxKey returns the outbox partition of event, which hashes the user it
+// is about, and its tenant, into one of outboxShards.
+func outboxKey(event *pb.UserEvent) string {
+	h := fnv.New32a()
+	h.Write([]byte(event.GetTenantId() + "/" + store.EventUserID(event)))
+	return outboxShardKey(int(h.Sum32() % outboxShards))
+}
+
+// outboxShardKey returns the key of the outbox partition numbered shard.
+func outboxShardKey(shard int) string {
+	return fmt.Sprintf("%s#%d", outboxPartition, shard)
+}
+
+// outboxPartitions returns the keys of every outbox partition, including the
+// unsharded one that events recorded before the outbox was sharded are in.
+func outboxPartitions() []string {
+	keys := []string{outboxPartition}
+	for shard := range outboxShards {
+		keys = append(keys, outboxShardKey(shard))
+	}
+	return keys
+}
+
+// AuditItem is an audit event in its tenant's audit partition, sorting by
+// when it occurred and then by its id. GSI1 lists the events of each user in
+// the same order, under a partition of their own.
+type AuditItem struct {
 	PK     string `dynamodbav:"PK"`
 	SK     string `dynamodbav:"SK"`
 	GSI1PK string `dynamodbav:"GSI1PK"`
 	GSI1SK string `dynamodbav:"GSI1SK"`
-	User   User   `dynamodbav:"user"`
+	Event  []byte `dynamodbav:"event"`
 }
 
-func (item *UserItem) SetKeys() {
-	item.PK = fmt.Sprintf("USER#%s", item.User.Id)
-	item.SK = fmt.Sprintf("USER#%s", item.User.Id)
-	item.GSI1PK = "USERS"
-	item.GSI1SK = item.User.Id
+// auditUserPartition is the partition of GSI1 holding the audit events of
+// the user with id in tenant.
+func auditUserPartition(tenant, id string) string {
+	return tenantKey(tenant, fmt.Sprintf("AUDIT#USER#%s", id))
+}
+
+// recordAudit returns the item of a transaction that adds event to the audit
+// log of the tenant of ctx, as recordEvent does for the outbox.
+func (s *Store) recordAudit(ctx context.Context, event *pb.AuditEvent) (types.TransactWriteItem, error) {
+	data, err := proto.Marshal(event)
+	if err != nil {
+		return types.TransactWriteItem{}, fmt.Errorf("%w: %w", ErrCouldNotRecordAudit, err)
+	}
+
+	tenant := store.TenantFromContext(ctx)
+	key := fmt.Sprintf("%s#%s", sortableTime(event.GetOccurredAt().AsTime()), event.GetId())
+	av, err := attributevalue.MarshalMap(AuditItem{
+		PK:     tenantKey(tenant, auditPartition),
+		SK:     key,
+		GSI1PK: auditUserPartition(tenant, event.GetUserId()),
+		GSI1SK: key,
+		Event:  data,
+	})
+	if err != nil {
+		return types.TransactWriteItem{}, fmt.Errorf("%w: %w", ErrCouldNotRecordAudit, err)
+	}
+
+	return types.TransactWriteItem{Put: &types.Put{
+		TableName: &s.table,
+		Item:      av,
+	}}, nil
+}
+
+// RevisionItem is a revision of a user, kept in the user's partition beside
+// it and sorting by revision, so the partition reads its history in order.
+// It is not indexed, so it never appears in ListUsers.
+type RevisionItem struct {
+	PK        string `dynamodbav:"PK"`
+	SK        string `dynamodbav:"SK"`
+	RevisedAt string `dynamodbav:"revisedAt"`
+	Revision  []byte `dynamodbav:"revision"`
+}
+
+// revisionPrefix starts the sort key of every revision item.
+const revisionPrefix = "REV#"
+
+// revisionKey is the sort key of revision. Revisions are zero padded so they
+// sort as their numbers do.
+func revisionKey(revision int64) string {
+	return fmt.Sprintf("%s%020d", revisionPrefix, revision)
+}
+
+// revisionItemKey returns the primary key of revision of the user with id in
+// tenant.
+func revisionItemKey(tenant, id string, revision int64) map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{
+		"PK": &types.AttributeValueMemberS{Value: userKey(tenant, id)},
+		"SK": &types.AttributeValueMemberS{Value: revisionKey(revision)},
+	}
+}
+
+// recordChange returns the items of a transaction that record the audit
+// event for a change made at occurredAt that left the user stored as before
+// as after, and the revision it made, dropping the revision that falls out
+// of those the store keeps. Purging a user, which leaves no after, drops
+// them all.
+func (s *Store) recordChange(ctx context.Context, before, after *pb.User, occurredAt time.Time) ([]types.TransactWriteItem, error) {
+	event := store.NewAuditEvent(ctx, before, after, occurredAt)
+	audit, err := s.recordAudit(ctx, event)
+	if err != nil {
+		return nil, err
+	}
+
+	if after == nil {
+		return append([]types.TransactWriteItem{audit}, s.deleteRevisions(ctx, before)...), nil
+	}
+
+	revision, err := s.recordRevision(ctx, store.NewRevision(after, event))
+	if err != nil {
+		return nil, err
+	}
+	items := []types.TransactWriteItem{audit, revision}
+
+	// Each write drops one revision, so no more than MaxRevisions are kept.
+	if dropped := after.GetVersion() - store.MaxRevisions; dropped >= store.FirstVersion {
+		items = append(items, types.TransactWriteItem{Delete: &types.Delete{
+			TableName: &s.table,
+			Key:       revisionItemKey(store.TenantFromContext(ctx), after.GetId(), dropped),
+		}})
+	}
+
+	return items, nil
+}
+
+// recordRevision returns the item of a transaction that adds revision to the
+// user's revisions, as recordAudit does for the audit log.
+func (s *Store) recordRevision(ctx context.Context, revision *pb.UserRevision) (types.TransactWriteItem, error) {
+	data, err := proto.Marshal(revision)
+	if err != nil {
+		return types.TransactWriteItem{}, fmt.Errorf("%w: %w", ErrCouldNotRecordRevision, err)
+	}
+
+	av, err := attributevalue.MarshalMap(RevisionItem{
+		PK:        userKey(store.TenantFromContext(ctx), revision.GetUser().GetId()),
+		SK:        revisionKey(revision.GetRevision()),
+		RevisedAt: sortableTime(revision.GetRevisedAt().AsTime()),
+		Revision:  data,
+	})
+	if err != nil {
+		return types.TransactWriteItem{}, fmt.Errorf("%w: %w", ErrCouldNotRecordRevision, err)
+	}
+
+	return types.TransactWriteItem{Put: &types.Put{
+		TableName: &s.table,
+		Item:      av,
+	}}, nil
+}
+
+// deleteRevisions returns the items of a transaction that delete every
+// revision the store keeps of user. At most MaxRevisions are kept, so they
+// fit in one transaction with the purge of the user.
+func (s *Store) deleteRevisions(ctx context.Context, user *pb.User) []types.TransactWriteItem {
+	tenant := store.TenantFromContext(ctx)
+	items := make([]types.TransactWriteItem, 0, store.MaxRevisions)
+	for revision := store.OldestRevision(user.GetVersion()); revision <= user.GetVersion(); revision++ {
+		items = append(items, types.TransactWriteItem{Delete: &types.Delete{
+			TableName: &s.table,
+			Key:       revisionItemKey(tenant, user.GetId(), revision),
+		}})
+	}
+	return items
 }
 
 func (s *Store) CreateUser(ctx context.Context, user *pb.User) error {
@@ -96,9 +495,10 @@ func (s *Store) CreateUser(ctx context.Context, user *pb.User) error {
 			Email:     user.GetEmail(),
 			CreatedAt: user.GetCreatedAt().AsTime(),
 			UpdatedAt: user.GetUpdatedAt().AsTime(),
+			Version:   store.FirstVersion,
 		},
 	}
-	item.SetKeys()
+	item.SetKeys(store.TenantFromContext(ctx))
 
 	av, err := attributevalue.MarshalMap(item)
 	if err != nil {
@@ -106,13 +506,56 @@ func (s *Store) CreateUser(ctx context.Context, user *pb.User) error {
 			slog.Any("error", err),
 			slog.String("user id", user.GetId()),
 		)
-		return ErrCouldNotCreateUser
+		return classify(ErrCouldNotCreateUser, err)
 	}
 
-	_, err = s.client.PutItem(ctx, &ddb.PutItemInput{
-		TableName:           &s.table,
-		Item:                av,
-		ConditionExpression: aws.String("attribute_not_exists(PK)"),
+	emailItem := EmailItem{UserID: user.GetId()}
+	emailItem.SetKeys(store.TenantFromContext(ctx), user.GetEmail())
+
+	emailAv, err := attributevalue.MarshalMap(emailItem)
+	if err != nil {
+		slog.ErrorContext(ctx, ErrCouldNotCreateUser.Error(),
+			slog.Any("error", err),
+			slog.String("user id", user.GetId()),
+		)
+		return classify(ErrCouldNotCreateUser, err)
+	}
+
+	created := convertUserItem(item)
+	event, err := s.recordEvent(store.NewUserCreated(ctx, created))
+	if err != nil {
+		slog.ErrorContext(ctx, ErrCouldNotCreateUser.Error(),
+			slog.Any("error", err),
+			slog.String("user id", user.GetId()),
+		)
+		return classify(ErrCouldNotCreateUser, err)
+	}
+
+	history, err := s.recordChange(ctx, nil, created, created.GetCreatedAt().AsTime())
+	if err != nil {
+		slog.ErrorContext(ctx, ErrCouldNotCreateUser.Error(),
+			slog.Any("error", err),
+			slog.String("user id", user.GetId()),
+		)
+		return classify(ErrCouldNotCreateUser, err)
+	}
+
+	// The user and its email are claimed together, so a taken id or email
+	// leaves nothing behind, and the events are only recorded with them.
+	_, err = s.client.TransactWriteItems(ctx, &ddb.TransactWriteItemsInput{
+		TransactItems: append([]types.TransactWriteItem{
+			{Put: &types.Put{
+				TableName:           &s.table,
+				Item:                av,
+				ConditionExpression: aws.String("attribute_not_exists(PK)"),
+			}},
+			{Put: &types.Put{
+				TableName:           &s.table,
+				Item:                emailAv,
+				ConditionExpression: aws.String("attribute_not_exists(PK)"),
+			}},
+			event,
+		}, history...),
 	})
 
 	if err != nil {
@@ -120,22 +563,76 @@ func (s *Store) CreateUser(ctx context.Context, user *pb.User) error {
 			slog.Any("error", err),
 			slog.String("user id", user.GetId()),
 		)
-		return ErrCouldNotCreateUser
+		return classifyConditional(ErrCouldNotCreateUser, err, store.AlreadyExists)
 	}
 
 	return nil
 }
 
-func (s *Store) DeleteUser(ctx context.Context, id string) error {
-	key := map[string]types.AttributeValue{
-		"PK": &types.AttributeValueMemberS{Value: fmt.Sprintf("USER#%s", id)},
-		"SK": &types.AttributeValueMemberS{Value: fmt.Sprintf("USER#%s", id)},
+// DeleteUser sets the user's deletedAt and moves it to the deleted partition
+// of GSI1, releasing its email.
+func (s *Store) DeleteUser(ctx context.Context, id string, expectedVersion int64, deletedAt time.Time) error {
+	current, err := s.readUser(ctx, id, expectedVersion)
+	if err != nil {
+		slog.ErrorContext(ctx, ErrCouldNotDeleteUser.Error(),
+			slog.Any("error", err),
+			slog.String("user id", id),
+		)
+		return classify(ErrCouldNotDeleteUser, err)
+	}
+
+	deleted := *current
+	deleted.User.DeletedAt = &deletedAt
+	deleted.User.Version = current.version() + 1
+	deleted.SetKeys(store.TenantFromContext(ctx))
+
+	names := map[string]string{
+		"#user":      "user",
+		"#deletedAt": "deletedAt",
+		"#version":   "version",
+	}
+	values := map[string]types.AttributeValue{
+		":deletedAt": &types.AttributeValueMemberS{Value: deletedAt.Format(time.RFC3339Nano)},
+		":version":   &types.AttributeValueMemberN{Value: strconv.FormatInt(deleted.User.Version, 10)},
+		":gsi1pk":    &types.AttributeValueMemberS{Value: deleted.GSI1PK},
+		":gsi1sk":    &types.AttributeValueMemberS{Value: deleted.GSI1SK},
 	}
+	guard := versionGuard(current.version(), names, values)
 
-	_, err := s.client.DeleteItem(ctx, &ddb.DeleteItemInput{
-		TableName:           &s.table,
-		Key:                 key,
-		ConditionExpression: aws.String("attribute_exists(PK)"),
+	event, err := s.recordEvent(store.NewUserDeleted(ctx, convertUserItem(deleted)))
+	if err != nil {
+		slog.ErrorContext(ctx, ErrCouldNotDeleteUser.Error(),
+			slog.Any("error", err),
+			slog.String("user id", id),
+		)
+		return classify(ErrCouldNotDeleteUser, err)
+	}
+
+	history, err := s.recordChange(ctx, convertUserItem(*current), convertUserItem(deleted), deletedAt)
+	if err != nil {
+		slog.ErrorContext(ctx, ErrCouldNotDeleteUser.Error(),
+			slog.Any("error", err),
+			slog.String("user id", id),
+		)
+		return classify(ErrCouldNotDeleteUser, err)
+	}
+
+	// The condition on the read version ensures the email released is the
+	// one the user still has, and that the user is not already deleted.
+	_, err = s.client.TransactWriteItems(ctx, &ddb.TransactWriteItemsInput{
+		TransactItems: append([]types.TransactWriteItem{
+			{Update: &types.Update{
+				TableName:                           &s.table,
+				Key:                                 current.key(),
+				UpdateExpression:                    aws.String("SET #user.#deletedAt = :deletedAt, #user.#version = :version, GSI1PK = :gsi1pk, GSI1SK = :gsi1sk"),
+				ConditionExpression:                 aws.String(guard),
+				ExpressionAttributeNames:            names,
+				ExpressionAttributeValues:           values,
+				ReturnValuesOnConditionCheckFailure: types.ReturnValuesOnConditionCheckFailureAllOld,
+			}},
+			releaseEmail(s.table, store.TenantFromContext(ctx), current.User.Email, id),
+			event,
+		}, history...),
 	})
 
 	if err != nil {
@@ -143,132 +640,1654 @@ func (s *Store) DeleteUser(ctx context.Context, id string) error {
 			slog.Any("error", err),
 			slog.String("user id", id),
 		)
-		return ErrCouldNotDeleteUser
+		return classifyConditional(ErrCouldNotDeleteUser, err, writeFailed(expectedVersion, -1))
 	}
 
 	return nil
 }
 
-func (s *Store) GetUser(ctx context.Context, id string) (*pb.User, error) {
-	key := map[string]types.AttributeValue{
-		"PK": &types.AttributeValueMemberS{Value: fmt.Sprintf("USER#%s", id)},
-		"SK": &types.AttributeValueMemberS{Value: fmt.Sprintf("USER#%s", id)},
+// UndeleteUser clears the user's deletedAt and moves it back out of the
+// deleted partition of GSI1, claiming its email again.
+func (s *Store) UndeleteUser(ctx context.Context, id string, expectedVersion int64, undeletedAt time.Time) (*pb.User, error) {
+	current, err := s.readUserItem(ctx, id, expectedVersion)
+	if err == nil && !current.deleted() {
+		err = store.AlreadyExists(errors.New("user is not deleted"))
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, ErrCouldNotUndeleteUser.Error(),
+			slog.Any("error", err),
+			slog.String("user id", id),
+		)
+		return nil, classify(ErrCouldNotUndeleteUser, err)
 	}
 
-	resp, err := s.client.GetItem(ctx, &ddb.GetItemInput{
-		TableName: &s.table,
-		Key:       key,
-	})
+	tenant := store.TenantFromContext(ctx)
+	restored := *current
+	restored.User.DeletedAt = nil
+	restored.User.Version = current.version() + 1
+	restored.SetKeys(tenant)
+
+	names := map[string]string{
+		"#user":      "user",
+		"#deletedAt": "deletedAt",
+		"#version":   "version",
+	}
+	values := map[string]types.AttributeValue{
+		":version": &types.AttributeValueMemberN{Value: strconv.FormatInt(restored.User.Version, 10)},
+		":gsi1pk":  &types.AttributeValueMemberS{Value: restored.GSI1PK},
+		":gsi1sk":  &types.AttributeValueMemberS{Value: restored.GSI1SK},
+	}
+	guard := versionGuard(current.version(), names, values)
 
+	emailItem := EmailItem{UserID: id}
+	emailItem.SetKeys(tenant, restored.User.Email)
+
+	emailAv, err := attributevalue.MarshalMap(emailItem)
 	if err != nil {
-		slog.ErrorContext(ctx, ErrCouldNotGetUser.Error(),
+		slog.ErrorContext(ctx, ErrCouldNotUndeleteUser.Error(),
 			slog.Any("error", err),
 			slog.String("user id", id),
 		)
-		return nil, ErrCouldNotGetUser
+		return nil, classify(ErrCouldNotUndeleteUser, err)
 	}
 
-	if resp.Item == nil {
-		return nil, ErrCouldNotGetUser
+	user := convertUserItem(restored)
+	event, err := s.recordEvent(store.NewUserUndeleted(ctx, user, undeletedAt))
+	if err != nil {
+		slog.ErrorContext(ctx, ErrCouldNotUndeleteUser.Error(),
+			slog.Any("error", err),
+			slog.String("user id", id),
+		)
+		return nil, classify(ErrCouldNotUndeleteUser, err)
 	}
 
-	var item UserItem
-	if err := attributevalue.UnmarshalMap(resp.Item, &item); err != nil {
-		slog.ErrorContext(ctx, ErrCouldNotGetUser.Error(),
+	history, err := s.recordChange(ctx, convertUserItem(*current), user, undeletedAt)
+	if err != nil {
+		slog.ErrorContext(ctx, ErrCouldNotUndeleteUser.Error(),
 			slog.Any("error", err),
 			slog.String("user id", id),
 		)
-		return nil, ErrCouldNotGetUser
+		return nil, classify(ErrCouldNotUndeleteUser, err)
 	}
 
-	return convertUserItem(item), nil
+	// The email is claimed as on create, so one another user has taken since
+	// fails the undelete.
+	_, err = s.client.TransactWriteItems(ctx, &ddb.TransactWriteItemsInput{
+		TransactItems: append([]types.TransactWriteItem{
+			{Update: &types.Update{
+				TableName:                           &s.table,
+				Key:                                 current.key(),
+				UpdateExpression:                    aws.String("SET #user.#version = :version, GSI1PK = :gsi1pk, GSI1SK = :gsi1sk REMOVE #user.#deletedAt"),
+				ConditionExpression:                 aws.String(guard),
+				ExpressionAttributeNames:            names,
+				ExpressionAttributeValues:           values,
+				ReturnValuesOnConditionCheckFailure: types.ReturnValuesOnConditionCheckFailureAllOld,
+			}},
+			{Put: &types.Put{
+				TableName:           &s.table,
+				Item:                emailAv,
+				ConditionExpression: aws.String("attribute_not_exists(PK)"),
+			}},
+			event,
+		}, history...),
+	})
+
+	if err != nil {
+		slog.ErrorContext(ctx, ErrCouldNotUndeleteUser.Error(),
+			slog.Any("error", err),
+			slog.String("user id", id),
+		)
+		return nil, classifyConditional(ErrCouldNotUndeleteUser, err, writeFailed(expectedVersion, 1))
+	}
+
+	return user, nil
 }
 
-func (s *Store) ListUsers(ctx context.Context) ([]*pb.User, error) {
-	resp, err := s.client.Query(ctx, &ddb.QueryInput{
+// PurgeUsers reads the oldest users of the deleted partition of GSI1, and
+// removes each that is still deleted as it was read. The index is eventually
+// consistent, so a user may take a moment to be purged once it is due. Users
+// that changed since they were read are skipped, and reading goes on past
+// them, so that they do not stand in for users still due.
+func (s *Store) PurgeUsers(ctx context.Context, deletedBefore time.Time, limit int32, purgedAt time.Time) (int, error) {
+	// Keys start with the time the user was deleted, so comparing them to a
+	// bare time compares those times, as listQuery does for creation times.
+	query := &ddb.QueryInput{
 		TableName:              &s.table,
 		IndexName:              aws.String("GSI1"),
-		KeyConditionExpression: aws.String("GSI1PK = :pk"),
+		KeyConditionExpression: aws.String("GSI1PK = :pk AND GSI1SK < :before"),
 		ExpressionAttributeValues: map[string]types.AttributeValue{
-			":pk": &types.AttributeValueMemberS{Value: "USERS"},
+			":pk":     &types.AttributeValueMemberS{Value: deletedPartition},
+			":before": &types.AttributeValueMemberS{Value: sortableTime(deletedBefore)},
 		},
-	})
-
-	if err != nil {
-		slog.ErrorContext(ctx, ErrCouldNotListUsers.Error(),
-			slog.Any("error", err),
-		)
-		return nil, ErrCouldNotListUsers
 	}
 
-	users := make([]*pb.User, 0, len(resp.Items))
-	for _, item := range resp.Items {
-		var userItem UserItem
-		if err := attributevalue.UnmarshalMap(item, &userItem); err != nil {
-			slog.ErrorContext(ctx, ErrCouldNotListUsers.Error(),
-				slog.Any("error", err),
-			)
-			continue
+	var purged int
+	for purged < int(limit) {
+		query.Limit = aws.Int32(limit - int32(purged))
+		resp, err := s.client.Query(ctx, query)
+		if err != nil {
+			slog.ErrorContext(ctx, ErrCouldNotPurgeUsers.Error(), slog.Any("error", err))
+			return purged, classify(ErrCouldNotPurgeUsers, err)
 		}
-		users = append(users, convertUserItem(userItem))
+
+		var items []UserItem
+		if err := attributevalue.UnmarshalListOfMaps(resp.Items, &items); err != nil {
+			slog.ErrorContext(ctx, ErrCouldNotPurgeUsers.Error(), slog.Any("error", err))
+			return purged, classify(ErrCouldNotPurgeUsers, err)
+		}
+
+		for _, item := range items {
+			err := s.purgeUser(store.WithTenant(ctx, tenantOf(item.PK)), item, purgedAt)
+			if errors.Is(err, store.ErrPreconditionFailed) {
+				// The user was undeleted, or purged by another caller, since
+				// the index was read.
+				continue
+			}
+			if err != nil {
+				return purged, err
+			}
+			purged++
+		}
+
+		if len(resp.LastEvaluatedKey) == 0 {
+			break
+		}
+		query.ExclusiveStartKey = resp.LastEvaluatedKey
 	}
 
-	return users, nil
+	return purged, nil
 }
 
-func (s *Store) UpdateUser(ctx context.Context, user *pb.User) error {
-	key := map[string]types.AttributeValue{
-		"PK": &types.AttributeValueMemberS{Value: fmt.Sprintf("USER#%s", user.GetId())},
-		"SK": &types.AttributeValueMemberS{Value: fmt.Sprintf("USER#%s", user.GetId())},
+// purgeUser removes the deleted user item, in the tenant of ctx, at
+// purgedAt, if it has not changed since it was read.
+func (s *Store) purgeUser(ctx context.Context, item UserItem, purgedAt time.Time) error {
+	names := map[string]string{
+		"#deletedAt": "deletedAt",
 	}
+	values := make(map[string]types.AttributeValue)
+	guard := versionGuard(item.version(), names, values) + " AND attribute_exists(#user.#deletedAt)"
 
-	userData := User{
-		Id:        user.GetId(),
-		Name:      user.GetName(),
-		Email:     user.GetEmail(),
-		CreatedAt: user.GetCreatedAt().AsTime(),
-		UpdatedAt: user.GetUpdatedAt().AsTime(),
+	user := convertUserItem(item)
+	event, err := s.recordEvent(store.NewUserPurged(ctx, user.GetId(), purgedAt))
+	if err != nil {
+		slog.ErrorContext(ctx, ErrCouldNotPurgeUsers.Error(),
+			slog.Any("error", err),
+			slog.String("user id", user.GetId()),
+		)
+		return classify(ErrCouldNotPurgeUsers, err)
 	}
 
-	userAv, err := attributevalue.Marshal(userData)
+	history, err := s.recordChange(ctx, user, nil, purgedAt)
 	if err != nil {
-		slog.ErrorContext(ctx, ErrCouldNotUpdateUser.Error(),
+		slog.ErrorContext(ctx, ErrCouldNotPurgeUsers.Error(),
 			slog.Any("error", err),
 			slog.String("user id", user.GetId()),
 		)
-		return ErrCouldNotUpdateUser
+		return classify(ErrCouldNotPurgeUsers, err)
 	}
 
-	_, err = s.client.UpdateItem(ctx, &ddb.UpdateItemInput{
-		TableName:        &s.table,
-		Key:              key,
-		UpdateExpression: aws.String("SET #user = :user"),
-		ExpressionAttributeValues: map[string]types.AttributeValue{
-			":user": userAv,
-		},
-		ExpressionAttributeNames: map[string]string{
-			"#user": "user",
-		},
-		ConditionExpression: aws.String("attribute_exists(PK)"),
+	_, err = s.client.TransactWriteItems(ctx, &ddb.TransactWriteItemsInput{
+		TransactItems: append([]types.TransactWriteItem{
+			{Delete: &types.Delete{
+				TableName:                 &s.table,
+				Key:                       item.key(),
+				ConditionExpression:       aws.String(guard),
+				ExpressionAttributeNames:  names,
+				ExpressionAttributeValues: values,
+			}},
+			event,
+		}, history...),
 	})
 
 	if err != nil {
-		slog.ErrorContext(ctx, ErrCouldNotUpdateUser.Error(),
+		slog.ErrorContext(ctx, ErrCouldNotPurgeUsers.Error(),
 			slog.Any("error", err),
 			slog.String("user id", user.GetId()),
 		)
-		return ErrCouldNotUpdateUser
+		return classifyConditional(ErrCouldNotPurgeUsers, err, store.PreconditionFailed)
 	}
 
 	return nil
 }
 
-func convertUserItem(item UserItem) *pb.User {
+func (s *Store) GetUser(ctx context.Context, id string) (*pb.User, error) {
+	resp, err := s.client.GetItem(ctx, &ddb.GetItemInput{
+		TableName: &s.table,
+		Key:       userItemKey(store.TenantFromContext(ctx), id),
+	})
+
+	if err != nil {
+		slog.ErrorContext(ctx, ErrCouldNotGetUser.Error(),
+			slog.Any("error", err),
+			slog.String("user id", id),
+		)
+		return nil, classify(ErrCouldNotGetUser, err)
+	}
+
+	if resp.Item == nil {
+		return nil, store.NotFound(ErrCouldNotGetUser)
+	}
+
+	var item UserItem
+	if err := attributevalue.UnmarshalMap(resp.Item, &item); err != nil {
+		slog.ErrorContext(ctx, ErrCouldNotGetUser.Error(),
+			slog.Any("error", err),
+			slog.String("user id", id),
+		)
+		return nil, classify(ErrCouldNotGetUser, err)
+	}
+
+	if item.deleted() {
+		return nil, store.NotFound(ErrCouldNotGetUser)
+	}
+
+	return convertUserItem(item), nil
+}
+
+// GetUserByEmail follows the item claiming email to its user. Both reads are
+// strongly consistent, but the user may change between them, so its email is
+// checked again.
+func (s *Store) GetUserByEmail(ctx context.Context, email string) (*pb.User, error) {
+	var emailItem EmailItem
+	emailItem.SetKeys(store.TenantFromContext(ctx), email)
+
+	resp, err := s.client.GetItem(ctx, &ddb.GetItemInput{
+		TableName: &s.table,
+		Key: map[string]types.AttributeValue{
+			"PK": &types.AttributeValueMemberS{Value: emailItem.PK},
+			"SK": &types.AttributeValueMemberS{Value: emailItem.SK},
+		},
+		ConsistentRead: aws.Bool(true),
+	})
+
+	if err != nil {
+		slog.ErrorContext(ctx, ErrCouldNotGetUser.Error(),
+			slog.Any("error", err),
+			slog.String("user email", email),
+		)
+		return nil, classify(ErrCouldNotGetUser, err)
+	}
+
+	if resp.Item == nil {
+		return nil, store.NotFound(ErrCouldNotGetUser)
+	}
+
+	if err := attributevalue.UnmarshalMap(resp.Item, &emailItem); err != nil {
+		slog.ErrorContext(ctx, ErrCouldNotGetUser.Error(),
+			slog.Any("error", err),
+			slog.String("user email", email),
+		)
+		return nil, classify(ErrCouldNotGetUser, err)
+	}
+
+	item, err := s.readUser(ctx, emailItem.UserID, 0)
+	if err != nil {
+		slog.ErrorContext(ctx, ErrCouldNotGetUser.Error(),
+			slog.Any("error", err),
+			slog.String("user email", email),
+		)
+		return nil, classify(ErrCouldNotGetUser, err)
+	}
+
+	if store.NormalizeEmail(item.User.Email) != store.NormalizeEmail(email) {
+		return nil, store.NotFound(ErrCouldNotGetUser)
+	}
+
+	return convertUserItem(*item), nil
+}
+
+// listCursor is the position encoded into page tokens: the last key DynamoDB
+// evaluated, and the order it was listing users in, since each order reads
+// its own index.
+type listCursor struct {
+	Order string            `json:"o"`
+	Key   map[string]string `json:"k"`
+}
+
+func (s *Store) ListUsers(ctx context.Context, params store.ListUsersParams) ([]*pb.User, string, error) {
+	order := params.OrderBy
+
+	var startKey map[string]types.AttributeValue
+	if params.PageToken != "" {
+		var cursor listCursor
+		if err := store.DecodePageToken(params.PageToken, &cursor); err != nil {
+			return nil, "", err
+		}
+		if err := store.CheckPageOrder(cursor.Order, order); err != nil {
+			return nil, "", err
+		}
+
+		av, err := attributevalue.MarshalMap(cursor.Key)
+		if err != nil {
+			return nil, "", fmt.Errorf("%w: %w", store.ErrInvalidPageToken, err)
+		}
+		startKey = av
+	}
+
+	query := listQuery(s.table, store.TenantFromContext(ctx), order, params.Filter)
+	limit := params.Limit()
+	users := make([]*pb.User, 0, limit)
+
+	// A single Query stops at 1 MB of data, and its limit counts users the
+	// filter then drops, so keep reading until the page is full or the index
+	// is exhausted.
+	for {
+		query.ExclusiveStartKey = startKey
+		query.Limit = aws.Int32(limit - int32(len(users)))
+
+		resp, err := s.client.Query(ctx, query)
+		if err != nil {
+			slog.ErrorContext(ctx, ErrCouldNotListUsers.Error(),
+				slog.Any("error", err),
+			)
+			return nil, "", classify(ErrCouldNotListUsers, err)
+		}
+
+		for _, item := range resp.Items {
+			var userItem UserItem
+			if err := attributevalue.UnmarshalMap(item, &userItem); err != nil {
+				slog.ErrorContext(ctx, ErrCouldNotListUsers.Error(),
+					slog.Any("error", err),
+				)
+				continue
+			}
+			users = append(users, convertUserItem(userItem))
+		}
+
+		startKey = resp.LastEvaluatedKey
+		if len(startKey) == 0 || len(users) >= int(limit) {
+			break
+		}
+	}
+
+	if len(startKey) == 0 {
+		return users, "", nil
+	}
+
+	cursor := listCursor{Order: order.String()}
+	if err := attributevalue.UnmarshalMap(startKey, &cursor.Key); err != nil {
+		slog.ErrorContext(ctx, ErrCouldNotListUsers.Error(),
+			slog.Any("error", err),
+		)
+		return nil, "", classify(ErrCouldNotListUsers, err)
+	}
+
+	nextPageToken, err := store.EncodePageToken(cursor)
+	if err != nil {
+		slog.ErrorContext(ctx, ErrCouldNotListUsers.Error(),
+			slog.Any("error", err),
+		)
+		return nil, "", classify(ErrCouldNotListUsers, err)
+	}
+
+	return users, nextPageToken, nil
+}
+
+// CountUsers counts the users matching filter by reading every one of them,
+// from the index whose key narrows the read the most.
+func (s *Store) CountUsers(ctx context.Context, filter store.UserFilter) (int64, error) {
+	var order store.UserOrder
+	if filter.NamePrefix == "" && (!filter.CreatedAfter.IsZero() || !filter.CreatedBefore.IsZero()) {
+		order.Field = store.OrderByCreatedAt
+	}
+
+	query := listQuery(s.table, store.TenantFromContext(ctx), order, filter)
+	query.Select = types.SelectCount
+
+	var count int64
+	for {
+		resp, err := s.client.Query(ctx, query)
+		if err != nil {
+			slog.ErrorContext(ctx, ErrCouldNotListUsers.Error(),
+				slog.Any("error", err),
+			)
+			return 0, classify(ErrCouldNotListUsers, err)
+		}
+
+		count += int64(resp.Count)
+
+		if len(resp.LastEvaluatedKey) == 0 {
+			return count, nil
+		}
+		query.ExclusiveStartKey = resp.LastEvaluatedKey
+	}
+}
+
+// listQuery builds the query of the index listing the users of tenant in
+// order. Filters on the index's own sort key become part of the key
+// condition, so DynamoDB only reads the users that match them; the rest are
+// filter expressions.
+func listQuery(table, tenant string, order store.UserOrder, filter store.UserFilter) *ddb.QueryInput {
+	index := "GSI2"
+	if order.Field == store.OrderByCreatedAt {
+		index = "GSI3"
+	}
+
+	keyCondition := index + "PK = :pk"
+	var filters []string
+	names := make(map[string]string)
+	values := map[string]types.AttributeValue{
+		":pk": &types.AttributeValueMemberS{Value: tenantKey(tenant, usersPartition)},
+	}
+
+	if filter.NamePrefix != "" {
+		values[":namePrefix"] = &types.AttributeValueMemberS{Value: filter.NamePrefix}
+
+		// GSI2SK is the name followed by nameKeySeparator, so a prefix
+		// holding it could match into the id.
+		if index == "GSI2" && !strings.Contains(filter.NamePrefix, nameKeySeparator) {
+			keyCondition += " AND begins_with(GSI2SK, :namePrefix)"
+		} else {
+			names["#user"] = "user"
+			names["#name"] = "name"
+			filters = append(filters, "begins_with(#user.#name, :namePrefix)")
+		}
+	}
+
+	// GSI3SK starts with the creation time, so comparing it to a bare time
+	// compares creation times: a user created at exactly the time sorts
+	// after it.
+	var created []string
+	if !filter.CreatedAfter.IsZero() {
+		values[":createdAfter"] = &types.AttributeValueMemberS{Value: sortableTime(filter.CreatedAfter)}
+		created = append(created, "GSI3SK >= :createdAfter")
+	}
+	if !filter.CreatedBefore.IsZero() {
+		values[":createdBefore"] = &types.AttributeValueMemberS{Value: sortableTime(filter.CreatedBefore)}
+		created = append(created, "GSI3SK < :createdBefore")
+	}
+	switch {
+	case index != "GSI3":
+		filters = append(filters, created...)
+	case len(created) == 2:
+		// A key condition allows one comparison of the sort key, and no user
+		// has a key equal to the bare upper bound.
+		keyCondition += " AND GSI3SK BETWEEN :createdAfter AND :createdBefore"
+	case len(created) == 1:
+		keyCondition += " AND " + created[0]
+	}
+
+	if filter.EmailDomain != "" {
+		values[":emailDomain"] = &types.AttributeValueMemberS{Value: store.NormalizeEmail(filter.EmailDomain)}
+		filters = append(filters, "emailDomain = :emailDomain")
+	}
+
+	if !filter.ShowDeleted {
+		names["#user"] = "user"
+		names["#deletedAt"] = "deletedAt"
+		filters = append(filters, "attribute_not_exists(#user.#deletedAt)")
+	}
+
+	query := &ddb.QueryInput{
+		TableName:                 &table,
+		IndexName:                 aws.String(index),
+		KeyConditionExpression:    aws.String(keyCondition),
+		ExpressionAttributeValues: values,
+		ScanIndexForward:          aws.Bool(!order.Desc),
+	}
+	if len(names) > 0 {
+		query.ExpressionAttributeNames = names
+	}
+	if len(filters) > 0 {
+		query.FilterExpression = aws.String(strings.Join(filters, " AND "))
+	}
+
+	return query
+}
+
+func (s *Store) UpdateUser(ctx context.Context, user *pb.User, mask store.FieldMask, expectedVersion int64) (*pb.User, error) {
+	current, err := s.readUser(ctx, user.GetId(), expectedVersion)
+	if err != nil {
+		slog.ErrorContext(ctx, ErrCouldNotUpdateUser.Error(),
+			slog.Any("error", err),
+			slog.String("user id", user.GetId()),
+		)
+		return nil, classify(ErrCouldNotUpdateUser, err)
+	}
+
+	updated := *current
+	updated.User.UpdatedAt = user.GetUpdatedAt().AsTime()
+	updated.User.Version = current.version() + 1
+
+	updatedAt, err := attributevalue.Marshal(updated.User.UpdatedAt)
+	if err != nil {
+		slog.ErrorContext(ctx, ErrCouldNotUpdateUser.Error(),
+			slog.Any("error", err),
+			slog.String("user id", user.GetId()),
+		)
+		return nil, classify(ErrCouldNotUpdateUser, err)
+	}
+
+	// Only the attributes being changed are set, leaving the rest of the
+	// user, such as createdAt, untouched.
+	set := []string{"#user.#updatedAt = :updatedAt", "#user.#version = :version"}
+	names := map[string]string{
+		"#updatedAt": "updatedAt",
+	}
+	values := map[string]types.AttributeValue{
+		":updatedAt": updatedAt,
+		":version":   &types.AttributeValueMemberN{Value: strconv.FormatInt(updated.User.Version, 10)},
+	}
+	if mask.Name {
+		updated.User.Name = user.GetName()
+		set = append(set, "#user.#name = :name")
+		names["#name"] = "name"
+		values[":name"] = &types.AttributeValueMemberS{Value: updated.User.Name}
+	}
+	if mask.Email {
+		updated.User.Email = user.GetEmail()
+		set = append(set, "#user.#email = :email")
+		names["#email"] = "email"
+		values[":email"] = &types.AttributeValueMemberS{Value: updated.User.Email}
+	}
+
+	// The keys that list users are always written, which also backfills them
+	// for users written before they were kept.
+	tenant := store.TenantFromContext(ctx)
+	updated.SetKeys(tenant)
+	set = append(set,
+		"GSI2PK = :listPK", "GSI2SK = :nameKey",
+		"GSI3PK = :listPK", "GSI3SK = :createdKey",
+		"emailDomain = :emailDomain",
+	)
+	values[":listPK"] = &types.AttributeValueMemberS{Value: updated.GSI2PK}
+	values[":nameKey"] = &types.AttributeValueMemberS{Value: updated.GSI2SK}
+	values[":createdKey"] = &types.AttributeValueMemberS{Value: updated.GSI3SK}
+	values[":emailDomain"] = &types.AttributeValueMemberS{Value: updated.EmailDomain}
+
+	guard := versionGuard(current.version(), names, values)
+
+	emailItem := EmailItem{UserID: user.GetId()}
+	emailItem.SetKeys(tenant, updated.User.Email)
+
+	emailAv, err := attributevalue.MarshalMap(emailItem)
+	if err != nil {
+		slog.ErrorContext(ctx, ErrCouldNotUpdateUser.Error(),
+			slog.Any("error", err),
+			slog.String("user id", user.GetId()),
+		)
+		return nil, classify(ErrCouldNotUpdateUser, err)
+	}
+
+	// The update is conditioned on the version that was read, so the email
+	// claimed and the one released cannot change underneath it. Claiming an
+	// email the user already has is allowed, which also backfills the email
+	// item of users created before emails were claimed.
+	items := []types.TransactWriteItem{
+		{Update: &types.Update{
+			TableName:                           &s.table,
+			Key:                                 current.key(),
+			UpdateExpression:                    aws.String("SET " + strings.Join(set, ", ")),
+			ConditionExpression:                 aws.String(guard),
+			ExpressionAttributeNames:            names,
+			ExpressionAttributeValues:           values,
+			ReturnValuesOnConditionCheckFailure: types.ReturnValuesOnConditionCheckFailureAllOld,
+		}},
+		{Put: &types.Put{
+			TableName:           &s.table,
+			Item:                emailAv,
+			ConditionExpression: aws.String("attribute_not_exists(PK) OR userId = :id"),
+			ExpressionAttributeValues: map[string]types.AttributeValue{
+				":id": &types.AttributeValueMemberS{Value: user.GetId()},
+			},
+		}},
+	}
+	if store.NormalizeEmail(current.User.Email) != store.NormalizeEmail(updated.User.Email) {
+		items = append(items, releaseEmail(s.table, tenant, current.User.Email, user.GetId()))
+	}
+
+	event, err := s.recordEvent(store.NewUserUpdated(ctx, convertUserItem(updated), mask))
+	if err != nil {
+		slog.ErrorContext(ctx, ErrCouldNotUpdateUser.Error(),
+			slog.Any("error", err),
+			slog.String("user id", user.GetId()),
+		)
+		return nil, classify(ErrCouldNotUpdateUser, err)
+	}
+
+	// The version guard makes the user read the one the update replaces.
+	history, err := s.recordChange(ctx, convertUserItem(*current), convertUserItem(updated), updated.User.UpdatedAt)
+	if err != nil {
+		slog.ErrorContext(ctx, ErrCouldNotUpdateUser.Error(),
+			slog.Any("error", err),
+			slog.String("user id", user.GetId()),
+		)
+		return nil, classify(ErrCouldNotUpdateUser, err)
+	}
+	items = append(append(items, event), history...)
+
+	_, err = s.client.TransactWriteItems(ctx, &ddb.TransactWriteItemsInput{
+		TransactItems: items,
+	})
+
+	if err != nil {
+		slog.ErrorContext(ctx, ErrCouldNotUpdateUser.Error(),
+			slog.Any("error", err),
+			slog.String("user id", user.GetId()),
+		)
+		return nil, classifyConditional(ErrCouldNotUpdateUser, err, writeFailed(expectedVersion, 1))
+	}
+
+	return convertUserItem(updated), nil
+}
+
+// BackfillEmails claims the email of every user, of any tenant, that is not
+// deleted, and returns how many it claimed, counting those already claimed.
+// It is for tables holding users created before emails were claimed, and is
+// safe to run again, or alongside other writes: each claim is conditioned on
+// the user not having changed since it was read, and users that did are
+// skipped, as the write that changed them claimed their email. Emails two
+// users already share cannot be claimed by both, so the later is logged and
+// skipped, to be resolved by changing one of them.
+func (s *Store) BackfillEmails(ctx context.Context) (int, error) {
+	scan := &ddb.ScanInput{
+		TableName:        &s.table,
+		FilterExpression: aws.String("attribute_exists(#user) AND attribute_not_exists(#user.#deletedAt)"),
+		ExpressionAttributeNames: map[string]string{
+			"#user":      "user",
+			"#deletedAt": "deletedAt",
+		},
+	}
+
+	var claimed int
+	for {
+		resp, err := s.client.Scan(ctx, scan)
+		if err != nil {
+			slog.ErrorContext(ctx, ErrCouldNotBackfillEmails.Error(), slog.Any("error", err))
+			return claimed, classify(ErrCouldNotBackfillEmails, err)
+		}
+
+		var items []UserItem
+		if err := attributevalue.UnmarshalListOfMaps(resp.Items, &items); err != nil {
+			slog.ErrorContext(ctx, ErrCouldNotBackfillEmails.Error(), slog.Any("error", err))
+			return claimed, classify(ErrCouldNotBackfillEmails, err)
+		}
+
+		for _, item := range items {
+			err := s.claimEmail(ctx, item)
+			switch {
+			case errors.Is(err, store.ErrAlreadyExists):
+				slog.WarnContext(ctx, "email already claimed by another user",
+					slog.String("tenant", tenantOf(item.PK)),
+					slog.String("user id", item.User.Id),
+				)
+				continue
+			case errors.Is(err, store.ErrPreconditionFailed):
+				continue
+			case err != nil:
+				return claimed, err
+			}
+			claimed++
+		}
+
+		if len(resp.LastEvaluatedKey) == 0 {
+			return claimed, nil
+		}
+		scan.ExclusiveStartKey = resp.LastEvaluatedKey
+	}
+}
+
+// BackfillListKeys sets the GSI2 and GSI3 keys and the email domain of every
+// user, of any tenant, whose keys are missing or differ from those SetKeys
+// gives it, and returns how many it set. It is for tables holding users
+// written before those keys were kept, which ListUsers and CountUsers do not
+// find, or before GSI2SK joined the name and id with nameKeySeparator. Like
+// BackfillEmails it is safe to run again, or alongside other writes: users
+// that changed since they were read are skipped, as the write that changed
+// them set their keys.
+func (s *Store) BackfillListKeys(ctx context.Context) (int, error) {
+	scan := &ddb.ScanInput{
+		TableName:        &s.table,
+		FilterExpression: aws.String("attribute_exists(#user)"),
+		ExpressionAttributeNames: map[string]string{
+			"#user": "user",
+		},
+	}
+
+	var set int
+	for {
+		resp, err := s.client.Scan(ctx, scan)
+		if err != nil {
+			slog.ErrorContext(ctx, ErrCouldNotBackfillKeys.Error(), slog.Any("error", err))
+			return set, classify(ErrCouldNotBackfillKeys, err)
+		}
+
+		var items []UserItem
+		if err := attributevalue.UnmarshalListOfMaps(resp.Items, &items); err != nil {
+			slog.ErrorContext(ctx, ErrCouldNotBackfillKeys.Error(), slog.Any("error", err))
+			return set, classify(ErrCouldNotBackfillKeys, err)
+		}
+
+		for _, item := range items {
+			want := item
+			want.SetKeys(tenantOf(item.PK))
+			if item.GSI2PK == want.GSI2PK && item.GSI2SK == want.GSI2SK &&
+				item.GSI3PK == want.GSI3PK && item.GSI3SK == want.GSI3SK &&
+				item.EmailDomain == want.EmailDomain {
+				continue
+			}
+
+			err := s.setListKeys(ctx, want, item.version())
+			switch {
+			case errors.Is(err, store.ErrPreconditionFailed):
+				continue
+			case err != nil:
+				return set, err
+			}
+			set++
+		}
+
+		if len(resp.LastEvaluatedKey) == 0 {
+			return set, nil
+		}
+		scan.ExclusiveStartKey = resp.LastEvaluatedKey
+	}
+}
+
+// setListKeys writes the GSI2 and GSI3 keys and the email domain of item, if
+// the user is still at expectedVersion. It fails with ErrPreconditionFailed
+// if the user has changed or is gone.
+func (s *Store) setListKeys(ctx context.Context, item UserItem, expectedVersion int64) error {
+	names := make(map[string]string)
+	values := map[string]types.AttributeValue{
+		":gsi2pk":      &types.AttributeValueMemberS{Value: item.GSI2PK},
+		":gsi2sk":      &types.AttributeValueMemberS{Value: item.GSI2SK},
+		":gsi3pk":      &types.AttributeValueMemberS{Value: item.GSI3PK},
+		":gsi3sk":      &types.AttributeValueMemberS{Value: item.GSI3SK},
+		":emailDomain": &types.AttributeValueMemberS{Value: item.EmailDomain},
+	}
+	guard := versionGuard(expectedVersion, names, values)
+
+	_, err := s.client.UpdateItem(ctx, &ddb.UpdateItemInput{
+		TableName:                 &s.table,
+		Key:                       item.key(),
+		UpdateExpression:          aws.String("SET GSI2PK = :gsi2pk, GSI2SK = :gsi2sk, GSI3PK = :gsi3pk, GSI3SK = :gsi3sk, emailDomain = :emailDomain"),
+		ConditionExpression:       aws.String(guard),
+		ExpressionAttributeNames:  names,
+		ExpressionAttributeValues: values,
+	})
+
+	if err != nil {
+		slog.ErrorContext(ctx, ErrCouldNotBackfillKeys.Error(),
+			slog.Any("error", err),
+			slog.String("user id", item.User.Id),
+		)
+		return classifyConditional(ErrCouldNotBackfillKeys, err, store.PreconditionFailed)
+	}
+
+	return nil
+}
+
+// claimEmail claims the email of the user item, if the user is as it was
+// read. It fails with ErrAlreadyExists if another user has claimed the
+// email, and with ErrPreconditionFailed if the user has changed or is gone.
+func (s *Store) claimEmail(ctx context.Context, item UserItem) error {
+	names := map[string]string{
+		"#deletedAt": "deletedAt",
+	}
+	values := make(map[string]types.AttributeValue)
+	guard := versionGuard(item.version(), names, values) + " AND attribute_not_exists(#user.#deletedAt)"
+
+	emailItem := EmailItem{UserID: item.User.Id}
+	emailItem.SetKeys(tenantOf(item.PK), item.User.Email)
+
+	emailAv, err := attributevalue.MarshalMap(emailItem)
+	if err != nil {
+		slog.ErrorContext(ctx, ErrCouldNotBackfillEmails.Error(),
+			slog.Any("error", err),
+			slog.String("user id", item.User.Id),
+		)
+		return classify(ErrCouldNotBackfillEmails, err)
+	}
+
+	_, err = s.client.TransactWriteItems(ctx, &ddb.TransactWriteItemsInput{
+		TransactItems: []types.TransactWriteItem{
+			{ConditionCheck: &types.ConditionCheck{
+				TableName:                 &s.table,
+				Key:                       item.key(),
+				ConditionExpression:       aws.String(guard),
+				ExpressionAttributeNames:  names,
+				ExpressionAttributeValues: values,
+			}},
+			{Put: &types.Put{
+				TableName:           &s.table,
+				Item:                emailAv,
+				ConditionExpression: aws.String("attribute_not_exists(PK) OR userId = :id"),
+				ExpressionAttributeValues: map[string]types.AttributeValue{
+					":id": &types.AttributeValueMemberS{Value: item.User.Id},
+				},
+			}},
+		},
+	})
+
+	if err != nil {
+		slog.ErrorContext(ctx, ErrCouldNotBackfillEmails.Error(),
+			slog.Any("error", err),
+			slog.String("user id", item.User.Id),
+		)
+		return classifyConditional(ErrCouldNotBackfillEmails, err, emailClaimFailed)
+	}
+
+	return nil
+}
+
+// emailClaimFailed explains a failed condition in the transaction of
+// claimEmail, whose second item claims the email.
+func emailClaimFailed(err error) error {
+	var canceled *types.TransactionCanceledException
+	if errors.As(err, &canceled) {
+		for i, reason := range canceled.CancellationReasons {
+			if i == 1 && aws.ToString(reason.Code) == "ConditionalCheckFailed" {
+				return store.AlreadyExists(err)
+			}
+		}
+	}
+
+	// The user changed, or was removed, after it was read.
+	return store.PreconditionFailed(err)
+}
+
+func (s *Store) BatchGetUsers(ctx context.Context, ids []string) ([]store.BatchResult, error) {
+	// BatchGetItem rejects duplicate keys, so each id is read once.
+	unique := make([]string, 0, len(ids))
+	seen := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		if !seen[id] {
+			seen[id] = true
+			unique = append(unique, id)
+		}
+	}
+
+	tenant := store.TenantFromContext(ctx)
+	found := make(map[string]UserItem, len(unique))
+	for chunk := range slices.Chunk(unique, maxBatchGetKeys) {
+		keys := make([]map[string]types.AttributeValue, 0, len(chunk))
+		for _, id := range chunk {
+			keys = append(keys, userItemKey(tenant, id))
+		}
+
+		items, err := s.batchGet(ctx, keys)
+		if err != nil {
+			slog.ErrorContext(ctx, ErrCouldNotGetUser.Error(),
+				slog.Any("error", err),
+			)
+			return nil, classify(ErrCouldNotGetUser, err)
+		}
+
+		for _, av := range items {
+			var item UserItem
+			if err := attributevalue.UnmarshalMap(av, &item); err != nil {
+				slog.ErrorContext(ctx, ErrCouldNotGetUser.Error(),
+					slog.Any("error", err),
+				)
+				continue
+			}
+			found[item.User.Id] = item
+		}
+	}
+
+	results := make([]store.BatchResult, len(ids))
+	for i, id := range ids {
+		item, ok := found[id]
+		if !ok || item.deleted() {
+			results[i].Err = store.NotFound(ErrCouldNotGetUser)
+			continue
+		}
+		results[i].User = convertUserItem(item)
+	}
+
+	return results, nil
+}
+
+// BatchCreateUsers creates each user as CreateUser does, several at a time.
+// BatchWriteItem is not used since it cannot carry the conditions that keep
+// ids and emails unique.
+func (s *Store) BatchCreateUsers(ctx context.Context, users []*pb.User) ([]store.BatchResult, error) {
+	results := make([]store.BatchResult, len(users))
+	concurrently(len(users), func(i int) {
+		results[i].Err = s.CreateUser(ctx, users[i])
+	})
+
+	return results, nil
+}
+
+// BatchDeleteUsers deletes each user as DeleteUser does, several at a time,
+// for the same reason as BatchCreateUsers.
+func (s *Store) BatchDeleteUsers(ctx context.Context, deletes []store.BatchDelete, deletedAt time.Time) ([]store.BatchResult, error) {
+	results := make([]store.BatchResult, len(deletes))
+	concurrently(len(deletes), func(i int) {
+		results[i].Err = s.DeleteUser(ctx, deletes[i].ID, deletes[i].ExpectedVersion, deletedAt)
+	})
+
+	return results, nil
+}
+
+// PendingEvents reads up to limit events from each outbox partition, and
+// returns the oldest limit of them all.
+func (s *Store) PendingEvents(ctx context.Context, limit int32) ([]*pb.UserEvent, error) {
+	partitions := outboxPartitions()
+	items := make([][]EventItem, len(partitions))
+	errs := make([]error, len(partitions))
+	concurrently(len(partitions), func(i int) {
+		resp, err := s.client.Query(ctx, &ddb.QueryInput{
+			TableName:              &s.table,
+			KeyConditionExpression: aws.String("PK = :pk"),
+			ExpressionAttributeValues: map[string]types.AttributeValue{
+				":pk": &types.AttributeValueMemberS{Value: partitions[i]},
+			},
+			ConsistentRead: aws.Bool(true),
+			Limit:          aws.Int32(limit),
+		})
+		if err != nil {
+			errs[i] = err
+			return
+		}
+		errs[i] = attributevalue.UnmarshalListOfMaps(resp.Items, &items[i])
+	})
+	if err := errors.Join(errs...); err != nil {
+		slog.ErrorContext(ctx, ErrCouldNotReadEvents.Error(), slog.Any("error", err))
+		return nil, classify(ErrCouldNotReadEvents, err)
+	}
+
+	merged := slices.Concat(items...)
+	slices.SortFunc(merged, func(a, b EventItem) int {
+		return strings.Compare(a.SK, b.SK)
+	})
+	merged = merged[:min(len(merged), int(limit))]
+
+	events := make([]*pb.UserEvent, 0, len(merged))
+	for _, item := range merged {
+		event := &pb.UserEvent{}
+		if err := proto.Unmarshal(item.Event, event); err != nil {
+			slog.ErrorContext(ctx, ErrCouldNotReadEvents.Error(),
+				slog.Any("error", err),
+				slog.String("event id", item.SK),
+			)
+			return nil, classify(ErrCouldNotReadEvents, err)
+		}
+		events = append(events, event)
+	}
+
+	return events, nil
+}
+
+// AckEvents deletes each event from its outbox partition, and from the
+// unsharded one, which may hold it if it was recorded before the outbox was
+// sharded.
+func (s *Store) AckEvents(ctx context.Context, events []*pb.UserEvent) error {
+	requests := make([]types.WriteRequest, 0, 2*len(events))
+	for _, event := range events {
+		for _, pk := range []string{outboxKey(event), outboxPartition} {
+			requests = append(requests, types.WriteRequest{DeleteRequest: &types.DeleteRequest{
+				Key: map[string]types.AttributeValue{
+					"PK": &types.AttributeValueMemberS{Value: pk},
+					"SK": &types.AttributeValueMemberS{Value: event.GetId()},
+				},
+			}})
+		}
+	}
+
+	for chunk := range slices.Chunk(requests, maxBatchWriteItems) {
+		if err := s.batchWrite(ctx, chunk); err != nil {
+			slog.ErrorContext(ctx, ErrCouldNotAckEvents.Error(), slog.Any("error", err))
+			return classify(ErrCouldNotAckEvents, err)
+		}
+	}
+
+	return nil
+}
+
+// auditCursor is the position encoded into audit page tokens: the last key
+// DynamoDB evaluated.
+type auditCursor struct {
+	Key map[string]string `json:"k"`
+}
+
+// ListAuditEvents reads the tenant's audit partition, or the user's partition
+// of GSI1 when the filter names a user. The index is eventually consistent, so
+// events for a user may take a moment to be listed.
+func (s *Store) ListAuditEvents(ctx context.Context, params store.ListAuditEventsParams) ([]*pb.AuditEvent, string, error) {
+	filter := params.Filter
+
+	query := &ddb.QueryInput{
+		TableName:      &s.table,
+		ConsistentRead: aws.Bool(true),
+		Limit:          aws.Int32(params.Limit()),
+	}
+	tenant := store.TenantFromContext(ctx)
+	pk, sk := "PK", "SK"
+	values := map[string]types.AttributeValue{
+		":pk": &types.AttributeValueMemberS{Value: tenantKey(tenant, auditPartition)},
+	}
+	if filter.UserID != "" {
+		query.IndexName = aws.String("GSI1")
+		query.ConsistentRead = nil
+		pk, sk = "GSI1PK", "GSI1SK"
+		values[":pk"] = &types.AttributeValueMemberS{Value: auditUserPartition(tenant, filter.UserID)}
+	}
+
+	// Keys start with the time the event occurred, so comparing them to a
+	// bare time compares those times, as listQuery does for creation times.
+	keyCondition := pk + " = :pk"
+	if !filter.StartTime.IsZero() {
+		values[":start"] = &types.AttributeValueMemberS{Value: sortableTime(filter.StartTime)}
+	}
+	if !filter.EndTime.IsZero() {
+		values[":end"] = &types.AttributeValueMemberS{Value: sortableTime(filter.EndTime)}
+	}
+	switch {
+	case !filter.StartTime.IsZero() && !filter.EndTime.IsZero():
+		keyCondition += fmt.Sprintf(" AND %s BETWEEN :start AND :end", sk)
+	case !filter.StartTime.IsZero():
+		keyCondition += fmt.Sprintf(" AND %s >= :start", sk)
+	case !filter.EndTime.IsZero():
+		keyCondition += fmt.Sprintf(" AND %s < :end", sk)
+	}
+	query.KeyConditionExpression = aws.String(keyCondition)
+	query.ExpressionAttributeValues = values
+
+	if params.PageToken != "" {
+		var cursor auditCursor
+		if err := store.DecodePageToken(params.PageToken, &cursor); err != nil {
+			return nil, "", err
+		}
+		av, err := attributevalue.MarshalMap(cursor.Key)
+		if err != nil {
+			return nil, "", fmt.Errorf("%w: %w", store.ErrInvalidPageToken, err)
+		}
+		query.ExclusiveStartKey = av
+	}
+
+	resp, err := s.client.Query(ctx, query)
+	if err != nil {
+		slog.ErrorContext(ctx, ErrCouldNotListAudit.Error(), slog.Any("error", err))
+		return nil, "", classify(ErrCouldNotListAudit, err)
+	}
+
+	var items []AuditItem
+	if err := attributevalue.UnmarshalListOfMaps(resp.Items, &items); err != nil {
+		slog.ErrorContext(ctx, ErrCouldNotListAudit.Error(), slog.Any("error", err))
+		return nil, "", classify(ErrCouldNotListAudit, err)
+	}
+
+	events := make([]*pb.AuditEvent, 0, len(items))
+	for _, item := range items {
+		event := &pb.AuditEvent{}
+		if err := proto.Unmarshal(item.Event, event); err != nil {
+			slog.ErrorContext(ctx, ErrCouldNotListAudit.Error(),
+				slog.Any("error", err),
+				slog.String("audit event key", item.SK),
+			)
+			return nil, "", classify(ErrCouldNotListAudit, err)
+		}
+		events = append(events, event)
+	}
+
+	if len(resp.LastEvaluatedKey) == 0 {
+		return events, "", nil
+	}
+
+	var cursor auditCursor
+	if err := attributevalue.UnmarshalMap(resp.LastEvaluatedKey, &cursor.Key); err != nil {
+		slog.ErrorContext(ctx, ErrCouldNotListAudit.Error(), slog.Any("error", err))
+		return nil, "", classify(ErrCouldNotListAudit, err)
+	}
+
+	nextPageToken, err := store.EncodePageToken(cursor)
+	if err != nil {
+		slog.ErrorContext(ctx, ErrCouldNotListAudit.Error(), slog.Any("error", err))
+		return nil, "", classify(ErrCouldNotListAudit, err)
+	}
+
+	return events, nextPageToken, nil
+}
+
+// revisionCursor is the position encoded into revision page tokens: the last
+// key DynamoDB evaluated.
+type revisionCursor struct {
+	Key map[string]string `json:"k"`
+}
+
+// revisionsQuery returns the query reading the revisions of the user with id
+// in tenant, newest first.
+func (s *Store) revisionsQuery(tenant, id string) *ddb.QueryInput {
+	return &ddb.QueryInput{
+		TableName:              &s.table,
+		ConsistentRead:         aws.Bool(true),
+		KeyConditionExpression: aws.String("PK = :pk AND begins_with(SK, :prefix)"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":pk":     &types.AttributeValueMemberS{Value: userKey(tenant, id)},
+			":prefix": &types.AttributeValueMemberS{Value: revisionPrefix},
+		},
+		ScanIndexForward: aws.Bool(false),
+	}
+}
+
+// ListUserRevisions reads the revision items of the user's partition.
+func (s *Store) ListUserRevisions(ctx context.Context, params store.ListUserRevisionsParams) ([]*pb.UserRevision, string, error) {
+	query := s.revisionsQuery(store.TenantFromContext(ctx), params.UserID)
+	query.Limit = aws.Int32(params.Limit())
+
+	if params.PageToken != "" {
+		var cursor revisionCursor
+		if err := store.DecodePageToken(params.PageToken, &cursor); err != nil {
+			return nil, "", err
+		}
+		av, err := attributevalue.MarshalMap(cursor.Key)
+		if err != nil {
+			return nil, "", fmt.Errorf("%w: %w", store.ErrInvalidPageToken, err)
+		}
+		query.ExclusiveStartKey = av
+	}
+
+	resp, err := s.client.Query(ctx, query)
+	if err != nil {
+		slog.ErrorContext(ctx, ErrCouldNotListRevisions.Error(),
+			slog.Any("error", err),
+			slog.String("user id", params.UserID),
+		)
+		return nil, "", classify(ErrCouldNotListRevisions, err)
+	}
+
+	revisions, err := convertRevisionItems(resp.Items)
+	if err != nil {
+		slog.ErrorContext(ctx, ErrCouldNotListRevisions.Error(),
+			slog.Any("error", err),
+			slog.String("user id", params.UserID),
+		)
+		return nil, "", classify(ErrCouldNotListRevisions, err)
+	}
+
+	if len(resp.LastEvaluatedKey) == 0 {
+		return revisions, "", nil
+	}
+
+	var cursor revisionCursor
+	if err := attributevalue.UnmarshalMap(resp.LastEvaluatedKey, &cursor.Key); err != nil {
+		slog.ErrorContext(ctx, ErrCouldNotListRevisions.Error(), slog.Any("error", err))
+		return nil, "", classify(ErrCouldNotListRevisions, err)
+	}
+
+	nextPageToken, err := store.EncodePageToken(cursor)
+	if err != nil {
+		slog.ErrorContext(ctx, ErrCouldNotListRevisions.Error(), slog.Any("error", err))
+		return nil, "", classify(ErrCouldNotListRevisions, err)
+	}
+
+	return revisions, nextPageToken, nil
+}
+
+// GetUserAsOf reads every revision of the user made at or before asOf, of
+// which there are at most MaxRevisions, and picks the most recent. Revisions
+// are numbered in the order they were written, but are timed by the
+// service's clock, so the newest revision need not be the most recent.
+func (s *Store) GetUserAsOf(ctx context.Context, id string, asOf time.Time) (*pb.User, error) {
+	query := s.revisionsQuery(store.TenantFromContext(ctx), id)
+	query.FilterExpression = aws.String("revisedAt <= :asOf")
+	query.ExpressionAttributeValues[":asOf"] = &types.AttributeValueMemberS{Value: sortableTime(asOf)}
+
+	var found *pb.UserRevision
+	paginator := ddb.NewQueryPaginator(s.client, query)
+	for paginator.HasMorePages() {
+		resp, err := paginator.NextPage(ctx)
+		if err != nil {
+			slog.ErrorContext(ctx, ErrCouldNotGetUser.Error(),
+				slog.Any("error", err),
+				slog.String("user id", id),
+			)
+			return nil, classify(ErrCouldNotGetUser, err)
+		}
+
+		revisions, err := convertRevisionItems(resp.Items)
+		if err != nil {
+			slog.ErrorContext(ctx, ErrCouldNotGetUser.Error(),
+				slog.Any("error", err),
+				slog.String("user id", id),
+			)
+			return nil, classify(ErrCouldNotGetUser, err)
+		}
+
+		// Revisions are read newest first, so of those made at the same
+		// time the first read wins.
+		for _, revision := range revisions {
+			if found == nil || revision.GetRevisedAt().AsTime().After(found.GetRevisedAt().AsTime()) {
+				found = revision
+			}
+		}
+	}
+
+	if found == nil || found.GetUser().GetDeletedAt() != nil {
+		return nil, store.NotFound(ErrCouldNotGetUser)
+	}
+
+	return found.GetUser(), nil
+}
+
+// IdempotencyItem is the record of a request made with an idempotency key,
+// keyed by the key in its tenant. It is not indexed. ExpiresAt is in Unix
+// seconds, so that time to live enabled on the table's expiresAt attribute
+// deletes expired records; until it does, they are ignored.
+type IdempotencyItem struct {
+	PK          string `dynamodbav:"PK"`
+	SK          string `dynamodbav:"SK"`
+	Fingerprint string `dynamodbav:"fingerprint"`
+	Completed   bool   `dynamodbav:"completed"`
+	Response    []byte `dynamodbav:"response,omitempty"`
+	ExpiresAt   int64  `dynamodbav:"expiresAt"`
+}
+
+// idempotencyKey is the partition and sort key of the record of key in
+// tenant.
+func idempotencyKey(tenant, key string) string {
+	return tenantKey(tenant, fmt.Sprintf("IDEMPOTENCY#%s", key))
+}
+
+// idempotencyItemKey returns the primary key of the record of key in tenant.
+func idempotencyItemKey(tenant, key string) map[string]types.AttributeValue {
+	pk := idempotencyKey(tenant, key)
+	return map[string]types.AttributeValue{
+		"PK": &types.AttributeValueMemberS{Value: pk},
+		"SK": &types.AttributeValueMemberS{Value: pk},
+	}
+}
+
+func (s *Store) ClaimIdempotencyKey(ctx context.Context, record store.IdempotencyRecord) (*store.IdempotencyRecord, error) {
+	pk := idempotencyKey(store.TenantFromContext(ctx), record.Key)
+	item, err := attributevalue.MarshalMap(IdempotencyItem{
+		PK:          pk,
+		SK:          pk,
+		Fingerprint: record.Fingerprint,
+		ExpiresAt:   record.ExpiresAt.Unix(),
+	})
+	if err != nil {
+		slog.ErrorContext(ctx, ErrCouldNotClaimKey.Error(),
+			slog.Any("error", err),
+			slog.String("idempotency key", record.Key),
+		)
+		return nil, classify(ErrCouldNotClaimKey, err)
+	}
+
+	_, err = s.client.PutItem(ctx, &ddb.PutItemInput{
+		TableName:           &s.table,
+		Item:                item,
+		ConditionExpression: aws.String("attribute_not_exists(PK) OR expiresAt <= :now"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":now": &types.AttributeValueMemberN{Value: strconv.FormatInt(time.Now().Unix(), 10)},
+		},
+		ReturnValuesOnConditionCheckFailure: types.ReturnValuesOnConditionCheckFailureAllOld,
+	})
+
+	// The key is taken by a record that has not expired, which the failed
+	// condition returns.
+	var ccf *types.ConditionalCheckFailedException
+	if errors.As(err, &ccf) {
+		var kept IdempotencyItem
+		if err = attributevalue.UnmarshalMap(ccf.Item, &kept); err == nil {
+			return &store.IdempotencyRecord{
+				Key:         record.Key,
+				Fingerprint: kept.Fingerprint,
+				Completed:   kept.Completed,
+				Response:    kept.Response,
+				ExpiresAt:   time.Unix(kept.ExpiresAt, 0),
+			}, nil
+		}
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, ErrCouldNotClaimKey.Error(),
+			slog.Any("error", err),
+			slog.String("idempotency key", record.Key),
+		)
+		return nil, classify(ErrCouldNotClaimKey, err)
+	}
+
+	return nil, nil
+}
+
+func (s *Store) CompleteIdempotencyKey(ctx context.Context, record store.IdempotencyRecord) error {
+	_, err := s.client.UpdateItem(ctx, &ddb.UpdateItemInput{
+		TableName:           &s.table,
+		Key:                 idempotencyItemKey(store.TenantFromContext(ctx), record.Key),
+		UpdateExpression:    aws.String("SET completed = :true, #response = :response, expiresAt = :expiresAt"),
+		ConditionExpression: aws.String("attribute_exists(PK) AND completed = :false AND fingerprint = :fingerprint"),
+		ExpressionAttributeNames: map[string]string{
+			"#response": "response",
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":true":        &types.AttributeValueMemberBOOL{Value: true},
+			":false":       &types.AttributeValueMemberBOOL{Value: false},
+			":response":    &types.AttributeValueMemberB{Value: record.Response},
+			":expiresAt":   &types.AttributeValueMemberN{Value: strconv.FormatInt(record.ExpiresAt.Unix(), 10)},
+			":fingerprint": &types.AttributeValueMemberS{Value: record.Fingerprint},
+		},
+	})
+	if err != nil {
+		slog.ErrorContext(ctx, ErrCouldNotCompleteKey.Error(),
+			slog.Any("error", err),
+			slog.String("idempotency key", record.Key),
+		)
+		return classifyConditional(ErrCouldNotCompleteKey, err, store.NotFound)
+	}
+
+	return nil
+}
+
+func (s *Store) ReleaseIdempotencyKey(ctx context.Context, key string) error {
+	_, err := s.client.DeleteItem(ctx, &ddb.DeleteItemInput{
+		TableName:           &s.table,
+		Key:                 idempotencyItemKey(store.TenantFromContext(ctx), key),
+		ConditionExpression: aws.String("completed = :false"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":false": &types.AttributeValueMemberBOOL{Value: false},
+		},
+	})
+
+	// A missing or completed record is left as it is.
+	var ccf *types.ConditionalCheckFailedException
+	if err != nil && !errors.As(err, &ccf) {
+		slog.ErrorContext(ctx, ErrCouldNotReleaseKey.Error(),
+			slog.Any("error", err),
+			slog.String("idempotency key", key),
+		)
+		return classify(ErrCouldNotReleaseKey, err)
+	}
+
+	return nil
+}
+
+// batchWrite makes the write requests, retrying any DynamoDB leaves
+// unprocessed as batchGet does.
+func (s *Store) batchWrite(ctx context.Context, requests []types.WriteRequest) error {
+	request := map[string][]types.WriteRequest{
+		s.table: requests,
+	}
+
+	delay := batchRetryDelay
+	for attempt := 0; ; attempt++ {
+		resp, err := s.client.BatchWriteItem(ctx, &ddb.BatchWriteItemInput{
+			RequestItems: request,
+		})
+		if err != nil {
+			return err
+		}
+
+		request = resp.UnprocessedItems
+		if len(request) == 0 {
+			return nil
+		}
+		if attempt == batchRetries {
+			return store.Unavailable(fmt.Errorf("%d writes left unprocessed", len(request[s.table])))
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+}
+
+// batchGet reads the items with keys, retrying any keys DynamoDB leaves
+// unprocessed.
+func (s *Store) batchGet(ctx context.Context, keys []map[string]types.AttributeValue) ([]map[string]types.AttributeValue, error) {
+	var items []map[string]types.AttributeValue
+	request := map[string]types.KeysAndAttributes{
+		s.table: {Keys: keys},
+	}
+
+	delay := batchRetryDelay
+	for attempt := 0; ; attempt++ {
+		resp, err := s.client.BatchGetItem(ctx, &ddb.BatchGetItemInput{
+			RequestItems: request,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		items = append(items, resp.Responses[s.table]...)
+
+		request = resp.UnprocessedKeys
+		if len(request) == 0 {
+			return items, nil
+		}
+		if attempt == batchRetries {
+			return nil, store.Unavailable(fmt.Errorf("%d keys left unprocessed", len(request[s.table].Keys)))
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+}
+
+// concurrently calls fn with every index below n, with at most
+// batchWriteWorkers calls running at once.
+func concurrently(n int, fn func(i int)) {
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, batchWriteWorkers)
+	for i := range n {
+		sem <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			fn(i)
+		}()
+	}
+	wg.Wait()
+}
+
+// readUser reads the user about to be written, in the tenant of ctx, with a
+// strongly consistent read so the write that follows can be conditioned on
+// its version. A deleted user is not found.
+func (s *Store) readUser(ctx context.Context, id string, expectedVersion int64) (*UserItem, error) {
+	item, err := s.readUserItem(ctx, id, expectedVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	if item.deleted() {
+		return nil, store.NotFound(errors.New("user is deleted"))
+	}
+
+	return item, nil
+}
+
+// readUserItem reads the user as readUser does, whether or not it is deleted.
+func (s *Store) readUserItem(ctx context.Context, id string, expectedVersion int64) (*UserItem, error) {
+	resp, err := s.client.GetItem(ctx, &ddb.GetItemInput{
+		TableName:      &s.table,
+		Key:            userItemKey(store.TenantFromContext(ctx), id),
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.Item == nil {
+		return nil, store.NotFound(errors.New("user does not exist"))
+	}
+
+	var item UserItem
+	if err := attributevalue.UnmarshalMap(resp.Item, &item); err != nil {
+		return nil, err
+	}
+
+	if expectedVersion != 0 && item.version() != expectedVersion {
+		return nil, store.PreconditionFailed(fmt.Errorf("expected version %d", expectedVersion))
+	}
+
+	return &item, nil
+}
+
+// releaseEmail deletes the item claiming email for the user with id in
+// tenant. An email claimed by another user is never released.
+func releaseEmail(table, tenant, email, id string) types.TransactWriteItem {
+	var item EmailItem
+	item.SetKeys(tenant, email)
+
+	return types.TransactWriteItem{Delete: &types.Delete{
+		TableName: &table,
+		Key: map[string]types.AttributeValue{
+			"PK": &types.AttributeValueMemberS{Value: item.PK},
+			"SK": &types.AttributeValueMemberS{Value: item.SK},
+		},
+		ConditionExpression: aws.String("attribute_not_exists(PK) OR userId = :id"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":id": &types.AttributeValueMemberS{Value: id},
+		},
+	}}
+}
+
+// versionGuard returns the condition for a write to an existing user,
+// adding a check of its version to names and values when expectedVersion is
+// set. Users written before versions were tracked have no version attribute
+// and count as FirstVersion.
+func versionGuard(expectedVersion int64, names map[string]string, values map[string]types.AttributeValue) string {
+	condition := "attribute_exists(PK)"
+	if expectedVersion == 0 {
+		return condition
+	}
+
+	names["#user"] = "user"
+	names["#version"] = "version"
+	values[":expectedVersion"] = &types.AttributeValueMemberN{Value: strconv.FormatInt(expectedVersion, 10)}
+
+	if expectedVersion == store.FirstVersion {
+		return condition + " AND (#user.#version = :expectedVersion OR attribute_not_exists(#user.#version))"
+	}
+	return condition + " AND #user.#version = :expectedVersion"
+}
+
+// writeFailed explains a failed condition in a transaction whose first item
+// writes a user read by readUser. claimed is the index of the item claiming
+// an email, or -1 if none does. DynamoDB only returns the user that failed
+// its check if it exists, so without one the user is missing.
+func writeFailed(expectedVersion int64, claimed int) func(error) error {
+	return func(err error) error {
+		var canceled *types.TransactionCanceledException
+		if errors.As(err, &canceled) {
+			for i, reason := range canceled.CancellationReasons {
+				if aws.ToString(reason.Code) != "ConditionalCheckFailed" {
+					continue
+				}
+
+				switch {
+				case i == claimed:
+					return store.AlreadyExists(err)
+				case i == 0 && reason.Item == nil:
+					return store.NotFound(err)
+				case i == 0 && expectedVersion != 0:
+					return store.PreconditionFailed(err)
+				}
+			}
+		}
+
+		// The user changed after it was read, or the email being released
+		// belongs to another user; either way, a retry may succeed.
+		return store.Conflict(err)
+	}
+}
+
+// classifyConditional classifies err as classify does, except that a failed
+// ConditionExpression is classified by conditionFailed, which decides what it
+// means for the calling operation.
+func classifyConditional(op, err error, conditionFailed func(error) error) error {
+	var ccf *types.ConditionalCheckFailedException
+	if errors.As(err, &ccf) {
+		return conditionFailed(fmt.Errorf("%w: %w", op, err))
+	}
+
+	// A canceled transaction gives a reason for each of its items, in order.
+	var canceled *types.TransactionCanceledException
+	if errors.As(err, &canceled) {
+		for _, reason := range canceled.CancellationReasons {
+			switch aws.ToString(reason.Code) {
+			case "ConditionalCheckFailed":
+				return conditionFailed(fmt.Errorf("%w: %w", op, err))
+			case "TransactionConflict", "ProvisionedThroughputExceeded", "RequestLimitExceeded", "ThrottlingError":
+				return classify(op, err)
+			}
+		}
+	}
+
+	return classify(op, err)
+}
+
+// classify wraps err with the operation that failed and the store error kind
+// that best describes it. It is for requests without conditions, which
+// classifyConditional classifies instead.
+func classify(op, err error) error {
+	wrapped := fmt.Errorf("%w: %w", op, err)
+
+	var storeErr *store.Error
+	if errors.As(err, &storeErr) {
+		return &store.Error{Kind: storeErr.Kind, Err: wrapped}
+	}
+
+	var canceled *types.TransactionCanceledException
+	if errors.As(err, &canceled) {
+		for _, reason := range canceled.CancellationReasons {
+			switch aws.ToString(reason.Code) {
+			case "TransactionConflict":
+				return store.Conflict(wrapped)
+			case "ProvisionedThroughputExceeded", "RequestLimitExceeded", "ThrottlingError":
+				return store.Unavailable(wrapped)
+			}
+		}
+	}
+
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) {
+		switch apiErr.ErrorCode() {
+		case "TransactionConflictException":
+			return store.Conflict(wrapped)
+		case "ProvisionedThroughputExceededException",
+			"RequestLimitExceeded",
+			"ThrottlingException",
+			"InternalServerError",
+			"ServiceUnavailable":
+			return store.Unavailable(wrapped)
+		}
+	}
+
+	return store.Internal(wrapped)
+}
+
+func convertRevisionItems(items []map[string]types.AttributeValue) ([]*pb.UserRevision, error) {
+	var revisionItems []RevisionItem
+	if err := attributevalue.UnmarshalListOfMaps(items, &revisionItems); err != nil {
+		return nil, err
+	}
+
+	revisions := make([]*pb.UserRevision, 0, len(revisionItems))
+	for _, item := range revisionItems {
+		revision := &pb.UserRevision{}
+		if err := proto.Unmarshal(item.Revision, revision); err != nil {
+			return nil, fmt.Errorf("revision %s: %w", item.SK, err)
+		}
+		revisions = append(revisions, revision)
+	}
+
+	return revisions, nil
+}
+
+func convertUserItem(item UserItem) *pb.User {
+	var deletedAt *timestamppb.Timestamp
+	if item.deleted() {
+		deletedAt = timestamppb.New(*item.User.DeletedAt)
+	}
+
 	return &pb.User{
 		Id:        item.User.Id,
 		Name:      item.User.Name,
 		Email:     item.User.Email,
 		CreatedAt: timestamppb.New(item.User.CreatedAt),
 		UpdatedAt: timestamppb.New(item.User.UpdatedAt),
+		Version:   item.version(),
+		DeletedAt: deletedAt,
 	}
 }
@@ -0,0 +1,479 @@
+package dynamodb_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	smithyendpoints "github.com/aws/smithy-go/endpoints"
+	tc "github.com/testcontainers/testcontainers-go/modules/dynamodb"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	pb "github.com/andrew-womeldorf/connect-boilerplate/gen/user/v1"
+	"github.com/andrew-womeldorf/connect-boilerplate/internal/services/user/store"
+	ddbstore "github.com/andrew-womeldorf/connect-boilerplate/internal/services/user/store/dynamodb"
+	"github.com/andrew-womeldorf/connect-boilerplate/internal/services/user/store/storetest"
+)
+
+type ddbResolver struct {
+	port string
+}
+
+func (r *ddbResolver) ResolveEndpoint(ctx context.Context, params dynamodb.EndpointParameters) (smithyendpoints.Endpoint, error) {
+	return smithyendpoints.Endpoint{URI: url.URL{Host: r.port, Scheme: "http"}}, nil
+}
+
+var (
+	sharedDynamoDBContainer *tc.DynamoDBContainer
+	sharedDynamoDBClient    *dynamodb.Client
+	sharedDynamoDBTableName = "users"
+	containerSetupOnce      sync.Once
+)
+
+func setupSharedDynamoDBContainer() error {
+	var err error
+	containerSetupOnce.Do(func() {
+		ctx := context.Background()
+
+		sharedDynamoDBContainer, err = tc.Run(ctx, "amazon/dynamodb-local:latest", tc.WithSharedDB())
+		if err != nil {
+			err = fmt.Errorf("could not start dynamodb container: %w", err)
+			return
+		}
+
+		port, portErr := sharedDynamoDBContainer.ConnectionString(ctx)
+		if portErr != nil {
+			err = fmt.Errorf("could not get connection string from dynamodb container: %w", portErr)
+			return
+		}
+
+		cfg, cfgErr := config.LoadDefaultConfig(ctx,
+			config.WithCredentialsProvider(credentials.StaticCredentialsProvider{
+				Value: aws.Credentials{AccessKeyID: "dummy", SecretAccessKey: "dummy"},
+			}),
+		)
+		if cfgErr != nil {
+			err = fmt.Errorf("failed to create aws config: %w", cfgErr)
+			return
+		}
+
+		sharedDynamoDBClient = dynamodb.NewFromConfig(cfg, dynamodb.WithEndpointResolverV2(&ddbResolver{port: port}))
+
+		_, tableErr := sharedDynamoDBClient.CreateTable(ctx, &dynamodb.CreateTableInput{
+			TableName: aws.String(sharedDynamoDBTableName),
+			KeySchema: []types.KeySchemaElement{
+				{
+					AttributeName: aws.String("PK"),
+					KeyType:       types.KeyTypeHash,
+				},
+				{
+					AttributeName: aws.String("SK"),
+					KeyType:       types.KeyTypeRange,
+				},
+			},
+			AttributeDefinitions: []types.AttributeDefinition{
+				{
+					AttributeName: aws.String("PK"),
+					AttributeType: types.ScalarAttributeTypeS,
+				},
+				{
+					AttributeName: aws.String("SK"),
+					AttributeType: types.ScalarAttributeTypeS,
+				},
+				{
+					AttributeName: aws.String("GSI1PK"),
+					AttributeType: types.ScalarAttributeTypeS,
+				},
+				{
+					AttributeName: aws.String("GSI1SK"),
+					AttributeType: types.ScalarAttributeTypeS,
+				},
+				{
+					AttributeName: aws.String("GSI2PK"),
+					AttributeType: types.ScalarAttributeTypeS,
+				},
+				{
+					AttributeName: aws.String("GSI2SK"),
+					AttributeType: types.ScalarAttributeTypeS,
+				},
+				{
+					AttributeName: aws.String("GSI3PK"),
+					AttributeType: types.ScalarAttributeTypeS,
+				},
+				{
+					AttributeName: aws.String("GSI3SK"),
+					AttributeType: types.ScalarAttributeTypeS,
+				},
+			},
+			GlobalSecondaryIndexes: []types.GlobalSecondaryIndex{
+				{
+					IndexName: aws.String("GSI1"),
+					KeySchema: []types.KeySchemaElement{
+						{
+							AttributeName: aws.String("GSI1PK"),
+							KeyType:       types.KeyTypeHash,
+						},
+						{
+							AttributeName: aws.String("GSI1SK"),
+							KeyType:       types.KeyTypeRange,
+						},
+					},
+					Projection: &types.Projection{
+						ProjectionType: types.ProjectionTypeAll,
+					},
+				},
+				{
+					IndexName: aws.String("GSI2"),
+					KeySchema: []types.KeySchemaElement{
+						{
+							AttributeName: aws.String("GSI2PK"),
+							KeyType:       types.KeyTypeHash,
+						},
+						{
+							AttributeName: aws.String("GSI2SK"),
+							KeyType:       types.KeyTypeRange,
+						},
+					},
+					Projection: &types.Projection{
+						ProjectionType: types.ProjectionTypeAll,
+					},
+				},
+				{
+					IndexName: aws.String("GSI3"),
+					KeySchema: []types.KeySchemaElement{
+						{
+							AttributeName: aws.String("GSI3PK"),
+							KeyType:       types.KeyTypeHash,
+						},
+						{
+							AttributeName: aws.String("GSI3SK"),
+							KeyType:       types.KeyTypeRange,
+						},
+					},
+					Projection: &types.Projection{
+						ProjectionType: types.ProjectionTypeAll,
+					},
+				},
+			},
+			BillingMode: types.BillingModePayPerRequest,
+		})
+		if tableErr != nil {
+			err = fmt.Errorf("failed to create dynamodb table: %w", tableErr)
+			return
+		}
+	})
+	return err
+}
+
+func cleanupDynamoDBTable(ctx context.Context) error {
+	if sharedDynamoDBClient == nil {
+		return nil
+	}
+
+	scanOutput, err := sharedDynamoDBClient.Scan(ctx, &dynamodb.ScanInput{
+		TableName: aws.String(sharedDynamoDBTableName),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to scan table for cleanup: %w", err)
+	}
+
+	for _, item := range scanOutput.Items {
+		_, err := sharedDynamoDBClient.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+			TableName: aws.String(sharedDynamoDBTableName),
+			Key: map[string]types.AttributeValue{
+				"PK": item["PK"],
+				"SK": item["SK"],
+			},
+		})
+		if err != nil {
+			return fmt.Errorf("failed to delete item during cleanup: %w", err)
+		}
+	}
+
+	return nil
+}
+
+func TestMain(m *testing.M) {
+	code := m.Run()
+
+	// Cleanup shared container after all tests
+	if sharedDynamoDBContainer != nil {
+		ctx := context.Background()
+		if err := sharedDynamoDBContainer.Terminate(ctx); err != nil {
+			fmt.Printf("failed to terminate shared dynamodb container: %v\n", err)
+		}
+	}
+
+	os.Exit(code)
+}
+
+// newStore returns a store over the shared table, emptied for the test.
+func newStore(t *testing.T) *ddbstore.Store {
+	t.Helper()
+	ctx := context.Background()
+
+	// Setup shared container if not already done
+	if err := setupSharedDynamoDBContainer(); err != nil {
+		t.Fatalf("failed to setup shared dynamodb container: %v", err)
+	}
+
+	// Clean the table before each test
+	if err := cleanupDynamoDBTable(ctx); err != nil {
+		t.Fatalf("failed to cleanup dynamodb table: %v", err)
+	}
+
+	// create the store using the shared client and table
+	s, err := ddbstore.NewStore(
+		ctx, ddbstore.WithClient(sharedDynamoDBClient), ddbstore.WithTable(sharedDynamoDBTableName),
+	)
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+
+	t.Cleanup(func() {
+		// Clean the table after each test
+		if err := cleanupDynamoDBTable(ctx); err != nil {
+			t.Logf("failed to cleanup dynamodb table: %v", err)
+		}
+	})
+
+	return s
+}
+
+// putItem writes item to the shared table as is, bypassing the store.
+func putItem(ctx context.Context, t *testing.T, item map[string]types.AttributeValue) {
+	t.Helper()
+
+	if _, err := sharedDynamoDBClient.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(sharedDynamoDBTableName),
+		Item:      item,
+	}); err != nil {
+		t.Fatalf("failed to put item: %v", err)
+	}
+}
+
+func TestStore(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) store.Store {
+		return newStore(t)
+	})
+}
+
+func TestPurgeUsersSkipsStaleIndex(t *testing.T) {
+	ctx := context.Background()
+	s := newStore(t)
+	now := time.Now()
+
+	// Users undeleted since GSI1 was read are still in its deleted
+	// partition, ahead of the user that is due.
+	for i := range 3 {
+		deletedAt := now.Add(-48*time.Hour + time.Duration(i)*time.Minute)
+		item := ddbstore.UserItem{User: ddbstore.User{
+			Id:        fmt.Sprintf("undeleted-%d", i),
+			Name:      "Undeleted",
+			Email:     fmt.Sprintf("undeleted-%d@example.com", i),
+			CreatedAt: now.Add(-72 * time.Hour),
+			UpdatedAt: now.Add(-72 * time.Hour),
+			Version:   2,
+			DeletedAt: &deletedAt,
+		}}
+		item.SetKeys(store.DefaultTenant)
+
+		av, err := attributevalue.MarshalMap(item)
+		if err != nil {
+			t.Fatalf("failed to marshal item: %v", err)
+		}
+		delete(av["user"].(*types.AttributeValueMemberM).Value, "deletedAt")
+		putItem(ctx, t, av)
+	}
+
+	created := timestamppb.New(now.Add(-72 * time.Hour))
+	if err := s.CreateUser(ctx, &pb.User{Id: "due", Name: "Due", Email: "due@example.com", CreatedAt: created, UpdatedAt: created}); err != nil {
+		t.Fatalf("failed to create user: %v", err)
+	}
+	if err := s.DeleteUser(ctx, "due", 0, now.Add(-24*time.Hour)); err != nil {
+		t.Fatalf("failed to delete user: %v", err)
+	}
+
+	n, err := s.PurgeUsers(ctx, now, 1, now)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if n != 1 {
+		t.Errorf("expected the due user purged past the stale ones, got %d purged", n)
+	}
+	if _, err := s.UndeleteUser(ctx, "due", 0, now); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("expected the purged user to be ErrNotFound, got %v", err)
+	}
+}
+
+func TestBackfillEmails(t *testing.T) {
+	ctx := context.Background()
+	s := newStore(t)
+	now := time.Now()
+
+	// Users created before emails were claimed have no item claiming theirs.
+	for _, id := range []string{"unclaimed", "updated"} {
+		item := ddbstore.UserItem{User: ddbstore.User{
+			Id:        id,
+			Name:      "Unclaimed",
+			Email:     id + "@example.com",
+			CreatedAt: now,
+			UpdatedAt: now,
+			Version:   1,
+		}}
+		item.SetKeys(store.DefaultTenant)
+
+		av, err := attributevalue.MarshalMap(item)
+		if err != nil {
+			t.Fatalf("failed to marshal item: %v", err)
+		}
+		putItem(ctx, t, av)
+	}
+
+	if _, err := s.GetUserByEmail(ctx, "unclaimed@example.com"); !errors.Is(err, store.ErrNotFound) {
+		t.Fatalf("expected an unclaimed email to be ErrNotFound, got %v", err)
+	}
+
+	// Updating a user claims its email.
+	if _, err := s.UpdateUser(ctx, &pb.User{Id: "updated", Name: "Updated", UpdatedAt: timestamppb.New(now)}, store.FieldMask{Name: true}, 0); err != nil {
+		t.Fatalf("failed to update user: %v", err)
+	}
+	if _, err := s.GetUserByEmail(ctx, "UPDATED@example.com"); err != nil {
+		t.Errorf("expected the updated user's email to be claimed, got %v", err)
+	}
+
+	n, err := s.BackfillEmails(ctx)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if n != 2 {
+		t.Errorf("expected 2 emails claimed, got %d", n)
+	}
+
+	got, err := s.GetUserByEmail(ctx, "Unclaimed@Example.com")
+	if err != nil {
+		t.Fatalf("expected the backfilled user, got %v", err)
+	}
+	if got.GetId() != "unclaimed" {
+		t.Errorf("expected user unclaimed, got %q", got.GetId())
+	}
+
+	err = s.CreateUser(ctx, &pb.User{Id: "duplicate", Name: "Duplicate", Email: "unclaimed@example.com", CreatedAt: timestamppb.New(now), UpdatedAt: timestamppb.New(now)})
+	if !errors.Is(err, store.ErrAlreadyExists) {
+		t.Errorf("expected a duplicate of a backfilled email to be ErrAlreadyExists, got %v", err)
+	}
+
+	// Running it again claims nothing new.
+	if n, err := s.BackfillEmails(ctx); err != nil || n != 2 {
+		t.Errorf("expected 2 emails claimed again, got %d, %v", n, err)
+	}
+}
+
+func TestBackfillListKeys(t *testing.T) {
+	ctx := context.Background()
+	s := newStore(t)
+	now := time.Now()
+
+	// Users written before the list keys were kept have none, and users last
+	// written when GSI2SK joined the name and id with "#" have that key.
+	for _, id := range []string{"unkeyed", "hashed"} {
+		item := ddbstore.UserItem{User: ddbstore.User{
+			Id:        id,
+			Name:      "Legacy",
+			Email:     id + "@example.com",
+			CreatedAt: now,
+			UpdatedAt: now,
+			Version:   1,
+		}}
+		item.SetKeys(store.DefaultTenant)
+
+		av, err := attributevalue.MarshalMap(item)
+		if err != nil {
+			t.Fatalf("failed to marshal item: %v", err)
+		}
+		if id == "unkeyed" {
+			for _, key := range []string{"GSI2PK", "GSI2SK", "GSI3PK", "GSI3SK", "emailDomain"} {
+				delete(av, key)
+			}
+		} else {
+			av["GSI2SK"] = &types.AttributeValueMemberS{Value: item.User.Name + "#" + id}
+		}
+		putItem(ctx, t, av)
+	}
+
+	if n, err := s.CountUsers(ctx, store.UserFilter{}); err != nil || n != 1 {
+		t.Fatalf("expected only the user with list keys counted, got %d, %v", n, err)
+	}
+
+	n, err := s.BackfillListKeys(ctx)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if n != 2 {
+		t.Errorf("expected 2 users given list keys, got %d", n)
+	}
+
+	users, _, err := s.ListUsers(ctx, store.ListUsersParams{Filter: store.UserFilter{EmailDomain: "example.com"}})
+	if err != nil {
+		t.Fatalf("failed to list users: %v", err)
+	}
+	if len(users) != 2 || users[0].GetId() != "hashed" || users[1].GetId() != "unkeyed" {
+		t.Errorf("expected users hashed and unkeyed, got %v", users)
+	}
+
+	// Running it again sets nothing.
+	if n, err := s.BackfillListKeys(ctx); err != nil || n != 0 {
+		t.Errorf("expected no users given list keys again, got %d, %v", n, err)
+	}
+}
+
+func TestPendingEventsReadsUnshardedOutbox(t *testing.T) {
+	ctx := context.Background()
+	s := newStore(t)
+
+	// Events recorded before the outbox was sharded are in one partition.
+	legacy := store.NewUserPurged(ctx, "legacy", time.Now())
+	data, err := proto.Marshal(legacy)
+	if err != nil {
+		t.Fatalf("failed to marshal event: %v", err)
+	}
+	av, err := attributevalue.MarshalMap(ddbstore.EventItem{PK: "OUTBOX", SK: legacy.GetId(), Event: data})
+	if err != nil {
+		t.Fatalf("failed to marshal item: %v", err)
+	}
+	putItem(ctx, t, av)
+
+	for _, id := range []string{"user-1", "user-2", "user-3"} {
+		created := timestamppb.Now()
+		if err := s.CreateUser(ctx, &pb.User{Id: id, Name: id, Email: id + "@example.com", CreatedAt: created, UpdatedAt: created}); err != nil {
+			t.Fatalf("failed to create user: %v", err)
+		}
+	}
+
+	events, err := s.PendingEvents(ctx, 10)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if len(events) != 4 || events[0].GetId() != legacy.GetId() {
+		t.Fatalf("expected the unsharded event first of 4, got %v", events)
+	}
+
+	if err := s.AckEvents(ctx, events); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if events, err := s.PendingEvents(ctx, 10); err != nil || len(events) != 0 {
+		t.Errorf("expected no events left, got %v, %v", events, err)
+	}
+}
@@ -0,0 +1,85 @@
+package store
+
+import "errors"
+
+// Kind classifies a store failure so callers can react to it without knowing
+// which backend produced it.
+type Kind int
+
+const (
+	KindInternal Kind = iota
+	KindNotFound
+	KindAlreadyExists
+	KindConflict
+	KindUnavailable
+	KindPreconditionFailed
+)
+
+func (k Kind) String() string {
+	switch k {
+	case KindNotFound:
+		return "not found"
+	case KindAlreadyExists:
+		return "already exists"
+	case KindConflict:
+		return "conflict"
+	case KindUnavailable:
+		return "unavailable"
+	case KindPreconditionFailed:
+		return "precondition failed"
+	default:
+		return "internal"
+	}
+}
+
+// Sentinels for use with errors.Is. Any *Error of the same Kind matches,
+// regardless of the cause it wraps.
+var (
+	ErrInternal           = &Error{Kind: KindInternal}
+	ErrNotFound           = &Error{Kind: KindNotFound}
+	ErrAlreadyExists      = &Error{Kind: KindAlreadyExists}
+	ErrConflict           = &Error{Kind: KindConflict}
+	ErrUnavailable        = &Error{Kind: KindUnavailable}
+	ErrPreconditionFailed = &Error{Kind: KindPreconditionFailed}
+)
+
+// Error is returned by every store implementation. It records the kind of
+// failure and wraps the backend error that caused it.
+type Error struct {
+	Kind Kind
+	Err  error
+}
+
+func (e *Error) Error() string {
+	if e.Err == nil {
+		return e.Kind.String()
+	}
+	return e.Err.Error()
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Is reports whether target is the sentinel for this error's Kind.
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	return ok && t.Err == nil && t.Kind == e.Kind
+}
+
+// KindOf returns the Kind of the first *Error in err's chain. Errors that did
+// not come from a store are reported as KindInternal.
+func KindOf(err error) Kind {
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Kind
+	}
+	return KindInternal
+}
+
+func Internal(err error) error           { return &Error{Kind: KindInternal, Err: err} }
+func NotFound(err error) error           { return &Error{Kind: KindNotFound, Err: err} }
+func AlreadyExists(err error) error      { return &Error{Kind: KindAlreadyExists, Err: err} }
+func Conflict(err error) error           { return &Error{Kind: KindConflict, Err: err} }
+func Unavailable(err error) error        { return &Error{Kind: KindUnavailable, Err: err} }
+func PreconditionFailed(err error) error { return &Error{Kind: KindPreconditionFailed, Err: err} }
@@ -0,0 +1,112 @@
+package store
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	pb "github.com/andrew-womeldorf/connect-boilerplate/gen/user/v1"
+)
+
+// Outbox holds the events a store records for its changes until they have
+// been delivered.
+//
+// Every write that changes a user records one event, in the same transaction
+// as the change, so an event exists exactly when its change was made. Stores
+// record them rather than their callers since only the store knows the user
+// it stored, such as the version an update reached.
+//
+// The outbox is shared by every tenant, whatever the tenant of the context,
+// since relays deliver all of their events. Each event names its tenant.
+type Outbox interface {
+	// PendingEvents returns up to limit events that have not been
+	// acknowledged, oldest first.
+	PendingEvents(ctx context.Context, limit int32) ([]*pb.UserEvent, error)
+	// AckEvents removes events, as PendingEvents returned them, from the
+	// outbox. Events that are not there are ignored.
+	AckEvents(ctx context.Context, events []*pb.UserEvent) error
+}
+
+// EventIDs returns the ids of events.
+func EventIDs(events []*pb.UserEvent) []string {
+	ids := make([]string, 0, len(events))
+	for _, event := range events {
+		ids = append(ids, event.GetId())
+	}
+	return ids
+}
+
+// EventUserID returns the id of the user event is about.
+func EventUserID(event *pb.UserEvent) string {
+	switch e := event.GetEvent().(type) {
+	case *pb.UserEvent_UserCreated:
+		return e.UserCreated.GetUser().GetId()
+	case *pb.UserEvent_UserUpdated:
+		return e.UserUpdated.GetUser().GetId()
+	case *pb.UserEvent_UserDeleted:
+		return e.UserDeleted.GetUserId()
+	case *pb.UserEvent_UserUndeleted:
+		return e.UserUndeleted.GetUser().GetId()
+	case *pb.UserEvent_UserPurged:
+		return e.UserPurged.GetUserId()
+	default:
+		return ""
+	}
+}
+
+// NewUserCreated returns the event for creating user, as stored, in the
+// tenant of ctx.
+func NewUserCreated(ctx context.Context, user *pb.User) *pb.UserEvent {
+	event := newEvent(ctx, user.GetCreatedAt())
+	event.Event = &pb.UserEvent_UserCreated{UserCreated: &pb.UserCreated{User: proto.CloneOf(user)}}
+	return event
+}
+
+// NewUserUpdated returns the event for the update of the fields in mask that
+// left user as it is, in the tenant of ctx.
+func NewUserUpdated(ctx context.Context, user *pb.User, mask FieldMask) *pb.UserEvent {
+	event := newEvent(ctx, user.GetUpdatedAt())
+	event.Event = &pb.UserEvent_UserUpdated{UserUpdated: &pb.UserUpdated{
+		User:       proto.CloneOf(user),
+		UpdateMask: &fieldmaskpb.FieldMask{Paths: mask.Paths()},
+	}}
+	return event
+}
+
+// NewUserDeleted returns the event for deleting user, which occurred at its
+// deleted_at, in the tenant of ctx.
+func NewUserDeleted(ctx context.Context, user *pb.User) *pb.UserEvent {
+	event := newEvent(ctx, user.GetDeletedAt())
+	event.Event = &pb.UserEvent_UserDeleted{UserDeleted: &pb.UserDeleted{UserId: user.GetId()}}
+	return event
+}
+
+// NewUserUndeleted returns the event for restoring user, as stored, at
+// undeletedAt, in the tenant of ctx.
+func NewUserUndeleted(ctx context.Context, user *pb.User, undeletedAt time.Time) *pb.UserEvent {
+	event := newEvent(ctx, timestamppb.New(undeletedAt))
+	event.Event = &pb.UserEvent_UserUndeleted{UserUndeleted: &pb.UserUndeleted{User: proto.CloneOf(user)}}
+	return event
+}
+
+// NewUserPurged returns the event for purging the user with id at purgedAt,
+// in the tenant of ctx.
+func NewUserPurged(ctx context.Context, id string, purgedAt time.Time) *pb.UserEvent {
+	event := newEvent(ctx, timestamppb.New(purgedAt))
+	event.Event = &pb.UserEvent_UserPurged{UserPurged: &pb.UserPurged{UserId: id}}
+	return event
+}
+
+// newEvent returns an event with a fresh id. The ids are version 7 UUIDs,
+// which sort by the time they were made, so stores may order events by id.
+func newEvent(ctx context.Context, occurredAt *timestamppb.Timestamp) *pb.UserEvent {
+	return &pb.UserEvent{
+		Id:         uuid.Must(uuid.NewV7()).String(),
+		OccurredAt: occurredAt,
+		TenantId:   TenantFromContext(ctx),
+	}
+}
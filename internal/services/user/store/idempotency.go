@@ -0,0 +1,42 @@
+package store
+
+import (
+	"context"
+	"time"
+)
+
+// IdempotencyRecord is what a store keeps of a request made with an
+// idempotency key, so that retries of it can be answered as it was.
+type IdempotencyRecord struct {
+	Key string
+	// Fingerprint identifies the request, so that a key reused for a
+	// different request can be told apart from a retry.
+	Fingerprint string
+	// Completed reports whether the request has been answered, with
+	// Response. Until then, it is in progress.
+	Completed bool
+	Response  []byte
+	// ExpiresAt is when the record is forgotten, and its key may be used
+	// again. Callers claim a key until a lease runs out, so that a request
+	// that never completes does not hold its key for long, and keep the
+	// response for longer once it completes.
+	ExpiresAt time.Time
+}
+
+// IdempotencyStore keeps a record of each request made with an idempotency
+// key, in the tenant of the context, until it expires. Keys need only be
+// unique within a tenant.
+type IdempotencyStore interface {
+	// ClaimIdempotencyKey keeps record, as in progress, unless a record
+	// with its key is kept and has not expired, which it returns instead.
+	// It returns nil once the key is claimed.
+	ClaimIdempotencyKey(ctx context.Context, record IdempotencyRecord) (*IdempotencyRecord, error)
+	// CompleteIdempotencyKey keeps record.Response as the answer to the
+	// request in progress with record.Key and record.Fingerprint, until
+	// record.ExpiresAt. It fails with ErrNotFound if there is none.
+	CompleteIdempotencyKey(ctx context.Context, record IdempotencyRecord) error
+	// ReleaseIdempotencyKey forgets the request in progress with key, as
+	// when it failed, so that the key may be claimed again. A key with no
+	// request in progress is ignored.
+	ReleaseIdempotencyKey(ctx context.Context, key string) error
+}
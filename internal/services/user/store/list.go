@@ -0,0 +1,124 @@
+package store
+
+import (
+	"cmp"
+	"fmt"
+	"strings"
+	"time"
+
+	pb "github.com/andrew-womeldorf/connect-boilerplate/gen/user/v1"
+)
+
+// ListUsersParams controls which page of users a store returns.
+type ListUsersParams struct {
+	PageSize  int32
+	PageToken string
+	Filter    UserFilter
+	OrderBy   UserOrder
+}
+
+// Limit returns the page size clamped to MaxPageSize, falling back to
+// DefaultPageSize when no page size was requested.
+func (p ListUsersParams) Limit() int32 {
+	switch {
+	case p.PageSize <= 0:
+		return DefaultPageSize
+	case p.PageSize > MaxPageSize:
+		return MaxPageSize
+	default:
+		return p.PageSize
+	}
+}
+
+// UserFilter selects the users that match every field that is set. The zero
+// UserFilter matches every user that is not deleted.
+type UserFilter struct {
+	// NamePrefix matches names starting with it, compared case sensitively.
+	NamePrefix string
+	// EmailDomain matches emails at the domain regardless of case.
+	EmailDomain string
+	// CreatedAfter matches users created at or after it, and CreatedBefore
+	// users created before it.
+	CreatedAfter  time.Time
+	CreatedBefore time.Time
+	// ShowDeleted also matches deleted users.
+	ShowDeleted bool
+}
+
+// Matches reports whether user passes the filter.
+func (f UserFilter) Matches(user *pb.User) bool {
+	createdAt := user.GetCreatedAt().AsTime()
+	switch {
+	case user.GetDeletedAt() != nil && !f.ShowDeleted:
+		return false
+	case !strings.HasPrefix(user.GetName(), f.NamePrefix):
+		return false
+	case f.EmailDomain != "" && EmailDomain(user.GetEmail()) != NormalizeEmail(f.EmailDomain):
+		return false
+	case !f.CreatedAfter.IsZero() && createdAt.Before(f.CreatedAfter):
+		return false
+	case !f.CreatedBefore.IsZero() && !createdAt.Before(f.CreatedBefore):
+		return false
+	default:
+		return true
+	}
+}
+
+// EmailDomain returns the normalized domain of email, the part after its
+// last "@".
+func EmailDomain(email string) string {
+	email = NormalizeEmail(email)
+	return email[strings.LastIndex(email, "@")+1:]
+}
+
+// OrderField is the field users are listed by.
+type OrderField int
+
+const (
+	OrderByName OrderField = iota
+	OrderByCreatedAt
+)
+
+func (f OrderField) String() string {
+	switch f {
+	case OrderByName:
+		return "name"
+	case OrderByCreatedAt:
+		return "created_at"
+	default:
+		return fmt.Sprintf("OrderField(%d)", int(f))
+	}
+}
+
+// UserOrder is the order ListUsers returns users in. Users with equal values
+// of Field are ordered by id, in the same direction. The zero UserOrder lists
+// users by name, ascending.
+type UserOrder struct {
+	Field OrderField
+	Desc  bool
+}
+
+// String returns the order as an order_by, such as "created_at desc".
+func (o UserOrder) String() string {
+	if o.Desc {
+		return o.Field.String() + " desc"
+	}
+	return o.Field.String()
+}
+
+// Compare orders a and b as ListUsers lists them.
+func (o UserOrder) Compare(a, b *pb.User) int {
+	var c int
+	switch o.Field {
+	case OrderByCreatedAt:
+		c = a.GetCreatedAt().AsTime().Compare(b.GetCreatedAt().AsTime())
+	default:
+		c = cmp.Compare(a.GetName(), b.GetName())
+	}
+
+	c = cmp.Or(c, cmp.Compare(a.GetId(), b.GetId()))
+	if o.Desc {
+		return -c
+	}
+	return c
+}
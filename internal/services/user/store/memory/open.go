@@ -0,0 +1,27 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+
+	"github.com/andrew-womeldorf/connect-boilerplate/internal/services/user/store"
+)
+
+func init() {
+	store.Register("memory", Open)
+}
+
+// Open is the store.Factory for the URL "memory:". Every call returns a new,
+// empty store.
+func Open(ctx context.Context, u *url.URL) (store.Store, error) {
+	if u.Opaque != "" || u.Host != "" || u.Path != "" {
+		return nil, fmt.Errorf("%w: memory url must not name a database", store.ErrInvalidURL)
+	}
+
+	if _, err := store.URLOptions(u); err != nil {
+		return nil, err
+	}
+
+	return NewStore(), nil
+}
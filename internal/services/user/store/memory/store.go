@@ -0,0 +1,628 @@
+// Package memory is a map backed store.Store. Nothing is persisted, which
+// makes it suited to unit tests and to trying the service out locally.
+package memory
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	pb "github.com/andrew-womeldorf/connect-boilerplate/gen/user/v1"
+	"github.com/andrew-womeldorf/connect-boilerplate/internal/services/user/store"
+)
+
+var (
+	ErrCouldNotGetUser      = errors.New("could not get user")
+	ErrCouldNotCreateUser   = errors.New("could not create user")
+	ErrCouldNotDeleteUser   = errors.New("could not delete user")
+	ErrCouldNotListUsers    = errors.New("could not list users")
+	ErrCouldNotUpdateUser   = errors.New("could not update user")
+	ErrCouldNotUndeleteUser = errors.New("could not undelete user")
+	ErrCouldNotListAudit    = errors.New("could not list audit events")
+	ErrCouldNotListRevision = errors.New("could not list revisions")
+	ErrCouldNotCompleteKey  = errors.New("could not complete idempotency key")
+)
+
+// Store is safe for concurrent use. Users are copied on the way in and out,
+// so callers never share memory with the store, and stored users are
+// replaced rather than modified, so they may be read after unlocking.
+type Store struct {
+	mu sync.RWMutex
+
+	// tenants holds the data of each tenant that has written any.
+	tenants map[string]*tenant
+
+	// outbox holds the events not yet acknowledged, oldest first.
+	outbox []*pb.UserEvent
+}
+
+// tenant is the data of one tenant.
+type tenant struct {
+	// users holds every user, including those that are deleted.
+	users map[string]*pb.User
+
+	// emails maps each normalized email to the id of the user that has it,
+	// which is never a deleted user.
+	emails map[string]string
+
+	// audit holds every audit event, in the order they were recorded.
+	audit []*pb.AuditEvent
+
+	// revisions holds the revisions kept of each user, oldest first.
+	revisions map[string][]*pb.UserRevision
+
+	// idempotency holds the idempotency records by key, including expired
+	// ones until the next claim sweeps them.
+	idempotency map[string]*store.IdempotencyRecord
+}
+
+func NewStore() *Store {
+	return &Store{
+		tenants: make(map[string]*tenant),
+	}
+}
+
+// tenant returns the data of the tenant of ctx, which is empty if it has
+// written none. The caller must hold the lock.
+func (s *Store) tenant(ctx context.Context) *tenant {
+	if t, ok := s.tenants[store.TenantFromContext(ctx)]; ok {
+		return t
+	}
+	return &tenant{}
+}
+
+// writableTenant returns the data of the tenant of ctx, adding it if it has
+// written none. The caller must hold the write lock.
+func (s *Store) writableTenant(ctx context.Context) *tenant {
+	id := store.TenantFromContext(ctx)
+	t, ok := s.tenants[id]
+	if !ok {
+		t = &tenant{
+			users:       make(map[string]*pb.User),
+			emails:      make(map[string]string),
+			revisions:   make(map[string][]*pb.UserRevision),
+			idempotency: make(map[string]*store.IdempotencyRecord),
+		}
+		s.tenants[id] = t
+	}
+	return t
+}
+
+func (s *Store) CreateUser(ctx context.Context, user *pb.User) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.createUser(ctx, user)
+}
+
+// liveUser returns the user with id if it is not deleted. The caller must
+// hold the lock.
+func (t *tenant) liveUser(id string) (*pb.User, bool) {
+	user, ok := t.users[id]
+	if !ok || user.GetDeletedAt() != nil {
+		return nil, false
+	}
+	return user, true
+}
+
+// record adds the audit event for a change made at occurredAt that left the
+// user stored as before as after, and the revision it made, dropping
+// revisions beyond store.MaxRevisions. Purging a user, which leaves no after,
+// drops them all.
+// The caller must hold the write lock.
+func (t *tenant) record(ctx context.Context, before, after *pb.User, occurredAt time.Time) {
+	event := store.NewAuditEvent(ctx, before, after, occurredAt)
+	t.audit = append(t.audit, event)
+
+	if after == nil {
+		delete(t.revisions, before.GetId())
+		return
+	}
+	revisions := append(t.revisions[after.GetId()], store.NewRevision(after, event))
+	t.revisions[after.GetId()] = revisions[max(len(revisions)-store.MaxRevisions, 0):]
+}
+
+// createUser is CreateUser for callers holding the write lock.
+func (s *Store) createUser(ctx context.Context, user *pb.User) error {
+	t := s.writableTenant(ctx)
+	if _, ok := t.users[user.GetId()]; ok {
+		return store.AlreadyExists(ErrCouldNotCreateUser)
+	}
+	email := store.NormalizeEmail(user.GetEmail())
+	if _, ok := t.emails[email]; ok {
+		return store.AlreadyExists(fmt.Errorf("%w: email is taken", ErrCouldNotCreateUser))
+	}
+
+	stored := proto.CloneOf(user)
+	stored.Version = store.FirstVersion
+	t.users[user.GetId()] = stored
+	t.emails[email] = user.GetId()
+	s.outbox = append(s.outbox, store.NewUserCreated(ctx, stored))
+	t.record(ctx, nil, stored, stored.GetCreatedAt().AsTime())
+	return nil
+}
+
+func (s *Store) DeleteUser(ctx context.Context, id string, expectedVersion int64, deletedAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.deleteUser(ctx, id, expectedVersion, deletedAt)
+}
+
+// deleteUser is DeleteUser for callers holding the write lock.
+func (s *Store) deleteUser(ctx context.Context, id string, expectedVersion int64, deletedAt time.Time) error {
+	t := s.writableTenant(ctx)
+	existing, ok := t.liveUser(id)
+	if !ok {
+		return store.NotFound(ErrCouldNotDeleteUser)
+	}
+	if expectedVersion != 0 && existing.GetVersion() != expectedVersion {
+		return store.PreconditionFailed(ErrCouldNotDeleteUser)
+	}
+
+	deleted := proto.CloneOf(existing)
+	deleted.DeletedAt = timestamppb.New(deletedAt)
+	deleted.Version++
+	t.users[id] = deleted
+	delete(t.emails, store.NormalizeEmail(existing.GetEmail()))
+	s.outbox = append(s.outbox, store.NewUserDeleted(ctx, deleted))
+	t.record(ctx, existing, deleted, deletedAt)
+	return nil
+}
+
+func (s *Store) UndeleteUser(ctx context.Context, id string, expectedVersion int64, undeletedAt time.Time) (*pb.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t := s.writableTenant(ctx)
+	existing, ok := t.users[id]
+	if !ok {
+		return nil, store.NotFound(ErrCouldNotUndeleteUser)
+	}
+	if existing.GetDeletedAt() == nil {
+		return nil, store.AlreadyExists(fmt.Errorf("%w: user is not deleted", ErrCouldNotUndeleteUser))
+	}
+	if expectedVersion != 0 && existing.GetVersion() != expectedVersion {
+		return nil, store.PreconditionFailed(ErrCouldNotUndeleteUser)
+	}
+
+	email := store.NormalizeEmail(existing.GetEmail())
+	if _, ok := t.emails[email]; ok {
+		return nil, store.AlreadyExists(fmt.Errorf("%w: email is taken", ErrCouldNotUndeleteUser))
+	}
+
+	restored := proto.CloneOf(existing)
+	restored.DeletedAt = nil
+	restored.Version++
+	t.users[id] = restored
+	t.emails[email] = id
+	s.outbox = append(s.outbox, store.NewUserUndeleted(ctx, restored, undeletedAt))
+	t.record(ctx, existing, restored, undeletedAt)
+
+	return proto.CloneOf(restored), nil
+}
+
+// PurgeUsers looks through the users of every tenant, since deleted users
+// are not kept apart.
+func (s *Store) PurgeUsers(ctx context.Context, deletedBefore time.Time, limit int32, purgedAt time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	type purgeable struct {
+		tenant string
+		user   *pb.User
+	}
+	var matched []purgeable
+	for id, t := range s.tenants {
+		for _, user := range t.users {
+			if user.GetDeletedAt() != nil && user.GetDeletedAt().AsTime().Before(deletedBefore) {
+				matched = append(matched, purgeable{tenant: id, user: user})
+			}
+		}
+	}
+
+	slices.SortFunc(matched, func(a, b purgeable) int {
+		return a.user.GetDeletedAt().AsTime().Compare(b.user.GetDeletedAt().AsTime())
+	})
+	matched = matched[:min(max(int(limit), 0), len(matched))]
+
+	for _, p := range matched {
+		ctx := store.WithTenant(ctx, p.tenant)
+		t := s.tenants[p.tenant]
+		delete(t.users, p.user.GetId())
+		s.outbox = append(s.outbox, store.NewUserPurged(ctx, p.user.GetId(), purgedAt))
+		t.record(ctx, p.user, nil, purgedAt)
+	}
+
+	return len(matched), nil
+}
+
+func (s *Store) GetUser(ctx context.Context, id string) (*pb.User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	user, ok := s.tenant(ctx).liveUser(id)
+	if !ok {
+		return nil, store.NotFound(ErrCouldNotGetUser)
+	}
+
+	return proto.CloneOf(user), nil
+}
+
+func (s *Store) GetUserByEmail(ctx context.Context, email string) (*pb.User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	t := s.tenant(ctx)
+	id, ok := t.emails[store.NormalizeEmail(email)]
+	if !ok {
+		return nil, store.NotFound(ErrCouldNotGetUser)
+	}
+
+	return proto.CloneOf(t.users[id]), nil
+}
+
+// BatchGetUsers reads every user under one lock, so the results are a
+// consistent snapshot.
+func (s *Store) BatchGetUsers(ctx context.Context, ids []string) ([]store.BatchResult, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	t := s.tenant(ctx)
+	results := make([]store.BatchResult, len(ids))
+	for i, id := range ids {
+		user, ok := t.liveUser(id)
+		if !ok {
+			results[i].Err = store.NotFound(ErrCouldNotGetUser)
+			continue
+		}
+		results[i].User = proto.CloneOf(user)
+	}
+
+	return results, nil
+}
+
+func (s *Store) BatchCreateUsers(ctx context.Context, users []*pb.User) ([]store.BatchResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	results := make([]store.BatchResult, len(users))
+	for i, user := range users {
+		results[i].Err = s.createUser(ctx, user)
+	}
+
+	return results, nil
+}
+
+func (s *Store) BatchDeleteUsers(ctx context.Context, deletes []store.BatchDelete, deletedAt time.Time) ([]store.BatchResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	results := make([]store.BatchResult, len(deletes))
+	for i, d := range deletes {
+		results[i].Err = s.deleteUser(ctx, d.ID, d.ExpectedVersion, deletedAt)
+	}
+
+	return results, nil
+}
+
+// pageCursor is the keyset position encoded into page tokens: the order
+// users were listed in and the last user listed.
+type pageCursor struct {
+	Order     string    `json:"o"`
+	Name      string    `json:"n,omitempty"`
+	CreatedAt time.Time `json:"c,omitzero"`
+	ID        string    `json:"i"`
+}
+
+func newPageCursor(order store.UserOrder, user *pb.User) pageCursor {
+	c := pageCursor{Order: order.String(), ID: user.GetId()}
+	switch order.Field {
+	case store.OrderByCreatedAt:
+		c.CreatedAt = user.GetCreatedAt().AsTime()
+	default:
+		c.Name = user.GetName()
+	}
+	return c
+}
+
+func (c pageCursor) user() *pb.User {
+	return &pb.User{Id: c.ID, Name: c.Name, CreatedAt: timestamppb.New(c.CreatedAt)}
+}
+
+func (s *Store) ListUsers(ctx context.Context, params store.ListUsersParams) ([]*pb.User, string, error) {
+	order := params.OrderBy
+
+	var after *pb.User
+	if params.PageToken != "" {
+		var cursor pageCursor
+		if err := store.DecodePageToken(params.PageToken, &cursor); err != nil {
+			return nil, "", err
+		}
+		if err := store.CheckPageOrder(cursor.Order, order); err != nil {
+			return nil, "", err
+		}
+		after = cursor.user()
+	}
+
+	limit := int(params.Limit())
+
+	s.mu.RLock()
+	t := s.tenant(ctx)
+	matched := make([]*pb.User, 0, len(t.users))
+	for _, user := range t.users {
+		if params.Filter.Matches(user) && (after == nil || order.Compare(user, after) > 0) {
+			matched = append(matched, user)
+		}
+	}
+	s.mu.RUnlock()
+
+	slices.SortFunc(matched, order.Compare)
+
+	var nextPageToken string
+	if len(matched) > limit {
+		matched = matched[:limit]
+
+		var err error
+		nextPageToken, err = store.EncodePageToken(newPageCursor(order, matched[len(matched)-1]))
+		if err != nil {
+			return nil, "", store.Internal(fmt.Errorf("%w: %w", ErrCouldNotListUsers, err))
+		}
+	}
+
+	users := make([]*pb.User, 0, len(matched))
+	for _, user := range matched {
+		users = append(users, proto.CloneOf(user))
+	}
+
+	return users, nextPageToken, nil
+}
+
+func (s *Store) CountUsers(ctx context.Context, filter store.UserFilter) (int64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var count int64
+	for _, user := range s.tenant(ctx).users {
+		if filter.Matches(user) {
+			count++
+		}
+	}
+
+	return count, nil
+}
+
+func (s *Store) UpdateUser(ctx context.Context, user *pb.User, mask store.FieldMask, expectedVersion int64) (*pb.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t := s.writableTenant(ctx)
+	existing, ok := t.liveUser(user.GetId())
+	if !ok {
+		return nil, store.NotFound(ErrCouldNotUpdateUser)
+	}
+	if expectedVersion != 0 && existing.GetVersion() != expectedVersion {
+		return nil, store.PreconditionFailed(ErrCouldNotUpdateUser)
+	}
+
+	updated := proto.CloneOf(existing)
+	if mask.Name {
+		updated.Name = user.GetName()
+	}
+	if mask.Email {
+		updated.Email = user.GetEmail()
+	}
+
+	email := store.NormalizeEmail(updated.GetEmail())
+	if owner, ok := t.emails[email]; ok && owner != user.GetId() {
+		return nil, store.AlreadyExists(fmt.Errorf("%w: email is taken", ErrCouldNotUpdateUser))
+	}
+
+	updated.UpdatedAt = proto.CloneOf(user.GetUpdatedAt())
+	updated.Version++
+	t.users[user.GetId()] = updated
+	delete(t.emails, store.NormalizeEmail(existing.GetEmail()))
+	t.emails[email] = user.GetId()
+	s.outbox = append(s.outbox, store.NewUserUpdated(ctx, updated, mask))
+	t.record(ctx, existing, updated, updated.GetUpdatedAt().AsTime())
+
+	return proto.CloneOf(updated), nil
+}
+
+func (s *Store) PendingEvents(ctx context.Context, limit int32) ([]*pb.UserEvent, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	pending := s.outbox[:min(max(int(limit), 0), len(s.outbox))]
+	events := make([]*pb.UserEvent, 0, len(pending))
+	for _, event := range pending {
+		events = append(events, proto.CloneOf(event))
+	}
+
+	return events, nil
+}
+
+func (s *Store) AckEvents(ctx context.Context, events []*pb.UserEvent) error {
+	ids := store.EventIDs(events)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.outbox = slices.DeleteFunc(s.outbox, func(event *pb.UserEvent) bool {
+		return slices.Contains(ids, event.GetId())
+	})
+	return nil
+}
+
+// auditCursor is the position encoded into audit page tokens: the last event
+// listed.
+type auditCursor struct {
+	OccurredAt time.Time `json:"t"`
+	ID         string    `json:"i"`
+}
+
+func (s *Store) ListAuditEvents(ctx context.Context, params store.ListAuditEventsParams) ([]*pb.AuditEvent, string, error) {
+	var after *pb.AuditEvent
+	if params.PageToken != "" {
+		var cursor auditCursor
+		if err := store.DecodePageToken(params.PageToken, &cursor); err != nil {
+			return nil, "", err
+		}
+		after = &pb.AuditEvent{Id: cursor.ID, OccurredAt: timestamppb.New(cursor.OccurredAt)}
+	}
+
+	limit := int(params.Limit())
+
+	s.mu.RLock()
+	t := s.tenant(ctx)
+	matched := make([]*pb.AuditEvent, 0, len(t.audit))
+	for _, event := range t.audit {
+		if params.Filter.Matches(event) && (after == nil || store.CompareAuditEvents(event, after) > 0) {
+			matched = append(matched, event)
+		}
+	}
+	s.mu.RUnlock()
+
+	// Events are recorded in order of their ids, but not necessarily of
+	// when they occurred, which the service's clock decides.
+	slices.SortFunc(matched, store.CompareAuditEvents)
+
+	var nextPageToken string
+	if len(matched) > limit {
+		matched = matched[:limit]
+
+		last := matched[len(matched)-1]
+		var err error
+		nextPageToken, err = store.EncodePageToken(auditCursor{OccurredAt: last.GetOccurredAt().AsTime(), ID: last.GetId()})
+		if err != nil {
+			return nil, "", store.Internal(fmt.Errorf("%w: %w", ErrCouldNotListAudit, err))
+		}
+	}
+
+	events := make([]*pb.AuditEvent, 0, len(matched))
+	for _, event := range matched {
+		events = append(events, proto.CloneOf(event))
+	}
+
+	return events, nextPageToken, nil
+}
+
+// revisionCursor is the position encoded into revision page tokens: the last
+// revision returned.
+type revisionCursor struct {
+	Revision int64 `json:"r"`
+}
+
+func (s *Store) ListUserRevisions(ctx context.Context, params store.ListUserRevisionsParams) ([]*pb.UserRevision, string, error) {
+	var cursor revisionCursor
+	if params.PageToken != "" {
+		if err := store.DecodePageToken(params.PageToken, &cursor); err != nil {
+			return nil, "", err
+		}
+	}
+
+	limit := int(params.Limit())
+
+	s.mu.RLock()
+	kept := s.tenant(ctx).revisions[params.UserID]
+	matched := make([]*pb.UserRevision, 0, min(len(kept), limit+1))
+	for i := len(kept) - 1; i >= 0 && len(matched) <= limit; i-- {
+		if cursor.Revision == 0 || kept[i].GetRevision() < cursor.Revision {
+			matched = append(matched, proto.CloneOf(kept[i]))
+		}
+	}
+	s.mu.RUnlock()
+
+	var nextPageToken string
+	if len(matched) > limit {
+		matched = matched[:limit]
+
+		var err error
+		nextPageToken, err = store.EncodePageToken(revisionCursor{Revision: matched[len(matched)-1].GetRevision()})
+		if err != nil {
+			return nil, "", store.Internal(fmt.Errorf("%w: %w", ErrCouldNotListRevision, err))
+		}
+	}
+
+	return matched, nextPageToken, nil
+}
+
+func (s *Store) GetUserAsOf(ctx context.Context, id string, asOf time.Time) (*pb.User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var found *pb.UserRevision
+	for _, revision := range s.tenant(ctx).revisions[id] {
+		if revision.GetRevisedAt().AsTime().After(asOf) {
+			continue
+		}
+		if found == nil || !revision.GetRevisedAt().AsTime().Before(found.GetRevisedAt().AsTime()) {
+			found = revision
+		}
+	}
+	if found == nil || found.GetUser().GetDeletedAt() != nil {
+		return nil, store.NotFound(ErrCouldNotGetUser)
+	}
+
+	return proto.CloneOf(found.GetUser()), nil
+}
+
+func (s *Store) ClaimIdempotencyKey(ctx context.Context, record store.IdempotencyRecord) (*store.IdempotencyRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t := s.writableTenant(ctx)
+	now := time.Now()
+	for key, kept := range t.idempotency {
+		if !kept.ExpiresAt.After(now) {
+			delete(t.idempotency, key)
+		}
+	}
+	if kept, ok := t.idempotency[record.Key]; ok {
+		return cloneRecord(kept), nil
+	}
+
+	record.Completed = false
+	record.Response = nil
+	t.idempotency[record.Key] = &record
+	return nil, nil
+}
+
+func (s *Store) CompleteIdempotencyKey(ctx context.Context, record store.IdempotencyRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	kept, ok := s.tenant(ctx).idempotency[record.Key]
+	if !ok || kept.Completed || kept.Fingerprint != record.Fingerprint {
+		return store.NotFound(ErrCouldNotCompleteKey)
+	}
+	completed := *kept
+	completed.Completed = true
+	completed.Response = slices.Clone(record.Response)
+	completed.ExpiresAt = record.ExpiresAt
+	s.tenant(ctx).idempotency[record.Key] = &completed
+	return nil
+}
+
+func (s *Store) ReleaseIdempotencyKey(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if kept, ok := s.tenant(ctx).idempotency[key]; ok && !kept.Completed {
+		delete(s.tenant(ctx).idempotency, key)
+	}
+	return nil
+}
+
+// cloneRecord returns a copy of record that shares no memory with it.
+func cloneRecord(record *store.IdempotencyRecord) *store.IdempotencyRecord {
+	clone := *record
+	clone.Response = slices.Clone(record.Response)
+	return &clone
+}
@@ -0,0 +1,175 @@
+// Package migrate holds the backend neutral parts of schema migrations:
+// loading ordered migration files and deciding which of them to apply or
+// revert. Each SQL store applies the plan with its own locking.
+package migrate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+)
+
+var (
+	ErrCouldNotMigrate      = errors.New("could not migrate database")
+	ErrMissingDownMigration = errors.New("migration has no down script")
+	ErrUnknownSchemaVersion = errors.New("database has migrations unknown to this binary")
+)
+
+// fileName matches files such as 0001_create_users.up.sql.
+var fileName = regexp.MustCompile(`^(\d+)_([a-z0-9_]+)\.(up|down)\.sql$`)
+
+// Migration is a single, ordered schema change.
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// Status reports whether a known migration has been applied.
+type Status struct {
+	Version   int
+	Name      string
+	Applied   bool
+	AppliedAt time.Time
+}
+
+// Migrator is implemented by stores whose schema is managed by migrations.
+type Migrator interface {
+	// Status returns every known migration along with whether it is applied.
+	Status(context.Context) ([]Status, error)
+	// Up applies every pending migration in version order.
+	Up(context.Context) error
+	// Down reverts applied migrations, newest first, until only migrations
+	// with a version at or below to remain. Down(ctx, 0) reverts everything.
+	Down(ctx context.Context, to int) error
+}
+
+// Load reads the migrations in dir of fsys, sorted by version.
+func Load(fsys fs.FS, dir string) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int]*Migration)
+	for _, entry := range entries {
+		match := fileName.FindStringSubmatch(entry.Name())
+		if match == nil {
+			return nil, fmt.Errorf("unexpected migration file name %q", entry.Name())
+		}
+
+		version, err := strconv.Atoi(match[1])
+		if err != nil {
+			return nil, err
+		}
+
+		body, err := fs.ReadFile(fsys, path.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		mig, ok := byVersion[version]
+		if !ok {
+			mig = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = mig
+		} else if mig.Name != match[2] {
+			return nil, fmt.Errorf("migration %d has conflicting names %q and %q", version, mig.Name, match[2])
+		}
+
+		if match[3] == "up" {
+			mig.Up = string(body)
+		} else {
+			mig.Down = string(body)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, mig := range byVersion {
+		if mig.Up == "" {
+			return nil, fmt.Errorf("migration %d_%s has no up script", mig.Version, mig.Name)
+		}
+		migrations = append(migrations, *mig)
+	}
+
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+
+	return migrations, nil
+}
+
+// Statuses pairs every known migration with when it was applied, if at all.
+func Statuses(migrations []Migration, applied map[int]time.Time) []Status {
+	statuses := make([]Status, 0, len(migrations))
+	for _, mig := range migrations {
+		status := Status{Version: mig.Version, Name: mig.Name}
+		if at, ok := applied[mig.Version]; ok {
+			status.Applied = true
+			status.AppliedAt = at
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses
+}
+
+// Pending returns the migrations to apply, in order.
+func Pending(migrations []Migration, applied map[int]time.Time) ([]Migration, error) {
+	if err := checkKnown(migrations, applied); err != nil {
+		return nil, err
+	}
+
+	var pending []Migration
+	for _, mig := range migrations {
+		if _, ok := applied[mig.Version]; !ok {
+			pending = append(pending, mig)
+		}
+	}
+	return pending, nil
+}
+
+// Reverts returns the migrations to revert to reach version to, newest first.
+func Reverts(migrations []Migration, applied map[int]time.Time, to int) ([]Migration, error) {
+	if err := checkKnown(migrations, applied); err != nil {
+		return nil, err
+	}
+
+	var reverts []Migration
+	for i := len(migrations) - 1; i >= 0; i-- {
+		mig := migrations[i]
+		if mig.Version <= to {
+			break
+		}
+		if _, ok := applied[mig.Version]; !ok {
+			continue
+		}
+		if mig.Down == "" {
+			return nil, fmt.Errorf("%w: %d_%s", ErrMissingDownMigration, mig.Version, mig.Name)
+		}
+		reverts = append(reverts, mig)
+	}
+	return reverts, nil
+}
+
+// checkKnown refuses to touch a database that has been migrated by a newer
+// binary, since this binary cannot know how to revert those changes.
+func checkKnown(migrations []Migration, applied map[int]time.Time) error {
+	known := make(map[int]bool, len(migrations))
+	for _, mig := range migrations {
+		known[mig.Version] = true
+	}
+
+	for version := range applied {
+		if !known[version] {
+			return fmt.Errorf("%w: version %d", ErrUnknownSchemaVersion, version)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,46 @@
+package store
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+var ErrInvalidPageToken = errors.New("invalid page token")
+
+// EncodePageToken serializes a store specific cursor into an opaque token that
+// can be handed back to clients as a next_page_token.
+func EncodePageToken(cursor any) (string, error) {
+	b, err := json.Marshal(cursor)
+	if err != nil {
+		return "", fmt.Errorf("could not encode page token: %w", err)
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// DecodePageToken is the inverse of EncodePageToken. Any token that cannot be
+// decoded into cursor is reported as ErrInvalidPageToken.
+func DecodePageToken(token string, cursor any) error {
+	b, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidPageToken, err)
+	}
+
+	if err := json.Unmarshal(b, cursor); err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidPageToken, err)
+	}
+
+	return nil
+}
+
+// CheckPageOrder reports ErrInvalidPageToken when a page token encoded while
+// listing users in tokenOrder is used to list them in order, since the
+// position it holds is meaningless in any other order.
+func CheckPageOrder(tokenOrder string, order UserOrder) error {
+	if tokenOrder != order.String() {
+		return fmt.Errorf("%w: token is for order %q, not %q", ErrInvalidPageToken, tokenOrder, order)
+	}
+	return nil
+}
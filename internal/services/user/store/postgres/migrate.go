@@ -0,0 +1,178 @@
+package postgres
+
+import (
+	"context"
+	"embed"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/andrew-womeldorf/connect-boilerplate/internal/services/user/store/migrate"
+)
+
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// migrationLockKey identifies the advisory lock held while migrating. It is
+// arbitrary but must be the same for every binary sharing a database.
+const migrationLockKey int64 = 0x75736572 // "user"
+
+const createMigrationsTable = `CREATE TABLE IF NOT EXISTS schema_migrations (
+    version integer PRIMARY KEY,
+    name text NOT NULL,
+    applied_at timestamptz NOT NULL
+)`
+
+// Migrator applies the embedded migrations to a postgres database. Every
+// operation runs in a single transaction holding a transaction scoped
+// advisory lock, which serializes migrators across processes and rolls back
+// a partially applied migration on failure.
+type Migrator struct {
+	pool       *pgxpool.Pool
+	migrations []migrate.Migration
+}
+
+var _ migrate.Migrator = (*Migrator)(nil)
+
+func NewMigrator(pool *pgxpool.Pool) (*Migrator, error) {
+	migrations, err := migrate.Load(migrationFiles, "migrations")
+	if err != nil {
+		return nil, err
+	}
+
+	return &Migrator{pool: pool, migrations: migrations}, nil
+}
+
+// Migrations returns every known migration in version order.
+func (m *Migrator) Migrations() []migrate.Migration {
+	return m.migrations
+}
+
+func (m *Migrator) Status(ctx context.Context) ([]migrate.Status, error) {
+	var statuses []migrate.Status
+	err := m.withLock(ctx, func(tx pgx.Tx) error {
+		applied, err := appliedMigrations(ctx, tx)
+		if err != nil {
+			return err
+		}
+
+		statuses = migrate.Statuses(m.migrations, applied)
+		return nil
+	})
+
+	return statuses, err
+}
+
+func (m *Migrator) Up(ctx context.Context) error {
+	return m.withLock(ctx, func(tx pgx.Tx) error {
+		applied, err := appliedMigrations(ctx, tx)
+		if err != nil {
+			return err
+		}
+
+		pending, err := migrate.Pending(m.migrations, applied)
+		if err != nil {
+			return err
+		}
+
+		for _, mig := range pending {
+			slog.InfoContext(ctx, "applying migration",
+				slog.Int("version", mig.Version),
+				slog.String("name", mig.Name),
+			)
+
+			if _, err := tx.Exec(ctx, mig.Up); err != nil {
+				return fmt.Errorf("migration %d_%s: %w", mig.Version, mig.Name, err)
+			}
+
+			if _, err := tx.Exec(ctx,
+				"INSERT INTO schema_migrations (version, name, applied_at) VALUES ($1, $2, $3)",
+				mig.Version, mig.Name, time.Now().UTC(),
+			); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+func (m *Migrator) Down(ctx context.Context, to int) error {
+	return m.withLock(ctx, func(tx pgx.Tx) error {
+		applied, err := appliedMigrations(ctx, tx)
+		if err != nil {
+			return err
+		}
+
+		reverts, err := migrate.Reverts(m.migrations, applied, to)
+		if err != nil {
+			return err
+		}
+
+		for _, mig := range reverts {
+			slog.InfoContext(ctx, "reverting migration",
+				slog.Int("version", mig.Version),
+				slog.String("name", mig.Name),
+			)
+
+			if _, err := tx.Exec(ctx, mig.Down); err != nil {
+				return fmt.Errorf("migration %d_%s: %w", mig.Version, mig.Name, err)
+			}
+
+			if _, err := tx.Exec(ctx,
+				"DELETE FROM schema_migrations WHERE version = $1", mig.Version,
+			); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// withLock runs fn inside a transaction holding the migration lock,
+// committing if fn succeeds and rolling back otherwise.
+func (m *Migrator) withLock(ctx context.Context, fn func(pgx.Tx) error) (err error) {
+	defer func() {
+		if err != nil {
+			err = fmt.Errorf("%w: %w", migrate.ErrCouldNotMigrate, err)
+		}
+	}()
+
+	return pgx.BeginFunc(ctx, m.pool, func(tx pgx.Tx) error {
+		if _, err := tx.Exec(ctx, "SELECT pg_advisory_xact_lock($1)", migrationLockKey); err != nil {
+			return err
+		}
+
+		// Created under the lock, since concurrent CREATE TABLE IF NOT
+		// EXISTS statements can still collide.
+		if _, err := tx.Exec(ctx, createMigrationsTable); err != nil {
+			return err
+		}
+
+		return fn(tx)
+	})
+}
+
+func appliedMigrations(ctx context.Context, tx pgx.Tx) (map[int]time.Time, error) {
+	rows, err := tx.Query(ctx, "SELECT version, applied_at FROM schema_migrations")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := make(map[int]time.Time)
+	for rows.Next() {
+		var version int
+		var appliedAt time.Time
+		if err := rows.Scan(&version, &appliedAt); err != nil {
+			return nil, err
+		}
+		applied[version] = appliedAt
+	}
+
+	return applied, rows.Err()
+}
@@ -0,0 +1,2 @@
+-- Existing users start at the first version.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS version bigint NOT NULL DEFAULT 1;
@@ -0,0 +1,3 @@
+-- Emails are unique regardless of case. This fails if existing users already
+-- share an email; resolve those before migrating.
+CREATE UNIQUE INDEX IF NOT EXISTS users_email_idx ON users (lower(email));
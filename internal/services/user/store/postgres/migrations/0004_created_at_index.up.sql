@@ -0,0 +1,2 @@
+-- ListUsers pages through users in keyset order by created_at as well as name.
+CREATE INDEX IF NOT EXISTS users_created_at_id_idx ON users (created_at, id);
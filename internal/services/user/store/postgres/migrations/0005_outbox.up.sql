@@ -0,0 +1,7 @@
+-- outbox holds the events recorded with each change to users, in the order
+-- they were recorded, until a relay has delivered them.
+CREATE TABLE IF NOT EXISTS outbox (
+    seq bigint GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+    id text NOT NULL UNIQUE,
+    event bytea NOT NULL
+);
@@ -0,0 +1,10 @@
+-- audit_events keeps the audit event recorded with each change to users.
+CREATE TABLE IF NOT EXISTS audit_events (
+    id text PRIMARY KEY,
+    user_id text NOT NULL,
+    occurred_at timestamptz NOT NULL,
+    event bytea NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS audit_events_occurred_at ON audit_events (occurred_at, id);
+CREATE INDEX IF NOT EXISTS audit_events_user_id ON audit_events (user_id, occurred_at, id);
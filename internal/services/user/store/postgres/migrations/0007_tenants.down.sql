@@ -0,0 +1,16 @@
+-- Merging the tenants fails if two of them have a user with the same id or
+-- email; resolve those before migrating.
+DROP INDEX IF EXISTS audit_events_user_id;
+DROP INDEX IF EXISTS audit_events_occurred_at;
+ALTER TABLE audit_events DROP COLUMN IF EXISTS tenant_id;
+CREATE INDEX IF NOT EXISTS audit_events_occurred_at ON audit_events (occurred_at, id);
+CREATE INDEX IF NOT EXISTS audit_events_user_id ON audit_events (user_id, occurred_at, id);
+
+DROP INDEX IF EXISTS users_created_at_id_idx;
+DROP INDEX IF EXISTS users_name_id_idx;
+DROP INDEX IF EXISTS users_email_idx;
+ALTER TABLE users DROP CONSTRAINT users_pkey, ADD PRIMARY KEY (id);
+ALTER TABLE users DROP COLUMN IF EXISTS tenant_id;
+CREATE UNIQUE INDEX IF NOT EXISTS users_email_idx ON users (lower(email));
+CREATE INDEX IF NOT EXISTS users_name_id_idx ON users (name, id);
+CREATE INDEX IF NOT EXISTS users_created_at_id_idx ON users (created_at, id);
@@ -0,0 +1,21 @@
+-- Users are partitioned by tenant, and existing users and audit events
+-- belong to the default tenant. Ids and emails are only unique within a
+-- tenant, so both are keyed by it.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS tenant_id text NOT NULL DEFAULT 'default';
+ALTER TABLE users ALTER COLUMN tenant_id DROP DEFAULT;
+ALTER TABLE users DROP CONSTRAINT users_pkey, ADD PRIMARY KEY (tenant_id, id);
+
+DROP INDEX IF EXISTS users_email_idx;
+DROP INDEX IF EXISTS users_name_id_idx;
+DROP INDEX IF EXISTS users_created_at_id_idx;
+CREATE UNIQUE INDEX IF NOT EXISTS users_email_idx ON users (tenant_id, lower(email));
+CREATE INDEX IF NOT EXISTS users_name_id_idx ON users (tenant_id, name, id);
+CREATE INDEX IF NOT EXISTS users_created_at_id_idx ON users (tenant_id, created_at, id);
+
+ALTER TABLE audit_events ADD COLUMN IF NOT EXISTS tenant_id text NOT NULL DEFAULT 'default';
+ALTER TABLE audit_events ALTER COLUMN tenant_id DROP DEFAULT;
+
+DROP INDEX IF EXISTS audit_events_occurred_at;
+DROP INDEX IF EXISTS audit_events_user_id;
+CREATE INDEX IF NOT EXISTS audit_events_occurred_at ON audit_events (tenant_id, occurred_at, id);
+CREATE INDEX IF NOT EXISTS audit_events_user_id ON audit_events (tenant_id, user_id, occurred_at, id);
@@ -0,0 +1,9 @@
+-- Users that are deleted but not yet purged are removed for good, since
+-- without deleted_at they would be restored.
+DELETE FROM users WHERE deleted_at IS NOT NULL;
+
+DROP INDEX IF EXISTS users_deleted_at_idx;
+DROP INDEX IF EXISTS users_email_idx;
+CREATE UNIQUE INDEX IF NOT EXISTS users_email_idx ON users (tenant_id, lower(email));
+
+ALTER TABLE users DROP COLUMN IF EXISTS deleted_at;
@@ -0,0 +1,12 @@
+-- user_revisions keeps the most recent revisions of each user, numbered by
+-- the version each left the user at.
+CREATE TABLE IF NOT EXISTS user_revisions (
+    tenant_id text NOT NULL,
+    user_id text NOT NULL,
+    version bigint NOT NULL,
+    revised_at timestamptz NOT NULL,
+    revision bytea NOT NULL,
+    PRIMARY KEY (tenant_id, user_id, version)
+);
+
+CREATE INDEX IF NOT EXISTS user_revisions_revised_at ON user_revisions (tenant_id, user_id, revised_at, version);
@@ -0,0 +1,14 @@
+-- idempotency_keys keeps a record of each request made with an idempotency
+-- key until it expires: a fingerprint of the request, and once it has been
+-- answered, its response.
+CREATE TABLE IF NOT EXISTS idempotency_keys (
+    tenant_id text NOT NULL,
+    idempotency_key text NOT NULL,
+    fingerprint text NOT NULL,
+    completed boolean NOT NULL DEFAULT false,
+    response bytea,
+    expires_at timestamptz NOT NULL,
+    PRIMARY KEY (tenant_id, idempotency_key)
+);
+
+CREATE INDEX IF NOT EXISTS idempotency_keys_expires_at ON idempotency_keys (expires_at);
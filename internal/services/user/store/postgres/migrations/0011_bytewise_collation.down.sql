@@ -0,0 +1,3 @@
+ALTER TABLE users
+    ALTER COLUMN id TYPE text COLLATE "default",
+    ALTER COLUMN name TYPE text COLLATE "default";
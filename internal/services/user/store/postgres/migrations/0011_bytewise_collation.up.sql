@@ -0,0 +1,6 @@
+-- Names and ids compare bytewise, as they do in the other stores, rather than
+-- by the database's default collation, which may ignore case and punctuation
+-- and differs between servers. Every store then lists users in one order.
+ALTER TABLE users
+    ALTER COLUMN id TYPE text COLLATE "C",
+    ALTER COLUMN name TYPE text COLLATE "C";
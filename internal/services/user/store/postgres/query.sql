@@ -0,0 +1,209 @@
+-- Users whose deleted_at is set are deleted, and only read when asked for.
+
+-- name: GetUser :one
+SELECT * FROM users WHERE tenant_id = $1 AND id = $2 AND deleted_at IS NULL LIMIT 1;
+
+-- GetUserForUpdate and GetDeletedUserForUpdate lock the user until the end of
+-- the transaction.
+
+-- name: GetUserForUpdate :one
+SELECT * FROM users WHERE tenant_id = $1 AND id = $2 AND deleted_at IS NULL FOR UPDATE;
+
+-- name: GetDeletedUserForUpdate :one
+SELECT * FROM users WHERE tenant_id = $1 AND id = $2 AND deleted_at IS NOT NULL FOR UPDATE;
+
+-- name: GetUserByEmail :one
+SELECT * FROM users WHERE tenant_id = @tenant_id AND lower(email) = lower(@email) AND deleted_at IS NULL LIMIT 1;
+
+-- name: BatchGetUsers :many
+SELECT * FROM users WHERE tenant_id = @tenant_id AND id = ANY(@ids::text[]) AND deleted_at IS NULL;
+
+-- ListUsers* list the users of a tenant matching a filter in one order each,
+-- starting after a keyset cursor, which is empty for the first page. An empty
+-- or null filter field matches every user, and deleted users only match when
+-- show_deleted is set.
+
+-- name: ListUsersByName :many
+SELECT * FROM users
+WHERE tenant_id = @tenant_id
+    AND (name, id) > (@after_name::text, @after_id::text)
+    AND starts_with(name, @name_prefix)
+    AND (@email_domain::text = '' OR right(lower(email), length(@email_domain) + 1) = '@' || @email_domain)
+    AND (sqlc.narg(created_after)::timestamptz IS NULL OR created_at >= sqlc.narg(created_after))
+    AND (sqlc.narg(created_before)::timestamptz IS NULL OR created_at < sqlc.narg(created_before))
+    AND (@show_deleted::boolean OR deleted_at IS NULL)
+ORDER BY name, id
+LIMIT @page_limit;
+
+-- name: ListUsersByNameDesc :many
+SELECT * FROM users
+WHERE tenant_id = @tenant_id
+    AND (@after_id::text = '' OR (name, id) < (@after_name::text, @after_id::text))
+    AND starts_with(name, @name_prefix)
+    AND (@email_domain::text = '' OR right(lower(email), length(@email_domain) + 1) = '@' || @email_domain)
+    AND (sqlc.narg(created_after)::timestamptz IS NULL OR created_at >= sqlc.narg(created_after))
+    AND (sqlc.narg(created_before)::timestamptz IS NULL OR created_at < sqlc.narg(created_before))
+    AND (@show_deleted::boolean OR deleted_at IS NULL)
+ORDER BY name DESC, id DESC
+LIMIT @page_limit;
+
+-- name: ListUsersByCreatedAt :many
+SELECT * FROM users
+WHERE tenant_id = @tenant_id
+    AND (created_at, id) > (@after_created_at::timestamptz, @after_id::text)
+    AND starts_with(name, @name_prefix)
+    AND (@email_domain::text = '' OR right(lower(email), length(@email_domain) + 1) = '@' || @email_domain)
+    AND (sqlc.narg(created_after)::timestamptz IS NULL OR created_at >= sqlc.narg(created_after))
+    AND (sqlc.narg(created_before)::timestamptz IS NULL OR created_at < sqlc.narg(created_before))
+    AND (@show_deleted::boolean OR deleted_at IS NULL)
+ORDER BY created_at, id
+LIMIT @page_limit;
+
+-- name: ListUsersByCreatedAtDesc :many
+SELECT * FROM users
+WHERE tenant_id = @tenant_id
+    AND (@after_id::text = '' OR (created_at, id) < (@after_created_at::timestamptz, @after_id::text))
+    AND starts_with(name, @name_prefix)
+    AND (@email_domain::text = '' OR right(lower(email), length(@email_domain) + 1) = '@' || @email_domain)
+    AND (sqlc.narg(created_after)::timestamptz IS NULL OR created_at >= sqlc.narg(created_after))
+    AND (sqlc.narg(created_before)::timestamptz IS NULL OR created_at < sqlc.narg(created_before))
+    AND (@show_deleted::boolean OR deleted_at IS NULL)
+ORDER BY created_at DESC, id DESC
+LIMIT @page_limit;
+
+-- name: CountUsers :one
+SELECT count(*) FROM users
+WHERE tenant_id = @tenant_id
+    AND starts_with(name, @name_prefix)
+    AND (@email_domain::text = '' OR right(lower(email), length(@email_domain) + 1) = '@' || @email_domain)
+    AND (sqlc.narg(created_after)::timestamptz IS NULL OR created_at >= sqlc.narg(created_after))
+    AND (sqlc.narg(created_before)::timestamptz IS NULL OR created_at < sqlc.narg(created_before))
+    AND (@show_deleted::boolean OR deleted_at IS NULL);
+
+-- name: CreateUser :one
+INSERT INTO users (
+    tenant_id, id, name, email, created_at, updated_at, version
+) VALUES (
+    $1, $2, $3, $4, $5, $6, 1
+) RETURNING *;
+
+-- name: UpdateUser :one
+UPDATE users SET
+    name = coalesce(sqlc.narg(name), name),
+    email = coalesce(sqlc.narg(email), email),
+    updated_at = @updated_at,
+    version = version + 1
+WHERE tenant_id = @tenant_id AND id = @id AND deleted_at IS NULL
+    AND (version = @expected_version OR @expected_version::bigint = 0)
+RETURNING *;
+
+-- name: DeleteUser :one
+UPDATE users SET
+    deleted_at = @deleted_at::timestamptz,
+    version = version + 1
+WHERE tenant_id = @tenant_id AND id = @id AND deleted_at IS NULL
+    AND (version = @expected_version OR @expected_version::bigint = 0)
+RETURNING *;
+
+-- name: UndeleteUser :one
+UPDATE users SET
+    deleted_at = NULL,
+    version = version + 1
+WHERE tenant_id = @tenant_id AND id = @id AND deleted_at IS NOT NULL
+    AND (version = @expected_version OR @expected_version::bigint = 0)
+RETURNING *;
+
+-- ListPurgeableUsers locks the users of every tenant deleted before a time,
+-- deleted longest ago first. Users another transaction has locked are
+-- skipped, so concurrent purges remove different users.
+
+-- name: ListPurgeableUsers :many
+SELECT * FROM users
+WHERE deleted_at < @deleted_before::timestamptz
+ORDER BY deleted_at
+LIMIT @page_limit
+FOR UPDATE SKIP LOCKED;
+
+-- name: PurgeUser :exec
+DELETE FROM users WHERE tenant_id = @tenant_id AND id = @id AND deleted_at IS NOT NULL;
+
+-- name: InsertEvent :exec
+INSERT INTO outbox (id, event) VALUES ($1, $2);
+
+-- name: ListEvents :many
+SELECT * FROM outbox ORDER BY seq LIMIT $1;
+
+-- name: DeleteEvents :exec
+DELETE FROM outbox WHERE id = ANY(@ids::text[]);
+
+-- name: InsertAuditEvent :exec
+INSERT INTO audit_events (id, tenant_id, user_id, occurred_at, event) VALUES ($1, $2, $3, $4, $5);
+
+-- ListAuditEvents lists the audit events of a tenant matching a filter,
+-- starting after a keyset cursor, which is empty for the first page. An empty
+-- or null filter field matches every event.
+
+-- name: ListAuditEvents :many
+SELECT * FROM audit_events
+WHERE tenant_id = @tenant_id
+    AND (occurred_at, id) > (@after_occurred_at::timestamptz, @after_id::text)
+    AND (@user_id::text = '' OR user_id = @user_id)
+    AND (sqlc.narg(start_time)::timestamptz IS NULL OR occurred_at >= sqlc.narg(start_time))
+    AND (sqlc.narg(end_time)::timestamptz IS NULL OR occurred_at < sqlc.narg(end_time))
+ORDER BY occurred_at, id
+LIMIT @page_limit;
+
+-- name: InsertUserRevision :exec
+INSERT INTO user_revisions (tenant_id, user_id, version, revised_at, revision) VALUES ($1, $2, $3, $4, $5);
+
+-- TrimUserRevisions drops the revisions of a user older than the oldest kept.
+
+-- name: TrimUserRevisions :exec
+DELETE FROM user_revisions
+WHERE tenant_id = @tenant_id AND user_id = @user_id AND version < @oldest_version;
+
+-- name: DeleteUserRevisions :exec
+DELETE FROM user_revisions WHERE tenant_id = @tenant_id AND user_id = @user_id;
+
+-- ListUserRevisions lists the revisions of a user newest first, starting
+-- below a version, which is 0 for the first page.
+
+-- name: ListUserRevisions :many
+SELECT * FROM user_revisions
+WHERE tenant_id = @tenant_id AND user_id = @user_id
+    AND (@after_version::bigint = 0 OR version < @after_version)
+ORDER BY version DESC
+LIMIT @page_limit;
+
+-- GetUserRevisionAsOf gets the most recent revision of a user made at or
+-- before a time.
+
+-- name: GetUserRevisionAsOf :one
+SELECT * FROM user_revisions
+WHERE tenant_id = @tenant_id AND user_id = @user_id AND revised_at <= @as_of
+ORDER BY revised_at DESC, version DESC
+LIMIT 1;
+
+-- DeleteExpiredIdempotencyKeys drops the idempotency records of every tenant
+-- that expired at or before a time.
+
+-- name: DeleteExpiredIdempotencyKeys :exec
+DELETE FROM idempotency_keys WHERE expires_at <= $1;
+
+-- InsertIdempotencyKey claims a key for a request in progress, unless a
+-- record with the key is kept.
+
+-- name: InsertIdempotencyKey :execrows
+INSERT INTO idempotency_keys (tenant_id, idempotency_key, fingerprint, expires_at) VALUES ($1, $2, $3, $4)
+ON CONFLICT DO NOTHING;
+
+-- name: GetIdempotencyKey :one
+SELECT * FROM idempotency_keys WHERE tenant_id = $1 AND idempotency_key = $2;
+
+-- name: CompleteIdempotencyKey :execrows
+UPDATE idempotency_keys SET completed = true, response = @response, expires_at = @expires_at
+WHERE tenant_id = @tenant_id AND idempotency_key = @idempotency_key AND fingerprint = @fingerprint
+  AND NOT completed;
+
+-- name: ReleaseIdempotencyKey :exec
+DELETE FROM idempotency_keys WHERE tenant_id = $1 AND idempotency_key = $2 AND NOT completed;
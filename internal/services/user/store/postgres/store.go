@@ -0,0 +1,1027 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	pb "github.com/andrew-womeldorf/connect-boilerplate/gen/user/v1"
+	"github.com/andrew-womeldorf/connect-boilerplate/internal/services/user/store"
+	"github.com/andrew-womeldorf/connect-boilerplate/internal/services/user/store/migrate"
+	"github.com/andrew-womeldorf/connect-boilerplate/internal/services/user/store/postgres/gen"
+)
+
+var (
+	ErrCouldNotGetUser        = errors.New("could not get user")
+	ErrCouldNotCreateUser     = errors.New("could not create user")
+	ErrCouldNotDeleteUser     = errors.New("could not delete user")
+	ErrCouldNotListUsers      = errors.New("could not list users")
+	ErrCouldNotUpdateUser     = errors.New("could not update user")
+	ErrCouldNotUndeleteUser   = errors.New("could not undelete user")
+	ErrCouldNotPurgeUsers     = errors.New("could not purge users")
+	ErrCouldNotRecordEvent    = errors.New("could not record event")
+	ErrCouldNotReadEvents     = errors.New("could not read events")
+	ErrCouldNotAckEvents      = errors.New("could not acknowledge events")
+	ErrCouldNotRecordAudit    = errors.New("could not record audit event")
+	ErrCouldNotListAudit      = errors.New("could not list audit events")
+	ErrCouldNotRecordRevision = errors.New("could not record revision")
+	ErrCouldNotListRevisions  = errors.New("could not list revisions")
+	ErrCouldNotClaimKey       = errors.New("could not claim idempotency key")
+	ErrCouldNotCompleteKey    = errors.New("could not complete idempotency key")
+	ErrCouldNotReleaseKey     = errors.New("could not release idempotency key")
+)
+
+type Store struct {
+	pool *pgxpool.Pool
+	q    *gen.Queries
+
+	autoMigrate bool
+}
+
+type Option func(*Store)
+
+// WithoutMigrations skips applying pending migrations when the store is
+// opened. Use it when migrations are managed separately, such as by the
+// migrate command.
+func WithoutMigrations() Option {
+	return func(s *Store) {
+		s.autoMigrate = false
+	}
+}
+
+// NewStore connects a pool to the database described by connString, a
+// postgres:// URL or key=value DSN as understood by pgx. Pool settings such
+// as pool_max_conns may be given in connString.
+func NewStore(ctx context.Context, connString string, opts ...Option) (*Store, error) {
+	s := &Store{
+		autoMigrate: true,
+	}
+
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	pool, err := pgxpool.New(ctx, connString)
+	if err != nil {
+		return nil, err
+	}
+
+	s.pool = pool
+	s.q = gen.New(pool)
+
+	if s.autoMigrate {
+		m, err := s.Migrator()
+		if err != nil {
+			pool.Close()
+			return nil, err
+		}
+		if err := m.Up(ctx); err != nil {
+			slog.ErrorContext(ctx, migrate.ErrCouldNotMigrate.Error(), slog.Any("error", err))
+			pool.Close()
+			return nil, err
+		}
+	}
+
+	return s, nil
+}
+
+// Migrator returns a Migrator for the store's database.
+func (s *Store) Migrator() (migrate.Migrator, error) {
+	m, err := NewMigrator(s.pool)
+	if err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Close closes every connection in the pool.
+func (s *Store) Close() error {
+	s.pool.Close()
+	return nil
+}
+
+func (s *Store) CreateUser(ctx context.Context, user *pb.User) error {
+	return s.writeTx(ctx, ErrCouldNotCreateUser, func(q *gen.Queries) error {
+		return createUser(ctx, q, user)
+	})
+}
+
+// createUser creates user and records the events for it. It must be called
+// in a transaction so that they are committed together.
+func createUser(ctx context.Context, q *gen.Queries, user *pb.User) error {
+	db, err := q.CreateUser(ctx, gen.CreateUserParams{
+		TenantID:  store.TenantFromContext(ctx),
+		ID:        user.GetId(),
+		Name:      user.GetName(),
+		Email:     user.GetEmail(),
+		CreatedAt: user.GetCreatedAt().AsTime(),
+		UpdatedAt: user.GetUpdatedAt().AsTime(),
+	})
+	if err != nil {
+		slog.ErrorContext(ctx, ErrCouldNotCreateUser.Error(),
+			slog.Any("error", err),
+			slog.String("user id", user.GetId()),
+		)
+		return classify(ErrCouldNotCreateUser, err)
+	}
+
+	created := convertUser(db)
+	if err := recordEvent(ctx, q, store.NewUserCreated(ctx, created)); err != nil {
+		return err
+	}
+	return recordChange(ctx, q, nil, created, created.GetCreatedAt().AsTime())
+}
+
+func (s *Store) DeleteUser(ctx context.Context, id string, expectedVersion int64, deletedAt time.Time) error {
+	return s.writeTx(ctx, ErrCouldNotDeleteUser, func(q *gen.Queries) error {
+		return deleteUser(ctx, q, id, expectedVersion, deletedAt)
+	})
+}
+
+// deleteUser marks the user with id deleted and records the events for it,
+// in the caller's transaction as createUser does.
+func deleteUser(ctx context.Context, q *gen.Queries, id string, expectedVersion int64, deletedAt time.Time) error {
+	db, err := q.DeleteUser(ctx, gen.DeleteUserParams{
+		DeletedAt:       deletedAt,
+		TenantID:        store.TenantFromContext(ctx),
+		ID:              id,
+		ExpectedVersion: expectedVersion,
+	})
+	if err != nil {
+		slog.ErrorContext(ctx, ErrCouldNotDeleteUser.Error(),
+			slog.Any("error", err),
+			slog.String("user id", id),
+		)
+		return classifyGuarded(ctx, q, ErrCouldNotDeleteUser, err, id, expectedVersion)
+	}
+
+	// Deleting only set deleted_at, so the user before is the one returned
+	// without it.
+	deleted := convertUser(db)
+	before := proto.CloneOf(deleted)
+	before.DeletedAt = nil
+
+	if err := recordEvent(ctx, q, store.NewUserDeleted(ctx, deleted)); err != nil {
+		return err
+	}
+	return recordChange(ctx, q, before, deleted, deleted.GetDeletedAt().AsTime())
+}
+
+func (s *Store) UndeleteUser(ctx context.Context, id string, expectedVersion int64, undeletedAt time.Time) (*pb.User, error) {
+	var restored *pb.User
+	err := s.writeTx(ctx, ErrCouldNotUndeleteUser, func(q *gen.Queries) error {
+		// The user is read for its audit event before it changes, and
+		// locked, as in UpdateUser, so the undelete can only miss it on its
+		// version.
+		current, err := q.GetDeletedUserForUpdate(ctx, gen.GetDeletedUserForUpdateParams{TenantID: store.TenantFromContext(ctx), ID: id})
+		if err != nil {
+			slog.ErrorContext(ctx, ErrCouldNotUndeleteUser.Error(),
+				slog.Any("error", err),
+				slog.String("user id", id),
+			)
+			return classifyNotDeleted(ctx, q, ErrCouldNotUndeleteUser, err, id)
+		}
+
+		db, err := q.UndeleteUser(ctx, gen.UndeleteUserParams{
+			TenantID:        store.TenantFromContext(ctx),
+			ID:              id,
+			ExpectedVersion: expectedVersion,
+		})
+		if err != nil {
+			slog.ErrorContext(ctx, ErrCouldNotUndeleteUser.Error(),
+				slog.Any("error", err),
+				slog.String("user id", id),
+			)
+			if errors.Is(err, pgx.ErrNoRows) {
+				return store.PreconditionFailed(fmt.Errorf("%w: expected version %d", ErrCouldNotUndeleteUser, expectedVersion))
+			}
+			return classify(ErrCouldNotUndeleteUser, err)
+		}
+
+		restored = convertUser(db)
+		if err := recordEvent(ctx, q, store.NewUserUndeleted(ctx, restored, undeletedAt)); err != nil {
+			return err
+		}
+		return recordChange(ctx, q, convertUser(current), restored, undeletedAt)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return restored, nil
+}
+
+// PurgeUsers removes the users in one transaction, recording the events of
+// each under its own tenant.
+func (s *Store) PurgeUsers(ctx context.Context, deletedBefore time.Time, limit int32, purgedAt time.Time) (int, error) {
+	var purged int
+	err := s.writeTx(ctx, ErrCouldNotPurgeUsers, func(q *gen.Queries) error {
+		rows, err := q.ListPurgeableUsers(ctx, gen.ListPurgeableUsersParams{
+			DeletedBefore: deletedBefore,
+			PageLimit:     limit,
+		})
+		if err != nil {
+			slog.ErrorContext(ctx, ErrCouldNotPurgeUsers.Error(), slog.Any("error", err))
+			return classify(ErrCouldNotPurgeUsers, err)
+		}
+
+		for _, row := range rows {
+			ctx := store.WithTenant(ctx, row.TenantID)
+
+			if err := q.PurgeUser(ctx, gen.PurgeUserParams{TenantID: row.TenantID, ID: row.ID}); err != nil {
+				slog.ErrorContext(ctx, ErrCouldNotPurgeUsers.Error(),
+					slog.Any("error", err),
+					slog.String("user id", row.ID),
+				)
+				return classify(ErrCouldNotPurgeUsers, err)
+			}
+
+			if err := recordEvent(ctx, q, store.NewUserPurged(ctx, row.ID, purgedAt)); err != nil {
+				return err
+			}
+			if err := recordChange(ctx, q, convertUser(row), nil, purgedAt); err != nil {
+				return err
+			}
+		}
+
+		purged = len(rows)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return purged, nil
+}
+
+func (s *Store) GetUser(ctx context.Context, id string) (*pb.User, error) {
+	db, err := s.q.GetUser(ctx, gen.GetUserParams{TenantID: store.TenantFromContext(ctx), ID: id})
+	if err != nil {
+		slog.ErrorContext(ctx, ErrCouldNotGetUser.Error(),
+			slog.Any("error", err),
+			slog.String("user id", id),
+		)
+		return nil, classify(ErrCouldNotGetUser, err)
+	}
+
+	return convertUser(db), nil
+}
+
+func (s *Store) GetUserByEmail(ctx context.Context, email string) (*pb.User, error) {
+	db, err := s.q.GetUserByEmail(ctx, gen.GetUserByEmailParams{TenantID: store.TenantFromContext(ctx), Email: email})
+	if err != nil {
+		slog.ErrorContext(ctx, ErrCouldNotGetUser.Error(),
+			slog.Any("error", err),
+			slog.String("user email", email),
+		)
+		return nil, classify(ErrCouldNotGetUser, err)
+	}
+
+	return convertUser(db), nil
+}
+
+// pageCursor is the keyset position encoded into page tokens: the order
+// users were listed in and the last user listed.
+type pageCursor struct {
+	Order     string    `json:"o"`
+	Name      string    `json:"n,omitempty"`
+	CreatedAt time.Time `json:"c,omitzero"`
+	ID        string    `json:"i"`
+}
+
+func (s *Store) ListUsers(ctx context.Context, params store.ListUsersParams) ([]*pb.User, string, error) {
+	order := params.OrderBy
+
+	var cursor pageCursor
+	if params.PageToken != "" {
+		if err := store.DecodePageToken(params.PageToken, &cursor); err != nil {
+			return nil, "", err
+		}
+		if err := store.CheckPageOrder(cursor.Order, order); err != nil {
+			return nil, "", err
+		}
+	}
+
+	limit := params.Limit()
+
+	// Fetch one extra row to learn whether there is another page.
+	db, err := listUsers(ctx, s.q, order, cursor, filterParams(ctx, params.Filter), limit+1)
+	if err != nil {
+		slog.ErrorContext(ctx, ErrCouldNotListUsers.Error(),
+			slog.Any("error", err),
+		)
+		return nil, "", classify(ErrCouldNotListUsers, err)
+	}
+
+	var nextPageToken string
+	if len(db) > int(limit) {
+		db = db[:limit]
+		last := db[len(db)-1]
+		next := pageCursor{Order: order.String(), ID: last.ID}
+		switch order.Field {
+		case store.OrderByCreatedAt:
+			next.CreatedAt = last.CreatedAt
+		default:
+			next.Name = last.Name
+		}
+		nextPageToken, err = store.EncodePageToken(next)
+		if err != nil {
+			slog.ErrorContext(ctx, ErrCouldNotListUsers.Error(),
+				slog.Any("error", err),
+			)
+			return nil, "", classify(ErrCouldNotListUsers, err)
+		}
+	}
+
+	users := []*pb.User{}
+	for _, u := range db {
+		users = append(users, convertUser(u))
+	}
+
+	return users, nextPageToken, nil
+}
+
+// listUsers runs the query listing the users of filter's tenant in order,
+// after cursor.
+func listUsers(ctx context.Context, q *gen.Queries, order store.UserOrder, cursor pageCursor, filter gen.CountUsersParams, limit int32) ([]gen.User, error) {
+	switch {
+	case order.Field == store.OrderByCreatedAt && order.Desc:
+		return q.ListUsersByCreatedAtDesc(ctx, gen.ListUsersByCreatedAtDescParams{
+			TenantID:       filter.TenantID,
+			AfterID:        cursor.ID,
+			AfterCreatedAt: cursor.CreatedAt,
+			NamePrefix:     filter.NamePrefix,
+			EmailDomain:    filter.EmailDomain,
+			CreatedAfter:   filter.CreatedAfter,
+			CreatedBefore:  filter.CreatedBefore,
+			ShowDeleted:    filter.ShowDeleted,
+			PageLimit:      limit,
+		})
+	case order.Field == store.OrderByCreatedAt:
+		return q.ListUsersByCreatedAt(ctx, gen.ListUsersByCreatedAtParams{
+			TenantID:       filter.TenantID,
+			AfterCreatedAt: cursor.CreatedAt,
+			AfterID:        cursor.ID,
+			NamePrefix:     filter.NamePrefix,
+			EmailDomain:    filter.EmailDomain,
+			CreatedAfter:   filter.CreatedAfter,
+			CreatedBefore:  filter.CreatedBefore,
+			ShowDeleted:    filter.ShowDeleted,
+			PageLimit:      limit,
+		})
+	case order.Desc:
+		return q.ListUsersByNameDesc(ctx, gen.ListUsersByNameDescParams{
+			TenantID:      filter.TenantID,
+			AfterID:       cursor.ID,
+			AfterName:     cursor.Name,
+			NamePrefix:    filter.NamePrefix,
+			EmailDomain:   filter.EmailDomain,
+			CreatedAfter:  filter.CreatedAfter,
+			CreatedBefore: filter.CreatedBefore,
+			ShowDeleted:   filter.ShowDeleted,
+			PageLimit:     limit,
+		})
+	default:
+		return q.ListUsersByName(ctx, gen.ListUsersByNameParams{
+			TenantID:      filter.TenantID,
+			AfterName:     cursor.Name,
+			AfterID:       cursor.ID,
+			NamePrefix:    filter.NamePrefix,
+			EmailDomain:   filter.EmailDomain,
+			CreatedAfter:  filter.CreatedAfter,
+			CreatedBefore: filter.CreatedBefore,
+			ShowDeleted:   filter.ShowDeleted,
+			PageLimit:     limit,
+		})
+	}
+}
+
+func (s *Store) CountUsers(ctx context.Context, filter store.UserFilter) (int64, error) {
+	count, err := s.q.CountUsers(ctx, filterParams(ctx, filter))
+	if err != nil {
+		slog.ErrorContext(ctx, ErrCouldNotListUsers.Error(),
+			slog.Any("error", err),
+		)
+		return 0, classify(ErrCouldNotListUsers, err)
+	}
+
+	return count, nil
+}
+
+// filterParams converts filter into the parameters the list queries share,
+// in which an empty string or null matches every user of the tenant of ctx.
+func filterParams(ctx context.Context, filter store.UserFilter) gen.CountUsersParams {
+	return gen.CountUsersParams{
+		TenantID:      store.TenantFromContext(ctx),
+		NamePrefix:    filter.NamePrefix,
+		EmailDomain:   store.NormalizeEmail(filter.EmailDomain),
+		CreatedAfter:  pgtype.Timestamptz{Time: filter.CreatedAfter, Valid: !filter.CreatedAfter.IsZero()},
+		CreatedBefore: pgtype.Timestamptz{Time: filter.CreatedBefore, Valid: !filter.CreatedBefore.IsZero()},
+		ShowDeleted:   filter.ShowDeleted,
+	}
+}
+
+func (s *Store) UpdateUser(ctx context.Context, user *pb.User, mask store.FieldMask, expectedVersion int64) (*pb.User, error) {
+	var updated *pb.User
+	err := s.writeTx(ctx, ErrCouldNotUpdateUser, func(q *gen.Queries) error {
+		// The user is read for its audit event before it changes, and
+		// locked so that it cannot change in between.
+		current, err := q.GetUserForUpdate(ctx, gen.GetUserForUpdateParams{TenantID: store.TenantFromContext(ctx), ID: user.GetId()})
+		if err != nil {
+			slog.ErrorContext(ctx, ErrCouldNotUpdateUser.Error(),
+				slog.Any("error", err),
+				slog.String("user id", user.GetId()),
+			)
+			return classify(ErrCouldNotUpdateUser, err)
+		}
+
+		db, err := q.UpdateUser(ctx, gen.UpdateUserParams{
+			TenantID:        store.TenantFromContext(ctx),
+			ID:              user.GetId(),
+			Name:            pgtype.Text{String: user.GetName(), Valid: mask.Name},
+			Email:           pgtype.Text{String: user.GetEmail(), Valid: mask.Email},
+			ExpectedVersion: expectedVersion,
+			UpdatedAt:       user.GetUpdatedAt().AsTime(),
+		})
+		if err != nil {
+			slog.ErrorContext(ctx, ErrCouldNotUpdateUser.Error(),
+				slog.Any("error", err),
+				slog.String("user id", user.GetId()),
+			)
+			return classifyGuarded(ctx, q, ErrCouldNotUpdateUser, err, user.GetId(), expectedVersion)
+		}
+
+		updated = convertUser(db)
+		if err := recordEvent(ctx, q, store.NewUserUpdated(ctx, updated, mask)); err != nil {
+			return err
+		}
+		return recordChange(ctx, q, convertUser(current), updated, updated.GetUpdatedAt().AsTime())
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return updated, nil
+}
+
+func (s *Store) BatchGetUsers(ctx context.Context, ids []string) ([]store.BatchResult, error) {
+	db, err := s.q.BatchGetUsers(ctx, gen.BatchGetUsersParams{TenantID: store.TenantFromContext(ctx), Ids: ids})
+	if err != nil {
+		slog.ErrorContext(ctx, ErrCouldNotGetUser.Error(),
+			slog.Any("error", err),
+		)
+		return nil, classify(ErrCouldNotGetUser, err)
+	}
+
+	found := make(map[string]gen.User, len(db))
+	for _, u := range db {
+		found[u.ID] = u
+	}
+
+	results := make([]store.BatchResult, len(ids))
+	for i, id := range ids {
+		u, ok := found[id]
+		if !ok {
+			results[i].Err = store.NotFound(ErrCouldNotGetUser)
+			continue
+		}
+		results[i].User = convertUser(u)
+	}
+
+	return results, nil
+}
+
+func (s *Store) BatchCreateUsers(ctx context.Context, users []*pb.User) ([]store.BatchResult, error) {
+	results := make([]store.BatchResult, len(users))
+	err := pgx.BeginFunc(ctx, s.pool, func(tx pgx.Tx) error {
+		for i, user := range users {
+			results[i].Err = savepoint(ctx, tx, func(q *gen.Queries) error {
+				return createUser(ctx, q, user)
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		slog.ErrorContext(ctx, ErrCouldNotCreateUser.Error(), slog.Any("error", err))
+		return nil, classify(ErrCouldNotCreateUser, err)
+	}
+
+	return results, nil
+}
+
+func (s *Store) BatchDeleteUsers(ctx context.Context, deletes []store.BatchDelete, deletedAt time.Time) ([]store.BatchResult, error) {
+	results := make([]store.BatchResult, len(deletes))
+	err := pgx.BeginFunc(ctx, s.pool, func(tx pgx.Tx) error {
+		for i, d := range deletes {
+			results[i].Err = savepoint(ctx, tx, func(q *gen.Queries) error {
+				return deleteUser(ctx, q, d.ID, d.ExpectedVersion, deletedAt)
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		slog.ErrorContext(ctx, ErrCouldNotDeleteUser.Error(), slog.Any("error", err))
+		return nil, classify(ErrCouldNotDeleteUser, err)
+	}
+
+	return results, nil
+}
+
+// savepoint runs fn in a savepoint of tx. A failed statement aborts the
+// whole transaction in postgres, so each item of a batch runs in its own
+// savepoint to keep its failure from undoing the rest. An item's change and
+// its events share the savepoint, so are undone together.
+func savepoint(ctx context.Context, tx pgx.Tx, fn func(*gen.Queries) error) error {
+	return pgx.BeginFunc(ctx, tx, func(sp pgx.Tx) error {
+		return fn(gen.New(sp))
+	})
+}
+
+// writeTx runs fn in a transaction, which is committed if fn returns nil,
+// classifying a failure to begin or commit it as a failure of op. fn
+// classifies its own errors.
+func (s *Store) writeTx(ctx context.Context, op error, fn func(*gen.Queries) error) error {
+	err := pgx.BeginFunc(ctx, s.pool, func(tx pgx.Tx) error {
+		return fn(gen.New(tx))
+	})
+
+	var storeErr *store.Error
+	if err != nil && !errors.As(err, &storeErr) {
+		slog.ErrorContext(ctx, op.Error(), slog.Any("error", err))
+		return classify(op, err)
+	}
+
+	return err
+}
+
+// recordEvent adds event to the outbox.
+func recordEvent(ctx context.Context, q *gen.Queries, event *pb.UserEvent) error {
+	data, err := proto.Marshal(event)
+	if err == nil {
+		err = q.InsertEvent(ctx, gen.InsertEventParams{ID: event.GetId(), Event: data})
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, ErrCouldNotRecordEvent.Error(),
+			slog.Any("error", err),
+			slog.String("event id", event.GetId()),
+		)
+		return classify(ErrCouldNotRecordEvent, err)
+	}
+
+	return nil
+}
+
+// recordAudit adds event to the audit log.
+func recordAudit(ctx context.Context, q *gen.Queries, event *pb.AuditEvent) error {
+	data, err := proto.Marshal(event)
+	if err == nil {
+		err = q.InsertAuditEvent(ctx, gen.InsertAuditEventParams{
+			ID:         event.GetId(),
+			TenantID:   store.TenantFromContext(ctx),
+			UserID:     event.GetUserId(),
+			OccurredAt: event.GetOccurredAt().AsTime(),
+			Event:      data,
+		})
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, ErrCouldNotRecordAudit.Error(),
+			slog.Any("error", err),
+			slog.String("audit event id", event.GetId()),
+		)
+		return classify(ErrCouldNotRecordAudit, err)
+	}
+
+	return nil
+}
+
+// recordChange records the audit event for a change made at occurredAt that
+// left the user stored as before as after, and the revision it made,
+// dropping revisions older than the store keeps. Purging a user, which
+// leaves no after, drops them all.
+func recordChange(ctx context.Context, q *gen.Queries, before, after *pb.User, occurredAt time.Time) error {
+	event := store.NewAuditEvent(ctx, before, after, occurredAt)
+	if err := recordAudit(ctx, q, event); err != nil {
+		return err
+	}
+
+	if after == nil {
+		return deleteRevisions(ctx, q, before.GetId())
+	}
+	return recordRevision(ctx, q, store.NewRevision(after, event))
+}
+
+// recordRevision adds revision to the user's revisions and drops those older
+// than the store keeps.
+func recordRevision(ctx context.Context, q *gen.Queries, revision *pb.UserRevision) error {
+	tenantID := store.TenantFromContext(ctx)
+	userID := revision.GetUser().GetId()
+
+	data, err := proto.Marshal(revision)
+	if err == nil {
+		err = q.InsertUserRevision(ctx, gen.InsertUserRevisionParams{
+			TenantID:  tenantID,
+			UserID:    userID,
+			Version:   revision.GetRevision(),
+			RevisedAt: revision.GetRevisedAt().AsTime(),
+			Revision:  data,
+		})
+	}
+	if err == nil {
+		err = q.TrimUserRevisions(ctx, gen.TrimUserRevisionsParams{
+			TenantID:      tenantID,
+			UserID:        userID,
+			OldestVersion: store.OldestRevision(revision.GetRevision()),
+		})
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, ErrCouldNotRecordRevision.Error(),
+			slog.Any("error", err),
+			slog.String("user id", userID),
+			slog.Int64("revision", revision.GetRevision()),
+		)
+		return classify(ErrCouldNotRecordRevision, err)
+	}
+
+	return nil
+}
+
+// deleteRevisions drops every revision of the user with id.
+func deleteRevisions(ctx context.Context, q *gen.Queries, id string) error {
+	err := q.DeleteUserRevisions(ctx, gen.DeleteUserRevisionsParams{TenantID: store.TenantFromContext(ctx), UserID: id})
+	if err != nil {
+		slog.ErrorContext(ctx, ErrCouldNotRecordRevision.Error(),
+			slog.Any("error", err),
+			slog.String("user id", id),
+		)
+		return classify(ErrCouldNotRecordRevision, err)
+	}
+
+	return nil
+}
+
+func (s *Store) PendingEvents(ctx context.Context, limit int32) ([]*pb.UserEvent, error) {
+	rows, err := s.q.ListEvents(ctx, limit)
+	if err != nil {
+		slog.ErrorContext(ctx, ErrCouldNotReadEvents.Error(), slog.Any("error", err))
+		return nil, classify(ErrCouldNotReadEvents, err)
+	}
+
+	events := make([]*pb.UserEvent, 0, len(rows))
+	for _, row := range rows {
+		event := &pb.UserEvent{}
+		if err := proto.Unmarshal(row.Event, event); err != nil {
+			slog.ErrorContext(ctx, ErrCouldNotReadEvents.Error(),
+				slog.Any("error", err),
+				slog.String("event id", row.ID),
+			)
+			return nil, classify(ErrCouldNotReadEvents, err)
+		}
+		events = append(events, event)
+	}
+
+	return events, nil
+}
+
+func (s *Store) AckEvents(ctx context.Context, events []*pb.UserEvent) error {
+	if err := s.q.DeleteEvents(ctx, store.EventIDs(events)); err != nil {
+		slog.ErrorContext(ctx, ErrCouldNotAckEvents.Error(), slog.Any("error", err))
+		return classify(ErrCouldNotAckEvents, err)
+	}
+
+	return nil
+}
+
+// auditCursor is the keyset position encoded into audit page tokens: the
+// last event listed.
+type auditCursor struct {
+	OccurredAt time.Time `json:"t"`
+	ID         string    `json:"i"`
+}
+
+func (s *Store) ListAuditEvents(ctx context.Context, params store.ListAuditEventsParams) ([]*pb.AuditEvent, string, error) {
+	var cursor auditCursor
+	if params.PageToken != "" {
+		if err := store.DecodePageToken(params.PageToken, &cursor); err != nil {
+			return nil, "", err
+		}
+	}
+
+	limit := params.Limit()
+	filter := params.Filter
+
+	// Fetch one extra row to learn whether there is another page.
+	rows, err := s.q.ListAuditEvents(ctx, gen.ListAuditEventsParams{
+		TenantID:        store.TenantFromContext(ctx),
+		AfterOccurredAt: cursor.OccurredAt,
+		AfterID:         cursor.ID,
+		UserID:          filter.UserID,
+		StartTime:       pgtype.Timestamptz{Time: filter.StartTime, Valid: !filter.StartTime.IsZero()},
+		EndTime:         pgtype.Timestamptz{Time: filter.EndTime, Valid: !filter.EndTime.IsZero()},
+		PageLimit:       limit + 1,
+	})
+	if err != nil {
+		slog.ErrorContext(ctx, ErrCouldNotListAudit.Error(), slog.Any("error", err))
+		return nil, "", classify(ErrCouldNotListAudit, err)
+	}
+
+	var nextPageToken string
+	if len(rows) > int(limit) {
+		rows = rows[:limit]
+		last := rows[len(rows)-1]
+		nextPageToken, err = store.EncodePageToken(auditCursor{OccurredAt: last.OccurredAt, ID: last.ID})
+		if err != nil {
+			slog.ErrorContext(ctx, ErrCouldNotListAudit.Error(), slog.Any("error", err))
+			return nil, "", classify(ErrCouldNotListAudit, err)
+		}
+	}
+
+	events := make([]*pb.AuditEvent, 0, len(rows))
+	for _, row := range rows {
+		event := &pb.AuditEvent{}
+		if err := proto.Unmarshal(row.Event, event); err != nil {
+			slog.ErrorContext(ctx, ErrCouldNotListAudit.Error(),
+				slog.Any("error", err),
+				slog.String("audit event id", row.ID),
+			)
+			return nil, "", classify(ErrCouldNotListAudit, err)
+		}
+		events = append(events, event)
+	}
+
+	return events, nextPageToken, nil
+}
+
+// revisionCursor is the position encoded into revision page tokens: the last
+// revision listed.
+type revisionCursor struct {
+	Revision int64 `json:"r"`
+}
+
+func (s *Store) ListUserRevisions(ctx context.Context, params store.ListUserRevisionsParams) ([]*pb.UserRevision, string, error) {
+	var cursor revisionCursor
+	if params.PageToken != "" {
+		if err := store.DecodePageToken(params.PageToken, &cursor); err != nil {
+			return nil, "", err
+		}
+	}
+
+	limit := params.Limit()
+
+	// Fetch one extra row to learn whether there is another page.
+	rows, err := s.q.ListUserRevisions(ctx, gen.ListUserRevisionsParams{
+		TenantID:     store.TenantFromContext(ctx),
+		UserID:       params.UserID,
+		AfterVersion: cursor.Revision,
+		PageLimit:    limit + 1,
+	})
+	if err != nil {
+		slog.ErrorContext(ctx, ErrCouldNotListRevisions.Error(),
+			slog.Any("error", err),
+			slog.String("user id", params.UserID),
+		)
+		return nil, "", classify(ErrCouldNotListRevisions, err)
+	}
+
+	var nextPageToken string
+	if len(rows) > int(limit) {
+		rows = rows[:limit]
+		nextPageToken, err = store.EncodePageToken(revisionCursor{Revision: rows[len(rows)-1].Version})
+		if err != nil {
+			slog.ErrorContext(ctx, ErrCouldNotListRevisions.Error(), slog.Any("error", err))
+			return nil, "", classify(ErrCouldNotListRevisions, err)
+		}
+	}
+
+	revisions := make([]*pb.UserRevision, 0, len(rows))
+	for _, row := range rows {
+		revision, err := convertRevision(row)
+		if err != nil {
+			slog.ErrorContext(ctx, ErrCouldNotListRevisions.Error(),
+				slog.Any("error", err),
+				slog.String("user id", row.UserID),
+				slog.Int64("revision", row.Version),
+			)
+			return nil, "", classify(ErrCouldNotListRevisions, err)
+		}
+		revisions = append(revisions, revision)
+	}
+
+	return revisions, nextPageToken, nil
+}
+
+func (s *Store) GetUserAsOf(ctx context.Context, id string, asOf time.Time) (*pb.User, error) {
+	row, err := s.q.GetUserRevisionAsOf(ctx, gen.GetUserRevisionAsOfParams{
+		TenantID: store.TenantFromContext(ctx),
+		UserID:   id,
+		AsOf:     asOf,
+	})
+	if err != nil {
+		slog.ErrorContext(ctx, ErrCouldNotGetUser.Error(),
+			slog.Any("error", err),
+			slog.String("user id", id),
+		)
+		return nil, classify(ErrCouldNotGetUser, err)
+	}
+
+	revision, err := convertRevision(row)
+	if err != nil {
+		slog.ErrorContext(ctx, ErrCouldNotGetUser.Error(),
+			slog.Any("error", err),
+			slog.String("user id", id),
+			slog.Int64("revision", row.Version),
+		)
+		return nil, classify(ErrCouldNotGetUser, err)
+	}
+	if revision.GetUser().GetDeletedAt() != nil {
+		return nil, store.NotFound(ErrCouldNotGetUser)
+	}
+
+	return revision.GetUser(), nil
+}
+
+func (s *Store) ClaimIdempotencyKey(ctx context.Context, record store.IdempotencyRecord) (*store.IdempotencyRecord, error) {
+	tenantID := store.TenantFromContext(ctx)
+
+	var kept *store.IdempotencyRecord
+	err := s.writeTx(ctx, ErrCouldNotClaimKey, func(q *gen.Queries) error {
+		// Sweep every expired record, so that the table only grows with the
+		// keys in use, and a claim may take the key of an expired one.
+		if err := q.DeleteExpiredIdempotencyKeys(ctx, time.Now()); err != nil {
+			return classify(ErrCouldNotClaimKey, err)
+		}
+
+		// A concurrent claim of the key makes the insert wait for it, and
+		// then insert nothing, leaving its record to be read.
+		n, err := q.InsertIdempotencyKey(ctx, gen.InsertIdempotencyKeyParams{
+			TenantID:       tenantID,
+			IdempotencyKey: record.Key,
+			Fingerprint:    record.Fingerprint,
+			ExpiresAt:      record.ExpiresAt,
+		})
+		if err != nil {
+			return classify(ErrCouldNotClaimKey, err)
+		}
+		if n == 1 {
+			return nil
+		}
+
+		row, err := q.GetIdempotencyKey(ctx, gen.GetIdempotencyKeyParams{TenantID: tenantID, IdempotencyKey: record.Key})
+		if err != nil {
+			return classify(ErrCouldNotClaimKey, err)
+		}
+		kept = convertIdempotencyKey(row)
+		return nil
+	})
+	if err != nil {
+		slog.ErrorContext(ctx, ErrCouldNotClaimKey.Error(),
+			slog.Any("error", err),
+			slog.String("idempotency key", record.Key),
+		)
+		return nil, err
+	}
+
+	return kept, nil
+}
+
+func (s *Store) CompleteIdempotencyKey(ctx context.Context, record store.IdempotencyRecord) error {
+	n, err := s.q.CompleteIdempotencyKey(ctx, gen.CompleteIdempotencyKeyParams{
+		Response:       record.Response,
+		ExpiresAt:      record.ExpiresAt,
+		TenantID:       store.TenantFromContext(ctx),
+		IdempotencyKey: record.Key,
+		Fingerprint:    record.Fingerprint,
+	})
+	if err == nil && n == 0 {
+		err = pgx.ErrNoRows
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, ErrCouldNotCompleteKey.Error(),
+			slog.Any("error", err),
+			slog.String("idempotency key", record.Key),
+		)
+		return classify(ErrCouldNotCompleteKey, err)
+	}
+
+	return nil
+}
+
+func (s *Store) ReleaseIdempotencyKey(ctx context.Context, key string) error {
+	err := s.q.ReleaseIdempotencyKey(ctx, gen.ReleaseIdempotencyKeyParams{
+		TenantID:       store.TenantFromContext(ctx),
+		IdempotencyKey: key,
+	})
+	if err != nil {
+		slog.ErrorContext(ctx, ErrCouldNotReleaseKey.Error(),
+			slog.Any("error", err),
+			slog.String("idempotency key", key),
+		)
+		return classify(ErrCouldNotReleaseKey, err)
+	}
+
+	return nil
+}
+
+// classifyGuarded classifies the failure of a statement guarded by
+// expectedVersion. A guarded statement matches no rows both when the user is
+// missing and when its version differs, so the user is looked up to tell the
+// two apart.
+func classifyGuarded(ctx context.Context, q *gen.Queries, op, err error, id string, expectedVersion int64) error {
+	if expectedVersion != 0 && errors.Is(err, pgx.ErrNoRows) {
+		if _, getErr := q.GetUser(ctx, gen.GetUserParams{TenantID: store.TenantFromContext(ctx), ID: id}); getErr == nil {
+			return store.PreconditionFailed(fmt.Errorf("%w: expected version %d", op, expectedVersion))
+		}
+	}
+
+	return classify(op, err)
+}
+
+// classifyNotDeleted classifies the failure to find a deleted user, which
+// either does not exist or is not deleted.
+func classifyNotDeleted(ctx context.Context, q *gen.Queries, op, err error, id string) error {
+	if errors.Is(err, pgx.ErrNoRows) {
+		if _, getErr := q.GetUser(ctx, gen.GetUserParams{TenantID: store.TenantFromContext(ctx), ID: id}); getErr == nil {
+			return store.AlreadyExists(fmt.Errorf("%w: user is not deleted", op))
+		}
+	}
+
+	return classify(op, err)
+}
+
+// classify wraps err with the operation that failed and the store error kind
+// that best describes it.
+func classify(op, err error) error {
+	err = fmt.Errorf("%w: %w", op, err)
+
+	if errors.Is(err, pgx.ErrNoRows) {
+		return store.NotFound(err)
+	}
+
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		switch pgErr.Code {
+		case "23505": // unique_violation
+			return store.AlreadyExists(err)
+		case "40001", "40P01": // serialization_failure, deadlock_detected
+			return store.Conflict(err)
+		case "53300", "57P01", "57P02", "57P03": // too_many_connections, *_shutdown, cannot_connect_now
+			return store.Unavailable(err)
+		}
+
+		// The class, the first two characters, groups related conditions.
+		switch pgErr.Code[:2] {
+		case "23": // integrity_constraint_violation
+			return store.Conflict(err)
+		case "08": // connection_exception
+			return store.Unavailable(err)
+		}
+	}
+
+	var connectErr *pgconn.ConnectError
+	if errors.As(err, &connectErr) || pgconn.Timeout(err) {
+		return store.Unavailable(err)
+	}
+
+	return store.Internal(err)
+}
+
+func convertRevision(db gen.UserRevision) (*pb.UserRevision, error) {
+	revision := &pb.UserRevision{}
+	if err := proto.Unmarshal(db.Revision, revision); err != nil {
+		return nil, err
+	}
+	return revision, nil
+}
+
+func convertUser(db gen.User) *pb.User {
+	user := &pb.User{
+		Id:        db.ID,
+		Name:      db.Name,
+		Email:     db.Email,
+		Version:   db.Version,
+		CreatedAt: timestamppb.New(db.CreatedAt),
+		UpdatedAt: timestamppb.New(db.UpdatedAt),
+	}
+	if db.DeletedAt.Valid {
+		user.DeletedAt = timestamppb.New(db.DeletedAt.Time)
+	}
+	return user
+}
+
+func convertIdempotencyKey(db gen.IdempotencyKey) *store.IdempotencyRecord {
+	return &store.IdempotencyRecord{
+		Key:         db.IdempotencyKey,
+		Fingerprint: db.Fingerprint,
+		Completed:   db.Completed,
+		Response:    db.Response,
+		ExpiresAt:   db.ExpiresAt,
+	}
+}
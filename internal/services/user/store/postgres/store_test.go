@@ -0,0 +1,109 @@
+package postgres_test
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+	tcpostgres "github.com/testcontainers/testcontainers-go/modules/postgres"
+
+	"github.com/andrew-womeldorf/connect-boilerplate/internal/services/user/store"
+	"github.com/andrew-womeldorf/connect-boilerplate/internal/services/user/store/postgres"
+	"github.com/andrew-womeldorf/connect-boilerplate/internal/services/user/store/storetest"
+)
+
+var (
+	sharedPostgresContainer *tcpostgres.PostgresContainer
+	sharedPostgresURL       string
+	postgresSetupOnce       sync.Once
+)
+
+func setupSharedPostgresContainer() error {
+	var err error
+	postgresSetupOnce.Do(func() {
+		ctx := context.Background()
+
+		sharedPostgresContainer, err = tcpostgres.Run(ctx, "postgres:17-alpine",
+			tcpostgres.WithDatabase("users"),
+			tcpostgres.BasicWaitStrategies(),
+		)
+		if err != nil {
+			err = fmt.Errorf("could not start postgres container: %w", err)
+			return
+		}
+
+		sharedPostgresURL, err = sharedPostgresContainer.ConnectionString(ctx, "sslmode=disable")
+		if err != nil {
+			err = fmt.Errorf("could not get connection string from postgres container: %w", err)
+			return
+		}
+	})
+	return err
+}
+
+func cleanupPostgresTable(ctx context.Context) error {
+	if sharedPostgresURL == "" {
+		return nil
+	}
+
+	conn, err := pgx.Connect(ctx, sharedPostgresURL)
+	if err != nil {
+		return fmt.Errorf("failed to connect for cleanup: %w", err)
+	}
+	defer func() { _ = conn.Close(ctx) }()
+
+	if _, err := conn.Exec(ctx, "TRUNCATE users"); err != nil {
+		return fmt.Errorf("failed to truncate table during cleanup: %w", err)
+	}
+
+	return nil
+}
+
+func TestMain(m *testing.M) {
+	code := m.Run()
+
+	// Cleanup shared container after all tests
+	if sharedPostgresContainer != nil {
+		ctx := context.Background()
+		if err := sharedPostgresContainer.Terminate(ctx); err != nil {
+			fmt.Printf("failed to terminate shared postgres container: %v\n", err)
+		}
+	}
+
+	os.Exit(code)
+}
+
+func TestStore(t *testing.T) {
+	ctx := context.Background()
+
+	storetest.Run(t, func(t *testing.T) store.Store {
+		if err := setupSharedPostgresContainer(); err != nil {
+			t.Fatalf("failed to setup shared postgres container: %v", err)
+		}
+
+		// The first store applies the migrations, so clean up after
+		// creating it.
+		s, err := postgres.NewStore(ctx, sharedPostgresURL)
+		if err != nil {
+			t.Fatalf("failed to create store: %v", err)
+		}
+
+		if err := cleanupPostgresTable(ctx); err != nil {
+			t.Fatalf("failed to cleanup postgres table: %v", err)
+		}
+
+		t.Cleanup(func() {
+			if err := s.Close(); err != nil {
+				t.Errorf("failed to close store: %v", err)
+			}
+			if err := cleanupPostgresTable(ctx); err != nil {
+				t.Logf("failed to cleanup postgres table: %v", err)
+			}
+		})
+
+		return s
+	})
+}
@@ -0,0 +1,93 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"slices"
+	"sort"
+	"sync"
+)
+
+var (
+	ErrUnknownScheme = errors.New("unknown store scheme")
+	ErrInvalidURL    = errors.New("invalid store url")
+)
+
+// Factory opens a Store from a URL whose scheme it was registered under. It
+// should reject options it does not understand with ErrInvalidURL.
+type Factory func(ctx context.Context, u *url.URL) (Store, error)
+
+var (
+	factoriesMu sync.RWMutex
+	factories   = make(map[string]Factory)
+)
+
+// Register makes a backend available to Open under scheme. Backends call it
+// from init, so importing a backend package is enough to enable it. Register
+// panics if scheme is registered twice.
+func Register(scheme string, factory Factory) {
+	factoriesMu.Lock()
+	defer factoriesMu.Unlock()
+
+	if factory == nil {
+		panic("store: Register factory is nil")
+	}
+	if _, dup := factories[scheme]; dup {
+		panic("store: Register called twice for scheme " + scheme)
+	}
+	factories[scheme] = factory
+}
+
+// Schemes returns the registered schemes in sorted order.
+func Schemes() []string {
+	factoriesMu.RLock()
+	defer factoriesMu.RUnlock()
+
+	schemes := make([]string, 0, len(factories))
+	for scheme := range factories {
+		schemes = append(schemes, scheme)
+	}
+	sort.Strings(schemes)
+	return schemes
+}
+
+// Open builds the Store described by rawURL, for example
+// "sqlite:///var/lib/app.db?journal=wal" or
+// "dynamodb://users?endpoint=http://localhost:4566".
+func Open(ctx context.Context, rawURL string) (Store, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidURL, err)
+	}
+	if u.Scheme == "" {
+		return nil, fmt.Errorf("%w: %q has no scheme", ErrInvalidURL, rawURL)
+	}
+
+	factoriesMu.RLock()
+	factory, ok := factories[u.Scheme]
+	factoriesMu.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("%w %q (registered: %v)", ErrUnknownScheme, u.Scheme, Schemes())
+	}
+
+	return factory(ctx, u)
+}
+
+// URLOptions returns the query options of u, rejecting any option not listed
+// in allowed and any option given more than once.
+func URLOptions(u *url.URL, allowed ...string) (map[string]string, error) {
+	opts := make(map[string]string)
+	for key, values := range u.Query() {
+		if !slices.Contains(allowed, key) {
+			return nil, fmt.Errorf("%w: unknown %s option %q", ErrInvalidURL, u.Scheme, key)
+		}
+		if len(values) != 1 {
+			return nil, fmt.Errorf("%w: %s option %q given more than once", ErrInvalidURL, u.Scheme, key)
+		}
+		opts[key] = values[0]
+	}
+	return opts, nil
+}
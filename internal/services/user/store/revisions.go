@@ -0,0 +1,60 @@
+package store
+
+import (
+	"context"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+
+	pb "github.com/andrew-womeldorf/connect-boilerplate/gen/user/v1"
+)
+
+// MaxRevisions is how many of each user's most recent revisions a store
+// keeps. Recording a revision beyond it drops the oldest.
+const MaxRevisions = 50
+
+// RevisionLog keeps the history of each user as a revision per change.
+//
+// Every write that changes a user records the revision it made, numbered by
+// the version it left the user at, in the same transaction as the change and
+// its AuditLog event. Purging a user removes its revisions. Users last
+// written before revisions were kept have none until they are written again.
+type RevisionLog interface {
+	// ListUserRevisions returns a page of the revisions of the user with
+	// params.UserID, in the tenant of ctx, newest first, and a token for the
+	// next page if there may be one.
+	ListUserRevisions(ctx context.Context, params ListUserRevisionsParams) ([]*pb.UserRevision, string, error)
+	// GetUserAsOf returns the user with id as its most recent revision made
+	// at or before asOf left it. It fails with ErrNotFound if there is no
+	// such revision, or the user was deleted by it.
+	GetUserAsOf(ctx context.Context, id string, asOf time.Time) (*pb.User, error)
+}
+
+// ListUserRevisionsParams controls which page of revisions a store returns.
+type ListUserRevisionsParams struct {
+	UserID    string
+	PageSize  int32
+	PageToken string
+}
+
+// Limit returns the page size clamped as ListUsersParams.Limit does.
+func (p ListUserRevisionsParams) Limit() int32 {
+	return ListUsersParams{PageSize: p.PageSize}.Limit()
+}
+
+// NewRevision returns the revision made by the change audited by event,
+// which left the user stored as user.
+func NewRevision(user *pb.User, event *pb.AuditEvent) *pb.UserRevision {
+	return &pb.UserRevision{
+		Revision:  user.GetVersion(),
+		RevisedAt: proto.CloneOf(event.GetOccurredAt()),
+		Action:    event.GetAction(),
+		User:      proto.CloneOf(user),
+	}
+}
+
+// OldestRevision returns the oldest revision a store keeps of a user that
+// has just been given revision latest.
+func OldestRevision(latest int64) int64 {
+	return max(latest-MaxRevisions+1, FirstVersion)
+}
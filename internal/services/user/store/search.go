@@ -0,0 +1,100 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+
+	pb "github.com/andrew-womeldorf/connect-boilerplate/gen/user/v1"
+)
+
+// Highlights wrap the matched words of a SearchResult.
+const (
+	HighlightStart = "<mark>"
+	HighlightEnd   = "</mark>"
+)
+
+// ErrSearchNotSupported is returned by searching through a wrapper, such as
+// the cache, of a store that cannot search.
+var ErrSearchNotSupported = errors.New("the user store does not support search")
+
+// Searcher is implemented by stores that search users natively. Stores that
+// cannot are searched through the fallback index of the search package.
+//
+// SearchUsers returns a page of the users matching every term of
+// params.Query, best match first, and a token for the next page if there may
+// be one. A term matches the start of any word of a user's name or email,
+// regardless of case. A query without terms matches no one.
+type Searcher interface {
+	SearchUsers(ctx context.Context, params SearchUsersParams) ([]SearchResult, string, error)
+}
+
+// SearchUsersParams controls which page of search results a store returns.
+type SearchUsersParams struct {
+	Query     string
+	PageSize  int32
+	PageToken string
+}
+
+// Limit returns the page size clamped as ListUsersParams.Limit does.
+func (p SearchUsersParams) Limit() int32 {
+	return ListUsersParams{PageSize: p.PageSize}.Limit()
+}
+
+// SearchResult is a user matching a search.
+type SearchResult struct {
+	User *pb.User
+	// Score ranks results within one search; higher is a better match.
+	Score float64
+	// NameHighlight and EmailHighlight are the user's name and email with
+	// the words that matched wrapped in HighlightStart and HighlightEnd.
+	NameHighlight  string
+	EmailHighlight string
+}
+
+// SearchTerms splits query into the lowercased words it searches for. Words
+// are runs of letters and digits, so punctuation such as the "@" of an email
+// separates them.
+func SearchTerms(query string) []string {
+	return strings.FieldsFunc(strings.ToLower(query), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+}
+
+// searchCursor is the position encoded into search page tokens. Results are
+// ranked rather than keyed, so the position is an offset, which is only
+// meaningful for the query it was returned for.
+type searchCursor struct {
+	Query  string `json:"q"`
+	Offset int    `json:"o"`
+}
+
+// EncodeSearchPageToken returns the token for the page of query's results
+// starting at offset.
+func EncodeSearchPageToken(query string, offset int) (string, error) {
+	return EncodePageToken(searchCursor{Query: query, Offset: offset})
+}
+
+// DecodeSearchPageToken returns the offset held by a token from
+// EncodeSearchPageToken, which is zero for an empty token. A token returned
+// for another query is reported as ErrInvalidPageToken.
+func DecodeSearchPageToken(token, query string) (int, error) {
+	if token == "" {
+		return 0, nil
+	}
+
+	var cursor searchCursor
+	if err := DecodePageToken(token, &cursor); err != nil {
+		return 0, err
+	}
+	if cursor.Query != query {
+		return 0, fmt.Errorf("%w: token is for another query", ErrInvalidPageToken)
+	}
+	if cursor.Offset < 0 {
+		return 0, fmt.Errorf("%w: negative offset", ErrInvalidPageToken)
+	}
+
+	return cursor.Offset, nil
+}
@@ -0,0 +1,192 @@
+package search
+
+import (
+	"cmp"
+	"context"
+	"slices"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+
+	"google.golang.org/protobuf/proto"
+
+	pb "github.com/andrew-womeldorf/connect-boilerplate/gen/user/v1"
+	"github.com/andrew-womeldorf/connect-boilerplate/internal/services/user/store"
+)
+
+// Matches in a name count for more than matches in an email.
+const (
+	nameWeight  = 2
+	emailWeight = 1
+)
+
+// MemoryIndex is an Index held in memory, which scans every user on each
+// search. It is safe for concurrent use. It only sees the writes of its own
+// process, so suits a single server; several should share an external Index
+// instead.
+type MemoryIndex struct {
+	mu sync.RWMutex
+	// users maps each tenant to its users by id.
+	users map[string]map[string]*pb.User
+}
+
+var _ Index = (*MemoryIndex)(nil)
+
+func NewMemoryIndex() *MemoryIndex {
+	return &MemoryIndex{
+		users: make(map[string]map[string]*pb.User),
+	}
+}
+
+// Put indexes user unless a later version of it is already indexed, so that
+// concurrent writes of one user may be indexed in any order.
+func (idx *MemoryIndex) Put(ctx context.Context, user *pb.User) error {
+	tenant := store.TenantFromContext(ctx)
+
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	users, ok := idx.users[tenant]
+	if !ok {
+		users = make(map[string]*pb.User)
+		idx.users[tenant] = users
+	}
+	if existing, ok := users[user.GetId()]; ok && existing.GetVersion() > user.GetVersion() {
+		return nil
+	}
+	users[user.GetId()] = proto.CloneOf(user)
+	return nil
+}
+
+func (idx *MemoryIndex) Delete(ctx context.Context, id string) error {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	delete(idx.users[store.TenantFromContext(ctx)], id)
+	return nil
+}
+
+// Search scores each user by how much of the words that the terms match
+// they cover, weighting names above emails.
+func (idx *MemoryIndex) Search(ctx context.Context, params store.SearchUsersParams) ([]store.SearchResult, string, error) {
+	offset, err := store.DecodeSearchPageToken(params.PageToken, params.Query)
+	if err != nil {
+		return nil, "", err
+	}
+
+	terms := store.SearchTerms(params.Query)
+	if len(terms) == 0 {
+		return []store.SearchResult{}, "", nil
+	}
+
+	idx.mu.RLock()
+	var matched []store.SearchResult
+	for _, user := range idx.users[store.TenantFromContext(ctx)] {
+		if score, ok := scoreUser(terms, user); ok {
+			matched = append(matched, store.SearchResult{User: user, Score: score})
+		}
+	}
+	idx.mu.RUnlock()
+
+	slices.SortFunc(matched, func(a, b store.SearchResult) int {
+		return cmp.Or(
+			cmp.Compare(b.Score, a.Score),
+			cmp.Compare(a.User.GetId(), b.User.GetId()),
+		)
+	})
+
+	limit := int(params.Limit())
+	page := matched[min(offset, len(matched)):min(offset+limit, len(matched))]
+
+	var nextPageToken string
+	if offset+limit < len(matched) {
+		nextPageToken, err = store.EncodeSearchPageToken(params.Query, offset+limit)
+		if err != nil {
+			return nil, "", store.Internal(err)
+		}
+	}
+
+	results := make([]store.SearchResult, 0, len(page))
+	for _, result := range page {
+		user := proto.CloneOf(result.User)
+		results = append(results, store.SearchResult{
+			User:           user,
+			Score:          result.Score,
+			NameHighlight:  highlight(user.GetName(), terms),
+			EmailHighlight: highlight(user.GetEmail(), terms),
+		})
+	}
+
+	return results, nextPageToken, nil
+}
+
+// scoreUser scores user against terms, reporting whether every term matches
+// a word of its name or email.
+func scoreUser(terms []string, user *pb.User) (float64, bool) {
+	names := store.SearchTerms(user.GetName())
+	emails := store.SearchTerms(user.GetEmail())
+
+	var total float64
+	for _, term := range terms {
+		score := nameWeight*scoreTerm(term, names) + emailWeight*scoreTerm(term, emails)
+		if score == 0 {
+			return 0, false
+		}
+		total += score
+	}
+
+	return total, true
+}
+
+// scoreTerm is the largest fraction of a word in words that term is a
+// prefix of, which is 1 for a whole word.
+func scoreTerm(term string, words []string) float64 {
+	var best float64
+	for _, word := range words {
+		if strings.HasPrefix(word, term) {
+			best = max(best, float64(len(term))/float64(len(word)))
+		}
+	}
+	return best
+}
+
+// highlight wraps the words of text that start with any of terms in
+// store.HighlightStart and store.HighlightEnd, splitting words as
+// store.SearchTerms does.
+func highlight(text string, terms []string) string {
+	var b strings.Builder
+
+	start := -1
+	flush := func(end int) {
+		word := text[start:end]
+		lower := strings.ToLower(word)
+		if slices.ContainsFunc(terms, func(term string) bool { return strings.HasPrefix(lower, term) }) {
+			b.WriteString(store.HighlightStart + word + store.HighlightEnd)
+		} else {
+			b.WriteString(word)
+		}
+		start = -1
+	}
+
+	for i := 0; i < len(text); {
+		r, size := utf8.DecodeRuneInString(text[i:])
+		switch {
+		case unicode.IsLetter(r) || unicode.IsNumber(r):
+			if start < 0 {
+				start = i
+			}
+		default:
+			if start >= 0 {
+				flush(i)
+			}
+			b.WriteString(text[i : i+size])
+		}
+		i += size
+	}
+	if start >= 0 {
+		flush(len(text))
+	}
+
+	return b.String()
+}
@@ -0,0 +1,207 @@
+// Package search adds full-text search to stores that cannot search users
+// natively. A Store wraps one, answering SearchUsers from a pluggable Index
+// that it keeps up to date as users are written through it.
+package search
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+
+	pb "github.com/andrew-womeldorf/connect-boilerplate/gen/user/v1"
+	"github.com/andrew-womeldorf/connect-boilerplate/internal/services/user/store"
+)
+
+// Index is a full-text index of users, partitioned by tenant as stores are:
+// each method acts on the users of the tenant of its context. Search has the
+// semantics of store.Searcher.
+type Index interface {
+	// Put adds user to the index, replacing any user with its id.
+	Put(ctx context.Context, user *pb.User) error
+	// Delete removes the user with id from the index, if it is there.
+	Delete(ctx context.Context, id string) error
+	Search(ctx context.Context, params store.SearchUsersParams) ([]store.SearchResult, string, error)
+}
+
+// Store is a store.Store that searches users through an Index. Writes made
+// through the Store are indexed once they succeed; writes made any other
+// way, such as by another process, are only indexed by Rebuild.
+//
+// Failing to index a write is logged rather than returned, since the write
+// itself has succeeded; the user is searched as it was until it is written
+// again or the index is rebuilt.
+//
+// Stores cannot list their tenants, so each tenant's users are indexed the
+// first time the tenant searches.
+type Store struct {
+	store.Store
+	index Index
+
+	mu sync.Mutex
+	// indexed holds the tenants whose users have been indexed.
+	indexed map[string]bool
+}
+
+var _ store.Searcher = (*Store)(nil)
+
+// New wraps s, searching its users through index. The users s already has
+// are indexed by the first search of each tenant, not here, so opening the
+// store does not read every user.
+func New(s store.Store, index Index) *Store {
+	return &Store{Store: s, index: index, indexed: make(map[string]bool)}
+}
+
+// Rebuild indexes every user of the tenant of ctx, reading them a page at a
+// time.
+func (s *Store) Rebuild(ctx context.Context) error {
+	if err := s.rebuild(ctx); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.indexed[store.TenantFromContext(ctx)] = true
+	return nil
+}
+
+func (s *Store) rebuild(ctx context.Context) error {
+	params := store.ListUsersParams{PageSize: store.MaxPageSize}
+	for {
+		users, nextPageToken, err := s.Store.ListUsers(ctx, params)
+		if err != nil {
+			return err
+		}
+
+		for _, user := range users {
+			if err := s.index.Put(ctx, user); err != nil {
+				return err
+			}
+		}
+
+		if nextPageToken == "" {
+			return nil
+		}
+		params.PageToken = nextPageToken
+	}
+}
+
+func (s *Store) SearchUsers(ctx context.Context, params store.SearchUsersParams) ([]store.SearchResult, string, error) {
+	if err := s.ensureIndexed(ctx); err != nil {
+		return nil, "", err
+	}
+	return s.index.Search(ctx, params)
+}
+
+// ensureIndexed indexes the users of the tenant of ctx unless they already
+// are. Searches of the tenant wait for it, and searches of other tenants
+// being indexed for the first time wait their turn.
+func (s *Store) ensureIndexed(ctx context.Context) error {
+	tenant := store.TenantFromContext(ctx)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.indexed[tenant] {
+		return nil
+	}
+	if err := s.rebuild(ctx); err != nil {
+		return err
+	}
+	s.indexed[tenant] = true
+	return nil
+}
+
+func (s *Store) CreateUser(ctx context.Context, user *pb.User) error {
+	if err := s.Store.CreateUser(ctx, user); err != nil {
+		return err
+	}
+
+	s.put(ctx, created(user))
+	return nil
+}
+
+func (s *Store) UpdateUser(ctx context.Context, user *pb.User, mask store.FieldMask, expectedVersion int64) (*pb.User, error) {
+	updated, err := s.Store.UpdateUser(ctx, user, mask, expectedVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	s.put(ctx, updated)
+	return updated, nil
+}
+
+func (s *Store) DeleteUser(ctx context.Context, id string, expectedVersion int64, deletedAt time.Time) error {
+	if err := s.Store.DeleteUser(ctx, id, expectedVersion, deletedAt); err != nil {
+		return err
+	}
+
+	s.delete(ctx, id)
+	return nil
+}
+
+func (s *Store) UndeleteUser(ctx context.Context, id string, expectedVersion int64, undeletedAt time.Time) (*pb.User, error) {
+	user, err := s.Store.UndeleteUser(ctx, id, expectedVersion, undeletedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	s.put(ctx, user)
+	return user, nil
+}
+
+func (s *Store) BatchCreateUsers(ctx context.Context, users []*pb.User) ([]store.BatchResult, error) {
+	results, err := s.Store.BatchCreateUsers(ctx, users)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, result := range results {
+		if result.Err == nil {
+			s.put(ctx, created(users[i]))
+		}
+	}
+	return results, nil
+}
+
+func (s *Store) BatchDeleteUsers(ctx context.Context, deletes []store.BatchDelete, deletedAt time.Time) ([]store.BatchResult, error) {
+	results, err := s.Store.BatchDeleteUsers(ctx, deletes, deletedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, result := range results {
+		if result.Err == nil {
+			s.delete(ctx, deletes[i].ID)
+		}
+	}
+	return results, nil
+}
+
+func (s *Store) put(ctx context.Context, user *pb.User) {
+	if err := s.index.Put(ctx, user); err != nil {
+		slog.ErrorContext(ctx, "could not index user",
+			slog.Any("error", err),
+			slog.String("user id", user.GetId()),
+		)
+	}
+}
+
+func (s *Store) delete(ctx context.Context, id string) {
+	if err := s.index.Delete(ctx, id); err != nil {
+		slog.ErrorContext(ctx, "could not remove user from index",
+			slog.Any("error", err),
+			slog.String("user id", id),
+		)
+	}
+}
+
+// created returns user as a store holds it once created.
+func created(user *pb.User) *pb.User {
+	user = proto.CloneOf(user)
+	user.Version = store.FirstVersion
+	return user
+}
@@ -0,0 +1,74 @@
+package search
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	pb "github.com/andrew-womeldorf/connect-boilerplate/gen/user/v1"
+	"github.com/andrew-womeldorf/connect-boilerplate/internal/services/user/store"
+	"github.com/andrew-womeldorf/connect-boilerplate/internal/services/user/store/memory"
+	"github.com/andrew-womeldorf/connect-boilerplate/internal/services/user/store/storetest"
+)
+
+func TestMemoryIndex(t *testing.T) {
+	storetest.RunSearch(t, func(t *testing.T) storetest.SearchableStore {
+		return New(memory.NewStore(), NewMemoryIndex())
+	})
+}
+
+func TestSearchIndexesExistingUsers(t *testing.T) {
+	ctx := context.Background()
+
+	backend := memory.NewStore()
+	for _, name := range []string{"Jon Smith", "Jane Doe"} {
+		now := timestamppb.New(time.Now())
+		user := &pb.User{Id: name, Name: name, Email: name + "@example.com", CreatedAt: now, UpdatedAt: now}
+		if err := backend.CreateUser(ctx, user); err != nil {
+			t.Fatalf("failed to create user: %v", err)
+		}
+	}
+
+	s := New(backend, NewMemoryIndex())
+
+	results, _, err := s.SearchUsers(ctx, store.SearchUsersParams{Query: "jane"})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if len(results) != 1 || results[0].User.GetId() != "Jane Doe" {
+		t.Errorf("expected Jane Doe, got %v", results)
+	}
+}
+
+func TestSearchIndexesEachTenant(t *testing.T) {
+	ctx := context.Background()
+	acme := store.WithTenant(ctx, "acme")
+
+	backend := memory.NewStore()
+	now := timestamppb.New(time.Now())
+	user := &pb.User{Id: "user-1", Name: "Jane Doe", Email: "jane@example.com", CreatedAt: now, UpdatedAt: now}
+	if err := backend.CreateUser(acme, user); err != nil {
+		t.Fatalf("failed to create user: %v", err)
+	}
+
+	s := New(backend, NewMemoryIndex())
+
+	results, _, err := s.SearchUsers(ctx, store.SearchUsersParams{Query: "jane"})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if len(results) != 0 {
+		t.Errorf("expected no results in the default tenant, got %v", results)
+	}
+
+	// The tenant's users are indexed by its first search.
+	results, _, err = s.SearchUsers(acme, store.SearchUsersParams{Query: "jane"})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if len(results) != 1 || results[0].User.GetId() != "user-1" {
+		t.Errorf("expected user-1, got %v", results)
+	}
+}
@@ -0,0 +1,186 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/andrew-womeldorf/connect-boilerplate/internal/services/user/store/migrate"
+)
+
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+const createMigrationsTable = `CREATE TABLE IF NOT EXISTS schema_migrations (
+    version integer PRIMARY KEY,
+    name text NOT NULL,
+    applied_at text NOT NULL
+)`
+
+// Migrator applies the embedded migrations to a sqlite database. Every
+// operation runs inside a single BEGIN IMMEDIATE transaction, which takes
+// sqlite's write lock and so serializes migrators across processes.
+type Migrator struct {
+	db         *sql.DB
+	migrations []migrate.Migration
+}
+
+var _ migrate.Migrator = (*Migrator)(nil)
+
+func NewMigrator(db *sql.DB) (*Migrator, error) {
+	migrations, err := migrate.Load(migrationFiles, "migrations")
+	if err != nil {
+		return nil, err
+	}
+
+	return &Migrator{db: db, migrations: migrations}, nil
+}
+
+// Migrations returns every known migration in version order.
+func (m *Migrator) Migrations() []migrate.Migration {
+	return m.migrations
+}
+
+func (m *Migrator) Status(ctx context.Context) ([]migrate.Status, error) {
+	var statuses []migrate.Status
+	err := m.withLock(ctx, func(conn *sql.Conn) error {
+		applied, err := appliedMigrations(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		statuses = migrate.Statuses(m.migrations, applied)
+		return nil
+	})
+
+	return statuses, err
+}
+
+func (m *Migrator) Up(ctx context.Context) error {
+	return m.withLock(ctx, func(conn *sql.Conn) error {
+		applied, err := appliedMigrations(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		pending, err := migrate.Pending(m.migrations, applied)
+		if err != nil {
+			return err
+		}
+
+		for _, mig := range pending {
+			slog.InfoContext(ctx, "applying migration",
+				slog.Int("version", mig.Version),
+				slog.String("name", mig.Name),
+			)
+
+			if _, err := conn.ExecContext(ctx, mig.Up); err != nil {
+				return fmt.Errorf("migration %d_%s: %w", mig.Version, mig.Name, err)
+			}
+
+			if _, err := conn.ExecContext(ctx,
+				"INSERT INTO schema_migrations (version, name, applied_at) VALUES (?, ?, ?)",
+				mig.Version, mig.Name, time.Now().UTC().Format(time.RFC3339),
+			); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+func (m *Migrator) Down(ctx context.Context, to int) error {
+	return m.withLock(ctx, func(conn *sql.Conn) error {
+		applied, err := appliedMigrations(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		reverts, err := migrate.Reverts(m.migrations, applied, to)
+		if err != nil {
+			return err
+		}
+
+		for _, mig := range reverts {
+			slog.InfoContext(ctx, "reverting migration",
+				slog.Int("version", mig.Version),
+				slog.String("name", mig.Name),
+			)
+
+			if _, err := conn.ExecContext(ctx, mig.Down); err != nil {
+				return fmt.Errorf("migration %d_%s: %w", mig.Version, mig.Name, err)
+			}
+
+			if _, err := conn.ExecContext(ctx,
+				"DELETE FROM schema_migrations WHERE version = ?", mig.Version,
+			); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// withLock runs fn on a dedicated connection inside an immediate transaction,
+// committing if fn succeeds and rolling back otherwise.
+func (m *Migrator) withLock(ctx context.Context, fn func(*sql.Conn) error) (err error) {
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("%w: %w", migrate.ErrCouldNotMigrate, err)
+	}
+	defer func() { _ = conn.Close() }()
+
+	if _, err := conn.ExecContext(ctx, "BEGIN IMMEDIATE"); err != nil {
+		return fmt.Errorf("%w: %w", migrate.ErrCouldNotMigrate, err)
+	}
+
+	defer func() {
+		if err != nil {
+			if _, rbErr := conn.ExecContext(context.WithoutCancel(ctx), "ROLLBACK"); rbErr != nil {
+				slog.ErrorContext(ctx, "could not roll back migration", slog.Any("error", rbErr))
+			}
+			err = fmt.Errorf("%w: %w", migrate.ErrCouldNotMigrate, err)
+			return
+		}
+
+		if _, err = conn.ExecContext(ctx, "COMMIT"); err != nil {
+			err = fmt.Errorf("%w: %w", migrate.ErrCouldNotMigrate, err)
+		}
+	}()
+
+	if _, err := conn.ExecContext(ctx, createMigrationsTable); err != nil {
+		return err
+	}
+
+	return fn(conn)
+}
+
+func appliedMigrations(ctx context.Context, conn *sql.Conn) (map[int]time.Time, error) {
+	rows, err := conn.QueryContext(ctx, "SELECT version, applied_at FROM schema_migrations")
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	applied := make(map[int]time.Time)
+	for rows.Next() {
+		var version int
+		var appliedAt string
+		if err := rows.Scan(&version, &appliedAt); err != nil {
+			return nil, err
+		}
+
+		t, err := time.Parse(time.RFC3339, appliedAt)
+		if err != nil {
+			return nil, fmt.Errorf("could not parse applied at timestamp for migration %d: %w", version, err)
+		}
+		applied[version] = t
+	}
+
+	return applied, rows.Err()
+}
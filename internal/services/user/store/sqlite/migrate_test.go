@@ -0,0 +1,81 @@
+package sqlite
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/andrew-womeldorf/connect-boilerplate/internal/services/user/store/migrate"
+)
+
+func TestMigrator(t *testing.T) {
+	ctx := context.Background()
+
+	s, err := NewStore(ctx, filepath.Join(t.TempDir(), "test.db"), WithoutMigrations())
+	if err != nil {
+		t.Fatalf("failed to open store: %v", err)
+	}
+	defer func() { _ = s.Close() }()
+
+	m, err := s.Migrator()
+	if err != nil {
+		t.Fatalf("failed to load migrations: %v", err)
+	}
+
+	hasUsersTable := func() bool {
+		var n int
+		err := s.db.QueryRowContext(ctx,
+			"SELECT count(*) FROM sqlite_master WHERE type = 'table' AND name = 'users'",
+		).Scan(&n)
+		if err != nil {
+			t.Fatalf("failed to inspect schema: %v", err)
+		}
+		return n == 1
+	}
+
+	t.Run("up", func(t *testing.T) {
+		// Applying twice must be a no-op the second time.
+		for range 2 {
+			if err := m.Up(ctx); err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+		}
+
+		if !hasUsersTable() {
+			t.Fatal("expected users table after migrating up")
+		}
+
+		statuses, err := m.Status(ctx)
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		for _, status := range statuses {
+			if !status.Applied {
+				t.Errorf("expected migration %d to be applied", status.Version)
+			}
+		}
+	})
+
+	t.Run("down", func(t *testing.T) {
+		if err := m.Down(ctx, 0); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+
+		if hasUsersTable() {
+			t.Fatal("expected no users table after migrating down")
+		}
+	})
+
+	t.Run("unknown_version", func(t *testing.T) {
+		if _, err := s.db.ExecContext(ctx,
+			"INSERT INTO schema_migrations (version, name, applied_at) VALUES (9999, 'future', '2030-01-01T00:00:00Z')",
+		); err != nil {
+			t.Fatalf("failed to record future migration: %v", err)
+		}
+
+		if err := m.Up(ctx); !errors.Is(err, migrate.ErrUnknownSchemaVersion) {
+			t.Fatalf("expected ErrUnknownSchemaVersion, got %v", err)
+		}
+	})
+}
@@ -0,0 +1,3 @@
+UPDATE users SET
+    created_at = strftime('%Y-%m-%dT%H:%M:%SZ', created_at),
+    updated_at = strftime('%Y-%m-%dT%H:%M:%SZ', updated_at);
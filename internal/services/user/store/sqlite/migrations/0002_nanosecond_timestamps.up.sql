@@ -0,0 +1,9 @@
+-- Rewrite second precision RFC 3339 timestamps into the fixed width,
+-- nanosecond precision UTC form the store now writes.
+UPDATE users SET
+    created_at = strftime('%Y-%m-%dT%H:%M:%S', created_at) || '.000000000Z'
+WHERE created_at NOT LIKE '%.%';
+
+UPDATE users SET
+    updated_at = strftime('%Y-%m-%dT%H:%M:%S', updated_at) || '.000000000Z'
+WHERE updated_at NOT LIKE '%.%';
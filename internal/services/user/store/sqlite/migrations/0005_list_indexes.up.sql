@@ -0,0 +1,3 @@
+-- ListUsers pages through users in keyset order by name or by created_at.
+CREATE INDEX IF NOT EXISTS users_name_id_idx ON users (name, id);
+CREATE INDEX IF NOT EXISTS users_created_at_id_idx ON users (created_at, id);
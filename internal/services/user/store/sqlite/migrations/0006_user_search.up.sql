@@ -0,0 +1,24 @@
+-- users_fts is the full-text index SearchUsers matches against. It keeps its
+-- own copy of each user's name and email, joined back to users by id, since
+-- the rowids of users may change on VACUUM. The triggers keep it in sync.
+CREATE VIRTUAL TABLE IF NOT EXISTS users_fts USING fts5(
+    id UNINDEXED,
+    name,
+    email,
+    tokenize = 'unicode61 remove_diacritics 2',
+    prefix = '2 3'
+);
+
+CREATE TRIGGER IF NOT EXISTS users_fts_insert AFTER INSERT ON users BEGIN
+    INSERT INTO users_fts (id, name, email) VALUES (new.id, new.name, new.email);
+END;
+
+CREATE TRIGGER IF NOT EXISTS users_fts_update AFTER UPDATE OF name, email ON users BEGIN
+    UPDATE users_fts SET name = new.name, email = new.email WHERE id = old.id;
+END;
+
+CREATE TRIGGER IF NOT EXISTS users_fts_delete AFTER DELETE ON users BEGIN
+    DELETE FROM users_fts WHERE id = old.id;
+END;
+
+INSERT INTO users_fts (id, name, email) SELECT id, name, email FROM users;
@@ -0,0 +1,7 @@
+-- outbox holds the events recorded with each change to users, in the order
+-- they were recorded, until a relay has delivered them.
+CREATE TABLE IF NOT EXISTS outbox (
+    seq INTEGER PRIMARY KEY AUTOINCREMENT,
+    id TEXT NOT NULL UNIQUE,
+    event BLOB NOT NULL
+);
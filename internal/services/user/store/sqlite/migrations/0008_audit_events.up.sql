@@ -0,0 +1,12 @@
+-- audit_events keeps the audit event recorded with each change to users.
+-- occurred_at is in the same fixed width form as the users' timestamps, so
+-- it sorts as a string in time order.
+CREATE TABLE IF NOT EXISTS audit_events (
+    id TEXT PRIMARY KEY,
+    user_id TEXT NOT NULL,
+    occurred_at TEXT NOT NULL,
+    event BLOB NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS audit_events_occurred_at ON audit_events (occurred_at, id);
+CREATE INDEX IF NOT EXISTS audit_events_user_id ON audit_events (user_id, occurred_at, id);
@@ -0,0 +1,53 @@
+-- Merging the tenants fails if two of them have a user with the same id or
+-- email; resolve those before migrating.
+DROP INDEX IF EXISTS audit_events_user_id;
+DROP INDEX IF EXISTS audit_events_occurred_at;
+ALTER TABLE audit_events DROP COLUMN tenant_id;
+CREATE INDEX IF NOT EXISTS audit_events_occurred_at ON audit_events (occurred_at, id);
+CREATE INDEX IF NOT EXISTS audit_events_user_id ON audit_events (user_id, occurred_at, id);
+
+DROP TRIGGER IF EXISTS users_fts_delete;
+DROP TRIGGER IF EXISTS users_fts_update;
+DROP TRIGGER IF EXISTS users_fts_insert;
+DROP TABLE IF EXISTS users_fts;
+
+CREATE TABLE users_untenanted (
+    id text PRIMARY KEY,
+    name text NOT NULL,
+    email text NOT NULL,
+    created_at text NOT NULL,
+    updated_at text NOT NULL,
+    version integer NOT NULL DEFAULT 1
+);
+
+INSERT INTO users_untenanted (id, name, email, created_at, updated_at, version)
+SELECT id, name, email, created_at, updated_at, version FROM users;
+
+DROP TABLE users;
+ALTER TABLE users_untenanted RENAME TO users;
+
+CREATE UNIQUE INDEX IF NOT EXISTS users_email_idx ON users (lower(email));
+CREATE INDEX IF NOT EXISTS users_name_id_idx ON users (name, id);
+CREATE INDEX IF NOT EXISTS users_created_at_id_idx ON users (created_at, id);
+
+CREATE VIRTUAL TABLE IF NOT EXISTS users_fts USING fts5(
+    id UNINDEXED,
+    name,
+    email,
+    tokenize = 'unicode61 remove_diacritics 2',
+    prefix = '2 3'
+);
+
+CREATE TRIGGER IF NOT EXISTS users_fts_insert AFTER INSERT ON users BEGIN
+    INSERT INTO users_fts (id, name, email) VALUES (new.id, new.name, new.email);
+END;
+
+CREATE TRIGGER IF NOT EXISTS users_fts_update AFTER UPDATE OF name, email ON users BEGIN
+    UPDATE users_fts SET name = new.name, email = new.email WHERE id = old.id;
+END;
+
+CREATE TRIGGER IF NOT EXISTS users_fts_delete AFTER DELETE ON users BEGIN
+    DELETE FROM users_fts WHERE id = old.id;
+END;
+
+INSERT INTO users_fts (id, name, email) SELECT id, name, email FROM users;
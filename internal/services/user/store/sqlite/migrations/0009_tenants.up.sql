@@ -0,0 +1,60 @@
+-- Users are partitioned by tenant, and existing users and audit events
+-- belong to the default tenant. Ids are only unique within a tenant, and
+-- sqlite cannot change a primary key in place, so users is rebuilt, taking
+-- its indexes and the full-text index's triggers with it.
+DROP TRIGGER IF EXISTS users_fts_delete;
+DROP TRIGGER IF EXISTS users_fts_update;
+DROP TRIGGER IF EXISTS users_fts_insert;
+DROP TABLE IF EXISTS users_fts;
+
+CREATE TABLE users_by_tenant (
+    tenant_id text NOT NULL,
+    id text NOT NULL,
+    name text NOT NULL,
+    email text NOT NULL,
+    created_at text NOT NULL,
+    updated_at text NOT NULL,
+    version integer NOT NULL DEFAULT 1,
+    PRIMARY KEY (tenant_id, id)
+);
+
+INSERT INTO users_by_tenant (tenant_id, id, name, email, created_at, updated_at, version)
+SELECT 'default', id, name, email, created_at, updated_at, version FROM users;
+
+DROP TABLE users;
+ALTER TABLE users_by_tenant RENAME TO users;
+
+CREATE UNIQUE INDEX IF NOT EXISTS users_email_idx ON users (tenant_id, lower(email));
+CREATE INDEX IF NOT EXISTS users_name_id_idx ON users (tenant_id, name, id);
+CREATE INDEX IF NOT EXISTS users_created_at_id_idx ON users (tenant_id, created_at, id);
+
+CREATE VIRTUAL TABLE IF NOT EXISTS users_fts USING fts5(
+    tenant_id UNINDEXED,
+    id UNINDEXED,
+    name,
+    email,
+    tokenize = 'unicode61 remove_diacritics 2',
+    prefix = '2 3'
+);
+
+CREATE TRIGGER IF NOT EXISTS users_fts_insert AFTER INSERT ON users BEGIN
+    INSERT INTO users_fts (tenant_id, id, name, email) VALUES (new.tenant_id, new.id, new.name, new.email);
+END;
+
+CREATE TRIGGER IF NOT EXISTS users_fts_update AFTER UPDATE OF name, email ON users BEGIN
+    UPDATE users_fts SET name = new.name, email = new.email
+    WHERE tenant_id = old.tenant_id AND id = old.id;
+END;
+
+CREATE TRIGGER IF NOT EXISTS users_fts_delete AFTER DELETE ON users BEGIN
+    DELETE FROM users_fts WHERE tenant_id = old.tenant_id AND id = old.id;
+END;
+
+INSERT INTO users_fts (tenant_id, id, name, email) SELECT tenant_id, id, name, email FROM users;
+
+ALTER TABLE audit_events ADD COLUMN tenant_id text NOT NULL DEFAULT 'default';
+
+DROP INDEX IF EXISTS audit_events_occurred_at;
+DROP INDEX IF EXISTS audit_events_user_id;
+CREATE INDEX IF NOT EXISTS audit_events_occurred_at ON audit_events (tenant_id, occurred_at, id);
+CREATE INDEX IF NOT EXISTS audit_events_user_id ON audit_events (tenant_id, user_id, occurred_at, id);
@@ -0,0 +1,9 @@
+-- Deleting a user only sets its deleted_at until it is purged. A deleted
+-- user frees its email, so only users that are not deleted need unique ones.
+ALTER TABLE users ADD COLUMN deleted_at text;
+
+DROP INDEX IF EXISTS users_email_idx;
+CREATE UNIQUE INDEX IF NOT EXISTS users_email_idx ON users (tenant_id, lower(email)) WHERE deleted_at IS NULL;
+
+-- Purging looks for the users deleted longest ago, of every tenant.
+CREATE INDEX IF NOT EXISTS users_deleted_at_idx ON users (deleted_at) WHERE deleted_at IS NOT NULL;
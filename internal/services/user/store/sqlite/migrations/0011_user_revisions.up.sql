@@ -0,0 +1,13 @@
+-- user_revisions keeps the most recent revisions of each user, numbered by
+-- the version each left the user at. revised_at is in the same fixed width
+-- form as the users' timestamps, so it sorts as a string in time order.
+CREATE TABLE IF NOT EXISTS user_revisions (
+    tenant_id TEXT NOT NULL,
+    user_id TEXT NOT NULL,
+    version INTEGER NOT NULL,
+    revised_at TEXT NOT NULL,
+    revision BLOB NOT NULL,
+    PRIMARY KEY (tenant_id, user_id, version)
+);
+
+CREATE INDEX IF NOT EXISTS user_revisions_revised_at ON user_revisions (tenant_id, user_id, revised_at, version);
@@ -0,0 +1,15 @@
+-- idempotency_keys keeps a record of each request made with an idempotency
+-- key until it expires: a fingerprint of the request, and once it has been
+-- answered, its response. expires_at is in the same fixed width form as the
+-- users' timestamps, so expired records can be found by comparing strings.
+CREATE TABLE IF NOT EXISTS idempotency_keys (
+    tenant_id TEXT NOT NULL,
+    idempotency_key TEXT NOT NULL,
+    fingerprint TEXT NOT NULL,
+    completed BOOLEAN NOT NULL DEFAULT FALSE,
+    response BLOB,
+    expires_at TEXT NOT NULL,
+    PRIMARY KEY (tenant_id, idempotency_key)
+);
+
+CREATE INDEX IF NOT EXISTS idempotency_keys_expires_at ON idempotency_keys (expires_at);
@@ -0,0 +1,84 @@
+package sqlite
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/andrew-womeldorf/connect-boilerplate/internal/services/user/store"
+)
+
+func init() {
+	store.Register("sqlite", Open)
+}
+
+var journalModes = []string{"delete", "truncate", "persist", "memory", "wal", "off"}
+
+// Open is the store.Factory for sqlite URLs:
+//
+//	sqlite:///var/lib/app.db?journal=wal   absolute path
+//	sqlite:app.db                          relative path
+//	sqlite::memory:                        in-memory database
+//
+// Supported options are journal (a journal_mode), busy_timeout (milliseconds)
+// and migrate (set to false to skip applying migrations on open).
+func Open(ctx context.Context, u *url.URL) (store.Store, error) {
+	// Unlike Path, Opaque is not unescaped by url.Parse.
+	path, err := url.PathUnescape(u.Opaque)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", store.ErrInvalidURL, err)
+	}
+	if path == "" {
+		path = u.Host + u.Path
+	}
+	if path == "" {
+		return nil, fmt.Errorf("%w: sqlite url has no database path", store.ErrInvalidURL)
+	}
+	// A '?' would start the query of the driver's DSN, so such a path is
+	// given as a file: URI instead, which SQLite unescapes.
+	if strings.Contains(path, "?") {
+		path = "file:" + (&url.URL{Path: path}).EscapedPath()
+	}
+
+	opts, err := store.URLOptions(u, "journal", "busy_timeout", "migrate")
+	if err != nil {
+		return nil, err
+	}
+
+	var storeOpts []Option
+
+	if mode, ok := opts["journal"]; ok {
+		if !slices.Contains(journalModes, mode) {
+			return nil, fmt.Errorf("%w: unknown sqlite journal mode %q", store.ErrInvalidURL, mode)
+		}
+		storeOpts = append(storeOpts, WithJournalMode(mode))
+	}
+
+	if v, ok := opts["busy_timeout"]; ok {
+		ms, err := strconv.Atoi(v)
+		if err != nil || ms < 0 {
+			return nil, fmt.Errorf("%w: sqlite busy_timeout must be a number of milliseconds, got %q", store.ErrInvalidURL, v)
+		}
+		storeOpts = append(storeOpts, WithBusyTimeout(time.Duration(ms)*time.Millisecond))
+	}
+
+	if v, ok := opts["migrate"]; ok {
+		migrate, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, fmt.Errorf("%w: sqlite migrate must be a boolean, got %q", store.ErrInvalidURL, v)
+		}
+		if !migrate {
+			storeOpts = append(storeOpts, WithoutMigrations())
+		}
+	}
+
+	s, err := NewStore(ctx, path, storeOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
@@ -1,23 +1,213 @@
+-- Users whose deleted_at is set are deleted, and only read when asked for.
+
 -- name: GetUser :one
-SELECT * FROM users WHERE id = ? LIMIT 1;
+SELECT * FROM users WHERE tenant_id = @tenant_id AND id = @id AND deleted_at IS NULL LIMIT 1;
+
+-- name: GetDeletedUser :one
+SELECT * FROM users WHERE tenant_id = @tenant_id AND id = @id AND deleted_at IS NOT NULL LIMIT 1;
+
+-- name: GetUserByEmail :one
+SELECT * FROM users WHERE tenant_id = @tenant_id AND lower(email) = lower(@email) AND deleted_at IS NULL LIMIT 1;
+
+-- ListUsers* list the users of a tenant matching a filter in one order each,
+-- starting after a keyset cursor, which is empty for the first page. An empty
+-- filter field matches every user, and deleted users only match when
+-- show_deleted is set.
+
+-- name: ListUsersByName :many
+SELECT * FROM users
+WHERE tenant_id = @tenant_id
+    AND (name > @after_name OR (name = @after_name AND id > @after_id))
+    AND substr(name, 1, length(@name_prefix)) = @name_prefix
+    AND (@email_domain = '' OR substr(lower(email), -length(@email_domain) - 1) = '@' || @email_domain)
+    AND created_at >= @created_after
+    AND (@created_before = '' OR created_at < @created_before)
+    AND (@show_deleted OR deleted_at IS NULL)
+ORDER BY name, id
+LIMIT @limit;
+
+-- name: ListUsersByNameDesc :many
+SELECT * FROM users
+WHERE tenant_id = @tenant_id
+    AND (@after_id = '' OR name < @after_name OR (name = @after_name AND id < @after_id))
+    AND substr(name, 1, length(@name_prefix)) = @name_prefix
+    AND (@email_domain = '' OR substr(lower(email), -length(@email_domain) - 1) = '@' || @email_domain)
+    AND created_at >= @created_after
+    AND (@created_before = '' OR created_at < @created_before)
+    AND (@show_deleted OR deleted_at IS NULL)
+ORDER BY name DESC, id DESC
+LIMIT @limit;
+
+-- name: ListUsersByCreatedAt :many
+SELECT * FROM users
+WHERE tenant_id = @tenant_id
+    AND (created_at > @after_created_at OR (created_at = @after_created_at AND id > @after_id))
+    AND substr(name, 1, length(@name_prefix)) = @name_prefix
+    AND (@email_domain = '' OR substr(lower(email), -length(@email_domain) - 1) = '@' || @email_domain)
+    AND created_at >= @created_after
+    AND (@created_before = '' OR created_at < @created_before)
+    AND (@show_deleted OR deleted_at IS NULL)
+ORDER BY created_at, id
+LIMIT @limit;
+
+-- name: ListUsersByCreatedAtDesc :many
+SELECT * FROM users
+WHERE tenant_id = @tenant_id
+    AND (@after_id = '' OR created_at < @after_created_at OR (created_at = @after_created_at AND id < @after_id))
+    AND substr(name, 1, length(@name_prefix)) = @name_prefix
+    AND (@email_domain = '' OR substr(lower(email), -length(@email_domain) - 1) = '@' || @email_domain)
+    AND created_at >= @created_after
+    AND (@created_before = '' OR created_at < @created_before)
+    AND (@show_deleted OR deleted_at IS NULL)
+ORDER BY created_at DESC, id DESC
+LIMIT @limit;
+
+-- name: CountUsers :one
+SELECT count(*) FROM users
+WHERE tenant_id = @tenant_id
+    AND substr(name, 1, length(@name_prefix)) = @name_prefix
+    AND (@email_domain = '' OR substr(lower(email), -length(@email_domain) - 1) = '@' || @email_domain)
+    AND created_at >= @created_after
+    AND (@created_before = '' OR created_at < @created_before)
+    AND (@show_deleted OR deleted_at IS NULL);
+
+-- SearchUsers ranks name matches above email matches. bm25 is lower for
+-- better matches, and the tenant_id and id columns are not indexed so take no
+-- weight.
 
--- name: ListUsers :many
-SELECT * FROM users ORDER BY name;
+-- name: SearchUsers :many
+SELECT users.*,
+    highlight(users_fts, 2, '<mark>', '</mark>') AS name_highlight,
+    highlight(users_fts, 3, '<mark>', '</mark>') AS email_highlight,
+    bm25(users_fts, 0.0, 0.0, 2.0, 1.0) AS rank
+FROM users_fts
+JOIN users ON users.tenant_id = users_fts.tenant_id AND users.id = users_fts.id
+WHERE users_fts MATCH @query AND users_fts.tenant_id = @tenant_id AND users.deleted_at IS NULL
+ORDER BY rank, users.id
+LIMIT @limit OFFSET @offset;
 
 -- name: CreateUser :one
 INSERT INTO users (
-    id, name, email, created_at, updated_at
+    tenant_id, id, name, email, created_at, updated_at, version
 ) VALUES (
-    ?, ?, ?, ?, ?
+    ?, ?, ?, ?, ?, ?, 1
 ) RETURNING *;
 
 -- name: UpdateUser :one
 UPDATE users SET
-    name = ?,
-    email = ?,
-    updated_at = ?
-WHERE id = ?
+    name = coalesce(sqlc.narg(name), name),
+    email = coalesce(sqlc.narg(email), email),
+    updated_at = @updated_at,
+    version = version + 1
+WHERE tenant_id = @tenant_id AND id = @id AND deleted_at IS NULL
+    AND (version = @expected_version OR @expected_version = 0)
 RETURNING *;
 
 -- name: DeleteUser :one
-DELETE FROM users WHERE id = ? RETURNING *;
+UPDATE users SET
+    deleted_at = @deleted_at,
+    version = version + 1
+WHERE tenant_id = @tenant_id AND id = @id AND deleted_at IS NULL
+    AND (version = @expected_version OR @expected_version = 0)
+RETURNING *;
+
+-- name: UndeleteUser :one
+UPDATE users SET
+    deleted_at = NULL,
+    version = version + 1
+WHERE tenant_id = @tenant_id AND id = @id AND deleted_at IS NOT NULL
+    AND (version = @expected_version OR @expected_version = 0)
+RETURNING *;
+
+-- ListPurgeableUsers lists the users of every tenant deleted before a time,
+-- deleted longest ago first.
+
+-- name: ListPurgeableUsers :many
+SELECT * FROM users
+WHERE deleted_at < @deleted_before
+ORDER BY deleted_at
+LIMIT @limit;
+
+-- name: PurgeUser :exec
+DELETE FROM users WHERE tenant_id = @tenant_id AND id = @id AND deleted_at IS NOT NULL;
+
+-- name: InsertEvent :exec
+INSERT INTO outbox (id, event) VALUES (?, ?);
+
+-- name: ListEvents :many
+SELECT * FROM outbox ORDER BY seq LIMIT ?;
+
+-- name: DeleteEvent :exec
+DELETE FROM outbox WHERE id = ?;
+
+-- name: InsertAuditEvent :exec
+INSERT INTO audit_events (id, tenant_id, user_id, occurred_at, event) VALUES (?, ?, ?, ?, ?);
+
+-- ListAuditEvents lists the audit events of a tenant matching a filter,
+-- starting after a keyset cursor, which is empty for the first page. An empty
+-- filter field matches every event.
+
+-- name: ListAuditEvents :many
+SELECT * FROM audit_events
+WHERE tenant_id = @tenant_id
+    AND (occurred_at > @after_occurred_at OR (occurred_at = @after_occurred_at AND id > @after_id))
+    AND (@user_id = '' OR user_id = @user_id)
+    AND occurred_at >= @start_time
+    AND (@end_time = '' OR occurred_at < @end_time)
+ORDER BY occurred_at, id
+LIMIT @limit;
+
+-- name: InsertUserRevision :exec
+INSERT INTO user_revisions (tenant_id, user_id, version, revised_at, revision) VALUES (?, ?, ?, ?, ?);
+
+-- TrimUserRevisions drops the revisions of a user older than the oldest kept.
+
+-- name: TrimUserRevisions :exec
+DELETE FROM user_revisions
+WHERE tenant_id = @tenant_id AND user_id = @user_id AND version < @oldest_version;
+
+-- name: DeleteUserRevisions :exec
+DELETE FROM user_revisions WHERE tenant_id = @tenant_id AND user_id = @user_id;
+
+-- ListUserRevisions lists the revisions of a user newest first, starting
+-- below a version, which is 0 for the first page.
+
+-- name: ListUserRevisions :many
+SELECT * FROM user_revisions
+WHERE tenant_id = @tenant_id AND user_id = @user_id
+    AND (@after_version = 0 OR version < @after_version)
+ORDER BY version DESC
+LIMIT @limit;
+
+-- GetUserRevisionAsOf gets the most recent revision of a user made at or
+-- before a time.
+
+-- name: GetUserRevisionAsOf :one
+SELECT * FROM user_revisions
+WHERE tenant_id = @tenant_id AND user_id = @user_id AND revised_at <= @as_of
+ORDER BY revised_at DESC, version DESC
+LIMIT 1;
+
+-- DeleteExpiredIdempotencyKeys drops the idempotency records of every tenant
+-- that expired at or before a time.
+
+-- name: DeleteExpiredIdempotencyKeys :exec
+DELETE FROM idempotency_keys WHERE expires_at <= ?;
+
+-- InsertIdempotencyKey claims a key for a request in progress, unless a
+-- record with the key is kept.
+
+-- name: InsertIdempotencyKey :execrows
+INSERT INTO idempotency_keys (tenant_id, idempotency_key, fingerprint, expires_at) VALUES (?, ?, ?, ?)
+ON CONFLICT DO NOTHING;
+
+-- name: GetIdempotencyKey :one
+SELECT * FROM idempotency_keys WHERE tenant_id = ? AND idempotency_key = ?;
+
+-- name: CompleteIdempotencyKey :execrows
+UPDATE idempotency_keys SET completed = TRUE, response = @response, expires_at = @expires_at
+WHERE tenant_id = @tenant_id AND idempotency_key = @idempotency_key AND fingerprint = @fingerprint
+  AND NOT completed;
+
+-- name: ReleaseIdempotencyKey :exec
+DELETE FROM idempotency_keys WHERE tenant_id = ? AND idempotency_key = ? AND NOT completed;
@@ -3,142 +3,1211 @@ package sqlite
 import (
 	"context"
 	"database/sql"
-	_ "embed"
 	"errors"
 	"fmt"
 	"log/slog"
+	"net/url"
+	"strings"
 	"time"
 
+	"google.golang.org/protobuf/proto"
 	"google.golang.org/protobuf/types/known/timestamppb"
-	_ "modernc.org/sqlite"
+	sqlitedriver "modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
 
 	pb "github.com/andrew-womeldorf/connect-boilerplate/gen/user/v1"
+	"github.com/andrew-womeldorf/connect-boilerplate/internal/services/user/store"
+	"github.com/andrew-womeldorf/connect-boilerplate/internal/services/user/store/migrate"
 	"github.com/andrew-womeldorf/connect-boilerplate/internal/services/user/store/sqlite/gen"
 )
 
 var (
-	ErrCouldNotGetUser    = errors.New("could not get user")
-	ErrCouldNotCreateUser = errors.New("could not create user")
-	ErrCouldNotDeleteUser = errors.New("could not delete user")
-	ErrCouldNotListUsers  = errors.New("could not list users")
-	ErrCouldNotUpdateUser = errors.New("could not update user")
+	ErrCouldNotGetUser        = errors.New("could not get user")
+	ErrCouldNotCreateUser     = errors.New("could not create user")
+	ErrCouldNotDeleteUser     = errors.New("could not delete user")
+	ErrCouldNotListUsers      = errors.New("could not list users")
+	ErrCouldNotUpdateUser     = errors.New("could not update user")
+	ErrCouldNotUndeleteUser   = errors.New("could not undelete user")
+	ErrCouldNotPurgeUsers     = errors.New("could not purge users")
+	ErrCouldNotSearchUsers    = errors.New("could not search users")
+	ErrCouldNotRecordEvent    = errors.New("could not record event")
+	ErrCouldNotReadEvents     = errors.New("could not read events")
+	ErrCouldNotAckEvents      = errors.New("could not acknowledge events")
+	ErrCouldNotRecordAudit    = errors.New("could not record audit event")
+	ErrCouldNotListAudit      = errors.New("could not list audit events")
+	ErrCouldNotRecordRevision = errors.New("could not record revision")
+	ErrCouldNotListRevisions  = errors.New("could not list revisions")
+	ErrCouldNotClaimKey       = errors.New("could not claim idempotency key")
+	ErrCouldNotCompleteKey    = errors.New("could not complete idempotency key")
+	ErrCouldNotReleaseKey     = errors.New("could not release idempotency key")
 )
 
-//go:embed schema.sql
-var Schema string
+// timestampLayout is a fixed width, nanosecond precision form of RFC 3339.
+// Timestamps are always stored in UTC so they sort lexically.
+const timestampLayout = "2006-01-02T15:04:05.000000000Z07:00"
+
+// defaultBusyTimeout is how long a connection waits for locks held by other
+// connections, such as a migration in progress, before failing.
+const defaultBusyTimeout = 5 * time.Second
 
 type Store struct {
-	q *gen.Queries
+	db *sql.DB
+	q  *gen.Queries
+
+	autoMigrate bool
+	journalMode string
+	busyTimeout time.Duration
 }
 
-func NewStore(ctx context.Context, sqliteFile string) (*Store, error) {
-	db, err := sql.Open("sqlite", sqliteFile)
+type Option func(*Store)
+
+// WithoutMigrations skips applying pending migrations when the store is
+// opened. Use it when migrations are managed separately, such as by the
+// migrate command.
+func WithoutMigrations() Option {
+	return func(s *Store) {
+		s.autoMigrate = false
+	}
+}
+
+// WithJournalMode sets sqlite's journal_mode, e.g. "wal".
+func WithJournalMode(mode string) Option {
+	return func(s *Store) {
+		s.journalMode = mode
+	}
+}
+
+// WithBusyTimeout overrides how long a connection waits on a locked database.
+func WithBusyTimeout(d time.Duration) Option {
+	return func(s *Store) {
+		s.busyTimeout = d
+	}
+}
+
+func NewStore(ctx context.Context, sqliteFile string, opts ...Option) (*Store, error) {
+	s := &Store{
+		autoMigrate: true,
+		busyTimeout: defaultBusyTimeout,
+	}
+
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	db, err := sql.Open("sqlite", s.dsn(sqliteFile))
 	if err != nil {
 		return nil, err
 	}
 
-	return &Store{
-		q: gen.New(db),
-	}, nil
+	// Every connection to :memory: is a separate database, so the pool must
+	// never hold more than one.
+	if isMemory(sqliteFile) {
+		db.SetMaxOpenConns(1)
+		db.SetConnMaxLifetime(0)
+		db.SetConnMaxIdleTime(0)
+	}
+
+	s.db = db
+	s.q = gen.New(db)
+
+	if s.autoMigrate {
+		m, err := s.Migrator()
+		if err != nil {
+			return nil, errors.Join(err, db.Close())
+		}
+		if err := m.Up(ctx); err != nil {
+			slog.ErrorContext(ctx, migrate.ErrCouldNotMigrate.Error(), slog.Any("error", err))
+			return nil, errors.Join(err, db.Close())
+		}
+	}
+
+	return s, nil
+}
+
+// Migrator returns a Migrator for the store's database.
+func (s *Store) Migrator() (migrate.Migrator, error) {
+	m, err := NewMigrator(s.db)
+	if err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Close closes the underlying database.
+func (s *Store) Close() error {
+	return s.db.Close()
 }
 
 func (s *Store) CreateUser(ctx context.Context, user *pb.User) error {
-	if _, err := s.q.CreateUser(ctx, gen.CreateUserParams{
+	return s.writeTx(ctx, ErrCouldNotCreateUser, func(q *gen.Queries) error {
+		return createUser(ctx, q, user)
+	})
+}
+
+// createUser creates user and records the events for it. It must be called
+// in a transaction so that they are committed together.
+func createUser(ctx context.Context, q *gen.Queries, user *pb.User) error {
+	db, err := q.CreateUser(ctx, gen.CreateUserParams{
+		TenantID:  store.TenantFromContext(ctx),
 		ID:        user.GetId(),
 		Name:      user.GetName(),
 		Email:     user.GetEmail(),
-		CreatedAt: user.GetCreatedAt().AsTime().Format(time.RFC3339),
-		UpdatedAt: user.GetUpdatedAt().AsTime().Format(time.RFC3339),
-	}); err != nil {
+		CreatedAt: user.GetCreatedAt().AsTime().UTC().Format(timestampLayout),
+		UpdatedAt: user.GetUpdatedAt().AsTime().UTC().Format(timestampLayout),
+	})
+	if err != nil {
 		slog.ErrorContext(ctx, ErrCouldNotCreateUser.Error(),
 			slog.Any("error", err),
 			slog.String("user id", user.GetId()),
 		)
-		return ErrCouldNotCreateUser
+		return classify(ErrCouldNotCreateUser, err)
 	}
 
-	return nil
+	created, err := convertUser(ctx, db)
+	if err != nil {
+		return classify(ErrCouldNotRecordEvent, err)
+	}
+
+	if err := recordEvent(ctx, q, store.NewUserCreated(ctx, created)); err != nil {
+		return err
+	}
+	return recordChange(ctx, q, nil, created, created.GetCreatedAt().AsTime())
+}
+
+func (s *Store) DeleteUser(ctx context.Context, id string, expectedVersion int64, deletedAt time.Time) error {
+	return s.writeTx(ctx, ErrCouldNotDeleteUser, func(q *gen.Queries) error {
+		return deleteUser(ctx, q, id, expectedVersion, deletedAt)
+	})
 }
 
-func (s *Store) DeleteUser(ctx context.Context, id string) error {
-	_, err := s.q.DeleteUser(ctx, id)
+// deleteUser marks the user with id deleted and records the events for it,
+// in the caller's transaction as createUser does.
+func deleteUser(ctx context.Context, q *gen.Queries, id string, expectedVersion int64, deletedAt time.Time) error {
+	db, err := q.DeleteUser(ctx, gen.DeleteUserParams{
+		DeletedAt:       formatNullTime(deletedAt),
+		TenantID:        store.TenantFromContext(ctx),
+		ID:              id,
+		ExpectedVersion: expectedVersion,
+	})
 	if err != nil {
 		slog.ErrorContext(ctx, ErrCouldNotDeleteUser.Error(),
 			slog.Any("error", err),
 			slog.String("user id", id),
 		)
-		return ErrCouldNotDeleteUser
+		return classifyGuarded(ctx, q, ErrCouldNotDeleteUser, err, id, expectedVersion)
 	}
 
-	return nil
+	deleted, err := convertUser(ctx, db)
+	if err != nil {
+		return classify(ErrCouldNotRecordAudit, err)
+	}
+
+	// Deleting only set deleted_at, so the user before is the one returned
+	// without it.
+	before := proto.CloneOf(deleted)
+	before.DeletedAt = nil
+
+	if err := recordEvent(ctx, q, store.NewUserDeleted(ctx, deleted)); err != nil {
+		return err
+	}
+	return recordChange(ctx, q, before, deleted, deleted.GetDeletedAt().AsTime())
+}
+
+func (s *Store) UndeleteUser(ctx context.Context, id string, expectedVersion int64, undeletedAt time.Time) (*pb.User, error) {
+	var restored *pb.User
+	err := s.writeTx(ctx, ErrCouldNotUndeleteUser, func(q *gen.Queries) error {
+		// The user is read for its audit event before it changes, as in
+		// UpdateUser, so the undelete can only miss it on its version.
+		current, err := q.GetDeletedUser(ctx, gen.GetDeletedUserParams{TenantID: store.TenantFromContext(ctx), ID: id})
+		if err != nil {
+			slog.ErrorContext(ctx, ErrCouldNotUndeleteUser.Error(),
+				slog.Any("error", err),
+				slog.String("user id", id),
+			)
+			return classifyNotDeleted(ctx, q, ErrCouldNotUndeleteUser, err, id)
+		}
+		before, err := convertUser(ctx, current)
+		if err != nil {
+			return classify(ErrCouldNotUndeleteUser, err)
+		}
+
+		db, err := q.UndeleteUser(ctx, gen.UndeleteUserParams{
+			TenantID:        store.TenantFromContext(ctx),
+			ID:              id,
+			ExpectedVersion: expectedVersion,
+		})
+		if err != nil {
+			slog.ErrorContext(ctx, ErrCouldNotUndeleteUser.Error(),
+				slog.Any("error", err),
+				slog.String("user id", id),
+			)
+			if errors.Is(err, sql.ErrNoRows) {
+				return store.PreconditionFailed(fmt.Errorf("%w: expected version %d", ErrCouldNotUndeleteUser, expectedVersion))
+			}
+			return classify(ErrCouldNotUndeleteUser, err)
+		}
+
+		restored, err = convertUser(ctx, db)
+		if err != nil {
+			return classify(ErrCouldNotUndeleteUser, err)
+		}
+
+		if err := recordEvent(ctx, q, store.NewUserUndeleted(ctx, restored, undeletedAt)); err != nil {
+			return err
+		}
+		return recordChange(ctx, q, before, restored, undeletedAt)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return restored, nil
+}
+
+// PurgeUsers removes the users in one transaction, recording the events of
+// each under its own tenant.
+func (s *Store) PurgeUsers(ctx context.Context, deletedBefore time.Time, limit int32, purgedAt time.Time) (int, error) {
+	var purged int
+	err := s.writeTx(ctx, ErrCouldNotPurgeUsers, func(q *gen.Queries) error {
+		rows, err := q.ListPurgeableUsers(ctx, gen.ListPurgeableUsersParams{
+			DeletedBefore: formatNullTime(deletedBefore),
+			Limit:         int64(limit),
+		})
+		if err != nil {
+			slog.ErrorContext(ctx, ErrCouldNotPurgeUsers.Error(), slog.Any("error", err))
+			return classify(ErrCouldNotPurgeUsers, err)
+		}
+
+		for _, row := range rows {
+			ctx := store.WithTenant(ctx, row.TenantID)
+
+			user, err := convertUser(ctx, row)
+			if err != nil {
+				return classify(ErrCouldNotPurgeUsers, err)
+			}
+
+			if err := q.PurgeUser(ctx, gen.PurgeUserParams{TenantID: row.TenantID, ID: row.ID}); err != nil {
+				slog.ErrorContext(ctx, ErrCouldNotPurgeUsers.Error(),
+					slog.Any("error", err),
+					slog.String("user id", row.ID),
+				)
+				return classify(ErrCouldNotPurgeUsers, err)
+			}
+
+			if err := recordEvent(ctx, q, store.NewUserPurged(ctx, row.ID, purgedAt)); err != nil {
+				return err
+			}
+			if err := recordChange(ctx, q, user, nil, purgedAt); err != nil {
+				return err
+			}
+		}
+
+		purged = len(rows)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return purged, nil
 }
 
 func (s *Store) GetUser(ctx context.Context, id string) (*pb.User, error) {
-	db, err := s.q.GetUser(ctx, id)
+	return getUser(ctx, s.q, id)
+}
+
+func getUser(ctx context.Context, q *gen.Queries, id string) (*pb.User, error) {
+	db, err := q.GetUser(ctx, gen.GetUserParams{TenantID: store.TenantFromContext(ctx), ID: id})
 	if err != nil {
 		slog.ErrorContext(ctx, ErrCouldNotGetUser.Error(),
 			slog.Any("error", err),
 			slog.String("user id", id),
 		)
-		return nil, ErrCouldNotGetUser
+		return nil, classify(ErrCouldNotGetUser, err)
 	}
 
 	user, err := convertUser(ctx, db)
 	if err != nil {
-		return nil, ErrCouldNotGetUser
+		return nil, classify(ErrCouldNotGetUser, err)
 	}
 
 	return user, nil
 }
 
-func (s *Store) ListUsers(ctx context.Context) ([]*pb.User, error) {
-	db, err := s.q.ListUsers(ctx)
+func (s *Store) GetUserByEmail(ctx context.Context, email string) (*pb.User, error) {
+	db, err := s.q.GetUserByEmail(ctx, gen.GetUserByEmailParams{TenantID: store.TenantFromContext(ctx), Email: email})
+	if err != nil {
+		slog.ErrorContext(ctx, ErrCouldNotGetUser.Error(),
+			slog.Any("error", err),
+			slog.String("user email", email),
+		)
+		return nil, classify(ErrCouldNotGetUser, err)
+	}
+
+	user, err := convertUser(ctx, db)
+	if err != nil {
+		return nil, classify(ErrCouldNotGetUser, err)
+	}
+
+	return user, nil
+}
+
+// pageCursor is the keyset position encoded into page tokens: the order
+// users were listed in and the last user listed. CreatedAt is kept as
+// stored, in timestampLayout.
+type pageCursor struct {
+	Order     string `json:"o"`
+	Name      string `json:"n,omitempty"`
+	CreatedAt string `json:"c,omitempty"`
+	ID        string `json:"i"`
+}
+
+func (s *Store) ListUsers(ctx context.Context, params store.ListUsersParams) ([]*pb.User, string, error) {
+	order := params.OrderBy
+
+	var cursor pageCursor
+	if params.PageToken != "" {
+		if err := store.DecodePageToken(params.PageToken, &cursor); err != nil {
+			return nil, "", err
+		}
+		if err := store.CheckPageOrder(cursor.Order, order); err != nil {
+			return nil, "", err
+		}
+	}
+
+	limit := params.Limit()
+
+	// Fetch one extra row to learn whether there is another page.
+	db, err := listUsers(ctx, s.q, order, cursor, filterParams(ctx, params.Filter), int64(limit)+1)
 	if err != nil {
 		slog.ErrorContext(ctx, ErrCouldNotListUsers.Error(),
 			slog.Any("error", err),
 		)
-		return nil, ErrCouldNotGetUser
+		return nil, "", classify(ErrCouldNotListUsers, err)
+	}
+
+	var nextPageToken string
+	if len(db) > int(limit) {
+		db = db[:limit]
+		last := db[len(db)-1]
+		next := pageCursor{Order: order.String(), ID: last.ID}
+		switch order.Field {
+		case store.OrderByCreatedAt:
+			next.CreatedAt = last.CreatedAt
+		default:
+			next.Name = last.Name
+		}
+		nextPageToken, err = store.EncodePageToken(next)
+		if err != nil {
+			slog.ErrorContext(ctx, ErrCouldNotListUsers.Error(),
+				slog.Any("error", err),
+			)
+			return nil, "", classify(ErrCouldNotListUsers, err)
+		}
 	}
 
 	users := []*pb.User{}
 	for _, u := range db {
 		pbu, err := convertUser(ctx, u)
 		if err != nil {
-			return nil, ErrCouldNotListUsers
+			return nil, "", classify(ErrCouldNotListUsers, err)
 		}
 		users = append(users, pbu)
 	}
 
-	return users, nil
+	return users, nextPageToken, nil
 }
 
-func (s *Store) UpdateUser(ctx context.Context, user *pb.User) error {
-	_, err := s.q.UpdateUser(ctx, gen.UpdateUserParams{
-		ID:        user.GetId(),
-		Name:      user.GetName(),
-		Email:     user.GetEmail(),
-		UpdatedAt: user.GetUpdatedAt().AsTime().Format(time.RFC3339),
+// listUsers runs the query listing the users of filter's tenant in order,
+// after cursor.
+func listUsers(ctx context.Context, q *gen.Queries, order store.UserOrder, cursor pageCursor, filter gen.CountUsersParams, limit int64) ([]gen.User, error) {
+	switch {
+	case order.Field == store.OrderByCreatedAt && order.Desc:
+		return q.ListUsersByCreatedAtDesc(ctx, gen.ListUsersByCreatedAtDescParams{
+			TenantID:       filter.TenantID,
+			AfterID:        cursor.ID,
+			AfterCreatedAt: cursor.CreatedAt,
+			NamePrefix:     filter.NamePrefix,
+			EmailDomain:    filter.EmailDomain,
+			CreatedAfter:   filter.CreatedAfter,
+			CreatedBefore:  filter.CreatedBefore,
+			ShowDeleted:    filter.ShowDeleted,
+			Limit:          limit,
+		})
+	case order.Field == store.OrderByCreatedAt:
+		return q.ListUsersByCreatedAt(ctx, gen.ListUsersByCreatedAtParams{
+			TenantID:       filter.TenantID,
+			AfterCreatedAt: cursor.CreatedAt,
+			AfterID:        cursor.ID,
+			NamePrefix:     filter.NamePrefix,
+			EmailDomain:    filter.EmailDomain,
+			CreatedAfter:   filter.CreatedAfter,
+			CreatedBefore:  filter.CreatedBefore,
+			ShowDeleted:    filter.ShowDeleted,
+			Limit:          limit,
+		})
+	case order.Desc:
+		return q.ListUsersByNameDesc(ctx, gen.ListUsersByNameDescParams{
+			TenantID:      filter.TenantID,
+			AfterID:       cursor.ID,
+			AfterName:     cursor.Name,
+			NamePrefix:    filter.NamePrefix,
+			EmailDomain:   filter.EmailDomain,
+			CreatedAfter:  filter.CreatedAfter,
+			CreatedBefore: filter.CreatedBefore,
+			ShowDeleted:   filter.ShowDeleted,
+			Limit:         limit,
+		})
+	default:
+		return q.ListUsersByName(ctx, gen.ListUsersByNameParams{
+			TenantID:      filter.TenantID,
+			AfterName:     cursor.Name,
+			AfterID:       cursor.ID,
+			NamePrefix:    filter.NamePrefix,
+			EmailDomain:   filter.EmailDomain,
+			CreatedAfter:  filter.CreatedAfter,
+			CreatedBefore: filter.CreatedBefore,
+			ShowDeleted:   filter.ShowDeleted,
+			Limit:         limit,
+		})
+	}
+}
+
+func (s *Store) CountUsers(ctx context.Context, filter store.UserFilter) (int64, error) {
+	count, err := s.q.CountUsers(ctx, filterParams(ctx, filter))
+	if err != nil {
+		slog.ErrorContext(ctx, ErrCouldNotListUsers.Error(),
+			slog.Any("error", err),
+		)
+		return 0, classify(ErrCouldNotListUsers, err)
+	}
+
+	return count, nil
+}
+
+// filterParams converts filter into the parameters the list queries share,
+// in which an empty string matches every user of the tenant of ctx.
+func filterParams(ctx context.Context, filter store.UserFilter) gen.CountUsersParams {
+	return gen.CountUsersParams{
+		TenantID:      store.TenantFromContext(ctx),
+		NamePrefix:    filter.NamePrefix,
+		EmailDomain:   store.NormalizeEmail(filter.EmailDomain),
+		CreatedAfter:  formatBound(filter.CreatedAfter),
+		CreatedBefore: formatBound(filter.CreatedBefore),
+		ShowDeleted:   filter.ShowDeleted,
+	}
+}
+
+// formatBound formats a time bound of a filter as stored, leaving an unset
+// bound empty, which the queries take as no bound.
+func formatBound(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(timestampLayout)
+}
+
+// formatNullTime formats t as stored in a nullable column.
+func formatNullTime(t time.Time) sql.NullString {
+	return sql.NullString{String: t.UTC().Format(timestampLayout), Valid: true}
+}
+
+var _ store.Searcher = (*Store)(nil)
+
+// SearchUsers matches every term of the query as a word prefix against the
+// users_fts index, which ranks results with bm25.
+func (s *Store) SearchUsers(ctx context.Context, params store.SearchUsersParams) ([]store.SearchResult, string, error) {
+	offset, err := store.DecodeSearchPageToken(params.PageToken, params.Query)
+	if err != nil {
+		return nil, "", err
+	}
+
+	terms := store.SearchTerms(params.Query)
+	if len(terms) == 0 {
+		return []store.SearchResult{}, "", nil
+	}
+
+	limit := params.Limit()
+
+	// Fetch one extra row to learn whether there is another page.
+	rows, err := s.q.SearchUsers(ctx, gen.SearchUsersParams{
+		Query:    matchQuery(terms),
+		TenantID: store.TenantFromContext(ctx),
+		Limit:    int64(limit) + 1,
+		Offset:   int64(offset),
+	})
+	if err != nil {
+		slog.ErrorContext(ctx, ErrCouldNotSearchUsers.Error(),
+			slog.Any("error", err),
+		)
+		return nil, "", classify(ErrCouldNotSearchUsers, err)
+	}
+
+	var nextPageToken string
+	if len(rows) > int(limit) {
+		rows = rows[:limit]
+		nextPageToken, err = store.EncodeSearchPageToken(params.Query, offset+int(limit))
+		if err != nil {
+			return nil, "", classify(ErrCouldNotSearchUsers, err)
+		}
+	}
+
+	results := make([]store.SearchResult, 0, len(rows))
+	for _, row := range rows {
+		user, err := convertUser(ctx, gen.User{
+			TenantID:  row.TenantID,
+			ID:        row.ID,
+			Name:      row.Name,
+			Email:     row.Email,
+			CreatedAt: row.CreatedAt,
+			UpdatedAt: row.UpdatedAt,
+			Version:   row.Version,
+			DeletedAt: row.DeletedAt,
+		})
+		if err != nil {
+			return nil, "", classify(ErrCouldNotSearchUsers, err)
+		}
+
+		results = append(results, store.SearchResult{
+			User:           user,
+			Score:          -row.Rank,
+			NameHighlight:  row.NameHighlight,
+			EmailHighlight: row.EmailHighlight,
+		})
+	}
+
+	return results, nextPageToken, nil
+}
+
+// matchQuery builds the FTS5 query matching every term as a word prefix.
+// Terms are quoted so that words such as OR and NEAR are not read as
+// operators; being letters and digits, they hold no quotes themselves.
+func matchQuery(terms []string) string {
+	quoted := make([]string, len(terms))
+	for i, term := range terms {
+		quoted[i] = `"` + term + `"*`
+	}
+	return strings.Join(quoted, " ")
+}
+
+func (s *Store) UpdateUser(ctx context.Context, user *pb.User, mask store.FieldMask, expectedVersion int64) (*pb.User, error) {
+	var updated *pb.User
+	err := s.writeTx(ctx, ErrCouldNotUpdateUser, func(q *gen.Queries) error {
+		// The user is read for its audit event before it changes. The
+		// transaction holds the write lock, so it cannot change in between.
+		current, err := q.GetUser(ctx, gen.GetUserParams{TenantID: store.TenantFromContext(ctx), ID: user.GetId()})
+		if err != nil {
+			slog.ErrorContext(ctx, ErrCouldNotUpdateUser.Error(),
+				slog.Any("error", err),
+				slog.String("user id", user.GetId()),
+			)
+			return classify(ErrCouldNotUpdateUser, err)
+		}
+		before, err := convertUser(ctx, current)
+		if err != nil {
+			return classify(ErrCouldNotUpdateUser, err)
+		}
+
+		db, err := q.UpdateUser(ctx, gen.UpdateUserParams{
+			TenantID:        store.TenantFromContext(ctx),
+			ID:              user.GetId(),
+			Name:            sql.NullString{String: user.GetName(), Valid: mask.Name},
+			Email:           sql.NullString{String: user.GetEmail(), Valid: mask.Email},
+			ExpectedVersion: expectedVersion,
+			UpdatedAt:       user.GetUpdatedAt().AsTime().UTC().Format(timestampLayout),
+		})
+		if err != nil {
+			slog.ErrorContext(ctx, ErrCouldNotUpdateUser.Error(),
+				slog.Any("error", err),
+				slog.String("user id", user.GetId()),
+			)
+			return classifyGuarded(ctx, q, ErrCouldNotUpdateUser, err, user.GetId(), expectedVersion)
+		}
+
+		updated, err = convertUser(ctx, db)
+		if err != nil {
+			return classify(ErrCouldNotUpdateUser, err)
+		}
+
+		if err := recordEvent(ctx, q, store.NewUserUpdated(ctx, updated, mask)); err != nil {
+			return err
+		}
+		return recordChange(ctx, q, before, updated, updated.GetUpdatedAt().AsTime())
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return updated, nil
+}
+
+// BatchGetUsers reads every user in one transaction, so the results are a
+// consistent snapshot.
+func (s *Store) BatchGetUsers(ctx context.Context, ids []string) ([]store.BatchResult, error) {
+	results := make([]store.BatchResult, len(ids))
+	err := s.inTx(ctx, func(q *gen.Queries) error {
+		for i, id := range ids {
+			results[i].User, results[i].Err = getUser(ctx, q, id)
+		}
+		return nil
+	})
+	if err != nil {
+		slog.ErrorContext(ctx, ErrCouldNotGetUser.Error(), slog.Any("error", err))
+		return nil, classify(ErrCouldNotGetUser, err)
+	}
+
+	return results, nil
+}
+
+// BatchCreateUsers creates every user in one transaction. A failed insert
+// only undoes its own statement, so the rest of the batch is still
+// committed. Failing to record an event or audit event fails the whole
+// batch, since the change it was for cannot be undone alone.
+func (s *Store) BatchCreateUsers(ctx context.Context, users []*pb.User) ([]store.BatchResult, error) {
+	results := make([]store.BatchResult, len(users))
+	err := s.inTx(ctx, func(q *gen.Queries) error {
+		for i, user := range users {
+			results[i].Err = createUser(ctx, q, user)
+			if failedToRecord(results[i].Err) {
+				return results[i].Err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		slog.ErrorContext(ctx, ErrCouldNotCreateUser.Error(), slog.Any("error", err))
+		return nil, classify(ErrCouldNotCreateUser, err)
+	}
+
+	return results, nil
+}
+
+// BatchDeleteUsers deletes every user in one transaction, as
+// BatchCreateUsers does.
+func (s *Store) BatchDeleteUsers(ctx context.Context, deletes []store.BatchDelete, deletedAt time.Time) ([]store.BatchResult, error) {
+	results := make([]store.BatchResult, len(deletes))
+	err := s.inTx(ctx, func(q *gen.Queries) error {
+		for i, d := range deletes {
+			results[i].Err = deleteUser(ctx, q, d.ID, d.ExpectedVersion, deletedAt)
+			if failedToRecord(results[i].Err) {
+				return results[i].Err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		slog.ErrorContext(ctx, ErrCouldNotDeleteUser.Error(), slog.Any("error", err))
+		return nil, classify(ErrCouldNotDeleteUser, err)
+	}
+
+	return results, nil
+}
+
+// inTx runs fn in a transaction, which is committed if fn returns nil.
+func (s *Store) inTx(ctx context.Context, fn func(*gen.Queries) error) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	if err := fn(s.q.WithTx(tx)); err != nil {
+		return errors.Join(err, tx.Rollback())
+	}
+
+	return tx.Commit()
+}
+
+// writeTx runs fn in a transaction as inTx does, classifying a failure to
+// begin or commit it as a failure of op. fn classifies its own errors.
+func (s *Store) writeTx(ctx context.Context, op error, fn func(*gen.Queries) error) error {
+	err := s.inTx(ctx, fn)
+
+	var storeErr *store.Error
+	if err != nil && !errors.As(err, &storeErr) {
+		slog.ErrorContext(ctx, op.Error(), slog.Any("error", err))
+		return classify(op, err)
+	}
+
+	return err
+}
+
+// recordEvent adds event to the outbox.
+func recordEvent(ctx context.Context, q *gen.Queries, event *pb.UserEvent) error {
+	data, err := proto.Marshal(event)
+	if err == nil {
+		err = q.InsertEvent(ctx, gen.InsertEventParams{ID: event.GetId(), Event: data})
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, ErrCouldNotRecordEvent.Error(),
+			slog.Any("error", err),
+			slog.String("event id", event.GetId()),
+		)
+		return classify(ErrCouldNotRecordEvent, err)
+	}
+
+	return nil
+}
+
+// recordAudit adds event to the audit log.
+func recordAudit(ctx context.Context, q *gen.Queries, event *pb.AuditEvent) error {
+	data, err := proto.Marshal(event)
+	if err == nil {
+		err = q.InsertAuditEvent(ctx, gen.InsertAuditEventParams{
+			ID:         event.GetId(),
+			TenantID:   store.TenantFromContext(ctx),
+			UserID:     event.GetUserId(),
+			OccurredAt: event.GetOccurredAt().AsTime().UTC().Format(timestampLayout),
+			Event:      data,
+		})
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, ErrCouldNotRecordAudit.Error(),
+			slog.Any("error", err),
+			slog.String("audit event id", event.GetId()),
+		)
+		return classify(ErrCouldNotRecordAudit, err)
+	}
+
+	return nil
+}
+
+// recordChange records the audit event for a change made at occurredAt that
+// left the user stored as before as after, and the revision it made,
+// dropping revisions older than the store keeps. Purging a user, which
+// leaves no after, drops them all.
+func recordChange(ctx context.Context, q *gen.Queries, before, after *pb.User, occurredAt time.Time) error {
+	event := store.NewAuditEvent(ctx, before, after, occurredAt)
+	if err := recordAudit(ctx, q, event); err != nil {
+		return err
+	}
+
+	if after == nil {
+		return deleteRevisions(ctx, q, before.GetId())
+	}
+	return recordRevision(ctx, q, store.NewRevision(after, event))
+}
+
+// recordRevision adds revision to the user's revisions and drops those older
+// than the store keeps.
+func recordRevision(ctx context.Context, q *gen.Queries, revision *pb.UserRevision) error {
+	tenantID := store.TenantFromContext(ctx)
+	userID := revision.GetUser().GetId()
+
+	data, err := proto.Marshal(revision)
+	if err == nil {
+		err = q.InsertUserRevision(ctx, gen.InsertUserRevisionParams{
+			TenantID:  tenantID,
+			UserID:    userID,
+			Version:   revision.GetRevision(),
+			RevisedAt: revision.GetRevisedAt().AsTime().UTC().Format(timestampLayout),
+			Revision:  data,
+		})
+	}
+	if err == nil {
+		err = q.TrimUserRevisions(ctx, gen.TrimUserRevisionsParams{
+			TenantID:      tenantID,
+			UserID:        userID,
+			OldestVersion: store.OldestRevision(revision.GetRevision()),
+		})
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, ErrCouldNotRecordRevision.Error(),
+			slog.Any("error", err),
+			slog.String("user id", userID),
+			slog.Int64("revision", revision.GetRevision()),
+		)
+		return classify(ErrCouldNotRecordRevision, err)
+	}
+
+	return nil
+}
+
+// deleteRevisions drops every revision of the user with id.
+func deleteRevisions(ctx context.Context, q *gen.Queries, id string) error {
+	err := q.DeleteUserRevisions(ctx, gen.DeleteUserRevisionsParams{TenantID: store.TenantFromContext(ctx), UserID: id})
+	if err != nil {
+		slog.ErrorContext(ctx, ErrCouldNotRecordRevision.Error(),
+			slog.Any("error", err),
+			slog.String("user id", id),
+		)
+		return classify(ErrCouldNotRecordRevision, err)
+	}
+
+	return nil
+}
+
+// failedToRecord reports whether err is a failure to record the events of a
+// change that was made.
+func failedToRecord(err error) bool {
+	return errors.Is(err, ErrCouldNotRecordEvent) || errors.Is(err, ErrCouldNotRecordAudit) ||
+		errors.Is(err, ErrCouldNotRecordRevision)
+}
+
+func (s *Store) PendingEvents(ctx context.Context, limit int32) ([]*pb.UserEvent, error) {
+	rows, err := s.q.ListEvents(ctx, int64(limit))
+	if err != nil {
+		slog.ErrorContext(ctx, ErrCouldNotReadEvents.Error(), slog.Any("error", err))
+		return nil, classify(ErrCouldNotReadEvents, err)
+	}
+
+	events := make([]*pb.UserEvent, 0, len(rows))
+	for _, row := range rows {
+		event := &pb.UserEvent{}
+		if err := proto.Unmarshal(row.Event, event); err != nil {
+			slog.ErrorContext(ctx, ErrCouldNotReadEvents.Error(),
+				slog.Any("error", err),
+				slog.String("event id", row.ID),
+			)
+			return nil, classify(ErrCouldNotReadEvents, err)
+		}
+		events = append(events, event)
+	}
+
+	return events, nil
+}
+
+func (s *Store) AckEvents(ctx context.Context, events []*pb.UserEvent) error {
+	err := s.inTx(ctx, func(q *gen.Queries) error {
+		for _, id := range store.EventIDs(events) {
+			if err := q.DeleteEvent(ctx, id); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		slog.ErrorContext(ctx, ErrCouldNotAckEvents.Error(), slog.Any("error", err))
+		return classify(ErrCouldNotAckEvents, err)
+	}
+
+	return nil
+}
+
+// auditCursor is the keyset position encoded into audit page tokens: the
+// last event listed, with OccurredAt kept as stored, in timestampLayout.
+type auditCursor struct {
+	OccurredAt string `json:"t"`
+	ID         string `json:"i"`
+}
+
+func (s *Store) ListAuditEvents(ctx context.Context, params store.ListAuditEventsParams) ([]*pb.AuditEvent, string, error) {
+	var cursor auditCursor
+	if params.PageToken != "" {
+		if err := store.DecodePageToken(params.PageToken, &cursor); err != nil {
+			return nil, "", err
+		}
+	}
+
+	limit := params.Limit()
+	filter := params.Filter
+
+	// Fetch one extra row to learn whether there is another page.
+	rows, err := s.q.ListAuditEvents(ctx, gen.ListAuditEventsParams{
+		TenantID:        store.TenantFromContext(ctx),
+		AfterOccurredAt: cursor.OccurredAt,
+		AfterID:         cursor.ID,
+		UserID:          filter.UserID,
+		StartTime:       formatBound(filter.StartTime),
+		EndTime:         formatBound(filter.EndTime),
+		Limit:           int64(limit) + 1,
 	})
+	if err != nil {
+		slog.ErrorContext(ctx, ErrCouldNotListAudit.Error(), slog.Any("error", err))
+		return nil, "", classify(ErrCouldNotListAudit, err)
+	}
 
+	var nextPageToken string
+	if len(rows) > int(limit) {
+		rows = rows[:limit]
+		last := rows[len(rows)-1]
+		nextPageToken, err = store.EncodePageToken(auditCursor{OccurredAt: last.OccurredAt, ID: last.ID})
+		if err != nil {
+			slog.ErrorContext(ctx, ErrCouldNotListAudit.Error(), slog.Any("error", err))
+			return nil, "", classify(ErrCouldNotListAudit, err)
+		}
+	}
+
+	events := make([]*pb.AuditEvent, 0, len(rows))
+	for _, row := range rows {
+		event := &pb.AuditEvent{}
+		if err := proto.Unmarshal(row.Event, event); err != nil {
+			slog.ErrorContext(ctx, ErrCouldNotListAudit.Error(),
+				slog.Any("error", err),
+				slog.String("audit event id", row.ID),
+			)
+			return nil, "", classify(ErrCouldNotListAudit, err)
+		}
+		events = append(events, event)
+	}
+
+	return events, nextPageToken, nil
+}
+
+// revisionCursor is the position encoded into revision page tokens: the last
+// revision listed.
+type revisionCursor struct {
+	Revision int64 `json:"r"`
+}
+
+func (s *Store) ListUserRevisions(ctx context.Context, params store.ListUserRevisionsParams) ([]*pb.UserRevision, string, error) {
+	var cursor revisionCursor
+	if params.PageToken != "" {
+		if err := store.DecodePageToken(params.PageToken, &cursor); err != nil {
+			return nil, "", err
+		}
+	}
+
+	limit := params.Limit()
+
+	// Fetch one extra row to learn whether there is another page.
+	rows, err := s.q.ListUserRevisions(ctx, gen.ListUserRevisionsParams{
+		TenantID:     store.TenantFromContext(ctx),
+		UserID:       params.UserID,
+		AfterVersion: cursor.Revision,
+		Limit:        int64(limit) + 1,
+	})
 	if err != nil {
-		slog.ErrorContext(ctx, ErrCouldNotUpdateUser.Error(),
+		slog.ErrorContext(ctx, ErrCouldNotListRevisions.Error(),
 			slog.Any("error", err),
-			slog.String("user id", user.GetId()),
+			slog.String("user id", params.UserID),
+		)
+		return nil, "", classify(ErrCouldNotListRevisions, err)
+	}
+
+	var nextPageToken string
+	if len(rows) > int(limit) {
+		rows = rows[:limit]
+		nextPageToken, err = store.EncodePageToken(revisionCursor{Revision: rows[len(rows)-1].Version})
+		if err != nil {
+			slog.ErrorContext(ctx, ErrCouldNotListRevisions.Error(), slog.Any("error", err))
+			return nil, "", classify(ErrCouldNotListRevisions, err)
+		}
+	}
+
+	revisions := make([]*pb.UserRevision, 0, len(rows))
+	for _, row := range rows {
+		revision, err := convertRevision(row)
+		if err != nil {
+			slog.ErrorContext(ctx, ErrCouldNotListRevisions.Error(),
+				slog.Any("error", err),
+				slog.String("user id", row.UserID),
+				slog.Int64("revision", row.Version),
+			)
+			return nil, "", classify(ErrCouldNotListRevisions, err)
+		}
+		revisions = append(revisions, revision)
+	}
+
+	return revisions, nextPageToken, nil
+}
+
+func (s *Store) GetUserAsOf(ctx context.Context, id string, asOf time.Time) (*pb.User, error) {
+	row, err := s.q.GetUserRevisionAsOf(ctx, gen.GetUserRevisionAsOfParams{
+		TenantID: store.TenantFromContext(ctx),
+		UserID:   id,
+		AsOf:     asOf.UTC().Format(timestampLayout),
+	})
+	if err != nil {
+		slog.ErrorContext(ctx, ErrCouldNotGetUser.Error(),
+			slog.Any("error", err),
+			slog.String("user id", id),
 		)
-		return ErrCouldNotUpdateUser
+		return nil, classify(ErrCouldNotGetUser, err)
+	}
+
+	revision, err := convertRevision(row)
+	if err != nil {
+		slog.ErrorContext(ctx, ErrCouldNotGetUser.Error(),
+			slog.Any("error", err),
+			slog.String("user id", id),
+			slog.Int64("revision", row.Version),
+		)
+		return nil, classify(ErrCouldNotGetUser, err)
+	}
+	if revision.GetUser().GetDeletedAt() != nil {
+		return nil, store.NotFound(ErrCouldNotGetUser)
+	}
+
+	return revision.GetUser(), nil
+}
+
+func (s *Store) ClaimIdempotencyKey(ctx context.Context, record store.IdempotencyRecord) (*store.IdempotencyRecord, error) {
+	tenantID := store.TenantFromContext(ctx)
+
+	var kept *store.IdempotencyRecord
+	err := s.writeTx(ctx, ErrCouldNotClaimKey, func(q *gen.Queries) error {
+		// Sweep every expired record, so that the table only grows with the
+		// keys in use, and a claim may take the key of an expired one.
+		err := q.DeleteExpiredIdempotencyKeys(ctx, time.Now().UTC().Format(timestampLayout))
+		if err != nil {
+			return classify(ErrCouldNotClaimKey, err)
+		}
+
+		n, err := q.InsertIdempotencyKey(ctx, gen.InsertIdempotencyKeyParams{
+			TenantID:       tenantID,
+			IdempotencyKey: record.Key,
+			Fingerprint:    record.Fingerprint,
+			ExpiresAt:      record.ExpiresAt.UTC().Format(timestampLayout),
+		})
+		if err != nil {
+			return classify(ErrCouldNotClaimKey, err)
+		}
+		if n == 1 {
+			return nil
+		}
+
+		row, err := q.GetIdempotencyKey(ctx, gen.GetIdempotencyKeyParams{TenantID: tenantID, IdempotencyKey: record.Key})
+		if err == nil {
+			kept, err = convertIdempotencyKey(row)
+		}
+		if err != nil {
+			return classify(ErrCouldNotClaimKey, err)
+		}
+		return nil
+	})
+	if err != nil {
+		slog.ErrorContext(ctx, ErrCouldNotClaimKey.Error(),
+			slog.Any("error", err),
+			slog.String("idempotency key", record.Key),
+		)
+		return nil, err
+	}
+
+	return kept, nil
+}
+
+func (s *Store) CompleteIdempotencyKey(ctx context.Context, record store.IdempotencyRecord) error {
+	n, err := s.q.CompleteIdempotencyKey(ctx, gen.CompleteIdempotencyKeyParams{
+		Response:       record.Response,
+		ExpiresAt:      record.ExpiresAt.UTC().Format(timestampLayout),
+		TenantID:       store.TenantFromContext(ctx),
+		IdempotencyKey: record.Key,
+		Fingerprint:    record.Fingerprint,
+	})
+	if err == nil && n == 0 {
+		err = sql.ErrNoRows
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, ErrCouldNotCompleteKey.Error(),
+			slog.Any("error", err),
+			slog.String("idempotency key", record.Key),
+		)
+		return classify(ErrCouldNotCompleteKey, err)
 	}
 
 	return nil
 }
 
+func (s *Store) ReleaseIdempotencyKey(ctx context.Context, key string) error {
+	err := s.q.ReleaseIdempotencyKey(ctx, gen.ReleaseIdempotencyKeyParams{
+		TenantID:       store.TenantFromContext(ctx),
+		IdempotencyKey: key,
+	})
+	if err != nil {
+		slog.ErrorContext(ctx, ErrCouldNotReleaseKey.Error(),
+			slog.Any("error", err),
+			slog.String("idempotency key", key),
+		)
+		return classify(ErrCouldNotReleaseKey, err)
+	}
+
+	return nil
+}
+
+// classifyGuarded classifies the failure of a statement guarded by
+// expectedVersion. A guarded statement matches no rows both when the user is
+// missing and when its version differs, so the user is looked up to tell the
+// two apart.
+func classifyGuarded(ctx context.Context, q *gen.Queries, op, err error, id string, expectedVersion int64) error {
+	if expectedVersion != 0 && errors.Is(err, sql.ErrNoRows) {
+		if _, getErr := getUser(ctx, q, id); getErr == nil {
+			return store.PreconditionFailed(fmt.Errorf("%w: expected version %d", op, expectedVersion))
+		}
+	}
+
+	return classify(op, err)
+}
+
+// classifyNotDeleted classifies the failure to find a deleted user, which
+// either does not exist or is not deleted.
+func classifyNotDeleted(ctx context.Context, q *gen.Queries, op, err error, id string) error {
+	if errors.Is(err, sql.ErrNoRows) {
+		if _, getErr := getUser(ctx, q, id); getErr == nil {
+			return store.AlreadyExists(fmt.Errorf("%w: user is not deleted", op))
+		}
+	}
+
+	return classify(op, err)
+}
+
+// classify wraps err with the operation that failed and the store error kind
+// that best describes it.
+func classify(op, err error) error {
+	err = fmt.Errorf("%w: %w", op, err)
+
+	if errors.Is(err, sql.ErrNoRows) {
+		return store.NotFound(err)
+	}
+
+	var sqliteErr *sqlitedriver.Error
+	if errors.As(err, &sqliteErr) {
+		switch sqliteErr.Code() {
+		case sqlite3.SQLITE_CONSTRAINT_PRIMARYKEY, sqlite3.SQLITE_CONSTRAINT_UNIQUE:
+			return store.AlreadyExists(err)
+		}
+
+		// Extended result codes carry the primary code in the low byte.
+		switch sqliteErr.Code() & 0xff {
+		case sqlite3.SQLITE_CONSTRAINT:
+			return store.Conflict(err)
+		case sqlite3.SQLITE_BUSY, sqlite3.SQLITE_LOCKED:
+			return store.Unavailable(err)
+		}
+	}
+
+	return store.Internal(err)
+}
+
+// dsn adds the pragmas every connection should run to the database path.
+func (s *Store) dsn(path string) string {
+	pragmas := url.Values{}
+	pragmas.Add("_pragma", fmt.Sprintf("busy_timeout(%d)", s.busyTimeout.Milliseconds()))
+	// Transactions take the write lock as they begin. A deferred transaction
+	// only takes it at its first write, and two of them upgrading at once
+	// fail with SQLITE_BUSY instead of waiting out the busy timeout.
+	pragmas.Set("_txlock", "immediate")
+	if s.journalMode != "" {
+		pragmas.Add("_pragma", fmt.Sprintf("journal_mode(%s)", s.journalMode))
+	}
+
+	sep := "?"
+	if strings.Contains(path, "?") {
+		sep = "&"
+	}
+	return path + sep + pragmas.Encode()
+}
+
+func isMemory(dsn string) bool {
+	return dsn == ":memory:" || strings.Contains(dsn, "mode=memory")
+}
+
+func convertRevision(db gen.UserRevision) (*pb.UserRevision, error) {
+	revision := &pb.UserRevision{}
+	if err := proto.Unmarshal(db.Revision, revision); err != nil {
+		return nil, err
+	}
+	return revision, nil
+}
+
 func convertUser(ctx context.Context, db gen.User) (*pb.User, error) {
 	user := &pb.User{
-		Id:    db.ID,
-		Name:  db.Name,
-		Email: db.Email,
+		Id:      db.ID,
+		Name:    db.Name,
+		Email:   db.Email,
+		Version: db.Version,
 	}
 
-	t, err := time.Parse(time.RFC3339, db.CreatedAt)
+	t, err := time.Parse(time.RFC3339Nano, db.CreatedAt)
 	if err != nil {
 		msg := "could not parse created at timestamp"
 		slog.ErrorContext(ctx, msg,
@@ -150,7 +1219,7 @@ func convertUser(ctx context.Context, db gen.User) (*pb.User, error) {
 	}
 	user.CreatedAt = timestamppb.New(t)
 
-	t, err = time.Parse(time.RFC3339, db.UpdatedAt)
+	t, err = time.Parse(time.RFC3339Nano, db.UpdatedAt)
 	if err != nil {
 		msg := "could not parse updated at timestamp"
 		slog.ErrorContext(ctx, msg,
@@ -162,5 +1231,33 @@ func convertUser(ctx context.Context, db gen.User) (*pb.User, error) {
 	}
 	user.UpdatedAt = timestamppb.New(t)
 
+	if db.DeletedAt.Valid {
+		t, err = time.Parse(time.RFC3339Nano, db.DeletedAt.String)
+		if err != nil {
+			msg := "could not parse deleted at timestamp"
+			slog.ErrorContext(ctx, msg,
+				slog.Any("error", err),
+				slog.String("user id", db.ID),
+				slog.String("deleted at", db.DeletedAt.String),
+			)
+			return nil, fmt.Errorf("%s: %w", msg, err)
+		}
+		user.DeletedAt = timestamppb.New(t)
+	}
+
 	return user, nil
 }
+
+func convertIdempotencyKey(db gen.IdempotencyKey) (*store.IdempotencyRecord, error) {
+	expiresAt, err := time.Parse(time.RFC3339Nano, db.ExpiresAt)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse expires at timestamp: %w", err)
+	}
+	return &store.IdempotencyRecord{
+		Key:         db.IdempotencyKey,
+		Fingerprint: db.Fingerprint,
+		Completed:   db.Completed,
+		Response:    db.Response,
+		ExpiresAt:   expiresAt,
+	}, nil
+}
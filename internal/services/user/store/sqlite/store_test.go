@@ -0,0 +1,48 @@
+package sqlite
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/andrew-womeldorf/connect-boilerplate/internal/services/user/store"
+	"github.com/andrew-womeldorf/connect-boilerplate/internal/services/user/store/storetest"
+)
+
+func TestStore(t *testing.T) {
+	ctx := context.Background()
+
+	storetest.Run(t, func(t *testing.T) store.Store {
+		s, err := NewStore(ctx, filepath.Join(t.TempDir(), "test.db"))
+		if err != nil {
+			t.Fatalf("failed to create store: %v", err)
+		}
+
+		t.Cleanup(func() {
+			if err := s.Close(); err != nil {
+				t.Errorf("failed to close store: %v", err)
+			}
+		})
+
+		return s
+	})
+}
+
+func TestSearch(t *testing.T) {
+	ctx := context.Background()
+
+	storetest.RunSearch(t, func(t *testing.T) storetest.SearchableStore {
+		s, err := NewStore(ctx, filepath.Join(t.TempDir(), "test.db"))
+		if err != nil {
+			t.Fatalf("failed to create store: %v", err)
+		}
+
+		t.Cleanup(func() {
+			if err := s.Close(); err != nil {
+				t.Errorf("failed to close store: %v", err)
+			}
+		})
+
+		return s
+	})
+}
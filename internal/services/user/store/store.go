@@ -2,14 +2,118 @@ package store
 
 import (
 	"context"
+	"strings"
+	"time"
 
 	pb "github.com/andrew-womeldorf/connect-boilerplate/gen/user/v1"
 )
 
+const (
+	// DefaultPageSize is used when a caller does not request a page size.
+	DefaultPageSize = 25
+
+	// MaxPageSize is the largest page a store will return, regardless of the
+	// requested page size.
+	MaxPageSize = 100
+)
+
+// FirstVersion is the version of a newly created user.
+const FirstVersion int64 = 1
+
+// Store persists users. Timestamps are kept to at least microsecond
+// precision; backends may truncate anything finer.
+//
+// Every user carries a version, which is FirstVersion on create, whatever
+// the given user says, and is incremented by every update. Operations taking
+// an expectedVersion fail with ErrPreconditionFailed when it is non-zero and
+// does not match the stored version; zero applies them unconditionally.
+//
+// Emails are unique regardless of case: creating or updating a user with an
+// email another user of the tenant has, as compared by NormalizeEmail, fails with
+// ErrAlreadyExists.
+//
+// Users are partitioned by tenant: every method acts only on the users of the
+// tenant of its context, as set by WithTenant, and ids and emails need only
+// be unique within a tenant.
+//
+// Deleting a user only sets its deleted_at, which, like any change, moves its
+// version. A deleted user keeps its id but frees its email, and is left out
+// of everything but listings asking for it, as UserFilter.ShowDeleted does,
+// until it is undeleted or purged. Writes to a deleted user fail with
+// ErrNotFound.
+//
+// Every change is recorded in the store's Outbox, AuditLog and RevisionLog as
+// it is made. The IdempotencyStore is written apart from the users, in
+// transactions of its own.
 type Store interface {
 	CreateUser(context.Context, *pb.User) error
-	DeleteUser(context.Context, string) error
+	// DeleteUser marks the user with id deleted at deletedAt.
+	DeleteUser(ctx context.Context, id string, expectedVersion int64, deletedAt time.Time) error
+	// UndeleteUser restores the deleted user with id at undeletedAt, and
+	// returns it. It fails with ErrAlreadyExists if the user is not deleted,
+	// or another user has taken its email since.
+	UndeleteUser(ctx context.Context, id string, expectedVersion int64, undeletedAt time.Time) (*pb.User, error)
 	GetUser(context.Context, string) (*pb.User, error)
-	ListUsers(context.Context) ([]*pb.User, error)
-	UpdateUser(context.Context, *pb.User) error
+	// GetUserByEmail returns the user whose email matches email regardless
+	// of case.
+	GetUserByEmail(ctx context.Context, email string) (*pb.User, error)
+	// ListUsers returns a page of the users matching params.Filter, in
+	// params.OrderBy, and a token for the next page if there may be one.
+	ListUsers(context.Context, ListUsersParams) ([]*pb.User, string, error)
+	// CountUsers returns how many users match filter.
+	CountUsers(ctx context.Context, filter UserFilter) (int64, error)
+	// UpdateUser sets the mutable fields of an existing user that mask
+	// selects to their values in user, and returns the stored result.
+	// updated_at is always set, and created_at never changed.
+	UpdateUser(ctx context.Context, user *pb.User, mask FieldMask, expectedVersion int64) (*pb.User, error)
+
+	// The batch methods apply their operation to each item independently,
+	// so one failing item does not stop the others. They return a result for
+	// every item, in order; the error is for failures of the whole batch.
+	BatchGetUsers(ctx context.Context, ids []string) ([]BatchResult, error)
+	BatchCreateUsers(ctx context.Context, users []*pb.User) ([]BatchResult, error)
+	BatchDeleteUsers(ctx context.Context, deletes []BatchDelete, deletedAt time.Time) ([]BatchResult, error)
+
+	Outbox
+	AuditLog
+	RevisionLog
+	IdempotencyStore
+	Purger
+}
+
+// Purger permanently removes deleted users.
+type Purger interface {
+	// PurgeUsers removes up to limit users, of any tenant, that were deleted
+	// before deletedBefore, oldest deletion first, and returns how many it
+	// removed. Each removal is recorded in the Outbox and AuditLog at
+	// purgedAt, under the tenant of the user, and removes the user's
+	// revisions.
+	PurgeUsers(ctx context.Context, deletedBefore time.Time, limit int32, purgedAt time.Time) (int, error)
+}
+
+// FieldMask selects the mutable fields of a user that an update changes.
+type FieldMask struct {
+	Name  bool
+	Email bool
+}
+
+// AllFields selects every mutable field.
+var AllFields = FieldMask{Name: true, Email: true}
+
+// Paths returns the field mask paths of the fields selected.
+func (m FieldMask) Paths() []string {
+	var paths []string
+	if m.Name {
+		paths = append(paths, "name")
+	}
+	if m.Email {
+		paths = append(paths, "email")
+	}
+	return paths
+}
+
+// NormalizeEmail returns the form of email that uniqueness is enforced on, so
+// that addresses differing only in case belong to one user.
+func NormalizeEmail(email string) string {
+	return strings.ToLower(email)
 }
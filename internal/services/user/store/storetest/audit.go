@@ -0,0 +1,212 @@
+package storetest
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	pb "github.com/andrew-womeldorf/connect-boilerplate/gen/user/v1"
+	"github.com/andrew-womeldorf/connect-boilerplate/internal/services/user/store"
+)
+
+func testAudit(ctx context.Context, t *testing.T, factory Factory) {
+	t.Run("records_changes", func(t *testing.T) {
+		s := factory(t)
+
+		info := store.AuditInfo{Actor: "alice", RPC: "UpdateUser", RequestID: "req-1"}
+		ctx := store.WithAuditInfo(ctx, info)
+
+		user := createTestUser("user-1", "Test User", "test@example.com")
+		if err := s.CreateUser(ctx, user); err != nil {
+			t.Fatalf("failed to create user: %v", err)
+		}
+		user.Name = "Renamed"
+		if _, err := s.UpdateUser(ctx, user, store.FieldMask{Name: true}, 0); err != nil {
+			t.Fatalf("failed to update user: %v", err)
+		}
+		if err := s.DeleteUser(ctx, user.GetId(), 0, time.Now()); err != nil {
+			t.Fatalf("failed to delete user: %v", err)
+		}
+		deleted := deletedUser(ctx, t, s, user.GetId())
+		if _, err := s.UndeleteUser(ctx, user.GetId(), 0, time.Now()); err != nil {
+			t.Fatalf("failed to undelete user: %v", err)
+		}
+
+		events := auditEvents(ctx, t, s, store.AuditFilter{})
+		if len(events) != 4 {
+			t.Fatalf("expected 4 audit events, got %d", len(events))
+		}
+
+		deletedAt := deleted.GetDeletedAt().AsTime().Format(time.RFC3339Nano)
+
+		want := []struct {
+			action  pb.AuditAction
+			changes []*pb.FieldChange
+		}{
+			{pb.AuditAction_AUDIT_ACTION_CREATE, []*pb.FieldChange{
+				{Field: "name", After: "Test User"},
+				{Field: "email", After: "test@example.com"},
+			}},
+			{pb.AuditAction_AUDIT_ACTION_UPDATE, []*pb.FieldChange{
+				{Field: "name", Before: "Test User", After: "Renamed"},
+			}},
+			{pb.AuditAction_AUDIT_ACTION_DELETE, []*pb.FieldChange{
+				{Field: "deleted_at", After: deletedAt},
+			}},
+			{pb.AuditAction_AUDIT_ACTION_UNDELETE, []*pb.FieldChange{
+				{Field: "deleted_at", Before: deletedAt},
+			}},
+		}
+		for i, event := range events {
+			if event.GetAction() != want[i].action {
+				t.Errorf("event %d: expected action %v, got %v", i, want[i].action, event.GetAction())
+			}
+			if !slices.EqualFunc(event.GetChanges(), want[i].changes, equalChange) {
+				t.Errorf("event %d: expected changes %v, got %v", i, want[i].changes, event.GetChanges())
+			}
+			if event.GetUserId() != user.GetId() {
+				t.Errorf("event %d: expected user id %q, got %q", i, user.GetId(), event.GetUserId())
+			}
+			got := store.AuditInfo{Actor: event.GetActor(), RPC: event.GetRpc(), RequestID: event.GetRequestId()}
+			if got != info {
+				t.Errorf("event %d: expected %+v, got %+v", i, info, got)
+			}
+			if event.GetId() == "" || event.GetOccurredAt() == nil {
+				t.Errorf("event %d: expected an id and occurred_at, got %v", i, event)
+			}
+		}
+	})
+
+	t.Run("skips_failed_writes", func(t *testing.T) {
+		s := factory(t)
+
+		user := createTestUser("user-1", "Test User", "test@example.com")
+		if err := s.CreateUser(ctx, user); err != nil {
+			t.Fatalf("failed to create user: %v", err)
+		}
+
+		if err := s.CreateUser(ctx, user); err == nil {
+			t.Fatal("expected creating a duplicate user to fail")
+		}
+		if _, err := s.UpdateUser(ctx, user, store.AllFields, store.FirstVersion+1); err == nil {
+			t.Fatal("expected an update at the wrong version to fail")
+		}
+		if err := s.DeleteUser(ctx, "missing", 0, time.Now()); err == nil {
+			t.Fatal("expected deleting a missing user to fail")
+		}
+
+		if events := auditEvents(ctx, t, s, store.AuditFilter{}); len(events) != 1 {
+			t.Errorf("expected only the create to be audited, got %v", events)
+		}
+	})
+
+	t.Run("filter_and_pages", func(t *testing.T) {
+		s := factory(t)
+
+		start := time.Now().Truncate(time.Second)
+		for i, id := range []string{"user-1", "user-2", "user-3"} {
+			user := createTestUser(id, id, id+"@example.com")
+			user.CreatedAt = timestamppb.New(start.Add(time.Duration(i) * time.Hour))
+			user.UpdatedAt = user.CreatedAt
+			if err := s.CreateUser(ctx, user); err != nil {
+				t.Fatalf("failed to create user: %v", err)
+			}
+		}
+		update := &pb.User{Id: "user-1", Name: "Renamed", UpdatedAt: timestamppb.New(start.Add(3 * time.Hour))}
+		if _, err := s.UpdateUser(ctx, update, store.FieldMask{Name: true}, 0); err != nil {
+			t.Fatalf("failed to update user: %v", err)
+		}
+
+		var got []string
+		for _, event := range auditEvents(ctx, t, s, store.AuditFilter{UserID: "user-1"}) {
+			got = append(got, event.GetAction().String())
+		}
+		if want := []string{"AUDIT_ACTION_CREATE", "AUDIT_ACTION_UPDATE"}; !slices.Equal(got, want) {
+			t.Errorf("expected %v for user-1, got %v", want, got)
+		}
+
+		got = nil
+		filter := store.AuditFilter{StartTime: start.Add(time.Hour), EndTime: start.Add(3 * time.Hour)}
+		for _, event := range auditEvents(ctx, t, s, filter) {
+			got = append(got, event.GetUserId())
+		}
+		if want := []string{"user-2", "user-3"}; !slices.Equal(got, want) {
+			t.Errorf("expected events for %v in the range, got %v", want, got)
+		}
+
+		// Paging one event at a time visits them all, in order.
+		got = nil
+		params := store.ListAuditEventsParams{PageSize: 1}
+		for {
+			events, next, err := s.ListAuditEvents(ctx, params)
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			for _, event := range events {
+				got = append(got, event.GetUserId())
+			}
+			if next == "" {
+				break
+			}
+			params.PageToken = next
+		}
+		if want := []string{"user-1", "user-2", "user-3", "user-1"}; !slices.Equal(got, want) {
+			t.Errorf("expected events for %v, got %v", want, got)
+		}
+	})
+
+	t.Run("batch", func(t *testing.T) {
+		s := factory(t)
+
+		results, err := s.BatchCreateUsers(ctx, []*pb.User{
+			createTestUser("user-1", "One", "one@example.com"),
+			createTestUser("user-2", "Two", "one@example.com"),
+		})
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if results[1].Err == nil {
+			t.Fatal("expected the user with a taken email to fail")
+		}
+
+		if _, err := s.BatchDeleteUsers(ctx, []store.BatchDelete{{ID: "user-1"}, {ID: "missing"}}, time.Now()); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+
+		var got []string
+		for _, event := range auditEvents(ctx, t, s, store.AuditFilter{}) {
+			got = append(got, event.GetUserId()+" "+event.GetAction().String())
+		}
+		if want := []string{"user-1 AUDIT_ACTION_CREATE", "user-1 AUDIT_ACTION_DELETE"}; !slices.Equal(got, want) {
+			t.Errorf("expected %v, got %v", want, got)
+		}
+	})
+
+	t.Run("invalid_page_token", func(t *testing.T) {
+		s := factory(t)
+
+		_, _, err := s.ListAuditEvents(ctx, store.ListAuditEventsParams{PageToken: "not a token"})
+		if !errors.Is(err, store.ErrInvalidPageToken) {
+			t.Errorf("expected ErrInvalidPageToken, got %v", err)
+		}
+	})
+}
+
+// auditEvents returns every audit event of s matching filter.
+func auditEvents(ctx context.Context, t *testing.T, s store.Store, filter store.AuditFilter) []*pb.AuditEvent {
+	t.Helper()
+
+	events, _, err := s.ListAuditEvents(ctx, store.ListAuditEventsParams{PageSize: store.MaxPageSize, Filter: filter})
+	if err != nil {
+		t.Fatalf("failed to list audit events: %v", err)
+	}
+	return events
+}
+
+func equalChange(a, b *pb.FieldChange) bool {
+	return a.GetField() == b.GetField() && a.GetBefore() == b.GetBefore() && a.GetAfter() == b.GetAfter()
+}
@@ -0,0 +1,286 @@
+package storetest
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	pb "github.com/andrew-womeldorf/connect-boilerplate/gen/user/v1"
+	"github.com/andrew-womeldorf/connect-boilerplate/internal/services/user/store"
+)
+
+func testSoftDelete(ctx context.Context, t *testing.T, factory Factory) {
+	t.Run("hides_deleted_users", func(t *testing.T) {
+		s := factory(t)
+
+		for _, user := range []*pb.User{
+			createTestUser("user-1", "Alice", "alice@example.com"),
+			createTestUser("user-2", "Bob", "bob@example.com"),
+		} {
+			if err := s.CreateUser(ctx, user); err != nil {
+				t.Fatalf("failed to create user: %v", err)
+			}
+		}
+		if err := s.DeleteUser(ctx, "user-1", 0, time.Now()); err != nil {
+			t.Fatalf("failed to delete user: %v", err)
+		}
+
+		if _, err := s.GetUser(ctx, "user-1"); !errors.Is(err, store.ErrNotFound) {
+			t.Errorf("expected GetUser of a deleted user to be ErrNotFound, got %v", err)
+		}
+		if _, err := s.GetUserByEmail(ctx, "alice@example.com"); !errors.Is(err, store.ErrNotFound) {
+			t.Errorf("expected GetUserByEmail of a deleted user to be ErrNotFound, got %v", err)
+		}
+		results, err := s.BatchGetUsers(ctx, []string{"user-1"})
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if !errors.Is(results[0].Err, store.ErrNotFound) {
+			t.Errorf("expected BatchGetUsers of a deleted user to be ErrNotFound, got %v", results[0])
+		}
+		renamed := createTestUser("user-1", "Renamed", "")
+		if _, err := s.UpdateUser(ctx, renamed, store.FieldMask{Name: true}, 0); !errors.Is(err, store.ErrNotFound) {
+			t.Errorf("expected UpdateUser of a deleted user to be ErrNotFound, got %v", err)
+		}
+		if err := s.DeleteUser(ctx, "user-1", 0, time.Now()); !errors.Is(err, store.ErrNotFound) {
+			t.Errorf("expected DeleteUser of a deleted user to be ErrNotFound, got %v", err)
+		}
+
+		if got := listIDs(ctx, t, s, store.ListUsersParams{}); !slices.Equal(got, []string{"user-2"}) {
+			t.Errorf("expected only the live user listed, got %v", got)
+		}
+		params := store.ListUsersParams{Filter: store.UserFilter{ShowDeleted: true}}
+		if got := listIDs(ctx, t, s, params); !slices.Equal(got, []string{"user-1", "user-2"}) {
+			t.Errorf("expected show_deleted to list both users, got %v", got)
+		}
+
+		for _, tt := range []struct {
+			filter store.UserFilter
+			want   int64
+		}{
+			{store.UserFilter{}, 1},
+			{store.UserFilter{ShowDeleted: true}, 2},
+		} {
+			count, err := s.CountUsers(ctx, tt.filter)
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if count != tt.want {
+				t.Errorf("expected %d users counted with %+v, got %d", tt.want, tt.filter, count)
+			}
+		}
+
+		deleted := deletedUser(ctx, t, s, "user-1")
+		if deleted.GetDeletedAt() == nil || deleted.GetVersion() != store.FirstVersion+1 {
+			t.Errorf("expected deleted_at set and the version bumped, got %v", deleted)
+		}
+	})
+
+	t.Run("undelete", func(t *testing.T) {
+		s := factory(t)
+
+		user := createTestUser("user-1", "Alice", "alice@example.com")
+		if err := s.CreateUser(ctx, user); err != nil {
+			t.Fatalf("failed to create user: %v", err)
+		}
+		if err := s.DeleteUser(ctx, user.GetId(), 0, time.Now()); err != nil {
+			t.Fatalf("failed to delete user: %v", err)
+		}
+
+		if _, err := s.UndeleteUser(ctx, user.GetId(), store.FirstVersion, time.Now()); !errors.Is(err, store.ErrPreconditionFailed) {
+			t.Errorf("expected ErrPreconditionFailed for a stale version, got %v", err)
+		}
+
+		restored, err := s.UndeleteUser(ctx, user.GetId(), store.FirstVersion+1, time.Now())
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if restored.GetDeletedAt() != nil || restored.GetVersion() != store.FirstVersion+2 {
+			t.Errorf("expected deleted_at cleared and the version bumped, got %v", restored)
+		}
+		if restored.GetName() != user.GetName() || restored.GetEmail() != user.GetEmail() {
+			t.Errorf("expected the user restored as it was, got %v", restored)
+		}
+
+		if _, err := s.GetUser(ctx, user.GetId()); err != nil {
+			t.Errorf("expected the restored user to be found, got %v", err)
+		}
+		if _, err := s.GetUserByEmail(ctx, user.GetEmail()); err != nil {
+			t.Errorf("expected the restored user to be found by email, got %v", err)
+		}
+
+		if _, err := s.UndeleteUser(ctx, user.GetId(), 0, time.Now()); !errors.Is(err, store.ErrAlreadyExists) {
+			t.Errorf("expected undeleting a live user to be ErrAlreadyExists, got %v", err)
+		}
+		if _, err := s.UndeleteUser(ctx, "missing", 0, time.Now()); !errors.Is(err, store.ErrNotFound) {
+			t.Errorf("expected undeleting a missing user to be ErrNotFound, got %v", err)
+		}
+	})
+
+	t.Run("undelete_email_taken", func(t *testing.T) {
+		s := factory(t)
+
+		if err := s.CreateUser(ctx, createTestUser("user-1", "Alice", "alice@example.com")); err != nil {
+			t.Fatalf("failed to create user: %v", err)
+		}
+		if err := s.DeleteUser(ctx, "user-1", 0, time.Now()); err != nil {
+			t.Fatalf("failed to delete user: %v", err)
+		}
+		if err := s.CreateUser(ctx, createTestUser("user-2", "Alice", "Alice@example.com")); err != nil {
+			t.Fatalf("expected the deleted user's email to be free, got %v", err)
+		}
+
+		if _, err := s.UndeleteUser(ctx, "user-1", 0, time.Now()); !errors.Is(err, store.ErrAlreadyExists) {
+			t.Fatalf("expected ErrAlreadyExists once the email is taken, got %v", err)
+		}
+		if deleted := deletedUser(ctx, t, s, "user-1"); deleted.GetDeletedAt() == nil {
+			t.Errorf("expected the user to stay deleted, got %v", deleted)
+		}
+	})
+
+	t.Run("purge", func(t *testing.T) {
+		s := factory(t)
+
+		for _, id := range []string{"user-1", "user-2", "user-3"} {
+			if err := s.CreateUser(ctx, createTestUser(id, id, id+"@example.com")); err != nil {
+				t.Fatalf("failed to create user: %v", err)
+			}
+		}
+		for _, id := range []string{"user-1", "user-2"} {
+			if err := s.DeleteUser(ctx, id, 0, time.Now()); err != nil {
+				t.Fatalf("failed to delete user: %v", err)
+			}
+		}
+
+		if n, err := s.PurgeUsers(ctx, time.Now().Add(-time.Hour), 10, time.Now()); err != nil || n != 0 {
+			t.Fatalf("expected no users deleted over an hour ago, got %d and %v", n, err)
+		}
+
+		due := time.Now().Add(time.Hour)
+		for _, want := range []int{1, 1, 0} {
+			n, err := s.PurgeUsers(ctx, due, 1, time.Now())
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if n != want {
+				t.Errorf("expected %d users purged, got %d", want, n)
+			}
+		}
+
+		params := store.ListUsersParams{Filter: store.UserFilter{ShowDeleted: true}}
+		if got := listIDs(ctx, t, s, params); !slices.Equal(got, []string{"user-3"}) {
+			t.Errorf("expected only the live user left, got %v", got)
+		}
+		if _, err := s.UndeleteUser(ctx, "user-1", 0, time.Now()); !errors.Is(err, store.ErrNotFound) {
+			t.Errorf("expected undeleting a purged user to be ErrNotFound, got %v", err)
+		}
+		if err := s.CreateUser(ctx, createTestUser("user-1", "Alice", "alice@example.com")); err != nil {
+			t.Errorf("expected a purged user's id to be free, got %v", err)
+		}
+	})
+
+	t.Run("purge_records_changes", func(t *testing.T) {
+		s := factory(t)
+		acme := store.WithTenant(ctx, "acme")
+
+		if err := s.CreateUser(acme, createTestUser("user-1", "Alice", "alice@example.com")); err != nil {
+			t.Fatalf("failed to create user: %v", err)
+		}
+		if err := s.DeleteUser(acme, "user-1", 0, time.Now()); err != nil {
+			t.Fatalf("failed to delete user: %v", err)
+		}
+
+		// Purging covers every tenant, whichever the context names.
+		if n, err := s.PurgeUsers(ctx, time.Now().Add(time.Hour), 10, time.Now()); err != nil || n != 1 {
+			t.Fatalf("expected 1 user purged, got %d and %v", n, err)
+		}
+
+		events := pendingEvents(ctx, t, s)
+		last := events[len(events)-1]
+		if last.GetUserPurged().GetUserId() != "user-1" || last.GetTenantId() != "acme" {
+			t.Errorf("expected the purge of user-1 in acme, got %v", last)
+		}
+
+		audit := auditEvents(acme, t, s, store.AuditFilter{UserID: "user-1"})
+		purged := audit[len(audit)-1]
+		if purged.GetAction() != pb.AuditAction_AUDIT_ACTION_PURGE {
+			t.Errorf("expected the purge audited last, got %v", purged)
+		}
+		changes := purged.GetChanges()
+		if len(changes) != 3 || !equalChange(changes[0], &pb.FieldChange{Field: "name", Before: "Alice"}) {
+			t.Errorf("expected the purged user's fields as before values, got %v", changes)
+		}
+	})
+
+	t.Run("records_the_given_times", func(t *testing.T) {
+		s := factory(t)
+
+		if err := s.CreateUser(ctx, createTestUser("user-1", "Alice", "alice@example.com")); err != nil {
+			t.Fatalf("failed to create user: %v", err)
+		}
+
+		// The times are the caller's, not the store's clock, so they are
+		// set well apart from it.
+		now := time.Now().Truncate(time.Microsecond)
+		deletedAt, undeletedAt := now.Add(time.Hour), now.Add(2*time.Hour)
+		redeletedAt, purgedAt := now.Add(3*time.Hour), now.Add(4*time.Hour)
+
+		if err := s.DeleteUser(ctx, "user-1", 0, deletedAt); err != nil {
+			t.Fatalf("failed to delete user: %v", err)
+		}
+		if got := deletedUser(ctx, t, s, "user-1").GetDeletedAt().AsTime(); !got.Equal(deletedAt) {
+			t.Errorf("expected deleted_at %v, got %v", deletedAt, got)
+		}
+		if _, err := s.UndeleteUser(ctx, "user-1", 0, undeletedAt); err != nil {
+			t.Fatalf("failed to undelete user: %v", err)
+		}
+		if _, err := s.BatchDeleteUsers(ctx, []store.BatchDelete{{ID: "user-1"}}, redeletedAt); err != nil {
+			t.Fatalf("failed to delete user: %v", err)
+		}
+		if n, err := s.PurgeUsers(ctx, purgedAt, 10, purgedAt); err != nil || n != 1 {
+			t.Fatalf("expected 1 user purged, got %d and %v", n, err)
+		}
+
+		want := []time.Time{deletedAt, undeletedAt, redeletedAt, purgedAt}
+
+		events := pendingEvents(ctx, t, s)
+		var occurred []time.Time
+		for _, event := range events[1:] {
+			occurred = append(occurred, event.GetOccurredAt().AsTime())
+		}
+		if !slices.EqualFunc(occurred, want, time.Time.Equal) {
+			t.Errorf("expected events at %v, got %v", want, occurred)
+		}
+
+		audit := auditEvents(ctx, t, s, store.AuditFilter{UserID: "user-1"})
+		occurred = nil
+		for _, event := range audit[1:] {
+			occurred = append(occurred, event.GetOccurredAt().AsTime())
+		}
+		if !slices.EqualFunc(occurred, want, time.Time.Equal) {
+			t.Errorf("expected audit events at %v, got %v", want, occurred)
+		}
+	})
+}
+
+// deletedUser returns the user with id, which may be deleted, by listing
+// every user.
+func deletedUser(ctx context.Context, t *testing.T, s store.Store, id string) *pb.User {
+	t.Helper()
+
+	params := store.ListUsersParams{PageSize: store.MaxPageSize, Filter: store.UserFilter{ShowDeleted: true}}
+	users, _, err := s.ListUsers(ctx, params)
+	if err != nil {
+		t.Fatalf("failed to list users: %v", err)
+	}
+	for _, user := range users {
+		if user.GetId() == id {
+			return user
+		}
+	}
+
+	t.Fatalf("expected user %q to be listed", id)
+	return nil
+}
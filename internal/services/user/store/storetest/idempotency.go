@@ -0,0 +1,177 @@
+package storetest
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/andrew-womeldorf/connect-boilerplate/internal/services/user/store"
+)
+
+func testIdempotency(ctx context.Context, t *testing.T, factory Factory) {
+	record := func(key, fingerprint string) store.IdempotencyRecord {
+		return store.IdempotencyRecord{Key: key, Fingerprint: fingerprint, ExpiresAt: time.Now().Add(time.Hour)}
+	}
+	completion := func(key, fingerprint string, response []byte) store.IdempotencyRecord {
+		completed := record(key, fingerprint)
+		completed.Response = response
+		return completed
+	}
+
+	t.Run("claim", func(t *testing.T) {
+		s := factory(t)
+
+		if kept := claimKey(ctx, t, s, record("key-1", "first")); kept != nil {
+			t.Fatalf("expected a new key to be claimed, got %v", kept)
+		}
+
+		kept := claimKey(ctx, t, s, record("key-1", "second"))
+		if kept == nil {
+			t.Fatal("expected a claimed key to be kept")
+		}
+		if kept.Key != "key-1" || kept.Fingerprint != "first" || kept.Completed {
+			t.Errorf("expected the first claim in progress, got %+v", kept)
+		}
+
+		if kept := claimKey(ctx, t, s, record("key-2", "second")); kept != nil {
+			t.Errorf("expected another key to be claimed, got %v", kept)
+		}
+	})
+
+	t.Run("complete", func(t *testing.T) {
+		s := factory(t)
+
+		claimKey(ctx, t, s, record("key-1", "first"))
+		if err := s.CompleteIdempotencyKey(ctx, completion("key-1", "first", []byte("response"))); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+
+		kept := claimKey(ctx, t, s, record("key-1", "first"))
+		if kept == nil || !kept.Completed || !bytes.Equal(kept.Response, []byte("response")) {
+			t.Fatalf("expected the completed record, got %+v", kept)
+		}
+
+		if err := s.CompleteIdempotencyKey(ctx, completion("key-1", "first", []byte("other"))); !errors.Is(err, store.ErrNotFound) {
+			t.Errorf("expected completing twice to be ErrNotFound, got %v", err)
+		}
+		if err := s.CompleteIdempotencyKey(ctx, completion("missing", "first", []byte("response"))); !errors.Is(err, store.ErrNotFound) {
+			t.Errorf("expected completing an unclaimed key to be ErrNotFound, got %v", err)
+		}
+	})
+
+	t.Run("complete_another_claim", func(t *testing.T) {
+		s := factory(t)
+
+		claimKey(ctx, t, s, record("key-1", "first"))
+		if err := s.CompleteIdempotencyKey(ctx, completion("key-1", "second", []byte("response"))); !errors.Is(err, store.ErrNotFound) {
+			t.Errorf("expected completing another request's claim to be ErrNotFound, got %v", err)
+		}
+		if kept := claimKey(ctx, t, s, record("key-1", "first")); kept == nil || kept.Completed {
+			t.Errorf("expected the claim still in progress, got %+v", kept)
+		}
+	})
+
+	t.Run("complete_extends_expiry", func(t *testing.T) {
+		s := factory(t)
+
+		// The claim's lease ran out, but no other request took the key.
+		leased := record("key-1", "first")
+		leased.ExpiresAt = time.Now().Add(-2 * time.Second)
+		claimKey(ctx, t, s, leased)
+		if err := s.CompleteIdempotencyKey(ctx, completion("key-1", "first", []byte("response"))); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+
+		kept := claimKey(ctx, t, s, record("key-1", "second"))
+		if kept == nil || !kept.Completed || kept.Fingerprint != "first" {
+			t.Errorf("expected the completed record kept until its new expiry, got %+v", kept)
+		}
+	})
+
+	t.Run("empty_response", func(t *testing.T) {
+		s := factory(t)
+
+		claimKey(ctx, t, s, record("key-1", "first"))
+		if err := s.CompleteIdempotencyKey(ctx, completion("key-1", "first", nil)); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+
+		kept := claimKey(ctx, t, s, record("key-1", "first"))
+		if kept == nil || !kept.Completed || len(kept.Response) != 0 {
+			t.Errorf("expected a completed record with an empty response, got %+v", kept)
+		}
+	})
+
+	t.Run("release", func(t *testing.T) {
+		s := factory(t)
+
+		claimKey(ctx, t, s, record("key-1", "first"))
+		if err := s.ReleaseIdempotencyKey(ctx, "key-1"); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if kept := claimKey(ctx, t, s, record("key-1", "second")); kept != nil {
+			t.Errorf("expected a released key to be claimed again, got %v", kept)
+		}
+
+		if err := s.CompleteIdempotencyKey(ctx, completion("key-1", "second", []byte("response"))); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if err := s.ReleaseIdempotencyKey(ctx, "key-1"); err != nil {
+			t.Fatalf("expected releasing a completed key to be ignored, got %v", err)
+		}
+		if kept := claimKey(ctx, t, s, record("key-1", "third")); kept == nil || !kept.Completed {
+			t.Errorf("expected a completed key to stay completed, got %+v", kept)
+		}
+
+		if err := s.ReleaseIdempotencyKey(ctx, "missing"); err != nil {
+			t.Errorf("expected releasing an unclaimed key to be ignored, got %v", err)
+		}
+	})
+
+	t.Run("expiry", func(t *testing.T) {
+		s := factory(t)
+
+		expired := record("key-1", "first")
+		expired.ExpiresAt = time.Now().Add(-2 * time.Second)
+		claimKey(ctx, t, s, expired)
+		expired.Response = []byte("response")
+		if err := s.CompleteIdempotencyKey(ctx, expired); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+
+		if kept := claimKey(ctx, t, s, record("key-1", "second")); kept != nil {
+			t.Fatalf("expected an expired key to be claimed again, got %+v", kept)
+		}
+		if kept := claimKey(ctx, t, s, record("key-1", "third")); kept == nil || kept.Fingerprint != "second" {
+			t.Errorf("expected the new claim kept, got %+v", kept)
+		}
+	})
+
+	t.Run("tenants", func(t *testing.T) {
+		s := factory(t)
+		acme := store.WithTenant(ctx, "acme")
+		globex := store.WithTenant(ctx, "globex")
+
+		claimKey(acme, t, s, record("key-1", "first"))
+		if kept := claimKey(globex, t, s, record("key-1", "second")); kept != nil {
+			t.Errorf("expected another tenant to claim the key, got %+v", kept)
+		}
+		if kept := claimKey(acme, t, s, record("key-1", "third")); kept == nil || kept.Fingerprint != "first" {
+			t.Errorf("expected the tenant's claim kept, got %+v", kept)
+		}
+	})
+}
+
+// claimKey claims record's key, and returns the record already kept with it,
+// if any.
+func claimKey(ctx context.Context, t *testing.T, s store.Store, record store.IdempotencyRecord) *store.IdempotencyRecord {
+	t.Helper()
+
+	kept, err := s.ClaimIdempotencyKey(ctx, record)
+	if err != nil {
+		t.Fatalf("failed to claim idempotency key: %v", err)
+	}
+	return kept
+}
@@ -0,0 +1,159 @@
+package storetest
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	pb "github.com/andrew-womeldorf/connect-boilerplate/gen/user/v1"
+	"github.com/andrew-womeldorf/connect-boilerplate/internal/services/user/store"
+)
+
+func testOutbox(ctx context.Context, t *testing.T, factory Factory) {
+	t.Run("records_changes", func(t *testing.T) {
+		s := factory(t)
+
+		user := createTestUser("user-1", "Test User", "test@example.com")
+		if err := s.CreateUser(ctx, user); err != nil {
+			t.Fatalf("failed to create user: %v", err)
+		}
+		user.Name = "Renamed"
+		if _, err := s.UpdateUser(ctx, user, store.FieldMask{Name: true}, 0); err != nil {
+			t.Fatalf("failed to update user: %v", err)
+		}
+		if err := s.DeleteUser(ctx, user.GetId(), 0, time.Now()); err != nil {
+			t.Fatalf("failed to delete user: %v", err)
+		}
+
+		events := pendingEvents(ctx, t, s)
+		if len(events) != 3 {
+			t.Fatalf("expected 3 events, got %d", len(events))
+		}
+
+		created := events[0].GetUserCreated()
+		if created.GetUser().GetId() != user.GetId() || created.GetUser().GetVersion() != store.FirstVersion {
+			t.Errorf("expected the created user at its first version, got %v", events[0])
+		}
+
+		updated := events[1].GetUserUpdated()
+		if updated.GetUser().GetName() != "Renamed" || updated.GetUser().GetVersion() != store.FirstVersion+1 {
+			t.Errorf("expected the renamed user at its second version, got %v", events[1])
+		}
+		if !slices.Equal(updated.GetUpdateMask().GetPaths(), []string{"name"}) {
+			t.Errorf("expected update mask [name], got %v", updated.GetUpdateMask().GetPaths())
+		}
+
+		if got := events[2].GetUserDeleted().GetUserId(); got != user.GetId() {
+			t.Errorf("expected the deleted user's id, got %v", events[2])
+		}
+
+		ids := make(map[string]bool)
+		for _, event := range events {
+			if event.GetId() == "" || ids[event.GetId()] {
+				t.Errorf("expected a unique event id, got %q", event.GetId())
+			}
+			ids[event.GetId()] = true
+			if event.GetOccurredAt() == nil {
+				t.Errorf("expected event %s to have occurred_at", event.GetId())
+			}
+		}
+	})
+
+	t.Run("skips_failed_writes", func(t *testing.T) {
+		s := factory(t)
+
+		user := createTestUser("user-1", "Test User", "test@example.com")
+		if err := s.CreateUser(ctx, user); err != nil {
+			t.Fatalf("failed to create user: %v", err)
+		}
+		ackAll(ctx, t, s)
+
+		if err := s.CreateUser(ctx, user); err == nil {
+			t.Fatal("expected creating a duplicate user to fail")
+		}
+		if _, err := s.UpdateUser(ctx, user, store.AllFields, store.FirstVersion+1); err == nil {
+			t.Fatal("expected an update at the wrong version to fail")
+		}
+		if err := s.DeleteUser(ctx, "missing", 0, time.Now()); err == nil {
+			t.Fatal("expected deleting a missing user to fail")
+		}
+
+		if events := pendingEvents(ctx, t, s); len(events) != 0 {
+			t.Errorf("expected no events, got %v", events)
+		}
+	})
+
+	t.Run("batch", func(t *testing.T) {
+		s := factory(t)
+
+		results, err := s.BatchCreateUsers(ctx, []*pb.User{
+			createTestUser("user-1", "One", "one@example.com"),
+			createTestUser("user-2", "Two", "one@example.com"),
+			createTestUser("user-3", "Three", "three@example.com"),
+		})
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if results[1].Err == nil {
+			t.Fatal("expected the user with a taken email to fail")
+		}
+
+		var got []string
+		for _, event := range pendingEvents(ctx, t, s) {
+			got = append(got, event.GetUserCreated().GetUser().GetId())
+		}
+		slices.Sort(got)
+		if want := []string{"user-1", "user-3"}; !slices.Equal(got, want) {
+			t.Errorf("expected events for %v, got %v", want, got)
+		}
+	})
+
+	t.Run("ack", func(t *testing.T) {
+		s := factory(t)
+
+		for _, id := range []string{"user-1", "user-2", "user-3"} {
+			if err := s.CreateUser(ctx, createTestUser(id, id, id+"@example.com")); err != nil {
+				t.Fatalf("failed to create user: %v", err)
+			}
+		}
+
+		first, err := s.PendingEvents(ctx, 2)
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if len(first) != 2 {
+			t.Fatalf("expected 2 events, got %d", len(first))
+		}
+
+		unknown := store.NewUserPurged(ctx, "unknown", time.Now())
+		if err := s.AckEvents(ctx, []*pb.UserEvent{first[0], first[1], unknown}); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+
+		rest := pendingEvents(ctx, t, s)
+		if len(rest) != 1 || rest[0].GetUserCreated().GetUser().GetId() != "user-3" {
+			t.Errorf("expected only the event for user-3, got %v", rest)
+		}
+	})
+}
+
+// pendingEvents returns every event in the outbox of s.
+func pendingEvents(ctx context.Context, t *testing.T, s store.Store) []*pb.UserEvent {
+	t.Helper()
+
+	events, err := s.PendingEvents(ctx, store.MaxPageSize)
+	if err != nil {
+		t.Fatalf("failed to read events: %v", err)
+	}
+	return events
+}
+
+// ackAll empties the outbox of s.
+func ackAll(ctx context.Context, t *testing.T, s store.Store) {
+	t.Helper()
+
+	if err := s.AckEvents(ctx, pendingEvents(ctx, t, s)); err != nil {
+		t.Fatalf("failed to acknowledge events: %v", err)
+	}
+}
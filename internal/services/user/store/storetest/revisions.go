@@ -0,0 +1,255 @@
+package storetest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	pb "github.com/andrew-womeldorf/connect-boilerplate/gen/user/v1"
+	"github.com/andrew-womeldorf/connect-boilerplate/internal/services/user/store"
+)
+
+func testRevisions(ctx context.Context, t *testing.T, factory Factory) {
+	t.Run("records_changes", func(t *testing.T) {
+		s := factory(t)
+
+		user := createTestUser("user-1", "Test User", "test@example.com")
+		if err := s.CreateUser(ctx, user); err != nil {
+			t.Fatalf("failed to create user: %v", err)
+		}
+		user.Name = "Renamed"
+		if _, err := s.UpdateUser(ctx, user, store.FieldMask{Name: true}, 0); err != nil {
+			t.Fatalf("failed to update user: %v", err)
+		}
+		if err := s.DeleteUser(ctx, user.GetId(), 0, time.Now()); err != nil {
+			t.Fatalf("failed to delete user: %v", err)
+		}
+		if _, err := s.UndeleteUser(ctx, user.GetId(), 0, time.Now()); err != nil {
+			t.Fatalf("failed to undelete user: %v", err)
+		}
+
+		revisions := userRevisions(ctx, t, s, user.GetId())
+		want := []struct {
+			action  pb.AuditAction
+			name    string
+			deleted bool
+		}{
+			{pb.AuditAction_AUDIT_ACTION_UNDELETE, "Renamed", false},
+			{pb.AuditAction_AUDIT_ACTION_DELETE, "Renamed", true},
+			{pb.AuditAction_AUDIT_ACTION_UPDATE, "Renamed", false},
+			{pb.AuditAction_AUDIT_ACTION_CREATE, "Test User", false},
+		}
+		if len(revisions) != len(want) {
+			t.Fatalf("expected %d revisions, got %d", len(want), len(revisions))
+		}
+
+		audit := auditEvents(ctx, t, s, store.AuditFilter{UserID: user.GetId()})
+		for i, revision := range revisions {
+			version := int64(len(want) - i)
+			if revision.GetRevision() != version || revision.GetUser().GetVersion() != version {
+				t.Errorf("revision %d: expected revision and version %d, got %v", i, version, revision)
+			}
+			if revision.GetAction() != want[i].action {
+				t.Errorf("revision %d: expected action %v, got %v", i, want[i].action, revision.GetAction())
+			}
+			if revision.GetUser().GetName() != want[i].name || (revision.GetUser().GetDeletedAt() != nil) != want[i].deleted {
+				t.Errorf("revision %d: expected name %q and deleted %v, got %v", i, want[i].name, want[i].deleted, revision.GetUser())
+			}
+			if event := audit[len(audit)-1-i]; !revision.GetRevisedAt().AsTime().Equal(event.GetOccurredAt().AsTime()) {
+				t.Errorf("revision %d: expected it revised when its audit event occurred, got %v and %v", i, revision.GetRevisedAt(), event.GetOccurredAt())
+			}
+		}
+	})
+
+	t.Run("pagination", func(t *testing.T) {
+		s := factory(t)
+
+		user := createTestUser("user-1", "Test User", "test@example.com")
+		if err := s.CreateUser(ctx, user); err != nil {
+			t.Fatalf("failed to create user: %v", err)
+		}
+		for i := range 4 {
+			user.Name = fmt.Sprintf("Name %d", i)
+			if _, err := s.UpdateUser(ctx, user, store.FieldMask{Name: true}, 0); err != nil {
+				t.Fatalf("failed to update user: %v", err)
+			}
+		}
+
+		var got []int64
+		var pageToken string
+		for range 5 {
+			revisions, next, err := s.ListUserRevisions(ctx, store.ListUserRevisionsParams{
+				UserID:    user.GetId(),
+				PageSize:  2,
+				PageToken: pageToken,
+			})
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if len(revisions) > 2 {
+				t.Fatalf("expected at most 2 revisions per page, got %d", len(revisions))
+			}
+			for _, revision := range revisions {
+				got = append(got, revision.GetRevision())
+			}
+			if next == "" {
+				break
+			}
+			pageToken = next
+		}
+
+		if want := []int64{5, 4, 3, 2, 1}; !slices.Equal(got, want) {
+			t.Errorf("expected revisions %v, got %v", want, got)
+		}
+
+		_, _, err := s.ListUserRevisions(ctx, store.ListUserRevisionsParams{UserID: user.GetId(), PageToken: "not-a-token"})
+		if !errors.Is(err, store.ErrInvalidPageToken) {
+			t.Errorf("expected ErrInvalidPageToken, got %v", err)
+		}
+	})
+
+	t.Run("keeps_most_recent", func(t *testing.T) {
+		s := factory(t)
+
+		user := createTestUser("user-1", "Test User", "test@example.com")
+		if err := s.CreateUser(ctx, user); err != nil {
+			t.Fatalf("failed to create user: %v", err)
+		}
+		latest := int64(store.MaxRevisions + 5)
+		for i := store.FirstVersion + 1; i <= latest; i++ {
+			user.Name = fmt.Sprintf("Name %d", i)
+			if _, err := s.UpdateUser(ctx, user, store.FieldMask{Name: true}, 0); err != nil {
+				t.Fatalf("failed to update user: %v", err)
+			}
+		}
+
+		revisions := userRevisions(ctx, t, s, user.GetId())
+		if len(revisions) != store.MaxRevisions {
+			t.Fatalf("expected %d revisions kept, got %d", store.MaxRevisions, len(revisions))
+		}
+		if newest := revisions[0].GetRevision(); newest != latest {
+			t.Errorf("expected newest revision %d, got %d", latest, newest)
+		}
+		if oldest := revisions[len(revisions)-1].GetRevision(); oldest != store.OldestRevision(latest) {
+			t.Errorf("expected oldest revision %d, got %d", store.OldestRevision(latest), oldest)
+		}
+	})
+
+	t.Run("as_of", func(t *testing.T) {
+		s := factory(t)
+
+		beforeCreate := time.Now()
+		user := createTestUser("user-1", "Test User", "test@example.com")
+		if err := s.CreateUser(ctx, user); err != nil {
+			t.Fatalf("failed to create user: %v", err)
+		}
+		created := time.Now()
+		// An update happened when its updated_at says, which the service
+		// sets.
+		user.Name = "Renamed"
+		user.UpdatedAt = timestamppb.Now()
+		if _, err := s.UpdateUser(ctx, user, store.FieldMask{Name: true}, 0); err != nil {
+			t.Fatalf("failed to update user: %v", err)
+		}
+		renamed := time.Now()
+		if err := s.DeleteUser(ctx, user.GetId(), 0, time.Now()); err != nil {
+			t.Fatalf("failed to delete user: %v", err)
+		}
+		deleted := time.Now()
+
+		for _, tt := range []struct {
+			asOf time.Time
+			want string
+		}{
+			{created, "Test User"},
+			{renamed, "Renamed"},
+		} {
+			got, err := s.GetUserAsOf(ctx, user.GetId(), tt.asOf)
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+			if got.GetName() != tt.want {
+				t.Errorf("expected %q as of %v, got %q", tt.want, tt.asOf, got.GetName())
+			}
+		}
+
+		for _, asOf := range []time.Time{beforeCreate, deleted} {
+			if _, err := s.GetUserAsOf(ctx, user.GetId(), asOf); !errors.Is(err, store.ErrNotFound) {
+				t.Errorf("expected ErrNotFound as of %v, got %v", asOf, err)
+			}
+		}
+		if _, err := s.GetUserAsOf(ctx, "missing", deleted); !errors.Is(err, store.ErrNotFound) {
+			t.Errorf("expected ErrNotFound for a missing user, got %v", err)
+		}
+	})
+
+	t.Run("purge_drops_revisions", func(t *testing.T) {
+		s := factory(t)
+
+		for _, id := range []string{"user-1", "user-2"} {
+			if err := s.CreateUser(ctx, createTestUser(id, id, id+"@example.com")); err != nil {
+				t.Fatalf("failed to create user: %v", err)
+			}
+		}
+		if err := s.DeleteUser(ctx, "user-1", 0, time.Now()); err != nil {
+			t.Fatalf("failed to delete user: %v", err)
+		}
+		if n, err := s.PurgeUsers(ctx, time.Now().Add(time.Hour), 10, time.Now()); err != nil || n != 1 {
+			t.Fatalf("expected 1 user purged, got %d and %v", n, err)
+		}
+
+		if revisions := userRevisions(ctx, t, s, "user-1"); len(revisions) != 0 {
+			t.Errorf("expected a purged user to have no revisions, got %v", revisions)
+		}
+		if revisions := userRevisions(ctx, t, s, "user-2"); len(revisions) != 1 {
+			t.Errorf("expected other users' revisions kept, got %v", revisions)
+		}
+
+		// A user created with a purged user's id starts a history of its own.
+		if err := s.CreateUser(ctx, createTestUser("user-1", "Alice", "alice@example.com")); err != nil {
+			t.Fatalf("failed to create user: %v", err)
+		}
+		revisions := userRevisions(ctx, t, s, "user-1")
+		if len(revisions) != 1 || revisions[0].GetUser().GetName() != "Alice" {
+			t.Errorf("expected only the new user's revision, got %v", revisions)
+		}
+	})
+
+	t.Run("tenants", func(t *testing.T) {
+		s := factory(t)
+		acme := store.WithTenant(ctx, "acme")
+		globex := store.WithTenant(ctx, "globex")
+
+		if err := s.CreateUser(acme, createTestUser("user-1", "Acme User", "user@example.com")); err != nil {
+			t.Fatalf("failed to create user: %v", err)
+		}
+
+		if revisions := userRevisions(globex, t, s, "user-1"); len(revisions) != 0 {
+			t.Errorf("expected another tenant to list no revisions, got %v", revisions)
+		}
+		if _, err := s.GetUserAsOf(globex, "user-1", time.Now()); !errors.Is(err, store.ErrNotFound) {
+			t.Errorf("expected GetUserAsOf from another tenant to be ErrNotFound, got %v", err)
+		}
+		if revisions := userRevisions(acme, t, s, "user-1"); len(revisions) != 1 {
+			t.Errorf("expected the tenant to list its revision, got %v", revisions)
+		}
+	})
+}
+
+// userRevisions returns every revision kept of the user with id, newest
+// first.
+func userRevisions(ctx context.Context, t *testing.T, s store.Store, id string) []*pb.UserRevision {
+	t.Helper()
+
+	params := store.ListUserRevisionsParams{UserID: id, PageSize: store.MaxPageSize}
+	revisions, _, err := s.ListUserRevisions(ctx, params)
+	if err != nil {
+		t.Fatalf("failed to list revisions: %v", err)
+	}
+	return revisions
+}
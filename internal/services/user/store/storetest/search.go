@@ -0,0 +1,210 @@
+package storetest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+
+	pb "github.com/andrew-womeldorf/connect-boilerplate/gen/user/v1"
+	"github.com/andrew-womeldorf/connect-boilerplate/internal/services/user/store"
+)
+
+// SearchableStore is a store that can also search its users.
+type SearchableStore interface {
+	store.Store
+	store.Searcher
+}
+
+// SearchFactory returns an empty searchable store for a single test.
+type SearchFactory func(t *testing.T) SearchableStore
+
+// RunSearch exercises every behaviour the store.Searcher contract promises.
+func RunSearch(t *testing.T, factory SearchFactory) {
+	ctx := context.Background()
+
+	t.Run("ranked", func(t *testing.T) {
+		s := factory(t)
+		createUsers(ctx, t, s,
+			createTestUser("a", "Jon Smith", "jon.smith@example.com"),
+			createTestUser("b", "Jonathan Smithers", "jsmithers@example.com"),
+			createTestUser("c", "Smita Jones", "sj@corp.io"),
+			createTestUser("d", "Bob Brown", "bob@jon.io"),
+		)
+
+		results := searchAll(ctx, t, s, "jon smi")
+
+		got := searchIDs(results)
+		if !sameIDs(got, []string{"a", "b", "c"}) {
+			t.Fatalf("expected users a, b and c, got %v", got)
+		}
+		if got[0] != "a" {
+			t.Errorf("expected a to match best, got %v", got)
+		}
+		for i := 1; i < len(results); i++ {
+			if results[i].Score > results[i-1].Score {
+				t.Errorf("expected results best first, got scores %v then %v", results[i-1].Score, results[i].Score)
+			}
+		}
+	})
+
+	t.Run("partial_email", func(t *testing.T) {
+		s := factory(t)
+		createUsers(ctx, t, s,
+			createTestUser("a", "Jon Smith", "jon.smith@example.com"),
+			createTestUser("b", "Jane Doe", "jane@smithfield.org"),
+		)
+
+		if got := searchIDs(searchAll(ctx, t, s, "smith@exa")); !sameIDs(got, []string{"a"}) {
+			t.Errorf("expected user a, got %v", got)
+		}
+		if got := searchIDs(searchAll(ctx, t, s, "SMITH")); !sameIDs(got, []string{"a", "b"}) {
+			t.Errorf("expected users a and b regardless of case, got %v", got)
+		}
+	})
+
+	t.Run("highlights", func(t *testing.T) {
+		s := factory(t)
+		createUsers(ctx, t, s, createTestUser("a", "Jon Smith", "jon.smith@example.com"))
+
+		results := searchAll(ctx, t, s, "jo")
+		if len(results) != 1 {
+			t.Fatalf("expected 1 result, got %d", len(results))
+		}
+
+		if want := "<mark>Jon</mark> Smith"; results[0].NameHighlight != want {
+			t.Errorf("expected name highlight %q, got %q", want, results[0].NameHighlight)
+		}
+		if want := "<mark>jon</mark>.smith@example.com"; results[0].EmailHighlight != want {
+			t.Errorf("expected email highlight %q, got %q", want, results[0].EmailHighlight)
+		}
+		if results[0].User.GetVersion() != store.FirstVersion {
+			t.Errorf("expected version %d, got %d", store.FirstVersion, results[0].User.GetVersion())
+		}
+	})
+
+	t.Run("follows_writes", func(t *testing.T) {
+		s := factory(t)
+		createUsers(ctx, t, s, createTestUser("a", "Jon Smith", "jon@example.com"))
+
+		updated := createTestUser("a", "Alfred Smith", "")
+		if _, err := s.UpdateUser(ctx, updated, store.FieldMask{Name: true}, 0); err != nil {
+			t.Fatalf("failed to update user: %v", err)
+		}
+
+		results := searchAll(ctx, t, s, "alfred")
+		if got := searchIDs(results); !sameIDs(got, []string{"a"}) {
+			t.Fatalf("expected the updated user, got %v", got)
+		}
+		if results[0].User.GetVersion() != store.FirstVersion+1 {
+			t.Errorf("expected version %d, got %d", store.FirstVersion+1, results[0].User.GetVersion())
+		}
+
+		if err := s.DeleteUser(ctx, "a", 0, time.Now()); err != nil {
+			t.Fatalf("failed to delete user: %v", err)
+		}
+		if got := searchIDs(searchAll(ctx, t, s, "alfred")); len(got) != 0 {
+			t.Errorf("expected no users after delete, got %v", got)
+		}
+	})
+
+	t.Run("tenants", func(t *testing.T) {
+		s := factory(t)
+		acme := store.WithTenant(ctx, "acme")
+		createUsers(acme, t, s, createTestUser("a", "Jon Smith", "jon@example.com"))
+		createUsers(ctx, t, s, createTestUser("a", "Jane Doe", "jane@example.com"))
+
+		if got := searchIDs(searchAll(ctx, t, s, "jon")); len(got) != 0 {
+			t.Errorf("expected no users of another tenant, got %v", got)
+		}
+		results := searchAll(acme, t, s, "jon")
+		if len(results) != 1 || results[0].User.GetName() != "Jon Smith" {
+			t.Errorf("expected the tenant's user, got %v", results)
+		}
+	})
+
+	t.Run("no_terms", func(t *testing.T) {
+		s := factory(t)
+		createUsers(ctx, t, s, createTestUser("a", "Jon Smith", "jon@example.com"))
+
+		results, nextPageToken, err := s.SearchUsers(ctx, store.SearchUsersParams{Query: " @. "})
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if len(results) != 0 || nextPageToken != "" {
+			t.Errorf("expected no results, got %d and token %q", len(results), nextPageToken)
+		}
+	})
+
+	t.Run("pagination", func(t *testing.T) {
+		s := factory(t)
+		for i := range 5 {
+			createUsers(ctx, t, s, createTestUser(fmt.Sprint(i), fmt.Sprintf("User %d", i), fmt.Sprintf("user%d@example.com", i)))
+		}
+
+		if got := searchIDs(searchAll(ctx, t, s, "user")); !sameIDs(got, []string{"0", "1", "2", "3", "4"}) {
+			t.Errorf("expected every user once across pages, got %v", got)
+		}
+
+		_, nextPageToken, err := s.SearchUsers(ctx, store.SearchUsersParams{Query: "user", PageSize: 2})
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		_, _, err = s.SearchUsers(ctx, store.SearchUsersParams{Query: "example", PageSize: 2, PageToken: nextPageToken})
+		if !errors.Is(err, store.ErrInvalidPageToken) {
+			t.Errorf("expected ErrInvalidPageToken for another query, got %v", err)
+		}
+	})
+}
+
+func createUsers(ctx context.Context, t *testing.T, s store.Store, users ...*pb.User) {
+	t.Helper()
+
+	for _, user := range users {
+		if err := s.CreateUser(ctx, user); err != nil {
+			t.Fatalf("failed to create user %s: %v", user.GetId(), err)
+		}
+	}
+}
+
+// searchAll searches two results at a time, returning every result in the
+// order found.
+func searchAll(ctx context.Context, t *testing.T, s store.Searcher, query string) []store.SearchResult {
+	t.Helper()
+
+	var all []store.SearchResult
+	params := store.SearchUsersParams{Query: query, PageSize: 2}
+	for pages := 0; ; pages++ {
+		if pages > 10 {
+			t.Fatal("pagination did not terminate")
+		}
+
+		results, nextPageToken, err := s.SearchUsers(ctx, params)
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		all = append(all, results...)
+
+		if nextPageToken == "" {
+			return all
+		}
+		params.PageToken = nextPageToken
+	}
+}
+
+func searchIDs(results []store.SearchResult) []string {
+	ids := make([]string, 0, len(results))
+	for _, result := range results {
+		ids = append(ids, result.User.GetId())
+	}
+	return ids
+}
+
+// sameIDs reports whether got holds exactly the ids of want, in any order.
+func sameIDs(got, want []string) bool {
+	return len(got) == len(want) && !slices.ContainsFunc(want, func(id string) bool {
+		return !slices.Contains(got, id)
+	})
+}
@@ -0,0 +1,1195 @@
+// Package storetest is a conformance suite for store.Store implementations.
+// A backend runs it from its own tests:
+//
+//	func TestStore(t *testing.T) {
+//		storetest.Run(t, func(t *testing.T) store.Store {
+//			return memory.NewStore()
+//		})
+//	}
+package storetest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	pb "github.com/andrew-womeldorf/connect-boilerplate/gen/user/v1"
+	"github.com/andrew-womeldorf/connect-boilerplate/internal/services/user/store"
+)
+
+// Factory returns an empty store for a single test. Anything that must be
+// released afterwards should be registered with t.Cleanup.
+type Factory func(t *testing.T) store.Store
+
+// Run exercises every behaviour the store.Store contract promises.
+func Run(t *testing.T, factory Factory) {
+	run(context.Background(), t, factory)
+}
+
+func run(ctx context.Context, t *testing.T, factory Factory) {
+	t.Run("CreateUser", func(t *testing.T) {
+		testCreateUser(ctx, t, factory)
+	})
+	t.Run("GetUser", func(t *testing.T) {
+		testGetUser(ctx, t, factory)
+	})
+	t.Run("GetUserByEmail", func(t *testing.T) {
+		testGetUserByEmail(ctx, t, factory)
+	})
+	t.Run("UpdateUser", func(t *testing.T) {
+		testUpdateUser(ctx, t, factory)
+	})
+	t.Run("DeleteUser", func(t *testing.T) {
+		testDeleteUser(ctx, t, factory)
+	})
+	t.Run("SoftDelete", func(t *testing.T) {
+		testSoftDelete(ctx, t, factory)
+	})
+	t.Run("ListUsers", func(t *testing.T) {
+		testListUsers(ctx, t, factory)
+	})
+	t.Run("Versions", func(t *testing.T) {
+		testVersions(ctx, t, factory)
+	})
+	t.Run("Emails", func(t *testing.T) {
+		testEmails(ctx, t, factory)
+	})
+	t.Run("Batch", func(t *testing.T) {
+		testBatch(ctx, t, factory)
+	})
+	t.Run("Concurrency", func(t *testing.T) {
+		testConcurrency(ctx, t, factory)
+	})
+	t.Run("Outbox", func(t *testing.T) {
+		testOutbox(ctx, t, factory)
+	})
+	t.Run("Audit", func(t *testing.T) {
+		testAudit(ctx, t, factory)
+	})
+	t.Run("Revisions", func(t *testing.T) {
+		testRevisions(ctx, t, factory)
+	})
+	t.Run("Idempotency", func(t *testing.T) {
+		testIdempotency(ctx, t, factory)
+	})
+	t.Run("Tenants", func(t *testing.T) {
+		testTenants(ctx, t, factory)
+	})
+}
+
+func testCreateUser(ctx context.Context, t *testing.T, factory Factory) {
+	t.Run("success", func(t *testing.T) {
+		store := factory(t)
+
+		user := createTestUser("1", "John Doe", "john@example.com")
+		err := store.CreateUser(ctx, user)
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+
+		retrieved, err := store.GetUser(ctx, "1")
+		if err != nil {
+			t.Fatalf("failed to retrieve created user: %v", err)
+		}
+
+		if retrieved.GetId() != user.GetId() {
+			t.Errorf("expected id %s, got %s", user.GetId(), retrieved.GetId())
+		}
+		if retrieved.GetName() != user.GetName() {
+			t.Errorf("expected name %s, got %s", user.GetName(), retrieved.GetName())
+		}
+		if retrieved.GetEmail() != user.GetEmail() {
+			t.Errorf("expected email %s, got %s", user.GetEmail(), retrieved.GetEmail())
+		}
+	})
+
+	t.Run("timestamps", func(t *testing.T) {
+		s := factory(t)
+
+		// Microseconds are the finest precision every backend keeps.
+		createdAt := time.Date(2024, 1, 2, 3, 4, 5, 123456000, time.UTC)
+		user := createTestUser("1", "John Doe", "john@example.com")
+		user.CreatedAt = timestamppb.New(createdAt)
+		user.UpdatedAt = timestamppb.New(createdAt)
+
+		if err := s.CreateUser(ctx, user); err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+
+		retrieved, err := s.GetUser(ctx, "1")
+		if err != nil {
+			t.Fatalf("failed to retrieve created user: %v", err)
+		}
+
+		listed, _, err := s.ListUsers(ctx, store.ListUsersParams{})
+		if err != nil || len(listed) != 1 {
+			t.Fatalf("failed to list created user: %d users, %v", len(listed), err)
+		}
+
+		for source, u := range map[string]*pb.User{"retrieved": retrieved, "listed": listed[0]} {
+			if got := u.GetCreatedAt().AsTime(); !got.Equal(createdAt) {
+				t.Errorf("%s: expected created_at %s, got %s", source, createdAt, got)
+			}
+			if got := u.GetUpdatedAt().AsTime(); !got.Equal(createdAt) {
+				t.Errorf("%s: expected updated_at %s, got %s", source, createdAt, got)
+			}
+		}
+	})
+
+	t.Run("duplicate_id", func(t *testing.T) {
+		s := factory(t)
+
+		user1 := createTestUser("1", "John Doe", "john@example.com")
+		user2 := createTestUser("1", "Jane Doe", "jane@example.com")
+
+		err := s.CreateUser(ctx, user1)
+		if err != nil {
+			t.Fatalf("first create should succeed: %v", err)
+		}
+
+		err = s.CreateUser(ctx, user2)
+		if !errors.Is(err, store.ErrAlreadyExists) {
+			t.Fatalf("expected ErrAlreadyExists for duplicate ID, got %v", err)
+		}
+	})
+}
+
+func testGetUser(ctx context.Context, t *testing.T, factory Factory) {
+	t.Run("existing_user", func(t *testing.T) {
+		store := factory(t)
+
+		user := createTestUser("1", "John Doe", "john@example.com")
+		err := store.CreateUser(ctx, user)
+		if err != nil {
+			t.Fatalf("failed to create user: %v", err)
+		}
+
+		retrieved, err := store.GetUser(ctx, "1")
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+
+		if retrieved.GetId() != user.GetId() {
+			t.Errorf("expected id %s, got %s", user.GetId(), retrieved.GetId())
+		}
+		if retrieved.GetName() != user.GetName() {
+			t.Errorf("expected name %s, got %s", user.GetName(), retrieved.GetName())
+		}
+		if retrieved.GetEmail() != user.GetEmail() {
+			t.Errorf("expected email %s, got %s", user.GetEmail(), retrieved.GetEmail())
+		}
+	})
+
+	t.Run("non_existing_user", func(t *testing.T) {
+		s := factory(t)
+
+		_, err := s.GetUser(ctx, "non-existent")
+		if !errors.Is(err, store.ErrNotFound) {
+			t.Fatalf("expected ErrNotFound for non-existent user, got %v", err)
+		}
+	})
+}
+
+func testGetUserByEmail(ctx context.Context, t *testing.T, factory Factory) {
+	t.Run("existing_user", func(t *testing.T) {
+		s := factory(t)
+
+		for _, user := range []*pb.User{
+			createTestUser("1", "John Doe", "John@Example.com"),
+			createTestUser("2", "Jane Doe", "jane@example.com"),
+		} {
+			if err := s.CreateUser(ctx, user); err != nil {
+				t.Fatalf("failed to create user %s: %v", user.GetId(), err)
+			}
+		}
+
+		for _, email := range []string{"John@Example.com", "john@example.com", "JOHN@EXAMPLE.COM"} {
+			retrieved, err := s.GetUserByEmail(ctx, email)
+			if err != nil {
+				t.Fatalf("%s: expected no error, got %v", email, err)
+			}
+			if retrieved.GetId() != "1" {
+				t.Errorf("%s: expected user 1, got %s", email, retrieved.GetId())
+			}
+			if retrieved.GetEmail() != "John@Example.com" {
+				t.Errorf("%s: expected the stored email John@Example.com, got %s", email, retrieved.GetEmail())
+			}
+		}
+	})
+
+	t.Run("changed_email", func(t *testing.T) {
+		s := factory(t)
+
+		if err := s.CreateUser(ctx, createTestUser("1", "John Doe", "john@example.com")); err != nil {
+			t.Fatalf("failed to create user: %v", err)
+		}
+		if _, err := s.UpdateUser(ctx, createTestUser("1", "John Doe", "johnny@example.com"), store.AllFields, 0); err != nil {
+			t.Fatalf("failed to update user: %v", err)
+		}
+
+		if _, err := s.GetUserByEmail(ctx, "john@example.com"); !errors.Is(err, store.ErrNotFound) {
+			t.Errorf("expected ErrNotFound for the previous email, got %v", err)
+		}
+		retrieved, err := s.GetUserByEmail(ctx, "johnny@example.com")
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if retrieved.GetId() != "1" {
+			t.Errorf("expected user 1, got %s", retrieved.GetId())
+		}
+	})
+
+	t.Run("non_existing_user", func(t *testing.T) {
+		s := factory(t)
+
+		_, err := s.GetUserByEmail(ctx, "nobody@example.com")
+		if !errors.Is(err, store.ErrNotFound) {
+			t.Fatalf("expected ErrNotFound for an unknown email, got %v", err)
+		}
+	})
+}
+
+func testUpdateUser(ctx context.Context, t *testing.T, factory Factory) {
+	t.Run("existing_user", func(t *testing.T) {
+		s := factory(t)
+
+		user := createTestUser("1", "John Doe", "john@example.com")
+		err := s.CreateUser(ctx, user)
+		if err != nil {
+			t.Fatalf("failed to create user: %v", err)
+		}
+
+		updatedUser := createTestUser("1", "John Smith", "johnsmith@example.com")
+		_, err = s.UpdateUser(ctx, updatedUser, store.AllFields, 0)
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+
+		retrieved, err := s.GetUser(ctx, "1")
+		if err != nil {
+			t.Fatalf("failed to retrieve updated user: %v", err)
+		}
+
+		if retrieved.GetName() != "John Smith" {
+			t.Errorf("expected updated name 'John Smith', got %s", retrieved.GetName())
+		}
+		if retrieved.GetEmail() != "johnsmith@example.com" {
+			t.Errorf("expected updated email 'johnsmith@example.com', got %s", retrieved.GetEmail())
+		}
+	})
+
+	t.Run("preserves_created_at", func(t *testing.T) {
+		s := factory(t)
+
+		createdAt := time.Date(2024, 1, 2, 3, 4, 5, 123456000, time.UTC)
+		updatedAt := createdAt.Add(90 * time.Minute)
+
+		user := createTestUser("1", "John Doe", "john@example.com")
+		user.CreatedAt = timestamppb.New(createdAt)
+		user.UpdatedAt = timestamppb.New(createdAt)
+		if err := s.CreateUser(ctx, user); err != nil {
+			t.Fatalf("failed to create user: %v", err)
+		}
+
+		updated, err := s.UpdateUser(ctx, &pb.User{
+			Id:        "1",
+			Name:      "John Smith",
+			Email:     "john@example.com",
+			UpdatedAt: timestamppb.New(updatedAt),
+		}, store.AllFields, 0)
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+
+		retrieved, err := s.GetUser(ctx, "1")
+		if err != nil {
+			t.Fatalf("failed to retrieve updated user: %v", err)
+		}
+
+		for source, u := range map[string]*pb.User{"returned": updated, "retrieved": retrieved} {
+			if u.GetName() != "John Smith" {
+				t.Errorf("%s: expected name 'John Smith', got %s", source, u.GetName())
+			}
+			if got := u.GetCreatedAt().AsTime(); !got.Equal(createdAt) {
+				t.Errorf("%s: expected created_at %s, got %s", source, createdAt, got)
+			}
+			if got := u.GetUpdatedAt().AsTime(); !got.Equal(updatedAt) {
+				t.Errorf("%s: expected updated_at %s, got %s", source, updatedAt, got)
+			}
+		}
+	})
+
+	t.Run("partial", func(t *testing.T) {
+		s := factory(t)
+
+		if err := s.CreateUser(ctx, createTestUser("1", "John Doe", "john@example.com")); err != nil {
+			t.Fatalf("failed to create user: %v", err)
+		}
+
+		// Fields outside the mask are ignored, even when empty.
+		updatedAt := time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC)
+		updated, err := s.UpdateUser(ctx, &pb.User{
+			Id:        "1",
+			Name:      "John Smith",
+			UpdatedAt: timestamppb.New(updatedAt),
+		}, store.FieldMask{Name: true}, 0)
+		if err != nil {
+			t.Fatalf("failed to update name: %v", err)
+		}
+		if updated.GetName() != "John Smith" || updated.GetEmail() != "john@example.com" {
+			t.Errorf("expected only the name to change, got %s <%s>", updated.GetName(), updated.GetEmail())
+		}
+		if got := updated.GetUpdatedAt().AsTime(); !got.Equal(updatedAt) {
+			t.Errorf("expected updated_at %s, got %s", updatedAt, got)
+		}
+
+		if _, err := s.UpdateUser(ctx, &pb.User{
+			Id:        "1",
+			Email:     "johnsmith@example.com",
+			UpdatedAt: timestamppb.New(updatedAt),
+		}, store.FieldMask{Email: true}, 0); err != nil {
+			t.Fatalf("failed to update email: %v", err)
+		}
+
+		retrieved, err := s.GetUser(ctx, "1")
+		if err != nil {
+			t.Fatalf("failed to retrieve user: %v", err)
+		}
+		if retrieved.GetName() != "John Smith" || retrieved.GetEmail() != "johnsmith@example.com" {
+			t.Errorf("expected John Smith <johnsmith@example.com>, got %s <%s>", retrieved.GetName(), retrieved.GetEmail())
+		}
+		if _, err := s.GetUserByEmail(ctx, "john@example.com"); !errors.Is(err, store.ErrNotFound) {
+			t.Errorf("expected the previous email to be released, got %v", err)
+		}
+	})
+
+	t.Run("non_existing_user", func(t *testing.T) {
+		s := factory(t)
+
+		user := createTestUser("non-existent", "John Doe", "john@example.com")
+		_, err := s.UpdateUser(ctx, user, store.AllFields, 0)
+		if !errors.Is(err, store.ErrNotFound) {
+			t.Fatalf("expected ErrNotFound for non-existent user, got %v", err)
+		}
+	})
+}
+
+func testDeleteUser(ctx context.Context, t *testing.T, factory Factory) {
+	t.Run("existing_user", func(t *testing.T) {
+		s := factory(t)
+
+		user := createTestUser("1", "John Doe", "john@example.com")
+		err := s.CreateUser(ctx, user)
+		if err != nil {
+			t.Fatalf("failed to create user: %v", err)
+		}
+
+		err = s.DeleteUser(ctx, "1", 0, time.Now())
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+
+		_, err = s.GetUser(ctx, "1")
+		if !errors.Is(err, store.ErrNotFound) {
+			t.Fatalf("user should not exist after deletion, got %v", err)
+		}
+	})
+
+	t.Run("non_existing_user", func(t *testing.T) {
+		s := factory(t)
+
+		err := s.DeleteUser(ctx, "non-existent", 0, time.Now())
+		if !errors.Is(err, store.ErrNotFound) {
+			t.Fatalf("deleting non-existent user should fail with ErrNotFound, got %v", err)
+		}
+	})
+}
+
+func testListUsers(ctx context.Context, t *testing.T, factory Factory) {
+	t.Run("empty_list", func(t *testing.T) {
+		s := factory(t)
+
+		users, _, err := s.ListUsers(ctx, store.ListUsersParams{})
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+
+		if len(users) != 0 {
+			t.Errorf("expected empty list, got %d users", len(users))
+		}
+	})
+
+	t.Run("multiple_users", func(t *testing.T) {
+		s := factory(t)
+
+		user1 := createTestUser("1", "John Doe", "john@example.com")
+		user2 := createTestUser("2", "Jane Doe", "jane@example.com")
+		user3 := createTestUser("3", "Bob Smith", "bob@example.com")
+
+		for _, user := range []*pb.User{user1, user2, user3} {
+			err := s.CreateUser(ctx, user)
+			if err != nil {
+				t.Fatalf("failed to create user %s: %v", user.GetId(), err)
+			}
+		}
+
+		users, _, err := s.ListUsers(ctx, store.ListUsersParams{})
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+
+		if len(users) != 3 {
+			t.Errorf("expected 3 users, got %d", len(users))
+		}
+
+		userMap := make(map[string]*pb.User)
+		for _, user := range users {
+			userMap[user.GetId()] = user
+		}
+
+		expectedUsers := []*pb.User{user1, user2, user3}
+		for _, expected := range expectedUsers {
+			actual, exists := userMap[expected.GetId()]
+			if !exists {
+				t.Errorf("user with ID %s not found in list", expected.GetId())
+				continue
+			}
+
+			if actual.GetName() != expected.GetName() {
+				t.Errorf("expected name %s, got %s for user %s", expected.GetName(), actual.GetName(), expected.GetId())
+			}
+			if actual.GetEmail() != expected.GetEmail() {
+				t.Errorf("expected email %s, got %s for user %s", expected.GetEmail(), actual.GetEmail(), expected.GetId())
+			}
+		}
+	})
+
+	t.Run("pagination", func(t *testing.T) {
+		s := factory(t)
+
+		for i := range 5 {
+			id := fmt.Sprintf("%d", i)
+			user := createTestUser(id, fmt.Sprintf("User %s", id), fmt.Sprintf("user%s@example.com", id))
+			if err := s.CreateUser(ctx, user); err != nil {
+				t.Fatalf("failed to create user %s: %v", id, err)
+			}
+		}
+
+		seen := make(map[string]bool)
+		pageToken := ""
+		for pages := 0; ; pages++ {
+			if pages > 5 {
+				t.Fatal("pagination did not terminate")
+			}
+
+			users, nextPageToken, err := s.ListUsers(ctx, store.ListUsersParams{
+				PageSize:  2,
+				PageToken: pageToken,
+			})
+			if err != nil {
+				t.Fatalf("expected no error, got %v", err)
+			}
+
+			if len(users) > 2 {
+				t.Errorf("expected at most 2 users per page, got %d", len(users))
+			}
+
+			for _, user := range users {
+				if seen[user.GetId()] {
+					t.Errorf("user %s returned on more than one page", user.GetId())
+				}
+				seen[user.GetId()] = true
+			}
+
+			if nextPageToken == "" {
+				break
+			}
+			pageToken = nextPageToken
+		}
+
+		if len(seen) != 5 {
+			t.Errorf("expected 5 users across all pages, got %d", len(seen))
+		}
+	})
+
+	t.Run("invalid_page_token", func(t *testing.T) {
+		s := factory(t)
+
+		_, _, err := s.ListUsers(ctx, store.ListUsersParams{PageToken: "not a token"})
+		if !errors.Is(err, store.ErrInvalidPageToken) {
+			t.Fatalf("expected ErrInvalidPageToken, got %v", err)
+		}
+	})
+
+	t.Run("filter_and_order", func(t *testing.T) {
+		s := factory(t)
+
+		base := time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC)
+		hours := func(n int) time.Time { return base.Add(time.Duration(n) * time.Hour) }
+
+		for i, user := range []*pb.User{
+			createTestUser("a", "Carol King", "carol@Example.com"),
+			createTestUser("b", "Alice Jones", "alice@example.org"),
+			createTestUser("c", "Bob Stone", "bob@EXAMPLE.com"),
+			createTestUser("d", "Alice Brown", "alice@other.com"),
+			createTestUser("e", "Alice Brown", "alice.b@example.com"),
+		} {
+			user.CreatedAt = timestamppb.New(hours(i))
+			if err := s.CreateUser(ctx, user); err != nil {
+				t.Fatalf("failed to create user %s: %v", user.GetId(), err)
+			}
+		}
+
+		byCreatedAt := store.UserOrder{Field: store.OrderByCreatedAt}
+		tests := []struct {
+			name   string
+			filter store.UserFilter
+			order  store.UserOrder
+			want   []string
+		}{
+			{name: "name", want: []string{"d", "e", "b", "c", "a"}},
+			{name: "name_desc", order: store.UserOrder{Desc: true}, want: []string{"a", "c", "b", "e", "d"}},
+			{name: "created_at", order: byCreatedAt, want: []string{"a", "b", "c", "d", "e"}},
+			{name: "created_at_desc", order: store.UserOrder{Field: store.OrderByCreatedAt, Desc: true}, want: []string{"e", "d", "c", "b", "a"}},
+			{name: "name_prefix", filter: store.UserFilter{NamePrefix: "Alice"}, want: []string{"d", "e", "b"}},
+			{name: "name_prefix_case", filter: store.UserFilter{NamePrefix: "alice"}, want: []string{}},
+			{name: "name_prefix_by_created_at", filter: store.UserFilter{NamePrefix: "Alice"}, order: byCreatedAt, want: []string{"b", "d", "e"}},
+			{name: "email_domain", filter: store.UserFilter{EmailDomain: "EXAMPLE.com"}, want: []string{"e", "c", "a"}},
+			{name: "email_domain_suffix", filter: store.UserFilter{EmailDomain: "ample.com"}, want: []string{}},
+			{name: "created_range", filter: store.UserFilter{CreatedAfter: hours(1), CreatedBefore: hours(3)}, want: []string{"b", "c"}},
+			{name: "created_range_by_created_at", filter: store.UserFilter{CreatedAfter: hours(1), CreatedBefore: hours(3)}, order: byCreatedAt, want: []string{"b", "c"}},
+			{name: "created_after", filter: store.UserFilter{CreatedAfter: hours(3)}, order: byCreatedAt, want: []string{"d", "e"}},
+			{name: "created_before", filter: store.UserFilter{CreatedBefore: hours(1)}, order: byCreatedAt, want: []string{"a"}},
+			{
+				name:   "combined",
+				filter: store.UserFilter{NamePrefix: "Alice", EmailDomain: "example.com", CreatedAfter: hours(2)},
+				order:  store.UserOrder{Field: store.OrderByCreatedAt, Desc: true},
+				want:   []string{"e"},
+			},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				got := listIDs(ctx, t, s, store.ListUsersParams{Filter: tt.filter, OrderBy: tt.order})
+				if !slices.Equal(got, tt.want) {
+					t.Errorf("expected users %v, got %v", tt.want, got)
+				}
+
+				count, err := s.CountUsers(ctx, tt.filter)
+				if err != nil {
+					t.Fatalf("expected no error counting users, got %v", err)
+				}
+				if count != int64(len(tt.want)) {
+					t.Errorf("expected a count of %d, got %d", len(tt.want), count)
+				}
+			})
+		}
+	})
+
+	t.Run("name_order_is_bytewise", func(t *testing.T) {
+		s := factory(t)
+
+		for _, user := range []*pb.User{
+			createTestUser("1", "bob", "bob.lower@example.com"),
+			createTestUser("2", "Émile", "emile@example.com"),
+			createTestUser("3", "Alice", "alice@example.com"),
+			createTestUser("4", "alice", "alice.lower@example.com"),
+			createTestUser("5", "Bob", "bob@example.com"),
+		} {
+			if err := s.CreateUser(ctx, user); err != nil {
+				t.Fatalf("failed to create user %s: %v", user.GetId(), err)
+			}
+		}
+
+		// Upper case sorts before lower case, and both before accented
+		// letters, whatever the collation of the backend's database.
+		want := []string{"3", "5", "4", "1", "2"}
+		if got := listIDs(ctx, t, s, store.ListUsersParams{}); !slices.Equal(got, want) {
+			t.Errorf("expected users %v, got %v", want, got)
+		}
+
+		slices.Reverse(want)
+		if got := listIDs(ctx, t, s, store.ListUsersParams{OrderBy: store.UserOrder{Desc: true}}); !slices.Equal(got, want) {
+			t.Errorf("expected users %v descending, got %v", want, got)
+		}
+	})
+
+	t.Run("name_before_names_it_prefixes", func(t *testing.T) {
+		s := factory(t)
+
+		for _, user := range []*pb.User{
+			createTestUser("1", "Al Smith", "al.smith@example.com"),
+			createTestUser("2", "Al-Bo", "al.bo@example.com"),
+			createTestUser("3", "Al", "al@example.com"),
+			createTestUser("4", "Al", "al.other@example.com"),
+		} {
+			if err := s.CreateUser(ctx, user); err != nil {
+				t.Fatalf("failed to create user %s: %v", user.GetId(), err)
+			}
+		}
+
+		want := []string{"3", "4", "1", "2"}
+		if got := listIDs(ctx, t, s, store.ListUsersParams{}); !slices.Equal(got, want) {
+			t.Errorf("expected users %v, got %v", want, got)
+		}
+
+		slices.Reverse(want)
+		if got := listIDs(ctx, t, s, store.ListUsersParams{OrderBy: store.UserOrder{Desc: true}}); !slices.Equal(got, want) {
+			t.Errorf("expected users %v descending, got %v", want, got)
+		}
+
+		want = []string{"1"}
+		params := store.ListUsersParams{Filter: store.UserFilter{NamePrefix: "Al "}}
+		if got := listIDs(ctx, t, s, params); !slices.Equal(got, want) {
+			t.Errorf("expected users %v named with prefix %q, got %v", want, params.Filter.NamePrefix, got)
+		}
+	})
+
+	t.Run("page_token_for_another_order", func(t *testing.T) {
+		s := factory(t)
+
+		for _, id := range []string{"1", "2", "3"} {
+			if err := s.CreateUser(ctx, createTestUser(id, "User "+id, "user"+id+"@example.com")); err != nil {
+				t.Fatalf("failed to create user %s: %v", id, err)
+			}
+		}
+
+		_, pageToken, err := s.ListUsers(ctx, store.ListUsersParams{PageSize: 1})
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if pageToken == "" {
+			t.Fatal("expected a next page token")
+		}
+
+		_, _, err = s.ListUsers(ctx, store.ListUsersParams{
+			PageSize:  1,
+			PageToken: pageToken,
+			OrderBy:   store.UserOrder{Field: store.OrderByCreatedAt},
+		})
+		if !errors.Is(err, store.ErrInvalidPageToken) {
+			t.Fatalf("expected ErrInvalidPageToken, got %v", err)
+		}
+	})
+}
+
+// listIDs lists every user matching params two at a time, returning their ids
+// in the order listed.
+func listIDs(ctx context.Context, t *testing.T, s store.Store, params store.ListUsersParams) []string {
+	t.Helper()
+
+	ids := []string{}
+	params.PageSize = 2
+	for pages := 0; ; pages++ {
+		if pages > 10 {
+			t.Fatal("pagination did not terminate")
+		}
+
+		users, nextPageToken, err := s.ListUsers(ctx, params)
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		for _, user := range users {
+			ids = append(ids, user.GetId())
+		}
+
+		if nextPageToken == "" {
+			return ids
+		}
+		params.PageToken = nextPageToken
+	}
+}
+
+func testVersions(ctx context.Context, t *testing.T, factory Factory) {
+	t.Run("create_and_update", func(t *testing.T) {
+		s := factory(t)
+
+		user := createTestUser("1", "John Doe", "john@example.com")
+		user.Version = 42
+		if err := s.CreateUser(ctx, user); err != nil {
+			t.Fatalf("failed to create user: %v", err)
+		}
+
+		retrieved, err := s.GetUser(ctx, "1")
+		if err != nil {
+			t.Fatalf("failed to retrieve user: %v", err)
+		}
+		if retrieved.GetVersion() != store.FirstVersion {
+			t.Errorf("expected version %d after create, got %d", store.FirstVersion, retrieved.GetVersion())
+		}
+
+		updated, err := s.UpdateUser(ctx, createTestUser("1", "John Smith", "john@example.com"), store.AllFields, 0)
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if updated.GetVersion() != store.FirstVersion+1 {
+			t.Errorf("expected version %d after update, got %d", store.FirstVersion+1, updated.GetVersion())
+		}
+	})
+
+	t.Run("update_expected_version", func(t *testing.T) {
+		s := factory(t)
+
+		if err := s.CreateUser(ctx, createTestUser("1", "John Doe", "john@example.com")); err != nil {
+			t.Fatalf("failed to create user: %v", err)
+		}
+
+		if _, err := s.UpdateUser(ctx, createTestUser("1", "John Smith", "john@example.com"), store.AllFields, store.FirstVersion); err != nil {
+			t.Fatalf("expected no error for the current version, got %v", err)
+		}
+
+		_, err := s.UpdateUser(ctx, createTestUser("1", "Johnny", "john@example.com"), store.AllFields, store.FirstVersion)
+		if !errors.Is(err, store.ErrPreconditionFailed) {
+			t.Fatalf("expected ErrPreconditionFailed for a stale version, got %v", err)
+		}
+
+		retrieved, err := s.GetUser(ctx, "1")
+		if err != nil {
+			t.Fatalf("failed to retrieve user: %v", err)
+		}
+		if retrieved.GetName() != "John Smith" {
+			t.Errorf("expected the stale update to be rejected, got name %s", retrieved.GetName())
+		}
+
+		_, err = s.UpdateUser(ctx, createTestUser("missing", "John Doe", "john@example.com"), store.AllFields, store.FirstVersion)
+		if !errors.Is(err, store.ErrNotFound) {
+			t.Fatalf("expected ErrNotFound for a missing user, got %v", err)
+		}
+	})
+
+	t.Run("delete_expected_version", func(t *testing.T) {
+		s := factory(t)
+
+		if err := s.CreateUser(ctx, createTestUser("1", "John Doe", "john@example.com")); err != nil {
+			t.Fatalf("failed to create user: %v", err)
+		}
+
+		err := s.DeleteUser(ctx, "1", store.FirstVersion+1, time.Now())
+		if !errors.Is(err, store.ErrPreconditionFailed) {
+			t.Fatalf("expected ErrPreconditionFailed for a stale version, got %v", err)
+		}
+
+		if err := s.DeleteUser(ctx, "1", store.FirstVersion, time.Now()); err != nil {
+			t.Fatalf("expected no error for the current version, got %v", err)
+		}
+
+		err = s.DeleteUser(ctx, "1", store.FirstVersion, time.Now())
+		if !errors.Is(err, store.ErrNotFound) {
+			t.Fatalf("expected ErrNotFound for a deleted user, got %v", err)
+		}
+	})
+}
+
+func testEmails(ctx context.Context, t *testing.T, factory Factory) {
+	t.Run("duplicate_on_create", func(t *testing.T) {
+		s := factory(t)
+
+		if err := s.CreateUser(ctx, createTestUser("1", "John Doe", "john@example.com")); err != nil {
+			t.Fatalf("failed to create user: %v", err)
+		}
+
+		err := s.CreateUser(ctx, createTestUser("2", "Johnny Doe", "John@Example.com"))
+		if !errors.Is(err, store.ErrAlreadyExists) {
+			t.Fatalf("expected ErrAlreadyExists for an email differing only in case, got %v", err)
+		}
+
+		if _, err := s.GetUser(ctx, "2"); !errors.Is(err, store.ErrNotFound) {
+			t.Errorf("expected the rejected user not to exist, got %v", err)
+		}
+	})
+
+	t.Run("duplicate_on_update", func(t *testing.T) {
+		s := factory(t)
+
+		for _, user := range []*pb.User{
+			createTestUser("1", "John Doe", "john@example.com"),
+			createTestUser("2", "Jane Doe", "jane@example.com"),
+		} {
+			if err := s.CreateUser(ctx, user); err != nil {
+				t.Fatalf("failed to create user %s: %v", user.GetId(), err)
+			}
+		}
+
+		_, err := s.UpdateUser(ctx, createTestUser("2", "Jane Doe", "JOHN@example.com"), store.AllFields, 0)
+		if !errors.Is(err, store.ErrAlreadyExists) {
+			t.Fatalf("expected ErrAlreadyExists for another user's email, got %v", err)
+		}
+
+		retrieved, err := s.GetUser(ctx, "2")
+		if err != nil {
+			t.Fatalf("failed to retrieve user: %v", err)
+		}
+		if retrieved.GetEmail() != "jane@example.com" {
+			t.Errorf("expected email to stay jane@example.com, got %s", retrieved.GetEmail())
+		}
+	})
+
+	t.Run("own_email", func(t *testing.T) {
+		s := factory(t)
+
+		if err := s.CreateUser(ctx, createTestUser("1", "John Doe", "john@example.com")); err != nil {
+			t.Fatalf("failed to create user: %v", err)
+		}
+
+		updated, err := s.UpdateUser(ctx, createTestUser("1", "John Doe", "John@Example.com"), store.AllFields, 0)
+		if err != nil {
+			t.Fatalf("expected changing the case of a user's own email to succeed, got %v", err)
+		}
+		if updated.GetEmail() != "John@Example.com" {
+			t.Errorf("expected email John@Example.com, got %s", updated.GetEmail())
+		}
+	})
+
+	t.Run("released_on_update", func(t *testing.T) {
+		s := factory(t)
+
+		if err := s.CreateUser(ctx, createTestUser("1", "John Doe", "john@example.com")); err != nil {
+			t.Fatalf("failed to create user: %v", err)
+		}
+		if _, err := s.UpdateUser(ctx, createTestUser("1", "John Doe", "johnny@example.com"), store.AllFields, 0); err != nil {
+			t.Fatalf("failed to update user: %v", err)
+		}
+
+		if err := s.CreateUser(ctx, createTestUser("2", "Jane Doe", "john@example.com")); err != nil {
+			t.Errorf("expected the previous email to be free, got %v", err)
+		}
+		err := s.CreateUser(ctx, createTestUser("3", "Jim Doe", "johnny@example.com"))
+		if !errors.Is(err, store.ErrAlreadyExists) {
+			t.Errorf("expected ErrAlreadyExists for the new email, got %v", err)
+		}
+	})
+
+	t.Run("released_on_delete", func(t *testing.T) {
+		s := factory(t)
+
+		if err := s.CreateUser(ctx, createTestUser("1", "John Doe", "john@example.com")); err != nil {
+			t.Fatalf("failed to create user: %v", err)
+		}
+		if err := s.DeleteUser(ctx, "1", 0, time.Now()); err != nil {
+			t.Fatalf("failed to delete user: %v", err)
+		}
+
+		if err := s.CreateUser(ctx, createTestUser("2", "Jane Doe", "john@example.com")); err != nil {
+			t.Errorf("expected the deleted user's email to be free, got %v", err)
+		}
+	})
+}
+
+func testBatch(ctx context.Context, t *testing.T, factory Factory) {
+	t.Run("get", func(t *testing.T) {
+		s := factory(t)
+
+		for _, user := range []*pb.User{
+			createTestUser("1", "John Doe", "john@example.com"),
+			createTestUser("2", "Jane Doe", "jane@example.com"),
+		} {
+			if err := s.CreateUser(ctx, user); err != nil {
+				t.Fatalf("failed to create user %s: %v", user.GetId(), err)
+			}
+		}
+
+		ids := []string{"2", "missing", "1", "2"}
+		results, err := s.BatchGetUsers(ctx, ids)
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if len(results) != len(ids) {
+			t.Fatalf("expected %d results, got %d", len(ids), len(results))
+		}
+
+		for i, id := range ids {
+			result := results[i]
+			if id == "missing" {
+				if !errors.Is(result.Err, store.ErrNotFound) {
+					t.Errorf("result %d: expected ErrNotFound, got %v", i, result.Err)
+				}
+				continue
+			}
+			if result.Err != nil {
+				t.Errorf("result %d: expected no error, got %v", i, result.Err)
+				continue
+			}
+			if result.User.GetId() != id {
+				t.Errorf("result %d: expected user %s, got %s", i, id, result.User.GetId())
+			}
+		}
+	})
+
+	t.Run("create", func(t *testing.T) {
+		s := factory(t)
+
+		if err := s.CreateUser(ctx, createTestUser("1", "John Doe", "john@example.com")); err != nil {
+			t.Fatalf("failed to create user: %v", err)
+		}
+
+		results, err := s.BatchCreateUsers(ctx, []*pb.User{
+			createTestUser("2", "Jane Doe", "jane@example.com"),
+			createTestUser("1", "Johnny Doe", "johnny@example.com"),
+			createTestUser("3", "Jim Doe", "JOHN@example.com"),
+			createTestUser("4", "Bob Smith", "bob@example.com"),
+		})
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if len(results) != 4 {
+			t.Fatalf("expected 4 results, got %d", len(results))
+		}
+
+		for i, want := range []error{nil, store.ErrAlreadyExists, store.ErrAlreadyExists, nil} {
+			if want == nil && results[i].Err != nil {
+				t.Errorf("result %d: expected no error, got %v", i, results[i].Err)
+			}
+			if want != nil && !errors.Is(results[i].Err, want) {
+				t.Errorf("result %d: expected %v, got %v", i, want, results[i].Err)
+			}
+		}
+
+		// The failed items leave the rest of the batch in place.
+		for _, id := range []string{"2", "4"} {
+			if _, err := s.GetUser(ctx, id); err != nil {
+				t.Errorf("expected user %s to be created, got %v", id, err)
+			}
+		}
+		if _, err := s.GetUser(ctx, "3"); !errors.Is(err, store.ErrNotFound) {
+			t.Errorf("expected user 3 not to be created, got %v", err)
+		}
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		s := factory(t)
+
+		for _, user := range []*pb.User{
+			createTestUser("1", "John Doe", "john@example.com"),
+			createTestUser("2", "Jane Doe", "jane@example.com"),
+			createTestUser("3", "Bob Smith", "bob@example.com"),
+		} {
+			if err := s.CreateUser(ctx, user); err != nil {
+				t.Fatalf("failed to create user %s: %v", user.GetId(), err)
+			}
+		}
+
+		results, err := s.BatchDeleteUsers(ctx, []store.BatchDelete{
+			{ID: "1"},
+			{ID: "missing"},
+			{ID: "2", ExpectedVersion: store.FirstVersion + 1},
+			{ID: "3", ExpectedVersion: store.FirstVersion},
+		}, time.Now())
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if len(results) != 4 {
+			t.Fatalf("expected 4 results, got %d", len(results))
+		}
+
+		for i, want := range []error{nil, store.ErrNotFound, store.ErrPreconditionFailed, nil} {
+			if want == nil && results[i].Err != nil {
+				t.Errorf("result %d: expected no error, got %v", i, results[i].Err)
+			}
+			if want != nil && !errors.Is(results[i].Err, want) {
+				t.Errorf("result %d: expected %v, got %v", i, want, results[i].Err)
+			}
+		}
+
+		users, _, err := s.ListUsers(ctx, store.ListUsersParams{})
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if len(users) != 1 || users[0].GetId() != "2" {
+			t.Errorf("expected only user 2 to remain, got %v", users)
+		}
+	})
+
+	t.Run("empty", func(t *testing.T) {
+		s := factory(t)
+
+		results, err := s.BatchGetUsers(ctx, nil)
+		if err != nil || len(results) != 0 {
+			t.Errorf("expected no results and no error, got %d results and %v", len(results), err)
+		}
+	})
+}
+
+func testConcurrency(ctx context.Context, t *testing.T, factory Factory) {
+	const workers = 10
+
+	t.Run("distinct_users", func(t *testing.T) {
+		s := factory(t)
+
+		var wg sync.WaitGroup
+		errs := make(chan error, workers)
+		for i := range workers {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				id := fmt.Sprintf("%d", i)
+				errs <- s.CreateUser(ctx, createTestUser(id, "User "+id, "user"+id+"@example.com"))
+			}()
+		}
+		wg.Wait()
+		close(errs)
+
+		for err := range errs {
+			if err != nil {
+				t.Errorf("expected no error, got %v", err)
+			}
+		}
+
+		users, _, err := s.ListUsers(ctx, store.ListUsersParams{PageSize: store.MaxPageSize})
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if len(users) != workers {
+			t.Errorf("expected %d users, got %d", workers, len(users))
+		}
+	})
+
+	t.Run("same_id", func(t *testing.T) {
+		s := factory(t)
+
+		var wg sync.WaitGroup
+		errs := make(chan error, workers)
+		for i := range workers {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				errs <- s.CreateUser(ctx, createTestUser("1", fmt.Sprintf("User %d", i), "user@example.com"))
+			}()
+		}
+		wg.Wait()
+		close(errs)
+
+		// Exactly one create wins; the losers either saw the winner's user
+		// or collided with its write.
+		created := 0
+		for err := range errs {
+			switch {
+			case err == nil:
+				created++
+			case errors.Is(err, store.ErrAlreadyExists), errors.Is(err, store.ErrConflict):
+			default:
+				t.Errorf("expected ErrAlreadyExists or ErrConflict, got %v", err)
+			}
+		}
+		if created != 1 {
+			t.Errorf("expected exactly one create to succeed, got %d", created)
+		}
+	})
+
+	t.Run("same_email", func(t *testing.T) {
+		s := factory(t)
+
+		var wg sync.WaitGroup
+		errs := make(chan error, workers)
+		for i := range workers {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				id := fmt.Sprintf("%d", i)
+				errs <- s.CreateUser(ctx, createTestUser(id, "User "+id, "user@example.com"))
+			}()
+		}
+		wg.Wait()
+		close(errs)
+
+		created := 0
+		for err := range errs {
+			switch {
+			case err == nil:
+				created++
+			case errors.Is(err, store.ErrAlreadyExists), errors.Is(err, store.ErrConflict):
+			default:
+				t.Errorf("expected ErrAlreadyExists or ErrConflict, got %v", err)
+			}
+		}
+		if created != 1 {
+			t.Errorf("expected exactly one create to succeed, got %d", created)
+		}
+	})
+
+	t.Run("updates", func(t *testing.T) {
+		s := factory(t)
+
+		if err := s.CreateUser(ctx, createTestUser("1", "John Doe", "john@example.com")); err != nil {
+			t.Fatalf("failed to create user: %v", err)
+		}
+
+		var wg sync.WaitGroup
+		names := make(map[string]bool, workers)
+		for i := range workers {
+			name := fmt.Sprintf("John %d", i)
+			names[name] = true
+
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				_, err := s.UpdateUser(ctx, createTestUser("1", name, "john@example.com"), store.AllFields, 0)
+				if err != nil && !errors.Is(err, store.ErrConflict) {
+					t.Errorf("expected no error or ErrConflict, got %v", err)
+				}
+			}()
+		}
+		wg.Wait()
+
+		retrieved, err := s.GetUser(ctx, "1")
+		if err != nil {
+			t.Fatalf("failed to retrieve user: %v", err)
+		}
+		if !names[retrieved.GetName()] {
+			t.Errorf("expected the name of one of the updates, got %s", retrieved.GetName())
+		}
+	})
+
+	t.Run("guarded_updates", func(t *testing.T) {
+		s := factory(t)
+
+		if err := s.CreateUser(ctx, createTestUser("1", "John Doe", "john@example.com")); err != nil {
+			t.Fatalf("failed to create user: %v", err)
+		}
+
+		// Every writer read the first version, so only one may win.
+		var wg sync.WaitGroup
+		errs := make(chan error, workers)
+		for i := range workers {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				user := createTestUser("1", fmt.Sprintf("John %d", i), "john@example.com")
+				_, err := s.UpdateUser(ctx, user, store.AllFields, store.FirstVersion)
+				errs <- err
+			}()
+		}
+		wg.Wait()
+		close(errs)
+
+		updated := 0
+		for err := range errs {
+			switch {
+			case err == nil:
+				updated++
+			case errors.Is(err, store.ErrPreconditionFailed), errors.Is(err, store.ErrConflict):
+			default:
+				t.Errorf("expected ErrPreconditionFailed or ErrConflict, got %v", err)
+			}
+		}
+		if updated != 1 {
+			t.Errorf("expected exactly one update to succeed, got %d", updated)
+		}
+	})
+}
+
+func createTestUser(id, name, email string) *pb.User {
+	now := time.Now()
+	return &pb.User{
+		Id:        id,
+		Name:      name,
+		Email:     email,
+		CreatedAt: timestamppb.New(now),
+		UpdatedAt: timestamppb.New(now),
+	}
+}
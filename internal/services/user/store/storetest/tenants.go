@@ -0,0 +1,153 @@
+package storetest
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/andrew-womeldorf/connect-boilerplate/internal/services/user/store"
+)
+
+func testTenants(ctx context.Context, t *testing.T, factory Factory) {
+	acme := store.WithTenant(ctx, "acme")
+	globex := store.WithTenant(ctx, "globex")
+
+	t.Run("same_id_and_email", func(t *testing.T) {
+		s := factory(t)
+
+		if err := s.CreateUser(acme, createTestUser("user-1", "Acme User", "user@example.com")); err != nil {
+			t.Fatalf("failed to create user: %v", err)
+		}
+		if err := s.CreateUser(globex, createTestUser("user-1", "Globex User", "USER@example.com")); err != nil {
+			t.Fatalf("expected another tenant to reuse the id and email, got %v", err)
+		}
+
+		for tenantCtx, want := range map[context.Context]string{acme: "Acme User", globex: "Globex User"} {
+			user, err := s.GetUser(tenantCtx, "user-1")
+			if err != nil {
+				t.Fatalf("failed to get user: %v", err)
+			}
+			if user.GetName() != want {
+				t.Errorf("expected %q, got %q", want, user.GetName())
+			}
+
+			user, err = s.GetUserByEmail(tenantCtx, "user@example.com")
+			if err != nil {
+				t.Fatalf("failed to get user by email: %v", err)
+			}
+			if user.GetName() != want {
+				t.Errorf("expected %q by email, got %q", want, user.GetName())
+			}
+		}
+	})
+
+	t.Run("no_cross_tenant_reads", func(t *testing.T) {
+		s := factory(t)
+
+		user := createTestUser("user-1", "Acme User", "user@example.com")
+		if err := s.CreateUser(acme, user); err != nil {
+			t.Fatalf("failed to create user: %v", err)
+		}
+		// Read it in its own tenant first, so that a store caching reads
+		// has it to leak.
+		if _, err := s.GetUser(acme, user.GetId()); err != nil {
+			t.Fatalf("failed to get user: %v", err)
+		}
+
+		if _, err := s.GetUser(globex, user.GetId()); !errors.Is(err, store.ErrNotFound) {
+			t.Errorf("expected GetUser from another tenant to be ErrNotFound, got %v", err)
+		}
+		if _, err := s.GetUserByEmail(globex, user.GetEmail()); !errors.Is(err, store.ErrNotFound) {
+			t.Errorf("expected GetUserByEmail from another tenant to be ErrNotFound, got %v", err)
+		}
+
+		results, err := s.BatchGetUsers(globex, []string{user.GetId()})
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if !errors.Is(results[0].Err, store.ErrNotFound) {
+			t.Errorf("expected BatchGetUsers from another tenant to be ErrNotFound, got %v", results[0])
+		}
+
+		if got := listIDs(globex, t, s, store.ListUsersParams{}); len(got) != 0 {
+			t.Errorf("expected another tenant to list no users, got %v", got)
+		}
+		if got := listIDs(acme, t, s, store.ListUsersParams{}); !slices.Equal(got, []string{"user-1"}) {
+			t.Errorf("expected the tenant to list its user, got %v", got)
+		}
+
+		count, err := s.CountUsers(globex, store.UserFilter{})
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if count != 0 {
+			t.Errorf("expected another tenant to count no users, got %d", count)
+		}
+
+		if events := auditEvents(globex, t, s, store.AuditFilter{}); len(events) != 0 {
+			t.Errorf("expected another tenant to list no audit events, got %v", events)
+		}
+		if events := auditEvents(acme, t, s, store.AuditFilter{}); len(events) != 1 {
+			t.Errorf("expected the tenant to list its audit event, got %v", events)
+		}
+	})
+
+	t.Run("no_cross_tenant_writes", func(t *testing.T) {
+		s := factory(t)
+
+		user := createTestUser("user-1", "Acme User", "user@example.com")
+		if err := s.CreateUser(acme, user); err != nil {
+			t.Fatalf("failed to create user: %v", err)
+		}
+
+		renamed := createTestUser("user-1", "Renamed", "")
+		if _, err := s.UpdateUser(globex, renamed, store.FieldMask{Name: true}, 0); !errors.Is(err, store.ErrNotFound) {
+			t.Errorf("expected UpdateUser from another tenant to be ErrNotFound, got %v", err)
+		}
+		if err := s.DeleteUser(globex, user.GetId(), 0, time.Now()); !errors.Is(err, store.ErrNotFound) {
+			t.Errorf("expected DeleteUser from another tenant to be ErrNotFound, got %v", err)
+		}
+
+		results, err := s.BatchDeleteUsers(globex, []store.BatchDelete{{ID: user.GetId()}}, time.Now())
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if !errors.Is(results[0].Err, store.ErrNotFound) {
+			t.Errorf("expected BatchDeleteUsers from another tenant to be ErrNotFound, got %v", results[0])
+		}
+
+		got, err := s.GetUser(acme, user.GetId())
+		if err != nil {
+			t.Fatalf("expected the user to be left alone, got %v", err)
+		}
+		if got.GetName() != user.GetName() || got.GetVersion() != store.FirstVersion {
+			t.Errorf("expected the user unchanged, got %v", got)
+		}
+	})
+
+	t.Run("events_name_tenant", func(t *testing.T) {
+		s := factory(t)
+
+		if err := s.CreateUser(acme, createTestUser("user-1", "Acme User", "acme@example.com")); err != nil {
+			t.Fatalf("failed to create user: %v", err)
+		}
+		if err := s.CreateUser(ctx, createTestUser("user-2", "Default User", "default@example.com")); err != nil {
+			t.Fatalf("failed to create user: %v", err)
+		}
+
+		// The outbox is shared, so every tenant's events are pending.
+		events, err := s.PendingEvents(globex, store.MaxPageSize)
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		var got []string
+		for _, event := range events {
+			got = append(got, event.GetUserCreated().GetUser().GetId()+" "+event.GetTenantId())
+		}
+		if want := []string{"user-1 acme", "user-2 " + store.DefaultTenant}; !slices.Equal(got, want) {
+			t.Errorf("expected %v, got %v", want, got)
+		}
+	})
+}
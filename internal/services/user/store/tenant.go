@@ -0,0 +1,51 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// DefaultTenant is the tenant of a context that names none. Users stored
+// before stores were partitioned by tenant belong to it.
+const DefaultTenant = "default"
+
+// maxTenantLength bounds tenant ids, which backends build into their keys.
+const maxTenantLength = 64
+
+var ErrInvalidTenant = errors.New("invalid tenant")
+
+type tenantKey struct{}
+
+// WithTenant returns a copy of ctx scoped to tenant. Stores read and write
+// only the users, and audit events, of the tenant of the context they are
+// given, so that tenants never see each other's data.
+func WithTenant(ctx context.Context, tenant string) context.Context {
+	return context.WithValue(ctx, tenantKey{}, tenant)
+}
+
+// TenantFromContext returns the tenant ctx is scoped to, or DefaultTenant if
+// it is scoped to none.
+func TenantFromContext(ctx context.Context) string {
+	if tenant, ok := ctx.Value(tenantKey{}).(string); ok && tenant != "" {
+		return tenant
+	}
+	return DefaultTenant
+}
+
+// ValidateTenant reports whether tenant may be used as a tenant id: 1 to 64
+// ASCII letters, digits, hyphens and underscores. Stores trust the tenant of
+// their context, so callers taking it from outside should validate it first.
+func ValidateTenant(tenant string) error {
+	if tenant == "" || len(tenant) > maxTenantLength {
+		return fmt.Errorf("%w: %q must be 1 to %d characters", ErrInvalidTenant, tenant, maxTenantLength)
+	}
+	for _, r := range tenant {
+		switch {
+		case 'a' <= r && r <= 'z', 'A' <= r && r <= 'Z', '0' <= r && r <= '9', r == '-', r == '_':
+		default:
+			return fmt.Errorf("%w: %q may only contain letters, digits, hyphens and underscores", ErrInvalidTenant, tenant)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+package user
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"buf.build/go/protovalidate"
+	"google.golang.org/protobuf/proto"
+)
+
+var ErrInvalidRequest = errors.New("invalid request")
+
+// FieldViolation is a rule a request broke, declared on its message in
+// proto/user/v1. Field is the path to the field that broke it, such as
+// "requests[2].email", or empty for a rule on the whole message.
+type FieldViolation struct {
+	Field       string
+	Description string
+}
+
+// ValidationError lists every rule a request broke. It wraps
+// ErrInvalidRequest.
+type ValidationError struct {
+	Violations []FieldViolation
+}
+
+func (e *ValidationError) Error() string {
+	descriptions := make([]string, len(e.Violations))
+	for i, v := range e.Violations {
+		descriptions[i] = v.Description
+		if v.Field != "" {
+			descriptions[i] = v.Field + ": " + v.Description
+		}
+	}
+	return fmt.Sprintf("%v: %s", ErrInvalidRequest, strings.Join(descriptions, "; "))
+}
+
+func (e *ValidationError) Unwrap() error {
+	return ErrInvalidRequest
+}
+
+// ValidateRequest checks req against the buf.validate rules of its message,
+// failing with a *ValidationError if it breaks any. The server checks each
+// request with it before the request claims an idempotency key, and every
+// method of Service checks its request with it first for its other callers,
+// so that the server, the CLI and the web UI all fail with the same messages.
+func ValidateRequest(req proto.Message) error {
+	err := protovalidate.Validate(req)
+
+	var validationErr *protovalidate.ValidationError
+	if !errors.As(err, &validationErr) {
+		// Otherwise the request is valid, or a rule could not be compiled
+		// or evaluated, which is a bug in the messages rather than in the
+		// request.
+		return err
+	}
+
+	violations := make([]FieldViolation, len(validationErr.Violations))
+	for i, v := range validationErr.Violations {
+		violations[i] = FieldViolation{
+			Field:       protovalidate.FieldPathString(v.Proto.GetField()),
+			Description: v.Proto.GetMessage(),
+		}
+	}
+	return &ValidationError{Violations: violations}
+}
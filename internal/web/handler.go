@@ -2,12 +2,14 @@ package web
 
 import (
 	_ "embed"
-	"fmt"
+	"errors"
 	"html/template"
+	"log/slog"
 	"net/http"
 
 	pb "github.com/andrew-womeldorf/connect-boilerplate/gen/user/v1"
 	"github.com/andrew-womeldorf/connect-boilerplate/internal/services/user"
+	"github.com/andrew-womeldorf/connect-boilerplate/internal/services/user/store"
 )
 
 //go:embed templates/index.html
@@ -23,30 +25,45 @@ func NewHandler(service *user.Service) *Handler {
 	}
 }
 
+// IndexHandler lists every user, reading page after page until there are no
+// more. Its failures may describe the backend, so they are only logged.
 func (h *Handler) IndexHandler(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 
-	listResp, err := h.service.ListUsers(ctx, &pb.ListUsersRequest{})
-	if err != nil {
-		http.Error(w, fmt.Sprintf("Failed to list users: %v", err), http.StatusInternalServerError)
-		return
+	var users []*pb.User
+	listReq := &pb.ListUsersRequest{PageSize: store.MaxPageSize}
+	for {
+		listResp, err := h.service.ListUsers(ctx, listReq)
+		if err != nil {
+			slog.ErrorContext(ctx, "failed to list users", slog.Any("error", err))
+			http.Error(w, "Failed to list users", http.StatusInternalServerError)
+			return
+		}
+		users = append(users, listResp.GetUsers()...)
+
+		if listResp.GetNextPageToken() == "" {
+			break
+		}
+		listReq.PageToken = listResp.GetNextPageToken()
 	}
 
 	data := struct {
 		Users []*pb.User
 	}{
-		Users: listResp.Users,
+		Users: users,
 	}
 
 	tmpl, err := template.New("index").Parse(indexTemplate)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Template error: %v", err), http.StatusInternalServerError)
+		slog.ErrorContext(ctx, "failed to parse template", slog.Any("error", err))
+		http.Error(w, "Failed to render page", http.StatusInternalServerError)
 		return
 	}
 
 	w.Header().Set("Content-Type", "text/html")
 	if err := tmpl.Execute(w, data); err != nil {
-		http.Error(w, fmt.Sprintf("Template execution error: %v", err), http.StatusInternalServerError)
+		slog.ErrorContext(ctx, "failed to execute template", slog.Any("error", err))
+		http.Error(w, "Failed to render page", http.StatusInternalServerError)
 		return
 	}
 }
@@ -59,22 +76,31 @@ func (h *Handler) CreateUserHandler(w http.ResponseWriter, r *http.Request) {
 
 	ctx := r.Context()
 
-	name := r.FormValue("name")
-	email := r.FormValue("email")
-
-	if name == "" || email == "" {
-		http.Error(w, "Name and email are required", http.StatusBadRequest)
-		return
-	}
-
 	createReq := &pb.CreateUserRequest{
-		Name:  name,
-		Email: email,
+		Name:  r.FormValue("name"),
+		Email: r.FormValue("email"),
 	}
 
+	// A form that breaks the rules of CreateUserRequest fails with the same
+	// messages as the API, and one that clashes with another user with a
+	// message of its own. Other failures may describe the backend, so they
+	// are only logged.
 	_, err := h.service.CreateUser(ctx, createReq)
+	if errors.Is(err, user.ErrInvalidRequest) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if errors.Is(err, store.ErrAlreadyExists) {
+		http.Error(w, "A user with that email already exists", http.StatusConflict)
+		return
+	}
+	if errors.Is(err, store.ErrConflict) {
+		http.Error(w, "The user could not be created at this time, please try again", http.StatusConflict)
+		return
+	}
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Failed to create user: %v", err), http.StatusInternalServerError)
+		slog.ErrorContext(ctx, "failed to create user", slog.Any("error", err))
+		http.Error(w, "Failed to create user", http.StatusInternalServerError)
 		return
 	}
 
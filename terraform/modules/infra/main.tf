@@ -1,3 +1,18 @@
+# Single table holding these item types, each keyed under TENANT#<tenant>#
+# for tenants other than the default:
+#   USER#<id>         the user; with every user under the "USERS" partition,
+#                     GSI1 sorts them by id, GSI2 by name and GSI3 by
+#                     creation time, which ListUsers reads. Deleted users
+#                     move to GSI1's "DELETED" partition, by deletion time
+#   USER#<id> REV#<n> a revision of the user, beside it in its partition
+#   EMAIL#<email>     claims a lowercased email for one user and serves
+#                     GetUserByEmail with a consistent read, so needs no index
+#   OUTBOX#<n>        events not yet relayed, of every tenant, spread over
+#                     eight partitions by user
+#   AUDIT             the tenant's audit events by time; GSI1 lists each
+#                     user's under AUDIT#USER#<id>
+#   IDEMPOTENCY#<key> the record of a request made with an idempotency key,
+#                     deleted by time to live once expiresAt passes
 resource "aws_dynamodb_table" "this" {
   name = var.name
   billing_mode = "PAY_PER_REQUEST"
@@ -24,6 +39,26 @@ resource "aws_dynamodb_table" "this" {
     type = "S"
   }
 
+  attribute {
+    name = "GSI2PK"
+    type = "S"
+  }
+
+  attribute {
+    name = "GSI2SK"
+    type = "S"
+  }
+
+  attribute {
+    name = "GSI3PK"
+    type = "S"
+  }
+
+  attribute {
+    name = "GSI3SK"
+    type = "S"
+  }
+
   global_secondary_index {
     name     = "GSI1"
     hash_key = "GSI1PK"
@@ -31,5 +66,24 @@ resource "aws_dynamodb_table" "this" {
     projection_type = "ALL"
   }
 
+  global_secondary_index {
+    name     = "GSI2"
+    hash_key = "GSI2PK"
+    range_key = "GSI2SK"
+    projection_type = "ALL"
+  }
+
+  global_secondary_index {
+    name     = "GSI3"
+    hash_key = "GSI3PK"
+    range_key = "GSI3SK"
+    projection_type = "ALL"
+  }
+
+  ttl {
+    attribute_name = "expiresAt"
+    enabled        = true
+  }
+
   tags = var.tags
 }